@@ -0,0 +1,873 @@
+package revproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/accesslog"
+	"github.com/ashpect/revproxy/pkg/admin"
+	"github.com/ashpect/revproxy/pkg/balancer"
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/client"
+	"github.com/ashpect/revproxy/pkg/config"
+	"github.com/ashpect/revproxy/pkg/discovery"
+	"github.com/ashpect/revproxy/pkg/errorpage"
+	"github.com/ashpect/revproxy/pkg/experiment"
+	"github.com/ashpect/revproxy/pkg/geoip"
+	"github.com/ashpect/revproxy/pkg/listener"
+	"github.com/ashpect/revproxy/pkg/metrics"
+	"github.com/ashpect/revproxy/pkg/middleware"
+	"github.com/ashpect/revproxy/pkg/mock"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/ashpect/revproxy/pkg/record"
+	"github.com/ashpect/revproxy/pkg/router"
+	"github.com/ashpect/revproxy/pkg/script"
+	"github.com/ashpect/revproxy/pkg/static"
+	"github.com/ashpect/revproxy/pkg/stats"
+	"github.com/ashpect/revproxy/pkg/utils"
+)
+
+// fetcher refetches a cache key, used by refresh-ahead.
+type fetcher interface {
+	Fetch(key string) (*proxy.CachedResponse, int, error)
+}
+
+// Proxy is the reverse proxy built from a config, an http.Handler. Reload swaps the handlers
+// atomically and keeps the response cache unless the [cache] section changed, so a routes/limits
+// tweak keeps it warm.
+type Proxy struct {
+	handler atomic.Pointer[http.Handler]
+	admin   atomic.Pointer[http.Handler]
+	// fetchers are the current proxies by the CachedResponse.Source they cache under, "" for the
+	// default one. The cache's refresh-ahead loader outlives reloads
+	fetchers atomic.Pointer[map[string]fetcher]
+	// liveCache is the current response cache for the metrics gauges, nil when disabled
+	liveCache atomic.Pointer[cache.Cache[string, *proxy.CachedResponse]]
+
+	mu      sync.Mutex
+	cfg     *config.SystemCfg
+	clients *client.Registry
+	cache   cache.Cache[string, *proxy.CachedResponse]
+	geo     *geoip.DB
+	// recorder writes sampled traffic to a file, nil when recording is off
+	recorder *record.Recorder
+	// accessLog is nil when no access log is configured
+	accessLog *accesslog.Logger
+	// metrics exports to an OTel collector, nil when no endpoint is configured
+	metrics *metrics.Metrics
+	// events are the embedder's callbacks, kept across reloads
+	events events
+	// counters feed the admin stats stream, kept across reloads
+	counters stats.Counters
+	// stopDiscovery stops the watchers feeding the current pools
+	stopDiscovery context.CancelFunc
+	// services are the routes discovered from Docker, added after the configured ones
+	services []discovery.DockerService
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*p.handler.Load()).ServeHTTP(w, r)
+}
+
+// ConnState counts client connections for the admin stats stream, set it as the server's ConnState.
+func (p *Proxy) ConnState(conn net.Conn, state http.ConnState) {
+	p.counters.ConnState(conn, state)
+}
+
+// Admin returns the admin handler, following reloads.
+func (p *Proxy) Admin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(*p.admin.Load()).ServeHTTP(w, r)
+	})
+}
+
+// Reload rebuilds the handlers from systemCfg. On error the running config is left in place.
+func (p *Proxy) Reload(systemCfg *config.SystemCfg) error {
+	err := p.reload(systemCfg)
+	p.events.configReloaded(systemCfg, err)
+	return err
+}
+
+func (p *Proxy) reload(systemCfg *config.SystemCfg) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.cfg
+	if old.ListenAddr != systemCfg.ListenAddr || old.ListenSocketMode != systemCfg.ListenSocketMode ||
+		old.AdminCfg != systemCfg.AdminCfg || old.ServerCfg != systemCfg.ServerCfg || !reflect.DeepEqual(old.TLSCfg, systemCfg.TLSCfg) {
+		log.Printf("reload: listener, server, admin and tls changes take effect on restart")
+	}
+	return p.apply(systemCfg)
+}
+
+// Warm fetches the [warm] URLs through the current handler to fill the cache.
+func (p *Proxy) Warm(ctx context.Context) proxy.WarmResult {
+	p.mu.Lock()
+	warmCfg := p.cfg.WarmCfg
+	p.mu.Unlock()
+	return proxy.Warm(ctx, p, warmCfg.ResolvedURLs(), warmCfg.Concurrency)
+}
+
+// SetDockerServices rebuilds the handlers with the routes discovered from Docker.
+func (p *Proxy) SetDockerServices(services []discovery.DockerService) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if reflect.DeepEqual(p.services, services) {
+		return
+	}
+	previous := p.services
+	p.services = services
+	if err := p.apply(p.cfg); err != nil {
+		log.Printf("docker discovery: keeping previous routes: %v", err)
+		p.services = previous
+		return
+	}
+	utils.Log("docker discovery: %d routes", len(services))
+}
+
+// withServices returns systemCfg plus a route per discovered service. Services named like a
+// configured upstream are skipped, configuration wins.
+func (p *Proxy) withServices(systemCfg *config.SystemCfg) *config.SystemCfg {
+	if len(p.services) == 0 {
+		return systemCfg
+	}
+	effective := *systemCfg
+	effective.Upstreams = slices.Clone(systemCfg.Upstreams)
+	effective.Routes = slices.Clone(systemCfg.Routes)
+	for _, service := range p.services {
+		if _, exists := effective.Upstream(service.Name); exists {
+			log.Printf("docker discovery: %s clashes with a configured upstream, skipped", service.Name)
+			continue
+		}
+		effective.AddRoute(service.Name, service.URL.String(), service.Host, service.PathPrefix)
+	}
+	return &effective
+}
+
+// apply builds handlers for systemCfg (plus discovered services) and swaps them in, callers hold
+// p.mu (or own p).
+func (p *Proxy) apply(baseCfg *config.SystemCfg) error {
+	systemCfg := p.withServices(baseCfg)
+	cacheCfg := systemCfg.CacheCfg
+
+	clients, err := newClients(systemCfg)
+	if err != nil {
+		return err
+	}
+
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	pools, err := startDiscovery(discoveryCtx, systemCfg)
+	if err != nil {
+		stopDiscovery()
+		return err
+	}
+
+	// Cache builder, reusing the running cache when its settings are unchanged
+	responseCache := p.cache
+	newCache := p.cfg == nil || !reflect.DeepEqual(p.cfg.CacheCfg, cacheCfg)
+	if newCache {
+		responseCache, err = p.newCache(systemCfg)
+		if err != nil {
+			stopDiscovery()
+			return err
+		}
+	}
+
+	// GeoIP database, reopened only when its path changes
+	geo := p.geo
+	newGeo := p.cfg == nil || p.cfg.GeoIPCfg.Database != systemCfg.GeoIPCfg.Database
+	if newGeo && systemCfg.GeoIPCfg.Database != "" {
+		if geo, err = geoip.Open(systemCfg.GeoIPCfg.Database); err != nil {
+			stopDiscovery()
+			if newCache && responseCache != nil {
+				responseCache.Close()
+			}
+			return fmt.Errorf("geoip: %w", err)
+		}
+	} else if newGeo {
+		geo = nil
+	}
+
+	// Traffic recording, access log and metrics export, restarted only when their settings change
+	recorder, accessLog, meters := p.recorder, p.accessLog, p.metrics
+	newRecorder := p.cfg == nil || !reflect.DeepEqual(p.cfg.RecordCfg, systemCfg.RecordCfg)
+	if newRecorder {
+		recorder = nil
+	}
+	// the logged client IP follows the proxy's strategy
+	newAccessLog := p.cfg == nil || !reflect.DeepEqual(p.cfg.AccessLogCfg, systemCfg.AccessLogCfg) ||
+		p.cfg.ProxyCfg.ClientIP != systemCfg.ProxyCfg.ClientIP || !slices.Equal(p.cfg.ProxyCfg.TrustedProxies, systemCfg.ProxyCfg.TrustedProxies)
+	if newAccessLog {
+		accessLog = nil
+	}
+	newMetrics := p.cfg == nil || !reflect.DeepEqual(p.cfg.MetricsCfg, systemCfg.MetricsCfg)
+	if newMetrics {
+		meters = nil
+	}
+
+	// discard releases what was built for this config when it can't be applied
+	discard := func() {
+		stopDiscovery()
+		if newCache && responseCache != nil {
+			responseCache.Close()
+		}
+		if newGeo && geo != nil {
+			geo.Close()
+		}
+		if newRecorder && recorder != nil {
+			recorder.Close()
+		}
+		if newAccessLog && accessLog != nil {
+			accessLog.Close()
+		}
+		if newMetrics && meters != nil {
+			meters.Shutdown(context.Background())
+		}
+	}
+
+	if recordCfg := systemCfg.RecordCfg; newRecorder && recordCfg.Path != "" {
+		recorder, err = record.Open(recordCfg.Path, recordCfg.Format,
+			record.WithSample(recordCfg.Sample),
+			record.WithMaxBodySize(recordCfg.MaxBodySize),
+			record.WithRedactHeaders(recordCfg.RedactHeaders...),
+		)
+		if err != nil {
+			discard()
+			return fmt.Errorf("record: %w", err)
+		}
+	}
+	if accessLogCfg := systemCfg.AccessLogCfg; newAccessLog && accessLogCfg.Path != "" {
+		accessLog, err = accesslog.Open(accessLogCfg.Path,
+			accesslog.WithFormat(accessLogCfg.Format),
+			accesslog.WithMaxSize(accessLogCfg.MaxSize),
+			accesslog.WithMaxBackups(accessLogCfg.MaxBackups),
+			accesslog.WithClientIP(middleware.ClientIP(systemCfg.ProxyCfg.ClientIP, systemCfg.ProxyCfg.TrustedNetworks())),
+		)
+		if err != nil {
+			discard()
+			return fmt.Errorf("access log: %w", err)
+		}
+	}
+	if metricsCfg := systemCfg.MetricsCfg; newMetrics && metricsCfg.OTLPEndpoint != "" {
+		metricsOpts := []metrics.Option{metrics.WithLabelLimit(metricsCfg.LabelLimit), metrics.WithHealth(p.counters.Health), metrics.WithCacheStats(p.cacheStats)}
+		if systemCfg.ServerName != "" {
+			metricsOpts = append(metricsOpts, metrics.WithServer(systemCfg.ServerName))
+		}
+		meters, err = metrics.NewOTLP(context.Background(), metricsCfg.OTLPEndpoint, metricsCfg.Interval.Duration,
+			metricsCfg.OTLPHeaders, metricsCfg.ServiceName, metricsOpts...)
+		if err != nil {
+			discard()
+			return fmt.Errorf("metrics: %w", err)
+		}
+	}
+
+	handler, fetchers, err := buildHandler(systemCfg, clients, pools, responseCache, geo, recorder, accessLog, meters, &p.counters, &p.events)
+	if err != nil {
+		discard()
+		return err
+	}
+
+	adminOpts := []admin.AdminOption{admin.WithStats(&p.counters)}
+	if responseCache != nil {
+		adminOpts = append(adminOpts, admin.WithCache(responseCache), admin.WithWarmer(p.Warm))
+	}
+	var adminHandler http.Handler = admin.NewAdmin(adminOpts...)
+
+	p.fetchers.Store(&fetchers)
+	p.handler.Store(&handler)
+	p.admin.Store(&adminHandler)
+	p.liveCache.Store(&responseCache)
+
+	if newCache && p.cache != nil {
+		p.cache.Close()
+	}
+	if newGeo && p.geo != nil {
+		p.geo.Close()
+	}
+	if newRecorder && p.recorder != nil {
+		p.recorder.Close()
+	}
+	if newAccessLog && p.accessLog != nil {
+		p.accessLog.Close()
+	}
+	if newMetrics && p.metrics != nil {
+		// flushes what the old exporter still holds, without holding up the reload
+		go p.metrics.Shutdown(context.Background())
+	}
+	if p.clients != nil {
+		p.clients.CloseIdleConnections()
+	}
+	if p.stopDiscovery != nil {
+		p.stopDiscovery()
+	}
+	p.recorder, p.accessLog, p.metrics = recorder, accessLog, meters
+	p.cfg, p.clients, p.cache, p.geo, p.stopDiscovery = baseCfg, clients, responseCache, geo, stopDiscovery
+	return nil
+}
+
+// cacheStats returns the usage of the current response cache, false when caching is disabled.
+// It doesn't take the lock, metrics collect it while a reload may be shutting them down.
+func (p *Proxy) cacheStats() (cache.Stats, bool) {
+	current := p.liveCache.Load()
+	if current == nil || *current == nil {
+		return cache.Stats{}, false
+	}
+	return (*current).Stats(), true
+}
+
+func (p *Proxy) newCache(systemCfg *config.SystemCfg) (cache.Cache[string, *proxy.CachedResponse], error) {
+	cacheCfg := systemCfg.CacheCfg
+	if !cacheCfg.Enabled {
+		return nil, nil
+	}
+	if cacheCfg.PartitionBy == "" {
+		return p.newLRU(systemCfg, cacheCfg.CacheCapacity)
+	}
+	partitionOf := func(key string) string {
+		partition, _ := proxy.SplitPartitionKey(key)
+		return partition
+	}
+	// only the partitions named in the config are built, the hosts (or routes) of any other share
+	// the default one, so clients can't add partitions by sending new Host values
+	names := slices.Collect(maps.Keys(cacheCfg.Partitions))
+	if cacheCfg.PartitionBy == "route" {
+		for _, route := range systemCfg.Routes {
+			names = append(names, route.Name)
+		}
+	}
+	return cache.NewPartitioned(partitionOf, names, "default", func(name string) (cache.Cache[string, *proxy.CachedResponse], error) {
+		return p.newLRU(systemCfg, cacheCfg.PartitionCapacityOf(name))
+	})
+}
+
+// newLRU builds one response cache of capacity entries.
+func (p *Proxy) newLRU(systemCfg *config.SystemCfg, capacity int) (cache.Cache[string, *proxy.CachedResponse], error) {
+	cacheCfg := systemCfg.CacheCfg
+	cacheOpts := []cache.LRUOption[string, *proxy.CachedResponse]{
+		cache.WithCapacity[string, *proxy.CachedResponse](capacity),
+		cache.WithDefaultTTL[string, *proxy.CachedResponse](cacheCfg.DefaultTTL),
+		cache.WithSizer(func(key string, resp *proxy.CachedResponse) int { return len(key) + resp.Size() }),
+		cache.WithOnEvict(func(key string, _ *proxy.CachedResponse) { p.events.cacheEvicted(key) }),
+	}
+	if cacheCfg.EvictionPolicy == "lfu" {
+		cacheOpts = append(cacheOpts, cache.WithEvictionPolicy[string, *proxy.CachedResponse](cache.NewLFUPolicy[string]()))
+	}
+	if cacheCfg.LazyExpiration {
+		cacheOpts = append(cacheOpts, cache.WithLazyExpiration[string, *proxy.CachedResponse](cache.DefaultSweepPerOp))
+	} else {
+		cacheOpts = append(cacheOpts, cache.WithCleanupStart[string, *proxy.CachedResponse](true))
+	}
+	var lru *cache.LRUWithTTL[string, *proxy.CachedResponse]
+	if cacheCfg.RefreshAhead > 0 {
+		// refetched through the proxy that cached the entry, routes may have their own upstreams
+		cacheOpts = append(cacheOpts, cache.WithRefreshAhead(cacheCfg.RefreshAhead, func(key string) (*proxy.CachedResponse, int, error) {
+			var source string
+			if entry, _, ok := lru.GetWithExpiry(key); ok {
+				source = entry.Source
+			}
+			f, ok := (*p.fetchers.Load())[source]
+			if !ok {
+				return nil, 0, fmt.Errorf("refresh of %s: no proxy for %q", key, source)
+			}
+			return f.Fetch(key)
+		}))
+	}
+	lru, err := cache.NewLRUTTL(cacheOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache: %w", err)
+	}
+	return lru, nil
+}
+
+// newClients builds a client per upstream from the [proxy] transport settings and the upstream's
+// own overrides and TLS.
+func newClients(systemCfg *config.SystemCfg) (*client.Registry, error) {
+	proxyCfg := systemCfg.ProxyCfg
+	var resolver *client.Resolver
+	if proxyCfg.DNSCacheTTL.Duration > 0 {
+		var err error
+		resolver, err = client.NewResolver(
+			client.WithResolverTTL(int(proxyCfg.DNSCacheTTL.Seconds())),
+			client.WithRoundRobin(proxyCfg.DNSRoundRobin),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clients := client.NewRegistry()
+	for _, upstreamCfg := range systemCfg.Upstreams {
+		maxIdleConnsPerHost := proxyCfg.MaxIdleConnsPerHost
+		if upstreamCfg.MaxIdleConnsPerHost > 0 {
+			maxIdleConnsPerHost = upstreamCfg.MaxIdleConnsPerHost
+		}
+		dialTimeout := proxyCfg.DialTimeout.Duration
+		if upstreamCfg.DialTimeout.Duration > 0 {
+			dialTimeout = upstreamCfg.DialTimeout.Duration
+		}
+		transportOpts := []client.TransportOption{
+			client.WithMaxIdleConns(proxyCfg.MaxIdleConns),
+			client.WithMaxIdleConnsPerHost(maxIdleConnsPerHost),
+			client.WithIdleConnTimeout(proxyCfg.IdleConnTimeout.Duration),
+			client.WithDisableCompression(proxyCfg.DisableCompression),
+			client.WithForceAttemptHTTP2(proxyCfg.HTTP2),
+			client.WithDisableHTTP2(upstreamCfg.DisableHTTP2),
+			client.WithDialTimeout(dialTimeout),
+			client.WithKeepAlive(proxyCfg.KeepAlive.Duration),
+		}
+		if listener.IsUnix(upstreamCfg.URL) {
+			transportOpts = append(transportOpts, client.WithUnixSocket(listener.SocketPath(upstreamCfg.URL)))
+		} else {
+			if proxyCfg.LocalAddr != "" {
+				transportOpts = append(transportOpts, client.WithLocalAddr(&net.TCPAddr{IP: net.ParseIP(proxyCfg.LocalAddr)}))
+			}
+			if resolver != nil {
+				transportOpts = append(transportOpts, client.WithResolver(resolver))
+			}
+		}
+
+		tlsCfg := upstreamCfg.TLS
+		if tlsCfg.InsecureSkipVerify {
+			transportOpts = append(transportOpts, client.WithInsecureSkipVerify(true))
+		}
+		if len(tlsCfg.ALPN) > 0 {
+			transportOpts = append(transportOpts, client.WithALPN(tlsCfg.ALPN...))
+		}
+		if tlsCfg.ServerName != "" {
+			transportOpts = append(transportOpts, client.WithServerName(tlsCfg.ServerName))
+		}
+		if tlsCfg.CAFile != "" {
+			pem, err := os.ReadFile(tlsCfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", upstreamCfg.Name, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("upstream %s: no certificates found in %s", upstreamCfg.Name, tlsCfg.CAFile)
+			}
+			transportOpts = append(transportOpts, client.WithRootCAs(pool))
+		}
+		if tlsCfg.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", upstreamCfg.Name, err)
+			}
+			transportOpts = append(transportOpts, client.WithClientCertificate(cert))
+		}
+
+		clientOpts := []client.ClientOption{
+			client.WithTransport(client.NewTransport(transportOpts...)),
+		}
+		if upstreamCfg.Timeout.Duration > 0 {
+			clientOpts = append(clientOpts, client.WithTimeout(upstreamCfg.Timeout.Duration))
+		}
+		clients.Register(upstreamCfg.Name, client.NewClient(clientOpts...))
+	}
+	return clients, nil
+}
+
+// startDiscovery builds a backend pool for every upstream using discovery, resolves it once so
+// traffic can flow right away and keeps it updated until ctx is done.
+func startDiscovery(ctx context.Context, systemCfg *config.SystemCfg) (map[string]*balancer.Pool, error) {
+	pools := map[string]*balancer.Pool{}
+	for _, upstreamCfg := range systemCfg.Upstreams {
+		discoveryCfg := upstreamCfg.Discovery
+		if discoveryCfg == nil {
+			continue
+		}
+		var discoverer discovery.Discoverer
+		switch discoveryCfg.Type {
+		case "srv":
+			discoverer = discovery.NewSRV(discoveryCfg.Name, discoveryCfg.Scheme)
+		case "consul":
+			consulOpts := []discovery.ConsulOption{
+				discovery.WithConsulTag(discoveryCfg.Tag),
+				discovery.WithConsulToken(discoveryCfg.Token),
+			}
+			if discoveryCfg.Address != "" {
+				consulOpts = append(consulOpts, discovery.WithConsulAddress(discoveryCfg.Address))
+			}
+			discoverer = discovery.NewConsul(discoveryCfg.Name, discoveryCfg.Scheme, consulOpts...)
+		default:
+			return nil, fmt.Errorf("upstream %s: unknown discovery type %q", upstreamCfg.Name, discoveryCfg.Type)
+		}
+
+		pool := balancer.NewPool(upstreamCfg.Name)
+		initCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if err := discovery.Refresh(initCtx, discoverer, pool); err != nil {
+			log.Printf("discovery for upstream %s failed, starting without backends: %v", upstreamCfg.Name, err)
+		}
+		cancel()
+		go discovery.Watch(ctx, discoverer, pool, discoveryCfg.Interval.Duration)
+		pools[upstreamCfg.Name] = pool
+	}
+	return pools, nil
+}
+
+// buildHandler assembles the router, per-route proxies and middleware. It also returns the
+// caching proxies by the source they cache under, for refresh-ahead. geo, recorder and meters are nil when disabled.
+func buildHandler(systemCfg *config.SystemCfg, clients *client.Registry, pools map[string]*balancer.Pool, responseCache cache.Cache[string, *proxy.CachedResponse], geo *geoip.DB, recorder *record.Recorder, accessLog *accesslog.Logger, meters *metrics.Metrics, counters *stats.Counters, events proxy.Observer) (http.Handler, map[string]fetcher, error) {
+	cacheCfg := systemCfg.CacheCfg
+
+	upstreamURL, err := parseUpstreamURL(systemCfg.DefaultUpstream().URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	// The client IP of logs, limits and ACLs, with proxy-protocol the listener set the remote address
+	clientIP := middleware.ClientIP(systemCfg.ProxyCfg.ClientIP, systemCfg.ProxyCfg.TrustedNetworks())
+
+	// Options shared by every proxy, the buffer budget is global across routes
+	commonOpts := []proxy.ProxyOption{
+		proxy.WithFlushInterval(systemCfg.ProxyCfg.FlushInterval.Duration),
+		proxy.WithMaxCacheBodySize(cacheCfg.MaxBodySize),
+		proxy.WithCacheTTL(cacheCfg.DefaultTTL),
+		proxy.WithStaleRetention(cacheCfg.StaleRetention),
+		proxy.WithCacheLock(cacheCfg.LockTimeout.Duration),
+		proxy.WithSliceSize(cacheCfg.SliceSize),
+		proxy.WithCacheTemporaryRedirects(cacheCfg.TemporaryRedirects),
+		proxy.WithMemoryBudget(proxy.NewMemoryBudget(systemCfg.ProxyCfg.BufferBudget)),
+		proxy.WithForwardedHeaders(systemCfg.ProxyCfg.Forwarded),
+		proxy.WithTrustedProxies(systemCfg.ProxyCfg.TrustedNetworks()),
+		proxy.WithClientIP(clientIP),
+		proxy.WithNormalizeURL(systemCfg.ProxyCfg.NormalizeURL),
+		proxy.WithObserver(counters),
+		proxy.WithObserver(events),
+	}
+	if meters != nil {
+		commonOpts = append(commonOpts, proxy.WithObserver(meters))
+	}
+
+	// Lua hooks, reloaded with the config
+	var hooks *script.Script
+	if scriptCfg := systemCfg.ScriptCfg; scriptCfg.Path != "" {
+		if hooks, err = script.Load(scriptCfg.Path, script.WithTimeout(scriptCfg.Timeout.Duration)); err != nil {
+			return nil, nil, fmt.Errorf("script: %w", err)
+		}
+		commonOpts = append(commonOpts, proxy.WithRewriteRequest(hooks.RewriteRequest), proxy.WithModifyResponse(hooks.ModifyResponse))
+	}
+
+	// Cache partitioning, unrouted traffic is the "default" route's partition
+	cachePartition := func(route string) []proxy.ProxyOption {
+		switch cacheCfg.PartitionBy {
+		case "host":
+			return []proxy.ProxyOption{proxy.WithCachePartition(proxy.RequestHost)}
+		case "route":
+			return []proxy.ProxyOption{proxy.WithCachePartition(func(*http.Request) string { return route })}
+		}
+		return nil
+	}
+
+	defaultOpts := append([]proxy.ProxyOption{}, commonOpts...)
+	if pool, ok := pools[systemCfg.DefaultUpstream().Name]; ok {
+		defaultOpts = append(defaultOpts, proxy.WithBalancer(pool))
+	}
+	if responseCache != nil {
+		defaultOpts = append(defaultOpts, proxy.WithCache(responseCache), proxy.WithCacheKeyHost(!cacheCfg.IgnoreHost))
+		defaultOpts = append(defaultOpts, cachePartition("default")...)
+	}
+	defaultClient, _ := clients.Get(systemCfg.DefaultUpstream().Name)
+	proxyHandler := proxy.NewProxy(upstreamURL, defaultClient, defaultOpts...)
+	fetchers := map[string]fetcher{"": proxyHandler}
+
+	// Rate limiting, per-IP limits apply per route so routes can override them. Each route checks
+	// the client's bucket before the global one, which all routes share
+	rateLimitCfg := systemCfg.RateLimitCfg
+	globalRate := middleware.WithGlobalRate(rateLimitCfg.GlobalRPS, rateLimitCfg.GlobalBurst)
+	rateLimit := func(rate float64, burst int) middleware.Middleware {
+		if !rateLimitCfg.Enabled || (rate <= 0 && rateLimitCfg.GlobalRPS <= 0) {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return middleware.RateLimit(
+			globalRate,
+			middleware.WithPerClientRate(rate, burst),
+			middleware.WithExempt(rateLimitCfg.ExemptNetworks()),
+			middleware.WithClientIP(clientIP),
+		)
+	}
+	defaultRateLimit := rateLimit(rateLimitCfg.PerIPRate, rateLimitCfg.PerIPBurst)
+
+	// Method filtering, proxy.denyMethods applies to every route so each can report its own Allow
+	methodFilter := func(allowed, denied []string) middleware.Middleware {
+		denied = append(slices.Clone(systemCfg.ProxyCfg.DenyMethods), denied...)
+		if len(allowed) == 0 && len(denied) == 0 {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return middleware.Methods(middleware.WithAllowedMethods(allowed...), middleware.WithDeniedMethods(denied...))
+	}
+
+	// Error pages, routes render their own over the global ones with their name and upstream
+	errorPages := func(files map[int]string, opts ...errorpage.Option) (middleware.Middleware, error) {
+		if len(files) == 0 {
+			return func(next http.Handler) http.Handler { return next }, nil
+		}
+		pages, err := errorpage.Load(files, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("error pages: %w", err)
+		}
+		return pages.Middleware, nil
+	}
+	fallbackPages, err := errorPages(systemCfg.ErrorPageFiles(nil), errorpage.WithRoute("", systemCfg.DefaultUpstream().Name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Metric labels, unrouted traffic is the "default" route like for cache partitions
+	routeLabels := func(route, upstream string) middleware.Middleware {
+		if meters == nil {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return meters.Route(route, upstream)
+	}
+	// Access log route names and sampling
+	routeLog := func(route string, sample int, slow time.Duration) middleware.Middleware {
+		if accessLog == nil {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return accessLog.Route(route, sample, slow)
+	}
+
+	// Router builder, one proxy per route so cache policy and timeouts can differ
+	fallback := middleware.Chain(proxyHandler, routeLabels("default", systemCfg.DefaultUpstream().Name), routeLog("default", 1, 0), fallbackPages, methodFilter(nil, nil), defaultRateLimit)
+	routerOpts := []router.RouterOption{router.WithFallback(fallback)}
+	for _, routeCfg := range systemCfg.Routes {
+		middlewares, err := middleware.Lookup(routeCfg.Middleware...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %s: %w", routeCfg.Name, err)
+		}
+		routePages, err := errorPages(systemCfg.ErrorPageFiles(&routeCfg), errorpage.WithRoute(routeCfg.Name, routeCfg.Upstream))
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %s: %w", routeCfg.Name, err)
+		}
+		// OPTIONS is answered locally behind the method filter, which lets it through, and rate limit
+		methods := routeCfg.Methods
+		if optionsCfg := routeCfg.Options; optionsCfg != nil {
+			if len(methods) > 0 {
+				methods = append(slices.Clone(methods), http.MethodOptions)
+			}
+			allow := optionsCfg.Allow
+			if len(allow) == 0 {
+				allow = routeCfg.Methods
+			}
+			preflight := middleware.Preflight(
+				middleware.WithPreflightAllow(allow...),
+				middleware.WithPreflightOrigins(optionsCfg.AllowOrigins...),
+				middleware.WithPreflightMethods(optionsCfg.AllowMethods...),
+				middleware.WithPreflightHeaders(optionsCfg.AllowHeaders...),
+				middleware.WithPreflightCredentials(optionsCfg.AllowCredentials),
+				middleware.WithPreflightMaxAge(optionsCfg.MaxAge.Duration),
+			)
+			middlewares = append([]middleware.Middleware{preflight}, middlewares...)
+		}
+		if routeCfg.RateLimit != nil {
+			middlewares = append([]middleware.Middleware{rateLimit(routeCfg.RateLimit.PerIPRate, routeCfg.RateLimit.PerIPBurst)}, middlewares...)
+		} else {
+			middlewares = append([]middleware.Middleware{defaultRateLimit}, middlewares...)
+		}
+		labels := routeLabels(routeCfg.Name, "")
+		if routeCfg.Mock == nil && routeCfg.Static == nil {
+			labels = routeLabels(routeCfg.Name, routeCfg.Upstream)
+		}
+		logSample, logSlow := 1, time.Duration(0)
+		if logCfg := routeCfg.AccessLog; logCfg != nil {
+			logSample, logSlow = logCfg.Sample, logCfg.Slow.Duration
+		}
+		middlewares = append([]middleware.Middleware{labels, routeLog(routeCfg.Name, logSample, logSlow), routePages, methodFilter(methods, routeCfg.DenyMethods)}, middlewares...)
+		if faultCfg := routeCfg.Fault; faultCfg != nil && systemCfg.FaultCfg.Enabled {
+			middlewares = append(middlewares, middleware.Fault(
+				middleware.WithFaultDelay(faultCfg.Delay.Duration, faultCfg.DelayPercent),
+				middleware.WithFaultAbort(faultCfg.AbortStatus, faultCfg.AbortPercent),
+				middleware.WithFaultReset(faultCfg.ResetPercent),
+			))
+		}
+
+		if mockCfg := routeCfg.Mock; mockCfg != nil {
+			mockOpts := []mock.Option{}
+			for _, name := range slices.Sorted(maps.Keys(mockCfg.Headers)) {
+				mockOpts = append(mockOpts, mock.WithHeader(name, mockCfg.Headers[name]))
+			}
+			if mockCfg.BodyFile != "" {
+				mockOpts = append(mockOpts, mock.WithBodyFile(mockCfg.BodyFile))
+			} else {
+				mockOpts = append(mockOpts, mock.WithBody(mockCfg.Body))
+			}
+			if mockCfg.Template {
+				mockOpts = append(mockOpts, mock.WithTemplate())
+			}
+			mockHandler, err := mock.NewHandler(mockCfg.Status, mockOpts...)
+			if err != nil {
+				return nil, nil, fmt.Errorf("route %s: mock: %w", routeCfg.Name, err)
+			}
+			routerOpts = append(routerOpts, router.WithRoute(router.Route{
+				Name:        routeCfg.Name,
+				Host:        routeCfg.Match.Host,
+				PathPrefix:  routeCfg.Match.PathPrefix,
+				Countries:   routeCfg.Match.Countries,
+				Experiments: routeCfg.Match.Experiments,
+				Query:       routeCfg.Match.Query,
+				Handler:     middleware.Chain(mockHandler, middlewares...),
+			}))
+			continue
+		}
+
+		if staticCfg := routeCfg.Static; staticCfg != nil {
+			staticOpts := []static.Option{static.WithSPAFallback(staticCfg.SPA), static.WithDotfiles(staticCfg.Dotfiles)}
+			if staticCfg.Index != "" {
+				staticOpts = append(staticOpts, static.WithIndex(staticCfg.Index))
+			}
+			routerOpts = append(routerOpts, router.WithRoute(router.Route{
+				Name:        routeCfg.Name,
+				Host:        routeCfg.Match.Host,
+				PathPrefix:  routeCfg.Match.PathPrefix,
+				Countries:   routeCfg.Match.Countries,
+				Experiments: routeCfg.Match.Experiments,
+				Query:       routeCfg.Match.Query,
+				Handler:     middleware.Chain(static.NewHandler(staticCfg.Root, staticOpts...), middlewares...),
+			}))
+			continue
+		}
+
+		upstreamCfg, _ := systemCfg.Upstream(routeCfg.Upstream)
+		routeUpstreamURL, err := parseUpstreamURL(upstreamCfg.URL)
+		routeClient, _ := clients.Get(upstreamCfg.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %s: invalid upstream URL: %w", routeCfg.Name, err)
+		}
+
+		proxyOpts := append([]proxy.ProxyOption{}, commonOpts...)
+		proxyOpts = append(proxyOpts, proxy.WithTimeout(routeCfg.Timeout.Duration))
+		if routeCfg.Cache.TTL > 0 {
+			proxyOpts = append(proxyOpts, proxy.WithCacheTTL(routeCfg.Cache.TTL))
+		}
+		if pool, ok := pools[upstreamCfg.Name]; ok {
+			proxyOpts = append(proxyOpts, proxy.WithBalancer(pool))
+		}
+		if responseCache != nil && routeCfg.CacheEnabled(cacheCfg.Enabled) {
+			proxyOpts = append(proxyOpts, proxy.WithCache(responseCache), proxy.WithCacheKeyHost(!cacheCfg.IgnoreHost), proxy.WithCacheSource(routeCfg.Name))
+			proxyOpts = append(proxyOpts, cachePartition(routeCfg.Name)...)
+		}
+		routeProxy := proxy.NewProxy(routeUpstreamURL, routeClient, proxyOpts...)
+		fetchers[routeCfg.Name] = routeProxy
+
+		routerOpts = append(routerOpts, router.WithRoute(router.Route{
+			Name:        routeCfg.Name,
+			Host:        routeCfg.Match.Host,
+			PathPrefix:  routeCfg.Match.PathPrefix,
+			Countries:   routeCfg.Match.Countries,
+			Experiments: routeCfg.Match.Experiments,
+			Query:       routeCfg.Match.Query,
+			Handler:     middleware.Chain(routeProxy, middlewares...),
+		}))
+	}
+	var handler http.Handler = router.NewRouter(routerOpts...)
+	if hooks != nil {
+		handler = hooks.Middleware(handler)
+	}
+	// Experiments assign buckets before routing, the first experiment is the outermost
+	for i := len(systemCfg.Experiments) - 1; i >= 0; i-- {
+		experimentCfg := systemCfg.Experiments[i]
+		buckets := make([]experiment.Bucket, 0, len(experimentCfg.Buckets))
+		for _, bucketCfg := range experimentCfg.Buckets {
+			buckets = append(buckets, experiment.Bucket{Name: bucketCfg.Name, Percent: bucketCfg.Percent})
+		}
+		experimentOpts := []middleware.ExperimentOption{}
+		if experimentCfg.Cookie != "" {
+			experimentOpts = append(experimentOpts, middleware.WithExperimentCookie(experimentCfg.Cookie))
+		}
+		if experimentCfg.Header != "" {
+			experimentOpts = append(experimentOpts, middleware.WithExperimentHeader(experimentCfg.Header))
+		}
+		if experimentCfg.MaxAge.Duration > 0 {
+			experimentOpts = append(experimentOpts, middleware.WithExperimentMaxAge(experimentCfg.MaxAge.Duration))
+		}
+		handler = middleware.Experiment(experimentCfg.Name, buckets, experimentOpts...)(handler)
+	}
+	// CONNECT is answered before routing, its target is a host rather than a path, and only takes
+	// a global token
+	if ports := systemCfg.ProxyCfg.ConnectPorts; len(ports) > 0 {
+		routed := handler
+		tunnel := rateLimit(0, 0)(proxy.Connect(routed, ports, systemCfg.ProxyCfg.ConnectHosts, systemCfg.ProxyCfg.DialTimeout.Duration))
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodConnect {
+				tunnel.ServeHTTP(w, r)
+				return
+			}
+			routed.ServeHTTP(w, r)
+		})
+	}
+	// Upstream override applies to every proxied route, including the fallback
+	if overrideCfg := systemCfg.OverrideCfg; overrideCfg.Enabled {
+		handler = middleware.UpstreamOverride(
+			middleware.WithOverrideHeader(overrideCfg.Header),
+			middleware.WithOverrideAllowed(overrideCfg.AllowNetworks()),
+			middleware.WithOverrideClientIP(clientIP),
+		)(handler)
+	}
+	// Trace headers are settled before hooks and upstreams see the request
+	if tracingCfg := systemCfg.TracingCfg; tracingCfg.Enabled() {
+		handler = middleware.Trace(
+			middleware.WithTraceStrip(tracingCfg.Strip...),
+			middleware.WithTraceTrusted(tracingCfg.TrustNetworks()),
+			middleware.WithTraceGenerate(tracingCfg.Generate),
+			middleware.WithTraceClientIP(clientIP),
+		)(handler)
+	}
+	// GeoIP runs first so blocked countries don't use up rate limits
+	if geo != nil {
+		handler = middleware.GeoIP(geo.Country,
+			middleware.WithCountryHeader(systemCfg.GeoIPCfg.Header),
+			middleware.WithBlockedCountries(systemCfg.GeoIPCfg.BlockCountries...),
+			middleware.WithGeoClientIP(clientIP),
+		)(handler)
+	}
+	// Paths are normalized before anything matches on them, routes and cache keys included
+	handler = middleware.NormalizePath(
+		middleware.WithMergeSlashes(systemCfg.ProxyCfg.MergeSlashes),
+		middleware.WithResolveDots(systemCfg.ProxyCfg.ResolveDots),
+		middleware.WithRejectEncodedTraversal(systemCfg.ProxyCfg.RejectEncodedTraversal),
+	)(handler)
+	// Metrics measure every request, including the ones rejected before reaching a route
+	if meters != nil {
+		handler = meters.Middleware(handler)
+	}
+	handler = counters.Middleware(handler)
+	// Errors answered before routing, e.g. the global rate limit, use the global pages
+	globalPages, err := errorPages(systemCfg.ErrorPageFiles(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	handler = globalPages(handler)
+	// The access log has the final status, error pages included
+	if accessLog != nil {
+		handler = accessLog.Middleware(handler)
+	}
+	// Recording sees every request as the client sent it, including rejected ones
+	if recorder != nil {
+		handler = recorder.Middleware(handler)
+	}
+	utils.Debug("built handler with %d routes", len(systemCfg.Routes))
+	return handler, fetchers, nil
+}
+
+// parseUpstreamURL parses an upstream URL. Requests to unix:// upstreams are plain HTTP sent to
+// localhost, the client dials the socket.
+func parseUpstreamURL(rawURL string) (*url.URL, error) {
+	if listener.IsUnix(rawURL) {
+		return &url.URL{Scheme: "http", Host: "localhost"}, nil
+	}
+	return url.Parse(rawURL)
+}
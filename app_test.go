@@ -0,0 +1,262 @@
+package revproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/config"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestConfig(t *testing.T, args ...string) *config.SystemCfg {
+	t.Helper()
+	systemCfg, err := config.Load(append([]string{"-upstream", "http://127.0.0.1:9"}, args...), nil)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return systemCfg
+}
+
+func TestApp_ReloadKeepsCache(t *testing.T) {
+	a, err := New(loadTestConfig(t, "-cache-enabled=true"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a.cache.Set("warm", &proxy.CachedResponse{Status: 200})
+	before := a.cache
+
+	// Routes and limits only, the warm cache is reused
+	reloaded := loadTestConfig(t, "-cache-enabled=true")
+	reloaded.RateLimitCfg.Enabled = true
+	reloaded.RateLimitCfg.GlobalRPS = 10
+	assert.NoError(t, a.Reload(reloaded))
+	assert.Same(t, before, a.cache)
+	_, ok := a.cache.Get("warm")
+	assert.True(t, ok)
+
+	// Cache settings changed, a fresh cache is built
+	assert.NoError(t, a.Reload(loadTestConfig(t, "-cache-enabled=true", "-cache-capacity=10")))
+	assert.NotSame(t, before, a.cache)
+	_, ok = a.cache.Get("warm")
+	assert.False(t, ok)
+}
+
+func TestApp_ReloadErrorKeepsRunningConfig(t *testing.T) {
+	a, err := New(loadTestConfig(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	running := a.cfg
+
+	broken := loadTestConfig(t)
+	broken.Upstreams[0].URL = "http://[::1"
+	assert.Error(t, a.Reload(broken))
+	assert.Same(t, running, a.cfg)
+}
+
+// Test the library entry point builds a routed proxy from options alone
+func TestNew_Options(t *testing.T) {
+	upstream := func(body string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	p, err := New(nil,
+		WithUpstream("app", upstream("app")),
+		WithUpstream("api", upstream("api")),
+		WithRoute("api", "api", "", "/api"),
+		WithCache(10, 60),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	for path, want := range map[string]string{"/": "app", "/api/users": "api"} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, want, rec.Body.String(), path)
+	}
+
+	_, err = New(nil, WithRoute("api", "missing", "", "/api"))
+	assert.Error(t, err)
+}
+
+// Test upstream failures render the route's error page, unrouted ones the global page
+func TestNew_ErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	global, api := filepath.Join(dir, "502.html"), filepath.Join(dir, "api-502.json")
+	assert.NoError(t, os.WriteFile(global, []byte(`<h1>{{.Status}} {{.Upstream}}</h1>`), 0o644))
+	assert.NoError(t, os.WriteFile(api, []byte(`{"error":"{{.Message}}","route":"{{.Route}}"}`), 0o644))
+
+	p, err := New(nil,
+		WithUpstream("app", "http://127.0.0.1:9"),
+		WithRoute("api", "app", "", "/api"),
+		WithConfig(func(c *Config) {
+			c.ErrorPages = map[string]string{"502": global}
+			c.Routes[0].ErrorPages = map[string]string{"502": api}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, `{"error":"upstream error","route":"api"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "<h1>502 app</h1>", rec.Body.String())
+}
+
+// Test embedders are told about upstream errors, cache evictions and reloads
+func TestProxy_Events(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+	p, err := New(nil,
+		WithUpstream("app", upstream.URL),
+		WithUpstream("down", "http://127.0.0.1:9"),
+		WithRoute("down", "down", "", "/down"),
+		WithCache(1, 60),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	var failed, evicted []string
+	var reloads []error
+	p.OnUpstreamError(func(r *http.Request, upstream *url.URL, err error) {
+		failed = append(failed, r.URL.Path+" "+upstream.Host)
+	})
+	p.OnCacheEvict(func(key string) {
+		evicted = append(evicted, key)
+	})
+	p.OnConfigReload(func(cfg *Config, err error) {
+		reloads = append(reloads, err)
+	})
+
+	for _, path := range []string{"/a", "/b", "/down"} {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	assert.Equal(t, []string{"/down 127.0.0.1:9"}, failed)
+	if assert.Len(t, evicted, 1) {
+		assert.Contains(t, evicted[0], "/a")
+	}
+
+	broken := *p.cfg
+	broken.Upstreams = slices.Clone(p.cfg.Upstreams)
+	broken.Upstreams[0].URL = "http://[::1"
+	assert.Error(t, p.Reload(&broken))
+	assert.NoError(t, p.Reload(p.cfg))
+	if assert.Len(t, reloads, 2) {
+		assert.Error(t, reloads[0])
+		assert.NoError(t, reloads[1])
+	}
+}
+
+// Test host partitions are only the configured hosts, other hosts share the default one
+func TestNew_HostPartitions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "page")
+	}))
+	defer server.Close()
+	p, err := New(nil, WithUpstream("app", server.URL), WithCache(10, 60), WithConfig(func(c *Config) {
+		c.CacheCfg.PartitionBy = "host"
+		c.CacheCfg.Partitions = map[string]int{"static.example.com": 5}
+	}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	for _, host := range []string{"static.example.com", "a.example.com", "b.example.com"} {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+	}
+	partitioned := p.cache.(*cache.Partitioned[string, *proxy.CachedResponse])
+	assert.Equal(t, []string{"default", "static.example.com"}, partitioned.Partitions())
+	shared, _ := partitioned.Partition("default")
+	assert.Equal(t, 2, shared.Len())
+}
+
+// Test refresh-ahead refetches an entry from the upstream of the route that cached it
+func TestNew_RefreshAheadRoutes(t *testing.T) {
+	var appHits, apiHits atomic.Int32
+	upstream := func(body string, hits *atomic.Int32) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			io.WriteString(w, body)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	p, err := New(nil,
+		WithUpstream("app", upstream("app", &appHits)),
+		WithUpstream("api", upstream("api", &apiHits)),
+		WithRoute("api", "api", "", "/api"),
+		WithCache(10, 60),
+		WithConfig(func(c *Config) { c.CacheCfg.RefreshAhead = 0.001 }), // after 60ms
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+		return rec.Body.String()
+	}
+	assert.Equal(t, "api", get())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "api", get()) // a hit, refreshed in the background
+	assert.Eventually(t, func() bool { return apiHits.Load() == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), appHits.Load())
+	assert.Equal(t, "api", get())
+}
+
+// Test a request the per-IP limit rejects doesn't spend a token of the global limit
+func TestNew_RateLimitOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	p, err := New(nil, WithUpstream("app", server.URL), WithConfig(func(c *Config) {
+		c.RateLimitCfg.Enabled = true
+		c.RateLimitCfg.GlobalRPS, c.RateLimitCfg.GlobalBurst = 0.001, 2
+		c.RateLimitCfg.PerIPRate, c.RateLimitCfg.PerIPBurst = 0.001, 1
+	}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	serve := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, serve("1.1.1.1:1000"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("1.1.1.1:1000"))
+	assert.Equal(t, http.StatusOK, serve("2.2.2.2:1000"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("3.3.3.3:1000"))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/bench"
+	"github.com/ashpect/revproxy/pkg/config"
+)
+
+// pathFlags collects the repeated -path flag.
+type pathFlags []string
+
+func (p *pathFlags) String() string     { return strings.Join(*p, ",") }
+func (p *pathFlags) Set(v string) error { *p = append(*p, v); return nil }
+
+// benchCommand drives load at the proxy the config describes, or straight at one of its upstreams
+// to compare against, and prints latency percentiles and the cache hit ratio.
+func benchCommand(args []string) int {
+	var target, direct, method string
+	var connections int
+	var rate float64
+	var duration time.Duration
+	var insecure bool
+	var paths pathFlags
+	systemCfg, err := config.Load(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&target, "target", "", "base URL to send requests to, the proxy's listenaddr by default")
+		fs.StringVar(&direct, "direct", "", "send requests to this upstream's URL instead of the proxy")
+		fs.IntVar(&connections, "connections", 10, "requests in flight, each over its own connection")
+		fs.Float64Var(&rate, "rps", 0, "requests per second across connections, 0 sends as fast as possible")
+		fs.DurationVar(&duration, "duration", 10*time.Second, "how long to send requests for")
+		fs.StringVar(&method, "method", "GET", "request method")
+		fs.Var(&paths, "path", "[weight:]path to request, repeatable, / by default")
+		fs.BoolVar(&insecure, "insecure", false, "skip verifying the target's TLS certificate")
+	})
+	var mix []bench.Path
+	if err == nil {
+		mix, err = bench.ParsePaths(paths)
+	}
+	transport := bench.NewTransport(connections)
+	if err == nil && target == "" {
+		target, err = benchTarget(systemCfg, direct, transport)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
+	}
+	if len(mix) == 0 {
+		mix = []bench.Path{{Path: "/", Weight: 1}}
+	}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "revproxy: sending %s requests to %s for %v over %d connections\n", method, target, duration, connections)
+	result, err := bench.Run(ctx, target,
+		bench.WithConnections(connections), bench.WithRate(rate), bench.WithDuration(duration),
+		bench.WithMethod(method), bench.WithPaths(mix), bench.WithTransport(transport))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
+	}
+	printBench(result)
+	return 0
+}
+
+// benchTarget returns the URL of the proxy's listener, or of the upstream named direct.
+func benchTarget(systemCfg *config.SystemCfg, direct string, transport *http.Transport) (string, error) {
+	if direct != "" {
+		for _, u := range systemCfg.Upstreams {
+			if u.Name != direct {
+				continue
+			}
+			if u.Discovery != nil {
+				return "", fmt.Errorf("upstream %s uses discovery, pass one of its backends as -target", direct)
+			}
+			return u.URL, nil
+		}
+		return "", fmt.Errorf("no upstream named %s", direct)
+	}
+
+	scheme := "http"
+	if systemCfg.TLSCfg.Enabled {
+		scheme = "https"
+	}
+	return localURL(scheme, systemCfg.ListenAddr, transport)
+}
+
+func printBench(result bench.Result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d in %v (%.1f/s), %d errors\n",
+		result.Requests, result.Elapsed.Round(time.Millisecond), result.Rate(), result.Errors)
+	var statuses []string
+	for _, status := range slices.Sorted(maps.Keys(result.Statuses)) {
+		statuses = append(statuses, fmt.Sprintf("%d: %d", status, result.Statuses[status]))
+	}
+	fmt.Fprintf(tw, "statuses\t%s\n", strings.Join(statuses, ", "))
+	fmt.Fprintf(tw, "cache hits\t%.1f%%\n", 100*result.HitRatio())
+	l := result.Latency
+	fmt.Fprintf(tw, "latency\tp50 %v, p90 %v, p99 %v, max %v\n", round(l.P50), round(l.P90), round(l.P99), round(l.Max))
+	tw.Flush()
+}
+
+// round keeps latencies readable, to the microsecond.
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/admin"
+	"github.com/ashpect/revproxy/pkg/config"
+	"github.com/ashpect/revproxy/pkg/proxy"
+)
+
+// headerFlags collects the repeated -header "Name: value" flag.
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+// cachectlActions are the cachectl actions, selected by the first argument.
+var cachectlActions = map[string]func(ctx context.Context, c *admin.Client, opts cachectlOptions) (any, error){
+	"keys":       listCacheKeys,
+	"entries":    listCacheEntries,
+	"stats":      showCacheStats,
+	"purge":      purgeCache,
+	"partitions": listPartitions,
+	"warm":       warmCache,
+	"status":     showStatus,
+}
+
+// cachectlOptions are the flags of the actions, each reading the ones it needs.
+type cachectlOptions struct {
+	sort, key, prefix, partition string
+	limit                        int
+	all                          bool
+}
+
+const cachectlUsage = "usage: revproxy cachectl keys|entries|stats|purge|partitions|warm|status [flags]"
+
+// cachectl calls the admin API of the proxy the config describes, so operators don't hand-craft
+// requests to it. Output is a table, or the API's JSON with -json.
+func cachectl(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, cachectlUsage)
+		return 2
+	}
+	action, ok := cachectlActions[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, cachectlUsage)
+		return 2
+	}
+
+	var adminURL, server string
+	var headers headerFlags
+	var asJSON bool
+	var opts cachectlOptions
+	systemCfg, err := config.Load(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&adminURL, "admin", "", "admin API URL, from [admin] listenaddr by default")
+		fs.StringVar(&server, "server", "", "name of the [[servers]] block to manage, the top-level server by default")
+		fs.Var(&headers, "header", `"Name: value" header sent with every call, repeatable`)
+		fs.BoolVar(&asJSON, "json", false, "print the API's JSON instead of a table")
+		fs.StringVar(&opts.sort, "sort", "hits", "entries: order by hits, size or age")
+		fs.IntVar(&opts.limit, "limit", 20, "entries: how many to list")
+		fs.StringVar(&opts.key, "key", "", "purge: the cache key to drop, with its slices")
+		fs.StringVar(&opts.prefix, "prefix", "", "purge: drop every key starting with it")
+		fs.StringVar(&opts.partition, "partition", "", "purge: drop every entry of this partition")
+		fs.BoolVar(&opts.all, "all", false, "purge: drop every entry")
+	})
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if err == nil && adminURL == "" {
+		adminURL, err = localURL("http", systemCfg.AdminCfg.ListenAddr, transport)
+	}
+	if server != "" {
+		adminURL = strings.TrimSuffix(adminURL, "/") + "/servers/" + url.PathEscape(server)
+	}
+	clientOpts := []admin.ClientOption{admin.WithHTTPClient(&http.Client{Transport: transport, Timeout: time.Minute})}
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		if !found && err == nil {
+			err = fmt.Errorf("header %q: want \"Name: value\"", header)
+		}
+		clientOpts = append(clientOpts, admin.WithHeader(strings.TrimSpace(name), strings.TrimSpace(value)))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), time.Minute)
+	defer stop()
+	out, err := action(ctx, admin.NewClient(adminURL, clientOpts...), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	printTable(out)
+	return 0
+}
+
+func listCacheKeys(ctx context.Context, c *admin.Client, _ cachectlOptions) (any, error) {
+	return c.Keys(ctx)
+}
+
+func listCacheEntries(ctx context.Context, c *admin.Client, opts cachectlOptions) (any, error) {
+	return c.Entries(ctx, opts.sort, opts.limit)
+}
+
+func showCacheStats(ctx context.Context, c *admin.Client, _ cachectlOptions) (any, error) {
+	return c.Stats(ctx)
+}
+
+func listPartitions(ctx context.Context, c *admin.Client, _ cachectlOptions) (any, error) {
+	return c.Partitions(ctx)
+}
+
+func warmCache(ctx context.Context, c *admin.Client, _ cachectlOptions) (any, error) {
+	return c.Warm(ctx)
+}
+
+func showStatus(ctx context.Context, c *admin.Client, _ cachectlOptions) (any, error) {
+	return c.Status(ctx)
+}
+
+// purgeCache drops what exactly one of -key, -prefix, -partition or -all selects.
+func purgeCache(ctx context.Context, c *admin.Client, opts cachectlOptions) (any, error) {
+	selected := 0
+	for _, set := range []bool{opts.key != "", opts.prefix != "", opts.partition != "", opts.all} {
+		if set {
+			selected++
+		}
+	}
+	if selected != 1 {
+		return nil, errors.New("purge takes one of -key, -prefix, -partition or -all")
+	}
+	switch {
+	case opts.key != "":
+		return admin.PurgeResult{Purged: 1}, c.PurgeKey(ctx, opts.key)
+	case opts.prefix != "":
+		purged, err := c.PurgePrefix(ctx, opts.prefix)
+		return admin.PurgeResult{Purged: purged}, err
+	case opts.partition != "":
+		return struct{}{}, c.PurgePartition(ctx, opts.partition)
+	default:
+		return struct{}{}, c.Invalidate(ctx)
+	}
+}
+
+// printTable prints an admin response as an aligned table.
+func printTable(out any) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	switch out := out.(type) {
+	case []admin.KeyInfo:
+		row(tw, "KEY", "STATUS", "SIZE")
+		for _, k := range out {
+			row(tw, k.Key, fmt.Sprint(k.Status), fmt.Sprint(k.Size))
+		}
+	case []admin.Entry:
+		row(tw, "KEY", "STATUS", "SIZE", "HITS", "STORED", "LAST ACCESS", "EXPIRES")
+		for _, e := range out {
+			row(tw, e.Key, fmt.Sprint(e.Status), fmt.Sprint(e.Size), fmt.Sprint(e.Hits),
+				formatTime(e.StoredAt), formatTime(e.LastAccess), formatTime(e.ExpiresAt))
+		}
+	case admin.CacheStats:
+		row(tw, "items", fmt.Sprint(out.Items))
+		row(tw, "bytes", fmt.Sprint(out.Bytes))
+		row(tw, "hits", fmt.Sprint(out.Hits))
+		row(tw, "misses", fmt.Sprint(out.Misses))
+		row(tw, "hit ratio", fmt.Sprintf("%.1f%%", 100*out.HitRatio))
+		row(tw, "evictions", fmt.Sprint(out.Evictions))
+		row(tw, "expirations", fmt.Sprint(out.Expirations))
+	case []admin.Partition:
+		row(tw, "PARTITION", "ITEMS", "BYTES", "HIT RATIO")
+		for _, p := range out {
+			row(tw, p.Name, fmt.Sprint(p.Items), fmt.Sprint(p.Bytes), fmt.Sprintf("%.1f%%", 100*p.HitRatio))
+		}
+	case admin.PurgeResult:
+		row(tw, "purged", fmt.Sprint(out.Purged))
+	case admin.Status:
+		row(tw, "BACKEND", "STATE", "FAILURES", "SINCE")
+		for _, backend := range slices.Sorted(maps.Keys(out.Upstreams)) {
+			health := out.Upstreams[backend]
+			row(tw, backend, health.State, fmt.Sprint(health.ConsecutiveFailures), formatTime(health.Since))
+		}
+	case proxy.WarmResult:
+		row(tw, "warmed", fmt.Sprintf("%d of %d", out.OK, out.Total))
+		for _, failed := range out.Failed {
+			row(tw, "failed", failed)
+		}
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// row writes cells as one tab separated line.
+func row(w io.Writer, cells ...string) {
+	fmt.Fprintln(w, strings.Join(cells, "\t"))
+}
@@ -1,65 +1,78 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
-	"net/url"
+	"os"
+	"strings"
 
-	"github.com/ashpect/revproxy/pkg/cache"
-	"github.com/ashpect/revproxy/pkg/client"
 	"github.com/ashpect/revproxy/pkg/config"
-	"github.com/ashpect/revproxy/pkg/proxy"
-	"github.com/ashpect/revproxy/pkg/utils"
 )
 
-func main() {
+// commands are the subcommands, selected by the first argument. Without one the proxy is served,
+// so "revproxy -config x.toml" keeps working.
+var commands = map[string]func(args []string) int{
+	"serve":    serve,
+	"validate": validate,
+	"replay":   replay,
+	"bench":    benchCommand,
+	"cachectl": cachectl,
+	"config":   configCommand,
+}
 
-	// Load configs
-	systemCfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("failed to load system config: %v", err)
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
 	}
-	proxyCfg := systemCfg.ProxyCfg
-	cacheCfg := systemCfg.CacheCfg
-	utils.Debug("config: %+v", systemCfg)
-
-	// Transport and client builders
-	transport := client.NewTransport(
-		client.WithMaxIdleConns(proxyCfg.MaxIdleConns),
-		client.WithMaxIdleConnsPerHost(proxyCfg.MaxIdleConnsPerHost),
-		client.WithIdleConnTimeout(proxyCfg.IdleConnTimeout),
-	)
-	client := client.NewClient(
-		client.WithTransport(transport),
-	)
+	os.Exit(serve(os.Args[1:]))
+}
 
-	upstreamURL, err := url.Parse(proxyCfg.UpstreamURL)
-	if err != nil {
-		log.Fatalf("invalid upstream URL: %v", err)
+// configCommand runs the config subcommands, only dump for now.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "dump" {
+		fmt.Fprintln(os.Stderr, "usage: revproxy config dump [-format toml|yaml|json] [flags]")
+		return 2
 	}
+	return dumpConfig(args[1:])
+}
 
-	// Cache builder
-	cache, err := cache.NewLRUTTL(
-		cache.WithCapacity[string, *proxy.CachedResponse](cacheCfg.CacheCapacity),
-		cache.WithDefaultTTL[string, *proxy.CachedResponse](cacheCfg.DefaultTTL),
-		cache.WithCleanupStart[string, *proxy.CachedResponse](true),
-	)
+// dumpConfig prints the fully merged config (defaults + file + env + flags), secrets redacted.
+func dumpConfig(args []string) int {
+	var format string
+	systemCfg, err := config.Load(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", "toml", "output format: toml, yaml or json")
+	})
+	if err == nil {
+		err = systemCfg.Dump(os.Stdout, format)
+	}
 	if err != nil {
-		log.Fatalf("failed to create cache: %v", err)
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
 	}
+	return 0
+}
 
-	// Proxyhandler builder
-	proxyHandler := proxy.NewProxy(upstreamURL, client, proxy.WithCache(cache))
-
-	// Initialize the server
-	server := &http.Server{
-		Addr:    systemCfg.ListenAddr,
-		Handler: proxyHandler,
+// localURL returns the base URL reaching listenAddr from this host, wildcard addresses becoming
+// localhost. A unix:// socket is dialed through transport.
+func localURL(scheme, listenAddr string, transport *http.Transport) (string, error) {
+	if socket, ok := strings.CutPrefix(listenAddr, "unix://"); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		return scheme + "://localhost", nil
 	}
-	utils.Log("reverse proxy listening on %s forwarding to %s", systemCfg.ListenAddr, upstreamURL.String())
-	utils.Log("server starting...")
-
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server error: %v", err)
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", fmt.Errorf("listenaddr: %w", err)
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
 	}
+	return scheme + "://" + net.JoinHostPort(host, port), nil
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the pidfile holds our pid and is removed on exit
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.pid")
+
+	remove, err := writePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+
+	remove()
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// Test a pidfile naming a live process is refused and left alone
+func TestWritePIDFile_live(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.pid")
+	live := strconv.Itoa(os.Getppid()) + "\n"
+	os.WriteFile(path, []byte(live), 0o644)
+
+	_, err := writePIDFile(path)
+	assert.ErrorContains(t, err, "process "+strconv.Itoa(os.Getppid())+" is running")
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, live, string(data))
+}
+
+// Test pidfiles left by a dead process, or unreadable ones, are replaced
+func TestWritePIDFile_stale(t *testing.T) {
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Skipf("no process to outlive: %v", err)
+	}
+
+	for _, content := range []string{strconv.Itoa(dead.Process.Pid), "garbage"} {
+		path := filepath.Join(t.TempDir(), "proxy.pid")
+		os.WriteFile(path, []byte(content+"\n"), 0o644)
+
+		remove, err := writePIDFile(path)
+		if assert.NoError(t, err, content) {
+			data, _ := os.ReadFile(path)
+			assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+			remove()
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+// Plugins compiled into revproxy. Each plugin package registers its middleware from init, so a
+// blank import here is all a custom build needs, e.g.
+//
+//	import _ "example.com/revproxy-auth"
+//
+// Routes then enable it by name in their middleware list. See package plugin.
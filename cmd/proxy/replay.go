@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ashpect/revproxy"
+	"github.com/ashpect/revproxy/pkg/config"
+	"github.com/ashpect/revproxy/pkg/record"
+)
+
+// replay re-issues recorded traffic through the proxy built from the config, against -target
+// instead of the configured upstreams when set, and reports the requests answered differently.
+func replay(args []string) int {
+	var file, target string
+	var speed float64
+	var concurrency int
+	systemCfg, err := config.Load(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&file, "file", "", "recording to replay, HAR or JSONL (required)")
+		fs.StringVar(&target, "target", "", "upstream URL every route is sent to instead of its own")
+		fs.Float64Var(&speed, "speed", 0, "pace relative to the recording, 0 sends as fast as possible")
+		fs.IntVar(&concurrency, "concurrency", 10, "requests in flight")
+	})
+	if err == nil && file == "" {
+		err = fmt.Errorf("-file is required")
+	}
+	var entries []record.Entry
+	if err == nil {
+		entries, err = readRecording(file)
+	}
+	var app *revproxy.Proxy
+	if err == nil {
+		app, err = revproxy.New(systemCfg, revproxy.WithConfig(func(c *revproxy.Config) {
+			// replayed traffic must not be recorded again, nor mixed into the access log
+			c.RecordCfg.Path = ""
+			c.AccessLogCfg.Path = ""
+			if target == "" {
+				return
+			}
+			for i := range c.Upstreams {
+				c.Upstreams[i].URL, c.Upstreams[i].Discovery = target, nil
+			}
+		}))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
+	}
+	defer app.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result := record.Replay(ctx, app, entries, record.WithSpeed(speed), record.WithConcurrency(concurrency))
+	for _, mismatch := range result.Mismatched {
+		fmt.Fprintln(os.Stderr, mismatch)
+	}
+	fmt.Fprintf(os.Stderr, "revproxy: replayed %d requests in %v, %d matched the recorded status\n",
+		result.Total, result.Elapsed.Round(time.Millisecond), result.Matched)
+	if len(result.Mismatched) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func readRecording(path string) ([]record.Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return record.Read(f)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ashpect/revproxy"
+	"github.com/ashpect/revproxy/pkg/config"
+	"github.com/ashpect/revproxy/pkg/discovery"
+	"github.com/ashpect/revproxy/pkg/listener"
+	"github.com/ashpect/revproxy/pkg/middleware"
+	"github.com/ashpect/revproxy/pkg/plugin"
+	"github.com/ashpect/revproxy/pkg/utils"
+)
+
+// serve runs the proxy until it fails or is stopped with SIGTERM, the default command.
+func serve(args []string) int {
+	// Load configs
+	var testOnly bool
+	var pidFile string
+	register := func(fs *flag.FlagSet) {
+		fs.BoolVar(&testOnly, "t", false, "test the configuration (resolve upstreams, load certs) and exit")
+		fs.StringVar(&pidFile, "pidfile", "", "write the process ID to this file while running")
+	}
+	systemCfg, err := config.Load(args, register)
+	if testOnly {
+		return testConfig(systemCfg, err)
+	}
+	if err != nil {
+		log.Fatalf("failed to load system config: %v", err)
+	}
+	if systemCfg.LogLevel != "" {
+		utils.SetLogLevel(systemCfg.LogLevel)
+	}
+	utils.Debug("config: %+v", systemCfg)
+
+	// One proxy per server, each with its own cache; the top-level one comes first
+	var instances []*instance
+	for _, serverCfg := range systemCfg.ServerConfigs() {
+		app, err := revproxy.New(serverCfg)
+		if err != nil {
+			log.Fatalf("%s%v", serverPrefix(serverCfg), err)
+		}
+		instances = append(instances, &instance{cfg: serverCfg, app: app})
+	}
+	primary := instances[0]
+
+	// SIGHUP reloads the config; routes, limits and upstreams are swapped in without dropping the cache
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			reloadCfg, err := config.Load(args, register)
+			if err == nil {
+				err = reload(instances, reloadCfg)
+			}
+			if err != nil {
+				log.Printf("reload failed, keeping running config: %v", err)
+				continue
+			}
+			if reloadCfg.LogLevel != "" {
+				utils.SetLogLevel(reloadCfg.LogLevel)
+			}
+			utils.Log("config reloaded")
+		}
+	}()
+
+	// SIGUSR1 reopens the access logs, e.g. from logrotate's postrotate
+	reopens := make(chan os.Signal, 1)
+	signal.Notify(reopens, syscall.SIGUSR1)
+	go func() {
+		for range reopens {
+			for _, inst := range instances {
+				if err := inst.app.ReopenLogs(); err != nil {
+					log.Printf("%sreopening logs failed: %v", serverPrefix(inst.cfg), err)
+				}
+			}
+			utils.Log("logs reopened")
+		}
+	}()
+
+	// Docker discovery adds routes for labelled containers as they come and go
+	if dockerCfg := systemCfg.DockerCfg; dockerCfg.Enabled {
+		dockerOpts := []discovery.DockerOption{discovery.WithDockerNetwork(dockerCfg.Network)}
+		if dockerCfg.Endpoint != "" {
+			dockerOpts = append(dockerOpts, discovery.WithDockerEndpoint(dockerCfg.Endpoint))
+		}
+		go discovery.NewDocker(dockerOpts...).Watch(context.Background(), primary.app.SetDockerServices)
+	}
+
+	// Sockets passed by systemd socket activation take precedence over binding ourselves
+	activated, err := listener.SystemdListeners()
+	if err != nil {
+		log.Fatalf("systemd socket activation error: %v", err)
+	}
+
+	// Admin server, kept on its own listener so it's never exposed with proxied traffic
+	var adminServer *http.Server
+	if systemCfg.AdminCfg.Enabled {
+		adminServer = newServer(systemCfg, adminHandler(instances))
+		adminListener, err := listen(activated, "admin", systemCfg.AdminCfg.ListenAddr)
+		if err != nil {
+			log.Fatalf("admin listen error: %v", err)
+		}
+		go func() {
+			utils.Log("admin listening on %s", systemCfg.AdminCfg.ListenAddr)
+			if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("admin server error: %v", err)
+			}
+		}()
+	}
+
+	// Initialize the servers, the blocks first so they claim their activated sockets by name
+	var serverTLS *tls.Config
+	if tlsCfg := systemCfg.TLSCfg; tlsCfg.Enabled {
+		tlsOpts := []listener.TLSOption{
+			listener.WithMinVersion(tlsCfg.MinVersion),
+			listener.WithALPN(tlsCfg.ALPN),
+			listener.WithClientCA(tlsCfg.ClientCAFile),
+		}
+		if tlsCfg.ACME.Enabled {
+			tlsOpts = append(tlsOpts, listener.WithACME(tlsCfg.ACME.Email, tlsCfg.ACME.Domains, tlsCfg.ACME.CacheDir, tlsCfg.ACME.DirectoryURL))
+		} else {
+			tlsOpts = append(tlsOpts, listener.WithCertificate(tlsCfg.CertFile, tlsCfg.KeyFile))
+		}
+		if serverTLS, err = listener.ServerTLSConfig(tlsOpts...); err != nil {
+			log.Fatalf("tls config error: %v", err)
+		}
+	}
+	for _, inst := range slices.Backward(instances) {
+		name := "proxy"
+		if inst != primary {
+			name = inst.cfg.ServerName
+		}
+		ln, err := listen(activated, name, inst.cfg.ListenAddr, listener.WithSocketMode(inst.cfg.SocketMode()))
+		if err != nil {
+			log.Fatalf("%slisten error: %v", serverPrefix(inst.cfg), err)
+		}
+		delete(activated, name)
+		// Balancers send the PROXY header ahead of TLS
+		if proxyCfg := inst.cfg.ProxyCfg; proxyCfg.ClientIP == "proxy-protocol" {
+			ln = listener.ProxyProtocol(ln, proxyCfg.TrustedNetworks())
+		}
+		inst.server = newServer(inst.cfg, inst.app)
+		inst.server.ConnState = inst.app.ConnState
+		if serverTLS != nil {
+			inst.server.TLSConfig = serverTLS
+			ln = tls.NewListener(ln, serverTLS)
+		}
+		inst.ln = ln
+		utils.Log("%sreverse proxy listening on %s forwarding to %s", serverPrefix(inst.cfg), ln.Addr(), inst.cfg.DefaultUpstream().URL)
+	}
+	if names := plugin.Names(); len(names) > 0 {
+		utils.Log("plugins compiled in: %s", strings.Join(names, ", "))
+	}
+	utils.Log("server starting...")
+
+	if pidFile != "" {
+		removePIDFile, err := writePIDFile(pidFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer removePIDFile()
+	}
+
+	// The listeners are bound, so connections queue from here on; tell systemd (Type=notify) we're up
+	if err := listener.Notify("READY=1"); err != nil {
+		log.Printf("sd_notify error: %v", err)
+	}
+
+	// Warm the caches in the background, requests go through the in-process handlers
+	for _, inst := range instances {
+		if warmCfg := inst.cfg.WarmCfg; warmCfg.OnStart && len(warmCfg.URLs) > 0 && inst.cfg.CacheCfg.Enabled {
+			go func() {
+				result := inst.app.Warm(context.Background())
+				utils.Log("%scache warming: %d/%d URLs fetched", serverPrefix(inst.cfg), result.OK, result.Total)
+				for _, failure := range result.Failed {
+					log.Printf("%scache warming failed: %s", serverPrefix(inst.cfg), failure)
+				}
+			}()
+		}
+	}
+
+	// SIGTERM (or Ctrl-C) stops accepting connections and lets requests in flight finish
+	stopping, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	served := make(chan error, len(instances))
+	for _, inst := range instances {
+		go func() {
+			if err := inst.server.Serve(inst.ln); err != nil && err != http.ErrServerClosed {
+				served <- fmt.Errorf("%s%w", serverPrefix(inst.cfg), err)
+			}
+		}()
+	}
+	select {
+	case err := <-served:
+		log.Printf("server error: %v", err)
+		return 1
+	case <-stopping.Done():
+	}
+	// a second signal kills the process instead of waiting for the requests
+	stop()
+	return shutdown(instances, adminServer, systemCfg.ServerCfg.ShutdownTimeout.Duration)
+}
+
+// instance is one of the proxies the process runs, see config.SystemCfg.ServerConfigs.
+type instance struct {
+	cfg    *config.SystemCfg
+	app    *revproxy.Proxy
+	server *http.Server
+	ln     net.Listener
+}
+
+// serverPrefix starts the log lines about the server of cfg, naming it once [[servers]] are
+// configured.
+func serverPrefix(cfg *config.SystemCfg) string {
+	if cfg.ServerName == "" {
+		return ""
+	}
+	return "server " + cfg.ServerName + ": "
+}
+
+// reload applies systemCfg to the running servers: the top-level one and the blocks still named
+// the same. Added and removed blocks take a restart.
+func reload(instances []*instance, systemCfg *config.SystemCfg) error {
+	serverCfgs := systemCfg.ServerConfigs()
+	running := map[string]*instance{}
+	for _, inst := range instances[1:] {
+		running[inst.cfg.ServerName] = inst
+	}
+	var errs []error
+	for i, serverCfg := range serverCfgs {
+		inst := instances[0]
+		if i > 0 {
+			var ok bool
+			if inst, ok = running[serverCfg.ServerName]; !ok {
+				log.Printf("reload: server %s starts on restart", serverCfg.ServerName)
+				continue
+			}
+			delete(running, serverCfg.ServerName)
+		}
+		if err := inst.app.Reload(serverCfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s%w", serverPrefix(serverCfg), err))
+		}
+	}
+	for name := range running {
+		log.Printf("reload: server %s keeps running until restart", name)
+	}
+	return errors.Join(errs...)
+}
+
+// adminHandler serves the top-level server's admin API, and each block's under /servers/{name}/.
+func adminHandler(instances []*instance) http.Handler {
+	if len(instances) == 1 {
+		return instances[0].app.Admin()
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", instances[0].app.Admin())
+	for _, inst := range instances[1:] {
+		prefix := "/servers/" + inst.cfg.ServerName
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, inst.app.Admin()))
+	}
+	return mux
+}
+
+// shutdown waits up to timeout for the requests in flight on every server, then releases the
+// proxies. Admin requests, like stats streams, aren't waited for. Returns the exit code.
+func shutdown(instances []*instance, adminServer *http.Server, timeout time.Duration) int {
+	utils.Log("shutting down, waiting up to %v for requests in flight", timeout)
+	if err := listener.Notify("STOPPING=1"); err != nil {
+		log.Printf("sd_notify error: %v", err)
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var cutOff atomic.Bool
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := inst.server.Shutdown(ctx); err != nil {
+				log.Printf("%sshutdown: requests still in flight were cut off: %v", serverPrefix(inst.cfg), err)
+				inst.server.Close()
+				cutOff.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, inst := range instances {
+		inst.app.Close()
+	}
+	utils.Log("server stopped")
+	if cutOff.Load() {
+		return 1
+	}
+	return 0
+}
+
+// newServer builds an http.Server with the [server] timeouts, limits and keep-alive settings.
+func newServer(systemCfg *config.SystemCfg, handler http.Handler) *http.Server {
+	serverCfg := systemCfg.ServerCfg
+	perConn := middleware.NewRequestsPerConn(serverCfg.MaxRequestsPerConn)
+	server := &http.Server{
+		Handler:           perConn.Middleware(handler),
+		ReadHeaderTimeout: serverCfg.ReadHeaderTimeout.Duration,
+		ReadTimeout:       serverCfg.ReadTimeout.Duration,
+		WriteTimeout:      serverCfg.WriteTimeout.Duration,
+		IdleTimeout:       serverCfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    serverCfg.MaxHeaderBytes,
+		ConnContext:       perConn.ConnContext,
+	}
+	server.SetKeepAlivesEnabled(!serverCfg.DisableKeepAlives)
+	return server
+}
+
+// listen returns the systemd activated socket named name, falling back to the only unclaimed
+// activated socket for the proxy, and otherwise binds addr.
+func listen(activated map[string]net.Listener, name, addr string, opts ...listener.ListenOption) (net.Listener, error) {
+	if ln, ok := activated[name]; ok {
+		return ln, nil
+	}
+	if name == "proxy" {
+		var unclaimed []net.Listener
+		for other, ln := range activated {
+			if other != "admin" {
+				unclaimed = append(unclaimed, ln)
+			}
+		}
+		if len(unclaimed) == 1 {
+			return unclaimed[0], nil
+		}
+	}
+	return listener.Listen(addr, opts...)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ashpect/revproxy"
+	"github.com/ashpect/revproxy/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// startInstance serves handler on a local port, returning the instance and its URL.
+func startInstance(t *testing.T, handler http.Handler) (*instance, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return &instance{cfg: &config.SystemCfg{}, app: &revproxy.Proxy{}, server: server, ln: ln}, "http://" + ln.Addr().String()
+}
+
+// Test shutdown exits 0 once idle, and 1 when the timeout cuts off requests in flight
+func TestShutdown(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	idle, _ := startInstance(t, http.NotFoundHandler())
+	assert.Equal(t, 0, shutdown([]*instance{idle}, nil, time.Second))
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	busy, url := startInstance(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	go http.Get(url)
+	<-entered
+
+	start := time.Now()
+	assert.Equal(t, 1, shutdown([]*instance{busy}, nil, 50*time.Millisecond))
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/config"
+)
+
+// validate loads the config, checks what it refers to (upstreams resolve, certificates and files
+// load) and prints a summary of what would be served. Problems exit non-zero.
+func validate(args []string) int {
+	systemCfg, err := config.Load(args, nil)
+	if err == nil {
+		err = checkConfig(systemCfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: configuration is invalid:\n%v\n", err)
+		return 1
+	}
+	if err := systemCfg.Summary(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// checkConfig runs the checks reaching out of the config, bounded so a hanging resolver can't
+// stall them.
+func checkConfig(systemCfg *config.SystemCfg) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return systemCfg.Check(ctx)
+}
+
+// testConfig reports configuration problems nginx -t style and returns the exit code.
+func testConfig(systemCfg *config.SystemCfg, loadErr error) int {
+	if loadErr == nil {
+		loadErr = checkConfig(systemCfg)
+	}
+	if loadErr != nil {
+		fmt.Fprintf(os.Stderr, "revproxy: configuration test failed:\n%v\n", loadErr)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "revproxy: configuration test is successful")
+	return 0
+}
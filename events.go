@@ -0,0 +1,75 @@
+package revproxy
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/proxy"
+)
+
+// events are the callbacks registered with the Proxy's On methods. They run synchronously where
+// the event happens, on the request path for upstream errors and cache evictions, so they should
+// be quick and hand slow work off to a goroutine.
+type events struct {
+	mu            sync.RWMutex
+	upstreamError []func(r *http.Request, upstream *url.URL, err error)
+	cacheEvict    []func(key string)
+	configReload  []func(cfg *Config, err error)
+}
+
+// OnUpstreamError calls fn when a request to upstream fails without a response (connection
+// refused, timeout, ...). Upstream error statuses are responses and don't count.
+func (p *Proxy) OnUpstreamError(fn func(r *http.Request, upstream *url.URL, err error)) {
+	p.events.mu.Lock()
+	defer p.events.mu.Unlock()
+	p.events.upstreamError = append(p.events.upstreamError, fn)
+}
+
+// OnCacheEvict calls fn with the key of each response evicted from the cache to make room. Keys
+// are the response URL, prefixed with the partition and a space when the cache is partitioned.
+func (p *Proxy) OnCacheEvict(fn func(key string)) {
+	p.events.mu.Lock()
+	defer p.events.mu.Unlock()
+	p.events.cacheEvict = append(p.events.cacheEvict, fn)
+}
+
+// OnConfigReload calls fn after each Reload with the config and the error it failed with, nil
+// when it was applied.
+func (p *Proxy) OnConfigReload(fn func(cfg *Config, err error)) {
+	p.events.mu.Lock()
+	defer p.events.mu.Unlock()
+	p.events.configReload = append(p.events.configReload, fn)
+}
+
+func (e *events) cacheEvicted(key string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, fn := range e.cacheEvict {
+		fn(key)
+	}
+}
+
+func (e *events) configReloaded(cfg *Config, err error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, fn := range e.configReload {
+		fn(cfg, err)
+	}
+}
+
+// CacheLookup implements proxy.Observer.
+func (e *events) CacheLookup(*http.Request, proxy.CacheResult) {}
+
+// UpstreamExchange implements proxy.Observer, reporting the failed exchanges.
+func (e *events) UpstreamExchange(r *http.Request, upstream *url.URL, _ int, err error, _ time.Duration) {
+	if err == nil {
+		return
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, fn := range e.upstreamError {
+		fn(r, upstream, err)
+	}
+}
@@ -0,0 +1,241 @@
+// Package accesslog writes a line per request to a file, in the combined log format or as JSON.
+// The file rotates itself past a size, and Reopen lets external tools like logrotate move it away
+// without restarting the proxy.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Logger writes the access log. Its methods are safe for concurrent use.
+type Logger struct {
+	format     string
+	maxSize    int64
+	maxBackups int
+	clientIP   func(r *http.Request) string
+	file       *file
+}
+
+type Option func(*Logger)
+
+// WithFormat sets the line format: "combined" (Apache/nginx combined log format, the default) or
+// "json".
+func WithFormat(format string) Option {
+	return func(l *Logger) {
+		l.format = format
+	}
+}
+
+// WithMaxSize rotates the file once a line would take it past size bytes, 0 (the default) never
+// rotates.
+func WithMaxSize(size int64) Option {
+	return func(l *Logger) {
+		l.maxSize = size
+	}
+}
+
+// WithMaxBackups keeps this many rotated files, path.1 being the newest. 0 drops the file on
+// rotation.
+func WithMaxBackups(n int) Option {
+	return func(l *Logger) {
+		l.maxBackups = n
+	}
+}
+
+// WithClientIP sets how the logged client IP is derived from a request. Defaults to the remote
+// address.
+func WithClientIP(fn func(r *http.Request) string) Option {
+	return func(l *Logger) {
+		l.clientIP = fn
+	}
+}
+
+// Open appends the access log to path, creating it when missing.
+func Open(path string, opts ...Option) (*Logger, error) {
+	l := &Logger{format: "combined", clientIP: remoteIP}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.format != "combined" && l.format != "json" {
+		return nil, fmt.Errorf("unknown access log format %q", l.format)
+	}
+	f, err := openFile(path, l.maxSize, l.maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+// Reopen closes and reopens the file, for logrotate's move-then-signal rotation.
+func (l *Logger) Reopen() error {
+	return l.file.Reopen()
+}
+
+func (l *Logger) Close() error {
+	return l.file.Close()
+}
+
+// Middleware logs the requests passing through it once they are answered, the ones sampled out by
+// their Route aside.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		routed := &routeRef{}
+		lw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			// logged even when the handler aborts, e.g. for a dropped upstream connection
+			if routed.route != nil && !routed.route.keep(lw.status, time.Since(start)) {
+				return
+			}
+			if _, err := l.file.Write(l.line(r, routed.route, lw, start)); err != nil {
+				log.Printf("access log: %v", err)
+			}
+		}()
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), routeKey{}, routed)))
+	})
+}
+
+// route is what a request's line depends on once it has been routed.
+type route struct {
+	name   string
+	sample uint64
+	slow   time.Duration
+	count  atomic.Uint64
+}
+
+// keep samples 1 in sample successful requests, error and slow responses are always kept.
+func (rt *route) keep(status int, elapsed time.Duration) bool {
+	if rt.sample <= 1 || status >= http.StatusBadRequest || (rt.slow > 0 && elapsed >= rt.slow) {
+		return true
+	}
+	return rt.count.Add(1)%rt.sample == 1
+}
+
+// routeRef is filled in by Route for Middleware to read once the request is answered.
+type routeRef struct {
+	route *route
+}
+
+type routeKey struct{}
+
+// Route names the route of the requests passing through it, in JSON lines, and logs only 1 in
+// sample of its successful requests. Responses with an error status or taking at least slow, when
+// set, are always logged. It wraps each route's handler, inside Middleware.
+func (l *Logger) Route(name string, sample int, slow time.Duration) func(http.Handler) http.Handler {
+	rt := &route{name: name, sample: uint64(max(sample, 1)), slow: slow}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ref, ok := r.Context().Value(routeKey{}).(*routeRef); ok {
+				ref.route = rt
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// entry is a JSON access log line.
+type entry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"durationMs"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Route     string    `json:"route,omitempty"`
+}
+
+func (l *Logger) line(r *http.Request, rt *route, lw *responseWriter, start time.Time) []byte {
+	status := lw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	remote := l.clientIP(r)
+
+	if l.format == "json" {
+		routeName := ""
+		if rt != nil {
+			routeName = rt.name
+		}
+		data, _ := json.Marshal(entry{
+			Time:      start,
+			Remote:    remote,
+			Method:    r.Method,
+			Host:      r.Host,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    status,
+			Bytes:     lw.bytes,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: r.Header.Get("X-Request-Id"),
+			Route:     routeName,
+		})
+		return append(data, '\n')
+	}
+
+	size := "-"
+	if lw.bytes > 0 {
+		size = strconv.FormatInt(lw.bytes, 10)
+	}
+	return fmt.Appendf(nil, "%s - - [%s] %q %d %s %q %q\n",
+		remote, start.Format("02/Jan/2006:15:04:05 -0700"), r.Method+" "+r.RequestURI+" "+r.Proto,
+		status, size, orDash(r.Referer()), orDash(r.UserAgent()))
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// responseWriter captures the status and counts the body bytes written through it.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streams.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,126 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(t *testing.T, l *Logger, target string) {
+	t.Helper()
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// Test requests are logged in the combined log format and as JSON
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, "/hello?x=1")
+	serve(t, l, "/missing")
+	l.Close()
+
+	lines := readLines(t, path)
+	if assert.Len(t, lines, 2) {
+		assert.Regexp(t, `^192\.0\.2\.1 - - \[[^\]]+\] "GET /hello\?x=1 HTTP/1\.1" 200 5 "-" "curl/8\.0"$`, lines[0])
+		assert.Contains(t, lines[1], `"GET /missing HTTP/1.1" 404 19`)
+	}
+
+	path = filepath.Join(t.TempDir(), "access.json")
+	l, err = Open(path, WithFormat("json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, "/hello")
+	l.Close()
+
+	var e entry
+	assert.NoError(t, json.Unmarshal([]byte(readLines(t, path)[0]), &e))
+	assert.Equal(t, "/hello", e.URI)
+	assert.Equal(t, http.StatusOK, e.Status)
+	assert.Equal(t, int64(5), e.Bytes)
+	assert.Equal(t, "curl/8.0", e.UserAgent)
+}
+
+// Test the log rotates past its max size keeping max backups, and reopens after being moved away
+func TestLogger_rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := Open(path, WithMaxSize(100), WithMaxBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for range 4 {
+		serve(t, l, "/hello") // ~80 bytes a line, one line per file
+	}
+	files, _ := filepath.Glob(path + "*")
+	assert.ElementsMatch(t, []string{path, path + ".1", path + ".2"}, files)
+	for _, f := range files {
+		assert.Len(t, readLines(t, f), 1, f)
+	}
+
+	assert.NoError(t, os.Rename(path, filepath.Join(dir, "moved.log")))
+	assert.NoError(t, l.Reopen())
+	serve(t, l, "/after")
+	assert.Contains(t, readLines(t, path)[0], "/after")
+	assert.Len(t, readLines(t, filepath.Join(dir, "moved.log")), 1)
+}
+
+// Test a sampled route logs 1 in N successful requests but every error and slow one
+func TestLogger_Route(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.json")
+	l, err := Open(path, WithFormat("json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := l.Middleware(l.Route("health", 3, 20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(30 * time.Millisecond)
+		}
+	})))
+	for _, target := range []string{"/1", "/2", "/3", "/4", "/error", "/slow", "/5", "/6", "/7"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	l.Close()
+
+	var logged []string
+	for _, line := range readLines(t, path) {
+		var e entry
+		assert.NoError(t, json.Unmarshal([]byte(line), &e))
+		assert.Equal(t, "health", e.Route)
+		logged = append(logged, e.URI)
+	}
+	assert.Equal(t, []string{"/1", "/4", "/error", "/slow", "/7"}, logged)
+}
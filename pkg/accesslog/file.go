@@ -0,0 +1,111 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// file is an append-only log file that rotates itself past a size and can be reopened after an
+// external rotation, e.g. by logrotate. Its methods are safe for concurrent use.
+type file struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// closed is set by Close, f is also nil after a failed rotation until the next open succeeds
+	closed bool
+}
+
+func openFile(path string, maxSize int64, maxBackups int) (*file, error) {
+	lf := &file{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *file) open() error {
+	f, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf.f, lf.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first when p would take the file past its max size.
+func (lf *file) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return 0, os.ErrClosed
+	}
+	if lf.f == nil {
+		if err := lf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxSize {
+		if err := lf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate %s: %w", lf.path, err)
+		}
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.1 to path.2 and so on, dropping the oldest beyond maxBackups, moves the file
+// to path.1 and starts a new one.
+func (lf *file) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return err
+	}
+	lf.f = nil
+	if lf.maxBackups == 0 {
+		os.Remove(lf.path)
+	} else {
+		for i := lf.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", lf.path, i), fmt.Sprintf("%s.%d", lf.path, i+1))
+		}
+		if err := os.Rename(lf.path, lf.path+".1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return lf.open()
+}
+
+// Reopen closes the file and opens path again, creating it when it was moved away.
+func (lf *file) Reopen() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return os.ErrClosed
+	}
+	if lf.f != nil {
+		lf.f.Close()
+		lf.f = nil
+	}
+	return lf.open()
+}
+
+func (lf *file) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	lf.closed = true
+	if lf.f == nil {
+		return nil
+	}
+	err := lf.f.Close()
+	lf.f = nil
+	return err
+}
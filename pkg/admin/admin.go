@@ -0,0 +1,350 @@
+package admin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/ashpect/revproxy/pkg/stats"
+)
+
+// admin serves operational endpoints on a separate listener from proxied traffic.
+type admin struct {
+	mux   *http.ServeMux
+	cache cache.Cache[string, *proxy.CachedResponse]
+	warm  func(ctx context.Context) proxy.WarmResult
+	stats *stats.Counters
+}
+
+type AdminOption func(*admin)
+
+func WithCache(c cache.Cache[string, *proxy.CachedResponse]) AdminOption {
+	return func(a *admin) {
+		a.cache = c
+	}
+}
+
+// WithWarmer serves POST /cache/warm, running warm and reporting its result.
+func WithWarmer(warm func(ctx context.Context) proxy.WarmResult) AdminOption {
+	return func(a *admin) {
+		a.warm = warm
+	}
+}
+
+// WithStats serves GET /stats/stream, the live rates of counters as server-sent events, and
+// GET /status, the health of the backends.
+func WithStats(counters *stats.Counters) AdminOption {
+	return func(a *admin) {
+		a.stats = counters
+	}
+}
+
+func NewAdmin(opts ...AdminOption) *admin {
+	a := &admin{
+		mux: http.NewServeMux(),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	a.mux.HandleFunc("GET /cache/keys", a.listKeys)
+	a.mux.HandleFunc("DELETE /cache/keys", a.purgeKey)
+	a.mux.HandleFunc("GET /cache/entries", a.listEntries)
+	a.mux.HandleFunc("GET /cache/stats", a.cacheStats)
+	a.mux.HandleFunc("DELETE /cache", a.invalidateCache)
+	a.mux.HandleFunc("POST /cache/warm", a.warmCache)
+	a.mux.HandleFunc("GET /cache/partitions", a.listPartitions)
+	a.mux.HandleFunc("DELETE /cache/partitions/{name}", a.purgePartition)
+	a.mux.HandleFunc("GET /stats/stream", a.streamStats)
+	a.mux.HandleFunc("GET /status", a.status)
+	return a
+}
+
+func (a *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// KeyInfo is a cached key as listed by GET /cache/keys.
+type KeyInfo struct {
+	Key    string `json:"key"`
+	Status int    `json:"status"`
+	Size   int    `json:"size"`
+}
+
+// listKeys lists cached keys, most recently used first.
+func (a *admin) listKeys(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache disabled", http.StatusNotFound)
+		return
+	}
+
+	keys := []KeyInfo{}
+	a.cache.Range(func(key string, value *proxy.CachedResponse) bool {
+		keys = append(keys, KeyInfo{Key: key, Status: value.Status, Size: value.Size()})
+		return true
+	})
+	writeJSON(w, keys)
+}
+
+// Entry is a cached entry as listed by GET /cache/entries.
+type Entry struct {
+	Key    string `json:"key"`
+	Status int    `json:"status"`
+	cache.EntryInfo
+}
+
+// entryOrders sort entries for GET /cache/entries?sort=, most relevant first.
+var entryOrders = map[string]func(a, b Entry) int{
+	"hits": func(a, b Entry) int { return cmp.Compare(b.Hits, a.Hits) },
+	"size": func(a, b Entry) int { return cmp.Compare(b.Size, a.Size) },
+	"age":  func(a, b Entry) int { return a.StoredAt.Compare(b.StoredAt) },
+}
+
+const defaultEntriesLimit = 20
+
+// listEntries lists the ?limit= (20) hottest entries, or the largest with ?sort=size and the
+// oldest with ?sort=age, with their hits, last access and expiry. Recency isn't affected.
+func (a *admin) listEntries(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache disabled", http.StatusNotFound)
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "hits"
+	}
+	order, ok := entryOrders[sortBy]
+	if !ok {
+		http.Error(w, "sort must be hits, size or age", http.StatusBadRequest)
+		return
+	}
+	limit := defaultEntriesLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	// Range holds the cache lock, so entries are inspected once it's done
+	statuses := map[string]int{}
+	a.cache.Range(func(key string, value *proxy.CachedResponse) bool {
+		statuses[key] = value.Status
+		return true
+	})
+	entries := []Entry{}
+	for key, status := range statuses {
+		if info, ok := a.cache.Inspect(key); ok { // unless expired or purged meanwhile
+			entries = append(entries, Entry{Key: key, Status: status, EntryInfo: info})
+		}
+	}
+	slices.SortFunc(entries, func(x, y Entry) int {
+		return cmp.Or(order(x, y), strings.Compare(x.Key, y.Key))
+	})
+	writeJSON(w, entries[:min(limit, len(entries))])
+}
+
+// purgeKey drops the entry of the ?key= cache key (as listed by GET /cache/keys) and its slices,
+// or with ?prefix= every entry whose key starts with it, reporting how many keys went.
+func (a *admin) purgeKey(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache disabled", http.StatusNotFound)
+		return
+	}
+
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		purged := proxy.PurgePrefix(a.cache, prefix)
+		log.Printf("cache keys with prefix %s purged: %d", prefix, purged)
+		writeJSON(w, PurgeResult{Purged: purged})
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key or prefix", http.StatusBadRequest)
+		return
+	}
+	if !proxy.Purge(a.cache, key) {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("cache key %s purged", key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeResult is the response of DELETE /cache/keys?prefix=.
+type PurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// invalidateCache drops every cached entry at once, e.g. after a bad deploy was cached.
+func (a *admin) invalidateCache(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache disabled", http.StatusNotFound)
+		return
+	}
+
+	a.cache.Invalidate()
+	log.Printf("cache invalidated")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CacheStats is the response of GET /cache/stats.
+type CacheStats struct {
+	cache.Stats
+	HitRatio float64 `json:"hitRatio"`
+}
+
+func (a *admin) cacheStats(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache disabled", http.StatusNotFound)
+		return
+	}
+
+	stats := a.cache.Stats()
+	writeJSON(w, CacheStats{Stats: stats, HitRatio: stats.HitRatio()})
+}
+
+// warmCache fetches the configured warm URLs through the proxy and reports the failures.
+func (a *admin) warmCache(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil || a.warm == nil {
+		http.Error(w, "cache warming not configured", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a.warm(r.Context()))
+}
+
+// Partition is a cache partition as listed by GET /cache/partitions.
+type Partition struct {
+	Name string `json:"name"`
+	CacheStats
+}
+
+// partitioned returns the cache when it is split into partitions.
+func (a *admin) partitioned(w http.ResponseWriter) (*cache.Partitioned[string, *proxy.CachedResponse], bool) {
+	partitioned, ok := a.cache.(*cache.Partitioned[string, *proxy.CachedResponse])
+	if !ok {
+		http.Error(w, "cache not partitioned", http.StatusNotFound)
+	}
+	return partitioned, ok
+}
+
+// listPartitions lists the cache partitions with their stats.
+func (a *admin) listPartitions(w http.ResponseWriter, r *http.Request) {
+	partitioned, ok := a.partitioned(w)
+	if !ok {
+		return
+	}
+
+	partitions := []Partition{}
+	for _, name := range partitioned.Partitions() {
+		c, ok := partitioned.Partition(name)
+		if !ok { // purged meanwhile
+			continue
+		}
+		stats := c.Stats()
+		partitions = append(partitions, Partition{Name: name, CacheStats: CacheStats{Stats: stats, HitRatio: stats.HitRatio()}})
+	}
+	writeJSON(w, partitions)
+}
+
+// purgePartition drops every entry of one partition.
+func (a *admin) purgePartition(w http.ResponseWriter, r *http.Request) {
+	partitioned, ok := a.partitioned(w)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	if !partitioned.Purge(name) {
+		http.Error(w, "partition not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("cache partition %s purged", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// streamStats sends a "stats" event with the rates over each ?interval= (1s by default) until the
+// client goes away, for dashboards watching the proxy live.
+func (a *admin) streamStats(w http.ResponseWriter, r *http.Request) {
+	if a.stats == nil {
+		http.Error(w, "stats disabled", http.StatusNotFound)
+		return
+	}
+	interval := time.Second
+	if raw := r.URL.Query().Get("interval"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 100*time.Millisecond {
+			http.Error(w, "interval must be a duration of at least 100ms", http.StatusBadRequest)
+			return
+		}
+		interval = parsed
+	}
+
+	rc := http.NewResponseController(w)
+	// the stream outlives the server's write timeout
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := a.stats.Snapshot()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		cur := a.stats.Snapshot()
+		data, err := json.Marshal(cur.Since(prev))
+		if err != nil {
+			log.Printf("error writing stats event: %v", err)
+			return
+		}
+		prev = cur
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Status is the response of GET /status.
+type Status struct {
+	// Upstreams is the health of the backends by address, the ones requests went to
+	Upstreams map[string]stats.UpstreamHealth `json:"upstreams"`
+}
+
+// status reports the backends' health, to watch failovers.
+func (a *admin) status(w http.ResponseWriter, r *http.Request) {
+	if a.stats == nil {
+		http.Error(w, "stats disabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, Status{Upstreams: a.stats.Health()})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing admin response: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/ashpect/revproxy/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the stats stream sends server-sent events with the rates
+func TestStreamStats(t *testing.T) {
+	var counters stats.Counters
+	server := httptest.NewServer(NewAdmin(WithStats(&counters)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stats/stream?interval=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stats/stream?interval=100ms", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	assert.True(t, lines.Scan())
+	assert.Equal(t, "event: stats", lines.Text())
+	assert.True(t, lines.Scan())
+	data, ok := strings.CutPrefix(lines.Text(), "data: ")
+	assert.True(t, ok)
+	var rates stats.Rates
+	assert.NoError(t, json.Unmarshal([]byte(data), &rates))
+	assert.NotNil(t, rates.Upstreams)
+}
+
+// Test the status reports each backend's health
+func TestStatus(t *testing.T) {
+	var counters stats.Counters
+	backend, _ := url.Parse("http://10.0.0.1:8080")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for range 3 {
+		counters.UpstreamExchange(r, backend, http.StatusBadGateway, nil, time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	NewAdmin(WithStats(&counters)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got Status
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "down", got.Upstreams["10.0.0.1:8080"].State)
+	assert.Equal(t, 3, got.Upstreams["10.0.0.1:8080"].ConsecutiveFailures)
+}
+
+// Test entries are listed hottest, largest or oldest first, without counting as hits
+func TestListEntries(t *testing.T) {
+	clock := cache.NewManualClock(time.Now())
+	c, err := cache.NewLRUTTL(cache.WithLazyExpiration[string, *proxy.CachedResponse](cache.DefaultSweepPerOp),
+		cache.WithClock[string, *proxy.CachedResponse](clock),
+		cache.WithSizer(func(_ string, resp *proxy.CachedResponse) int { return resp.Size() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("/old", &proxy.CachedResponse{Status: http.StatusOK, Body: []byte("a")})
+	clock.Advance(time.Minute)
+	c.Set("/large", &proxy.CachedResponse{Status: http.StatusOK, Body: []byte("abcdef")})
+	c.Set("/hot", &proxy.CachedResponse{Status: http.StatusMovedPermanently, Body: []byte("ab")})
+	c.Get("/hot")
+	c.Get("/hot")
+	c.Get("/large")
+	server := httptest.NewServer(NewAdmin(WithCache(c)))
+	defer server.Close()
+
+	list := func(query string) []string {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/cache/entries" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var entries []Entry
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+		keys := []string{}
+		for _, entry := range entries {
+			keys = append(keys, entry.Key)
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"/hot", "/large", "/old"}, list(""))
+	assert.Equal(t, []string{"/large", "/hot"}, list("?sort=size&limit=2"))
+	assert.Equal(t, []string{"/old"}, list("?sort=age&limit=1"))
+
+	info, ok := c.Inspect("/hot")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), info.Hits)
+	assert.Equal(t, 2, info.Size)
+
+	resp, err := http.Get(server.URL + "/cache/entries?sort=name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
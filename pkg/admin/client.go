@@ -0,0 +1,139 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/proxy"
+)
+
+// Client calls the admin API of a running proxy.
+type Client struct {
+	baseURL string
+	client  *http.Client
+	header  http.Header
+}
+
+type ClientOption func(*Client)
+
+// WithHeader sends a header with every call, e.g. the credentials of a proxy guarding the admin
+// listener.
+func WithHeader(name, value string) ClientOption {
+	return func(c *Client) {
+		c.header.Add(name, value)
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// NewClient returns a client of the admin API at baseURL, e.g. http://127.0.0.1:8001.
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	c := &Client{baseURL: strings.TrimSuffix(baseURL, "/"), client: http.DefaultClient, header: http.Header{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Keys lists the cached keys, most recently used first.
+func (c *Client) Keys(ctx context.Context) ([]KeyInfo, error) {
+	return call[[]KeyInfo](ctx, c, http.MethodGet, "/cache/keys", nil)
+}
+
+// Entries lists the limit entries first in sort order: hits, size or age.
+func (c *Client) Entries(ctx context.Context, sort string, limit int) ([]Entry, error) {
+	query := url.Values{"sort": {sort}, "limit": {strconv.Itoa(limit)}}
+	return call[[]Entry](ctx, c, http.MethodGet, "/cache/entries", query)
+}
+
+// Stats returns the cache stats.
+func (c *Client) Stats(ctx context.Context) (CacheStats, error) {
+	return call[CacheStats](ctx, c, http.MethodGet, "/cache/stats", nil)
+}
+
+// PurgeKey drops one cached key and its slices.
+func (c *Client) PurgeKey(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, "/cache/keys", url.Values{"key": {key}}, nil)
+}
+
+// PurgePrefix drops the cached keys starting with prefix and returns how many went.
+func (c *Client) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	result, err := call[PurgeResult](ctx, c, http.MethodDelete, "/cache/keys", url.Values{"prefix": {prefix}})
+	return result.Purged, err
+}
+
+// Invalidate drops every cached entry.
+func (c *Client) Invalidate(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/cache", nil, nil)
+}
+
+// Partitions lists the cache partitions with their stats.
+func (c *Client) Partitions(ctx context.Context) ([]Partition, error) {
+	return call[[]Partition](ctx, c, http.MethodGet, "/cache/partitions", nil)
+}
+
+// PurgePartition drops every entry of the partition name.
+func (c *Client) PurgePartition(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/cache/partitions/"+url.PathEscape(name), nil, nil)
+}
+
+// Warm fetches the configured warm URLs through the proxy.
+func (c *Client) Warm(ctx context.Context) (proxy.WarmResult, error) {
+	return call[proxy.WarmResult](ctx, c, http.MethodPost, "/cache/warm", nil)
+}
+
+// Status returns the health of the backends.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	return call[Status](ctx, c, http.MethodGet, "/status", nil)
+}
+
+// call calls path and decodes the JSON response into a T.
+func call[T any](ctx context.Context, c *Client, method, path string, query url.Values) (T, error) {
+	var out T
+	err := c.do(ctx, method, path, query, &out)
+	return out, err
+}
+
+// do calls path and decodes the JSON response into out, unless nil. Responses other than 2xx are
+// returned as errors carrying the admin's message.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, out any) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
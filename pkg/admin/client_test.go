@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the client lists, purges by key and prefix and reports admin errors, sending its headers
+func TestClient(t *testing.T) {
+	c, err := cache.NewLRUTTL[string, *proxy.CachedResponse]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/static/a.css", "/static/b.js", "/static/b.js#slice=0", "/index.html"} {
+		c.Set(key, &proxy.CachedResponse{Status: http.StatusOK, Body: []byte("body")})
+	}
+	handler := NewAdmin(WithCache(c))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	_, err = NewClient(server.URL).Keys(ctx)
+	assert.ErrorContains(t, err, "401 Unauthorized: unauthorized")
+
+	client := NewClient(server.URL+"/", WithHeader("Authorization", "Bearer token"))
+	keys, err := client.Keys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 4)
+
+	purged, err := client.PurgePrefix(ctx, "/static/")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+	assert.NoError(t, client.PurgeKey(ctx, "/index.html"))
+	assert.ErrorContains(t, client.PurgeKey(ctx, "/index.html"), "key not found")
+
+	stats, err := client.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, stats.Items)
+	_, err = client.Partitions(ctx)
+	assert.ErrorContains(t, err, "cache not partitioned")
+}
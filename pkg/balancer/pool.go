@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoBackends is returned when a pool has no backends to pick from, e.g. before discovery has
+// found any or while every instance fails its health checks. Proxies answer it with 503 and a
+// Retry-After, see proxy.Unavailable.
+var ErrNoBackends error = &unavailableError{"no backends available", "no_backends"}
+
+// noBackendsRetryAfter is the wait suggested to clients while a pool has no backends, about the
+// time for discovery to notice new instances.
+const noBackendsRetryAfter = 5 * time.Second
+
+// unavailableError implements proxy.Unavailable.
+type unavailableError struct {
+	message, code string
+}
+
+func (e *unavailableError) Error() string             { return e.message }
+func (e *unavailableError) Code() string              { return e.code }
+func (e *unavailableError) RetryAfter() time.Duration { return noBackendsRetryAfter }
+
+// Pool is a set of interchangeable backends picked round-robin. The set can be replaced at any
+// time (e.g. by service discovery) without blocking requests.
+type Pool struct {
+	name     string
+	backends atomic.Pointer[[]*url.URL]
+	next     atomic.Uint64
+}
+
+func NewPool(name string, backends ...*url.URL) *Pool {
+	p := &Pool{name: name}
+	p.Set(backends)
+	return p
+}
+
+// Name returns the upstream the pool serves.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Set replaces the backends.
+func (p *Pool) Set(backends []*url.URL) {
+	backends = append([]*url.URL{}, backends...)
+	p.backends.Store(&backends)
+}
+
+// Backends returns the current backends.
+func (p *Pool) Backends() []*url.URL {
+	return *p.backends.Load()
+}
+
+// Next picks the backend for r.
+func (p *Pool) Next(r *http.Request) (*url.URL, error) {
+	backends := p.Backends()
+	if len(backends) == 0 {
+		return nil, ErrNoBackends
+	}
+	return backends[(p.next.Add(1)-1)%uint64(len(backends))], nil
+}
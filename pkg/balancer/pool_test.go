@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool_RoundRobin(t *testing.T) {
+	a, _ := url.Parse("http://10.0.0.1:8080")
+	b, _ := url.Parse("http://10.0.0.2:8080")
+	pool := NewPool("api", a, b)
+
+	var picked []string
+	for range 3 {
+		u, err := pool.Next(nil)
+		assert.NoError(t, err)
+		picked = append(picked, u.Host)
+	}
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.1:8080"}, picked)
+}
+
+func TestPool_Empty(t *testing.T) {
+	pool := NewPool("api")
+	_, err := pool.Next(nil)
+	assert.ErrorIs(t, err, ErrNoBackends)
+	if unavailable, ok := err.(proxy.Unavailable); assert.True(t, ok) {
+		assert.Equal(t, "no_backends", unavailable.Code())
+		assert.Positive(t, unavailable.RetryAfter())
+	}
+
+	u, _ := url.Parse("http://10.0.0.3")
+	pool.Set([]*url.URL{u})
+	got, err := pool.Next(nil)
+	assert.NoError(t, err)
+	assert.Same(t, u, got)
+}
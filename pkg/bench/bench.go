@@ -0,0 +1,287 @@
+// Package bench drives HTTP load against a proxy or upstream and reports latency percentiles and
+// how much of it was answered from cache.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Path is requested in proportion to its Weight among the paths of a run.
+type Path struct {
+	Path   string
+	Weight int
+}
+
+// ParsePaths parses "[weight:]path" specs, the weight defaulting to 1. Paths start with / and may
+// carry a query.
+func ParsePaths(specs []string) ([]Path, error) {
+	paths := make([]Path, 0, len(specs))
+	for _, spec := range specs {
+		p := Path{Path: spec, Weight: 1}
+		if weight, path, found := strings.Cut(spec, ":"); found && !strings.HasPrefix(spec, "/") {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("path %q: weight must be a positive integer", spec)
+			}
+			p = Path{Path: path, Weight: w}
+		}
+		if !strings.HasPrefix(p.Path, "/") {
+			return nil, fmt.Errorf("path %q: must start with /", spec)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// Result summarizes a run. Requests failing without a response count as Errors, every response
+// counts in Statuses, whatever its status.
+type Result struct {
+	Requests int
+	Errors   int
+	Statuses map[int]int
+	// CacheHits are the responses carrying an Age header, which the proxy sets on responses
+	// served from its cache
+	CacheHits int
+	Latency   Latencies
+	Elapsed   time.Duration
+}
+
+// Latencies are percentiles of the time from sending a request to reading the last byte of its
+// response, over the requests answered.
+type Latencies struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// HitRatio is the share of responses answered from cache, 0 without responses.
+func (r Result) HitRatio() float64 {
+	answered := r.Requests - r.Errors
+	if answered == 0 {
+		return 0
+	}
+	return float64(r.CacheHits) / float64(answered)
+}
+
+// Rate is the requests completed per second.
+func (r Result) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+type runner struct {
+	connections int
+	rate        float64
+	duration    time.Duration
+	method      string
+	paths       []Path
+	transport   http.RoundTripper
+}
+
+type Option func(*runner)
+
+// WithConnections sets the requests in flight, each over its own connection, 10 by default.
+func WithConnections(connections int) Option {
+	return func(r *runner) {
+		r.connections = connections
+	}
+}
+
+// WithRate paces requests to rate per second across all connections. 0, the default, sends as
+// fast as the connections allow.
+func WithRate(rate float64) Option {
+	return func(r *runner) {
+		r.rate = rate
+	}
+}
+
+// WithDuration sets how long requests are sent for, 10s by default. Requests in flight when it
+// ends are waited for.
+func WithDuration(duration time.Duration) Option {
+	return func(r *runner) {
+		r.duration = duration
+	}
+}
+
+// WithMethod sets the request method, GET by default.
+func WithMethod(method string) Option {
+	return func(r *runner) {
+		r.method = method
+	}
+}
+
+// WithPaths sets the weighted paths requests pick from, "/" by default.
+func WithPaths(paths []Path) Option {
+	return func(r *runner) {
+		r.paths = paths
+	}
+}
+
+// WithTransport replaces the transport, NewTransport sized for the connections by default.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(r *runner) {
+		r.transport = transport
+	}
+}
+
+// NewTransport returns a transport keeping up to connections connections open to a host, so each
+// connection of a run reuses its own.
+func NewTransport(connections int) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = connections
+	transport.MaxConnsPerHost = connections
+	return transport
+}
+
+// Run sends requests to target, a base URL the paths are appended to, until the duration passes or
+// ctx is done, and returns what came back. Redirects aren't followed.
+func Run(ctx context.Context, target string, opts ...Option) (Result, error) {
+	r := &runner{connections: 10, duration: 10 * time.Second, method: http.MethodGet, paths: []Path{{Path: "/", Weight: 1}}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.connections < 1 {
+		return Result{}, fmt.Errorf("connections must be at least 1")
+	}
+	if len(r.paths) == 0 {
+		return Result{}, fmt.Errorf("no paths to request")
+	}
+	for _, p := range r.paths {
+		if p.Weight < 1 {
+			return Result{}, fmt.Errorf("path %s: weight must be at least 1", p.Path)
+		}
+	}
+	if r.transport == nil {
+		r.transport = NewTransport(r.connections)
+	}
+	client := &http.Client{
+		Transport:     r.transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	target = strings.TrimSuffix(target, "/")
+	pick := r.picker()
+
+	runCtx, cancel := context.WithTimeout(ctx, r.duration)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var sent atomic.Int64
+	result := Result{Statuses: map[int]int{}}
+	var latencies []time.Duration
+	start := time.Now()
+
+	for range r.connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var own []time.Duration
+			local := Result{Statuses: map[int]int{}}
+			for r.pace(runCtx, start, sent.Add(1)-1) == nil {
+				// requests outlive the run so the last ones aren't cut off and counted as errors
+				status, hit, elapsed, err := r.send(ctx, client, target+pick())
+				local.Requests++
+				if err != nil {
+					local.Errors++
+					continue
+				}
+				local.Statuses[status]++
+				if hit {
+					local.CacheHits++
+				}
+				own = append(own, elapsed)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result.Requests += local.Requests
+			result.Errors += local.Errors
+			result.CacheHits += local.CacheHits
+			for status, n := range local.Statuses {
+				result.Statuses[status] += n
+			}
+			latencies = append(latencies, own...)
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+	result.Latency = percentiles(latencies)
+	return result, nil
+}
+
+// picker returns a function choosing paths in proportion to their weights.
+func (r *runner) picker() func() string {
+	total := 0
+	for _, p := range r.paths {
+		total += p.Weight
+	}
+	return func() string {
+		roll := rand.IntN(total)
+		for _, p := range r.paths {
+			if roll < p.Weight {
+				return p.Path
+			}
+			roll -= p.Weight
+		}
+		return r.paths[len(r.paths)-1].Path
+	}
+}
+
+// pace waits until the nth request is due at the configured rate, or returns ctx's error when the
+// run is over first.
+func (r *runner) pace(ctx context.Context, start time.Time, n int64) error {
+	if r.rate <= 0 {
+		return ctx.Err()
+	}
+	delay := time.Until(start.Add(time.Duration(float64(n) / r.rate * float64(time.Second))))
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *runner) send(ctx context.Context, client *http.Client, url string) (int, bool, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, url, nil)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	sentAt := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, false, 0, err
+	}
+	return resp.StatusCode, resp.Header.Get("Age") != "", time.Since(sentAt), nil
+}
+
+// percentiles picks nearest-rank percentiles out of latencies, sorting it.
+func percentiles(latencies []time.Duration) Latencies {
+	if len(latencies) == 0 {
+		return Latencies{}
+	}
+	slices.Sort(latencies)
+	at := func(q float64) time.Duration {
+		return latencies[max(int(math.Ceil(q*float64(len(latencies))))-1, 0)]
+	}
+	return Latencies{P50: at(0.50), P90: at(0.90), P99: at(0.99), Max: latencies[len(latencies)-1]}
+}
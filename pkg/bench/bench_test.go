@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test a run spreads requests over the weighted paths, paces them and counts cached responses
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/cached":
+			w.Header().Set("Age", "3")
+		case "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	paths, err := ParsePaths([]string{"3:/cached", "/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Run(context.Background(), server.URL+"/", WithPaths(paths), WithConnections(4),
+		WithRate(200), WithDuration(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.InDelta(t, 100, result.Requests, 15)
+	assert.Zero(t, result.Errors)
+	assert.Equal(t, result.Requests, result.Statuses[http.StatusOK]+result.Statuses[http.StatusNotFound])
+	assert.Equal(t, result.Statuses[http.StatusOK], result.CacheHits)
+	assert.Equal(t, seen["/cached"], result.CacheHits)
+	assert.InDelta(t, 0.75, result.HitRatio(), 0.15)
+	assert.Positive(t, result.Latency.P50)
+	assert.LessOrEqual(t, result.Latency.P50, result.Latency.P99)
+	assert.LessOrEqual(t, result.Latency.P99, result.Latency.Max)
+}
+
+// Test path specs parse with their weights and bad ones are rejected
+func TestParsePaths(t *testing.T) {
+	paths, err := ParsePaths([]string{"/", "5:/search?q=a:b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Path{{Path: "/", Weight: 1}, {Path: "/search?q=a:b", Weight: 5}}, paths)
+
+	for _, spec := range []string{"api", "0:/a", "x:/a", "2:api"} {
+		_, err := ParsePaths([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+// Test percentiles are picked by nearest rank
+func TestPercentiles(t *testing.T) {
+	assert.Equal(t, Latencies{P50: 2, P90: 10, P99: 10, Max: 10}, percentiles([]time.Duration{10, 2, 5, 1}))
+	assert.Equal(t, Latencies{}, percentiles(nil))
+}
@@ -1,24 +1,62 @@
 package cache
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNegativeTTL is returned by SetWithTTL for a ttl below 0.
+var ErrNegativeTTL = errors.New("ttl must be >= 0")
+
 type Cache[K comparable, V any] interface {
 	// Get returns the value for key and true if present (and not expired).
 	Get(key K) (V, bool)
 
+	// GetWithExpiry returns the value with its absolute expiry (zero time means no expiry).
+	// Unlike Get it doesn't affect recency, so it's safe for Age/revalidation bookkeeping.
+	GetWithExpiry(key K) (V, time.Time, bool)
+
+	// Inspect returns the metadata of key's entry without affecting recency, false if absent or expired.
+	Inspect(key K) (EntryInfo, bool)
+
 	// Set stores the value for key using the cache's default TTL (if any).
 	Set(key K, value V)
 
-	// SetWithTTL stores the value for key with a custom ttl (ttl > 0).
-	SetWithTTL(key K, value V, ttlSeconds int)
+	// SetWithTTL stores the value for key with a custom ttl (ttl >= 0, 0 means no expiry).
+	// Negative ttls are rejected with ErrNegativeTTL.
+	SetWithTTL(key K, value V, ttlSeconds int) error
+
+	// GetOrLoad returns the value for key, calling loader on a miss and storing what it returns.
+	// Concurrent misses on a key share one loader call and its result. loader's ttlSeconds > 0
+	// stores with that ttl, 0 with the default TTL and < 0 hands the value back without storing.
+	// ctx bounds waiting for another caller's loader; the loader itself runs to completion.
+	GetOrLoad(ctx context.Context, key K, loader func() (V, int, error)) (V, error)
 
 	// Delete removes the key from the cache.
 	Delete(key K)
 
+	// Invalidate drops every entry at once, without walking them on large caches.
+	Invalidate()
+
+	// GetMany returns the present (non-expired) values for keys in one operation.
+	GetMany(keys []K) map[K]V
+
+	// SetMany stores all entries using the cache's default TTL in one operation.
+	SetMany(entries map[K]V)
+
 	// Len returns the number of items currently stored (non-expired items). May trigger lazy eviction.
 	Len() int
 
 	// GetAll returns a copy of all the cache contents (non-expired items).
 	GetAll() map[K]V
 
+	// Range calls fn for each non-expired item until fn returns false, without copying the cache.
+	Range(fn func(key K, value V) bool)
+
+	// Stats returns a snapshot of hit/miss/eviction counters and current usage.
+	Stats() Stats
+
 	//// TTL Specific ////
 
 	// StartCleanupDaemon starts a background cleanup cronjob that periodically removes expired entries.
@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -51,9 +55,48 @@ func TestLRUTTL_GET_SET_DELETE(t *testing.T) {
 
 // TODO : Add the builder tests with options to cover their unit tests
 
+// Test invalid options are all reported instead of panicking
+func TestNewLRUTTL_invalidOptions(t *testing.T) {
+	_, err := NewLRUTTL(
+		WithCapacity[string, int](0),
+		WithDefaultTTL[string, int](-1),
+		WithCleanupInterval[string, int](0),
+		WithRefreshAhead[string, int](0.5, nil),
+		WithClock[string, int](nil),
+		WithEvictionPolicy[string, int](nil),
+	)
+	assert.ErrorContains(t, err, "capacity must be > 0, got 0")
+	assert.ErrorContains(t, err, "default TTL must be >= 0, got -1")
+	assert.ErrorContains(t, err, "cleanup interval must be > 0, got 0")
+	assert.ErrorContains(t, err, "refresh loader is required")
+	assert.ErrorContains(t, err, "clock is required")
+	assert.ErrorContains(t, err, "eviction policy is required")
+
+	_, err = NewLRUTTL(WithLazyExpiration[string, int](0), WithRefreshAhead[string, int](1, func(string) (int, int, error) { return 0, 0, nil }))
+	assert.ErrorContains(t, err, "sweep per operation must be > 0, got 0")
+	assert.ErrorContains(t, err, "refresh threshold must be between 0 and 1, got 1")
+}
+
+// Test negative TTLs are rejected without storing anything
+func TestLRUTTL_SetWithTTL_negative(t *testing.T) {
+	cache, err := NewLRUTTL(WithLazyExpiration[string, int](DefaultSweepPerOp))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	assert.ErrorIs(t, cache.SetWithTTL("key1", 1, -1), ErrNegativeTTL)
+	assert.NoError(t, cache.SetWithTTL("key2", 2, 0))
+	_, ok := cache.Get("key1")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Len())
+
+	assert.ErrorIs(t, WithContext[string, int](cache).SetWithTTL(context.Background(), "key1", 1, -5), ErrNegativeTTL)
+}
+
 // Test TTL functionality
 func TestLRUTTL_ttl(t *testing.T) {
-	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](100),
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](100),
 		WithDefaultTTL[string, *CachedResponse](3),
 		WithCleanupInterval[string, *CachedResponse](2),
 		WithCleanupStart[string, *CachedResponse](true))
@@ -67,7 +110,7 @@ func TestLRUTTL_ttl(t *testing.T) {
 		Body:   []byte("body"),
 	}, 3)
 
-	time.Sleep(4 * time.Second)
+	clock.Advance(4 * time.Second)
 
 	_, ok := cache.Get_Exclusive("key1")
 	assert.False(t, ok)
@@ -121,7 +164,9 @@ func TestLRUTTL_eviction(t *testing.T) {
 
 // Test LRU + TTL
 func TestLRU_TTL(t *testing.T) {
-	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](3),
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](3),
 		WithDefaultTTL[string, *CachedResponse](3),
 		WithCleanupInterval[string, *CachedResponse](1),
 		WithCleanupStart[string, *CachedResponse](true),
@@ -168,8 +213,8 @@ func TestLRU_TTL(t *testing.T) {
 		CachedAt: time.Now(),
 	}, 100)
 
-	time.Sleep(4 * time.Second)
-	// Sleeps 5 seconds so key1 expires
+	clock.Advance(4 * time.Second)
+	// Advances 4 seconds so key1 expires
 
 	// Hence final remaining should be key2 and key4
 	_, ok = cache.Get_Exclusive("key1")
@@ -181,3 +226,568 @@ func TestLRU_TTL(t *testing.T) {
 	_, ok = cache.Get_Exclusive("key4")
 	assert.True(t, ok)
 }
+
+// Test the context-aware adapter surfaces done contexts as errors
+func TestLRUTTL_ctx(t *testing.T) {
+	lru, err := NewLRUTTL(WithCapacity[string, *CachedResponse](10),
+		WithCleanupStart[string, *CachedResponse](false))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	c := WithContext[string, *CachedResponse](lru)
+
+	ctx := context.Background()
+	assert.NoError(t, c.SetWithTTL(ctx, "key1", &CachedResponse{Status: 200}, 10))
+	value, ok, err := c.Get(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 200, value.Status)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, ok, err = c.Get(canceled, "key1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, ok)
+	assert.ErrorIs(t, c.Delete(canceled, "key1"), context.Canceled)
+
+	_, ok = lru.Get_Exclusive("key1")
+	assert.True(t, ok)
+}
+
+// Test expiry is reported alongside the value
+func TestLRUTTL_GetWithExpiry(t *testing.T) {
+	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](10),
+		WithCleanupStart[string, *CachedResponse](false))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	before := time.Now()
+	cache.SetWithTTL("key1", &CachedResponse{Status: 200}, 10)
+	cache.SetWithTTL("key2", &CachedResponse{Status: 200}, 0)
+
+	_, expiresAt, ok := cache.GetWithExpiry("key1")
+	assert.True(t, ok)
+	assert.WithinDuration(t, before.Add(10*time.Second), expiresAt, time.Second)
+
+	_, expiresAt, ok = cache.GetWithExpiry("key2")
+	assert.True(t, ok)
+	assert.True(t, expiresAt.IsZero())
+
+	_, _, ok = cache.GetWithExpiry("missing")
+	assert.False(t, ok)
+}
+
+// Test eviction and expiry callbacks fire with the removed entries
+func TestLRUTTL_callbacks(t *testing.T) {
+	var evicted, expired []string
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](1),
+		WithCleanupStart[string, *CachedResponse](false),
+		WithOnEvict(func(key string, _ *CachedResponse) { evicted = append(evicted, key) }),
+		WithOnExpire(func(key string, _ *CachedResponse) { expired = append(expired, key) }),
+	)
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	cache.SetWithTTL("key1", &CachedResponse{Status: 200}, 100)
+	cache.SetWithTTL("key2", &CachedResponse{Status: 200}, 1)
+	assert.Equal(t, []string{"key1"}, evicted)
+
+	clock.Advance(1100 * time.Millisecond)
+	_, ok := cache.Get("key2")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"key2"}, expired)
+}
+
+// Test batch get and set
+func TestLRUTTL_GetMany_SetMany(t *testing.T) {
+	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](10),
+		WithCleanupStart[string, *CachedResponse](false))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	cache.SetMany(map[string]*CachedResponse{
+		"key1": {Status: 200},
+		"key2": {Status: 404},
+	})
+	assert.Equal(t, 2, cache.Len())
+
+	values := cache.GetMany([]string{"key1", "key2", "missing"})
+	assert.Len(t, values, 2)
+	assert.Equal(t, 200, values["key1"].Status)
+	assert.Equal(t, 404, values["key2"].Status)
+}
+
+// Test Range walks entries most recent first and stops early
+func TestLRUTTL_Range(t *testing.T) {
+	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](10),
+		WithCleanupStart[string, *CachedResponse](false))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	cache.SetWithTTL("key1", &CachedResponse{Status: 200}, 100)
+	cache.SetWithTTL("key2", &CachedResponse{Status: 200}, 100)
+	cache.SetWithTTL("key3", &CachedResponse{Status: 200}, 100)
+
+	var keys []string
+	cache.Range(func(key string, _ *CachedResponse) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	assert.Equal(t, []string{"key3", "key2"}, keys)
+}
+
+// Test stats counters and byte usage
+func TestLRUTTL_Stats(t *testing.T) {
+	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](2),
+		WithCleanupStart[string, *CachedResponse](false),
+		WithSizer(func(_ string, v *CachedResponse) int { return len(v.Body) }),
+	)
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	cache.SetWithTTL("key1", &CachedResponse{Body: []byte("1234")}, 100)
+	cache.SetWithTTL("key2", &CachedResponse{Body: []byte("12")}, 100)
+	cache.Get("key1")
+	cache.Get("missing")
+	cache.SetWithTTL("key3", &CachedResponse{Body: []byte("1")}, 100) // evicts key2
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Items)
+	assert.Equal(t, int64(5), stats.Bytes)
+	assert.Equal(t, 0.5, stats.HitRatio())
+
+	cache.Delete("key1")
+	assert.Equal(t, int64(1), cache.Stats().Bytes)
+}
+
+// Test hot entries are refreshed in the background before expiring
+func TestLRUTTL_RefreshAhead(t *testing.T) {
+	loads := make(chan string, 1)
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](10),
+		WithCleanupStart[string, *CachedResponse](false),
+		WithRefreshAhead(0.5, func(key string) (*CachedResponse, int, error) {
+			loads <- key
+			return &CachedResponse{Status: 201}, 10, nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	cache.SetWithTTL("key1", &CachedResponse{Status: 200}, 2)
+	value, _ := cache.Get("key1")
+	assert.Equal(t, 200, value.Status)
+
+	clock.Advance(1100 * time.Millisecond)
+	value, _ = cache.Get("key1") // past half the TTL, so this hit triggers a refresh
+	assert.Equal(t, 200, value.Status)
+
+	select {
+	case key := <-loads:
+		assert.Equal(t, "key1", key)
+	case <-time.After(time.Second):
+		t.Fatal("refresh was not triggered")
+	}
+
+	assert.Eventually(t, func() bool {
+		value, ok := cache.Get_Exclusive("key1")
+		return ok && value.Status == 201
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Test cleanup removes only expired entries, including ones whose TTL was updated
+func TestLRUTTL_cleanupExpired(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](10),
+		WithCleanupStart[string, *CachedResponse](false))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	cache.SetWithTTL("key1", &CachedResponse{}, 1)
+	cache.SetWithTTL("key2", &CachedResponse{}, 100)
+	cache.SetWithTTL("key3", &CachedResponse{}, 0)
+	cache.SetWithTTL("key4", &CachedResponse{}, 100)
+	cache.SetWithTTL("key4", &CachedResponse{}, 1) // shortened
+	cache.SetWithTTL("key2", &CachedResponse{}, 1)
+	cache.SetWithTTL("key2", &CachedResponse{}, 0) // no expiry anymore
+
+	clock.Advance(1100 * time.Millisecond)
+	cache.cleanupExpired()
+
+	assert.Equal(t, 2, cache.Len())
+	_, ok := cache.Get_Exclusive("key2")
+	assert.True(t, ok)
+	_, ok = cache.Get_Exclusive("key3")
+	assert.True(t, ok)
+	assert.Len(t, cache.expiries, 0)
+}
+
+// Test the cleanup daemon can be stopped and restarted while running, e.g. by reloads (run with -race)
+func TestLRUTTL_CleanupDaemonRestart(t *testing.T) {
+	cache, err := NewLRUTTL(WithCapacity[string, int](10), WithCleanupStart[string, int](true))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	for range 100 {
+		cache.StopCleanupDaemon()
+		cache.StartCleanupDaemon()
+	}
+	cache.Close()
+	assert.False(t, cache.cleanupRunning)
+}
+
+// Test lazy mode reclaims expired entries on access without a daemon
+func TestLRUTTL_LazyExpiration(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](10),
+		WithLazyExpiration[string, *CachedResponse](1))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	assert.False(t, cache.cleanupRunning)
+
+	cache.SetWithTTL("key1", &CachedResponse{}, 1)
+	cache.SetWithTTL("key2", &CachedResponse{}, 1)
+	cache.SetWithTTL("key3", &CachedResponse{}, 100)
+
+	clock.Advance(1100 * time.Millisecond)
+	assert.Equal(t, 3, cache.Len())
+
+	cache.Get("key3") // sweeps one
+	assert.Equal(t, 2, cache.Len())
+	cache.Get("key3") // sweeps the other
+	assert.Equal(t, 1, cache.Len())
+	assert.Equal(t, uint64(2), cache.Stats().Expirations)
+}
+
+// Test Inspect reports hits, last access and expiry without touching recency or stats
+func TestLRUTTL_Inspect(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cache, err := NewLRUTTL(WithClock[string, *CachedResponse](clock),
+		WithCapacity[string, *CachedResponse](2),
+		WithCleanupStart[string, *CachedResponse](false),
+		WithSizer(func(_ string, value *CachedResponse) int { return len(value.Body) }))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	stored := clock.Now()
+	cache.SetWithTTL("key1", &CachedResponse{Body: []byte("body")}, 10)
+	cache.SetWithTTL("key2", &CachedResponse{}, 0)
+	clock.Advance(time.Second)
+	cache.Get("key1")
+	cache.GetMany([]string{"key1"})
+
+	info, ok := cache.Inspect("key1")
+	assert.True(t, ok)
+	assert.Equal(t, EntryInfo{
+		Size:       4,
+		Hits:       2,
+		StoredAt:   stored,
+		LastAccess: stored.Add(time.Second),
+		ExpiresAt:  stored.Add(10 * time.Second),
+	}, info)
+
+	// inspecting key2 doesn't make it recent, so it's still the one evicted
+	info, ok = cache.Inspect("key2")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), info.Hits)
+	assert.True(t, info.ExpiresAt.IsZero())
+	assert.Equal(t, uint64(2), cache.Stats().Hits)
+	cache.Set("key3", &CachedResponse{})
+	_, ok = cache.Inspect("key2")
+	assert.False(t, ok)
+
+	clock.Advance(10 * time.Second)
+	_, ok = cache.Inspect("key1")
+	assert.False(t, ok)
+}
+
+// Test concurrent misses share one load, and errors and negative ttls aren't stored
+func TestLRUTTL_GetOrLoad(t *testing.T) {
+	cache, err := NewLRUTTL(WithCapacity[string, int](10), WithCleanupStart[string, int](false))
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrLoad(context.Background(), "key1", func() (int, int, error) {
+				loads.Add(1)
+				<-release
+				return 1, 100, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 1, value)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+	value, ok := cache.Get_Exclusive("key1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	failure := errors.New("upstream down")
+	_, err = cache.GetOrLoad(context.Background(), "key2", func() (int, int, error) { return 0, 0, failure })
+	assert.ErrorIs(t, err, failure)
+	value, err = cache.GetOrLoad(context.Background(), "key2", func() (int, int, error) { return 2, -1, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+	_, ok = cache.Get_Exclusive("key2")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() {
+		cache.GetOrLoad(context.Background(), "key3", func() (int, int, error) { panic("boom") })
+	})
+
+	// a waiter stops waiting once its context is done, the load carries on for the others
+	loading, finish := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.GetOrLoad(context.Background(), "key4", func() (int, int, error) {
+			close(loading)
+			<-finish
+			return 4, 0, nil
+		})
+	}()
+	<-loading
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cache.GetOrLoad(ctx, "key4", func() (int, int, error) { return 0, 0, errors.New("not called") })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	close(finish)
+	<-done
+	value, ok = cache.Get_Exclusive("key4")
+	assert.True(t, ok)
+	assert.Equal(t, 4, value)
+	assert.Empty(t, cache.loading)
+}
+
+// Test invalidated entries are gone at once and reclaimed later without callbacks
+func TestLRUTTL_Invalidate(t *testing.T) {
+	var evicted, expired []string
+	cache, err := NewLRUTTL(WithCapacity[string, *CachedResponse](3),
+		WithCleanupStart[string, *CachedResponse](false),
+		WithSizer(func(_ string, value *CachedResponse) int { return len(value.Body) }),
+		WithOnEvict(func(key string, _ *CachedResponse) { evicted = append(evicted, key) }),
+		WithOnExpire(func(key string, _ *CachedResponse) { expired = append(expired, key) }),
+	)
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	cache.SetWithTTL("key1", &CachedResponse{Body: []byte("a")}, 100)
+	cache.SetWithTTL("key2", &CachedResponse{Body: []byte("bb")}, 100)
+	cache.SetWithTTL("key3", &CachedResponse{Body: []byte("ccc")}, 0)
+
+	cache.Invalidate()
+	assert.Equal(t, 0, cache.Len())
+	assert.Equal(t, Stats{}, cache.Stats())
+	_, ok := cache.Get("key1")
+	assert.False(t, ok)
+	assert.Empty(t, cache.GetAll())
+
+	// key2 stored again is current, the others make room for new entries
+	cache.SetWithTTL("key2", &CachedResponse{Body: []byte("new")}, 100)
+	cache.SetWithTTL("key4", &CachedResponse{Body: []byte("dddd")}, 100)
+	cache.SetWithTTL("key5", &CachedResponse{}, 100)
+	assert.Equal(t, 3, cache.Len())
+	assert.Equal(t, int64(7), cache.Stats().Bytes)
+	value, ok := cache.Get("key2")
+	assert.True(t, ok)
+	assert.Equal(t, "new", string(value.Body))
+
+	// each cleanup tick reclaims a batch
+	cache.cleanupBatch = 2
+	cache.Invalidate()
+	cache.cleanupExpired()
+	assert.Equal(t, 1, cache.ll.Len())
+	cache.cleanupExpired()
+	assert.Equal(t, 0, cache.ll.Len())
+	assert.Empty(t, cache.items)
+	assert.Empty(t, cache.expiries)
+	assert.Equal(t, int64(0), cache.bytes)
+	assert.Empty(t, evicted)
+	assert.Empty(t, expired)
+}
+
+// Test an LFU policy evicts the least used entry where LRU would evict the least recent one
+func TestLRUTTL_EvictionPolicy(t *testing.T) {
+	var evicted []string
+	cache, err := NewLRUTTL(WithCapacity[string, int](2),
+		WithCleanupStart[string, int](false),
+		WithEvictionPolicy[string, int](NewLFUPolicy[string]()),
+		WithOnEvict(func(key string, _ int) { evicted = append(evicted, key) }),
+	)
+	if err != nil {
+		t.Fatalf("NewLRUTTL error: %v", err)
+	}
+	cache.Set("hot", 1)
+	cache.Set("cold", 2)
+	cache.Get("hot")
+	cache.Get("hot")
+	cache.Get("cold") // most recent, but used less
+
+	cache.Set("new", 3)
+	assert.Equal(t, []string{"cold"}, evicted)
+	_, ok := cache.Get_Exclusive("hot")
+	assert.True(t, ok)
+
+	// deleted keys are forgotten by the policy, invalidated ones make room first
+	cache.Delete("hot")
+	cache.Set("other", 4)
+	cache.Invalidate()
+	cache.Set("a", 5)
+	cache.Set("b", 6)
+	assert.Equal(t, []string{"cold"}, evicted)
+	assert.Equal(t, 2, cache.Len())
+}
+
+// Test LFU picks the least frequent key, the least recent one among equally frequent keys
+func TestLFUPolicy(t *testing.T) {
+	policy := NewLFUPolicy[string]()
+	_, ok := policy.Victim()
+	assert.False(t, ok)
+
+	policy.Added("a")
+	policy.Added("b")
+	policy.Added("c")
+	victim, _ := policy.Victim()
+	assert.Equal(t, "a", victim)
+
+	policy.Accessed("a")
+	policy.Accessed("b")
+	victim, _ = policy.Victim()
+	assert.Equal(t, "c", victim)
+
+	policy.Removed("c")
+	victim, _ = policy.Victim()
+	assert.Equal(t, "a", victim)
+	policy.Accessed("a")
+	victim, _ = policy.Victim()
+	assert.Equal(t, "b", victim)
+}
+
+// Test partitions have their own capacity and can be purged independently, and keys of unknown
+// partitions share the overflow one instead of creating more
+func TestPartitioned(t *testing.T) {
+	capacities := map[string]int{"a": 1, "b": 2, "z": 1}
+	var built []string
+	cache, err := NewPartitioned(func(key string) string { return key[:1] }, []string{"a", "b"}, "z", func(name string) (Cache[string, *CachedResponse], error) {
+		built = append(built, name)
+		return NewLRUTTL(WithCapacity[string, *CachedResponse](capacities[name]),
+			WithLazyExpiration[string, *CachedResponse](1))
+	})
+	if err != nil {
+		t.Fatalf("NewPartitioned error: %v", err)
+	}
+
+	cache.Set("a1", &CachedResponse{Status: 200})
+	cache.Set("b1", &CachedResponse{Status: 200})
+	cache.Set("b2", &CachedResponse{Status: 200})
+	cache.Set("a2", &CachedResponse{Status: 200}) // evicts a1 only
+	_, ok := cache.Get("a1")
+	assert.False(t, ok)
+	_, ok = cache.Get("b1")
+	assert.True(t, ok)
+	assert.Equal(t, 3, cache.Len())
+	assert.Equal(t, uint64(1), cache.Stats().Evictions)
+
+	// any other name goes to the overflow partition, so they evict each other
+	cache.Set("c1", &CachedResponse{Status: 200})
+	_, err = cache.GetOrLoad(context.Background(), "d1", func() (*CachedResponse, int, error) { return &CachedResponse{}, 0, nil })
+	assert.NoError(t, err)
+	_, ok = cache.Get("c1")
+	assert.False(t, ok)
+	_, ok = cache.Get("e1")
+	assert.False(t, ok)
+	overflow, _ := cache.Partition("z")
+	assert.Equal(t, 1, overflow.Len())
+	assert.Equal(t, []string{"a", "b", "z"}, cache.Partitions())
+	assert.Equal(t, []string{"a", "b", "z"}, built)
+
+	assert.True(t, cache.Purge("b"))
+	assert.False(t, cache.Purge("c"))
+	_, ok = cache.Get("b1")
+	assert.False(t, ok)
+	_, ok = cache.Get("a2")
+	assert.True(t, ok)
+
+	cache.Invalidate()
+	_, ok = cache.Get("a2")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.Len())
+	assert.Equal(t, []string{"a", "b", "z"}, cache.Partitions())
+}
+
+// Test the tiered cache promotes back tier hits and writes per its policy
+func TestTiered(t *testing.T) {
+	newTier := func() *LRUWithTTL[string, int] {
+		c, err := NewLRUTTL(WithCapacity[string, int](10), WithCleanupStart[string, int](false))
+		if err != nil {
+			t.Fatalf("NewLRUTTL error: %v", err)
+		}
+		return c
+	}
+
+	front, back := newTier(), newTier()
+	tiered := NewTiered[string, int](front, back)
+	back.SetWithTTL("key1", 1, 100)
+	value, ok := tiered.Get("key1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	_, expiresAt, ok := front.GetWithExpiry("key1")
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(100*time.Second), expiresAt, 2*time.Second)
+
+	tiered.SetWithTTL("key2", 2, 0)
+	_, ok = front.Get_Exclusive("key2")
+	assert.True(t, ok)
+	tiered.Delete("key2")
+	_, ok = back.Get_Exclusive("key2")
+	assert.False(t, ok)
+
+	loads := 0
+	load := func() (int, int, error) { loads++; return 3, 100, nil }
+	value, err := tiered.GetOrLoad(context.Background(), "key3", load)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, value)
+	tiered.GetOrLoad(context.Background(), "key3", load)
+	assert.Equal(t, 1, loads)
+	_, ok = back.Get_Exclusive("key3")
+	assert.True(t, ok)
+
+	front, back = newTier(), newTier()
+	tiered = NewTiered(front, back, WithPromotion[string, int](PromoteNever), WithWritePolicy[string, int](WriteAround))
+	front.Set("key1", 0)
+	tiered.Set("key1", 1)
+	_, ok = front.Get_Exclusive("key1")
+	assert.False(t, ok)
+	value, ok = tiered.Get("key1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	_, ok = front.Get_Exclusive("key1")
+	assert.False(t, ok)
+	assert.Equal(t, map[string]int{"key1": 1}, tiered.GetMany([]string{"key1", "missing"}))
+}
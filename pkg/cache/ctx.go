@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// CacheCtx is the context-aware counterpart of Cache. Every call takes a context so
+// remote backends can honor deadlines/cancellation, and surfaces backend failures as errors.
+type CacheCtx[K comparable, V any] interface {
+	// Get returns the value for key and true if present (and not expired).
+	Get(ctx context.Context, key K) (V, bool, error)
+
+	// GetWithExpiry returns the value with its absolute expiry (zero time means no expiry),
+	// without affecting recency, see Cache.GetWithExpiry.
+	GetWithExpiry(ctx context.Context, key K) (V, time.Time, bool, error)
+
+	// Set stores the value for key using the cache's default TTL (if any).
+	Set(ctx context.Context, key K, value V) error
+
+	// SetWithTTL stores the value for key with a custom ttl (ttl >= 0, 0 means no expiry).
+	SetWithTTL(ctx context.Context, key K, value V, ttlSeconds int) error
+
+	// GetOrLoad returns the value for key, loading and storing it on a miss, see Cache.GetOrLoad.
+	// Concurrent misses on a key share one loader call.
+	GetOrLoad(ctx context.Context, key K, loader func() (V, int, error)) (V, error)
+
+	// Delete removes the key from the cache.
+	Delete(ctx context.Context, key K) error
+
+	// GetMany returns the present values for keys; backends should use a single round trip.
+	GetMany(ctx context.Context, keys []K) (map[K]V, error)
+
+	// SetMany stores all entries using the default TTL; backends should use a single round trip.
+	SetMany(ctx context.Context, entries map[K]V) error
+}
+
+// ctxAdapter lifts an in-memory Cache into a CacheCtx.
+// In-memory operations can't fail, so the only errors are from an already done context.
+type ctxAdapter[K comparable, V any] struct {
+	c Cache[K, V]
+}
+
+// WithContext wraps an in-memory Cache so it satisfies CacheCtx.
+func WithContext[K comparable, V any](c Cache[K, V]) CacheCtx[K, V] {
+	return &ctxAdapter[K, V]{c: c}
+}
+
+func (a *ctxAdapter[K, V]) Get(ctx context.Context, key K) (V, bool, error) {
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, false, err
+	}
+	v, ok := a.c.Get(key)
+	return v, ok, nil
+}
+
+func (a *ctxAdapter[K, V]) GetWithExpiry(ctx context.Context, key K) (V, time.Time, bool, error) {
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, time.Time{}, false, err
+	}
+	v, expiresAt, ok := a.c.GetWithExpiry(key)
+	return v, expiresAt, ok, nil
+}
+
+func (a *ctxAdapter[K, V]) Set(ctx context.Context, key K, value V) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.c.Set(key, value)
+	return nil
+}
+
+func (a *ctxAdapter[K, V]) SetWithTTL(ctx context.Context, key K, value V, ttlSeconds int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.c.SetWithTTL(key, value, ttlSeconds)
+}
+
+// GetOrLoad stops waiting for another request's loader once ctx is done, the loader is expected to
+// honor its own context.
+func (a *ctxAdapter[K, V]) GetOrLoad(ctx context.Context, key K, loader func() (V, int, error)) (V, error) {
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, err
+	}
+	return a.c.GetOrLoad(ctx, key, loader)
+}
+
+func (a *ctxAdapter[K, V]) Delete(ctx context.Context, key K) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.c.Delete(key)
+	return nil
+}
+
+func (a *ctxAdapter[K, V]) GetMany(ctx context.Context, keys []K) (map[K]V, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.c.GetMany(keys), nil
+}
+
+func (a *ctxAdapter[K, V]) SetMany(ctx context.Context, entries map[K]V) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.c.SetMany(entries)
+	return nil
+}
@@ -0,0 +1,57 @@
+package cache
+
+import "container/heap"
+
+// expiryHeap is a min-heap of entries ordered by expiresAt, so cleanup can stop at the first
+// unexpired entry instead of scanning the whole list. Entries without expiry are never in it.
+type expiryHeap[K comparable, V any] []*ttlEntry[K, V]
+
+func (h expiryHeap[K, V]) Len() int { return len(h) }
+
+func (h expiryHeap[K, V]) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	entry := x.(*ttlEntry[K, V])
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.heapIndex = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// contains reports whether entry is currently tracked by this heap.
+func (h expiryHeap[K, V]) contains(entry *ttlEntry[K, V]) bool {
+	return entry.heapIndex >= 0 && entry.heapIndex < len(h) && h[entry.heapIndex] == entry
+}
+
+// track adds, repositions or drops entry after its expiresAt changed.
+func (h *expiryHeap[K, V]) track(entry *ttlEntry[K, V]) {
+	switch {
+	case entry.expiresAt.IsZero():
+		h.untrack(entry)
+	case h.contains(entry):
+		heap.Fix(h, entry.heapIndex)
+	default:
+		heap.Push(h, entry)
+	}
+}
+
+// untrack removes entry from the heap if present.
+func (h *expiryHeap[K, V]) untrack(entry *ttlEntry[K, V]) {
+	if h.contains(entry) {
+		heap.Remove(h, entry.heapIndex)
+	}
+}
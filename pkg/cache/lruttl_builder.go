@@ -2,6 +2,8 @@ package cache
 
 import (
 	"container/list"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -10,28 +12,69 @@ const defaultCapacity = 10
 const defaultTTL = 10 * time.Minute
 const defaultCleanupInterval = 10 * time.Millisecond
 
+// defaultCleanupBatch bounds the entries one cleanup tick removes, so reclaiming a large
+// invalidation doesn't hold the lock for long; the next ticks carry on.
+const defaultCleanupBatch = 1024
+
+// DefaultSweepPerOp is a reasonable number of expired entries to reclaim per operation in lazy mode
+const DefaultSweepPerOp = 4
+
 // LRUOption is a functional option for building LRUTTL cache
 type LRUOption[K comparable, V any] func(*LRUWithTTL[K, V])
 
 // ttlEntry stored in list.Element
 type ttlEntry[K comparable, V any] struct {
-	key       K
-	value     V
-	expiresAt time.Time
+	key        K
+	value      V
+	size       int
+	storedAt   time.Time
+	expiresAt  time.Time
+	heapIndex  int    // position in expiries, -1 when not tracked
+	generation uint64 // the cache's generation when stored, older ones are invalidated
+
+	hits       uint64 // guarded by the cache lock, like lastAccess
+	lastAccess time.Time
 }
 
-// LRU cache with TTL based cleanup
+// LRU cache with TTL based cleanup. When full it evicts the least recently used entry, or the one
+// its EvictionPolicy picks.
 type LRUWithTTL[K comparable, V any] struct {
 	capacity int
 	mu       sync.RWMutex
-	ll       *list.List
+	ll       *list.List // most recent first
 	items    map[K]*list.Element
+	expiries expiryHeap[K, V]
+	policy   EvictionPolicy[K] // nil evicts from the back of ll
 
 	defaultTTL      time.Duration
 	cleanupInterval time.Duration
+	cleanupBatch    int
+	clock           Clock
 
 	cleanupStop    chan struct{}
 	cleanupRunning bool
+	sweepPerOp     int // lazy mode: expired entries removed per Get/Set, 0 when disabled
+
+	onEvict  func(key K, value V)
+	onExpire func(key K, value V)
+
+	sizer func(key K, value V) int
+	bytes int64 // guarded by mu
+	stats counters
+
+	// generation is bumped by Invalidate, entries of older ones are stale: left in place until
+	// swept or evicted but no longer found. staleItems and staleBytes are their share of the
+	// usage. All guarded by mu
+	generation uint64
+	staleItems int
+	staleBytes int64
+
+	refreshThreshold float64
+	refreshLoader    func(key K) (V, int, error)
+	refreshing       map[K]struct{}     // guarded by mu
+	loading          map[K]*loadCall[V] // GetOrLoad calls in flight, guarded by mu
+
+	optionErrs []error // invalid options, reported by NewLRUTTL
 }
 
 // WithCapacity sets the capacity of the cache.
@@ -40,7 +83,7 @@ func WithCapacity[K comparable, V any](capacity int) LRUOption[K, V] {
 		if capacity > 0 {
 			c.capacity = capacity
 		} else {
-			panic("capacity must be > 0")
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("capacity must be > 0, got %d", capacity))
 		}
 	}
 }
@@ -52,7 +95,7 @@ func WithDefaultTTL[K comparable, V any](ttlSeconds int) LRUOption[K, V] {
 		if ttlSeconds >= 0 {
 			c.defaultTTL = time.Duration(ttlSeconds) * time.Second
 		} else {
-			panic("default TTL must be >= 0")
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("default TTL must be >= 0, got %d", ttlSeconds))
 		}
 	}
 }
@@ -63,7 +106,7 @@ func WithCleanupInterval[K comparable, V any](intervalSeconds int) LRUOption[K,
 		if intervalSeconds > 0 {
 			c.cleanupInterval = time.Duration(intervalSeconds) * time.Second
 		} else {
-			panic("cleanup interval must be > 0")
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("cleanup interval must be > 0, got %d", intervalSeconds))
 		}
 	}
 }
@@ -75,6 +118,84 @@ func WithCleanupStart[K comparable, V any](cleanupRunning bool) LRUOption[K, V]
 	}
 }
 
+// WithLazyExpiration runs without any background goroutine. Expiry is enforced on Get/Set, and
+// every Get/Set also removes up to sweepPerOp already expired entries so memory is reclaimed
+// incrementally. Meant for serverless/embedded use where daemons are undesirable.
+func WithLazyExpiration[K comparable, V any](sweepPerOp int) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		if sweepPerOp > 0 {
+			c.sweepPerOp = sweepPerOp
+			c.cleanupRunning = false
+		} else {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("sweep per operation must be > 0, got %d", sweepPerOp))
+		}
+	}
+}
+
+// WithOnEvict registers a callback invoked when an entry is evicted to make room (capacity).
+// Callbacks run outside the cache lock, so they may safely call back into the cache.
+func WithOnEvict[K comparable, V any](fn func(key K, value V)) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		c.onEvict = fn
+	}
+}
+
+// WithOnExpire registers a callback invoked when an entry is removed because its TTL passed,
+// either lazily on Get or by the cleanup daemon.
+func WithOnExpire[K comparable, V any](fn func(key K, value V)) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		c.onExpire = fn
+	}
+}
+
+// WithSizer sets how many bytes an entry accounts for in Stats().Bytes. Without it bytes stay 0.
+func WithSizer[K comparable, V any](sizer func(key K, value V) int) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		c.sizer = sizer
+	}
+}
+
+// WithRefreshAhead refetches an entry in the background once threshold (0-1) of its TTL has
+// elapsed and it is still being read, so hot keys are replaced before they expire.
+// loader returns the fresh value and its ttlSeconds (<= 0 uses the default TTL).
+func WithRefreshAhead[K comparable, V any](threshold float64, loader func(key K) (V, int, error)) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		switch {
+		case threshold <= 0 || threshold >= 1:
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("refresh threshold must be between 0 and 1, got %v", threshold))
+		case loader == nil:
+			c.optionErrs = append(c.optionErrs, errors.New("refresh loader is required"))
+		default:
+			c.refreshThreshold = threshold
+			c.refreshLoader = loader
+		}
+	}
+}
+
+// WithEvictionPolicy sets which entry is evicted when the cache is full, e.g. NewLFUPolicy. Each
+// cache needs its own policy.
+func WithEvictionPolicy[K comparable, V any](policy EvictionPolicy[K]) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		if policy != nil {
+			c.policy = policy
+		} else {
+			c.optionErrs = append(c.optionErrs, errors.New("eviction policy is required"))
+		}
+	}
+}
+
+// WithClock sets the clock TTLs are checked against, SystemClock by default. The cleanup daemon
+// still ticks in real time, it just removes what is expired by clock.
+func WithClock[K comparable, V any](clock Clock) LRUOption[K, V] {
+	return func(c *LRUWithTTL[K, V]) {
+		if clock != nil {
+			c.clock = clock
+		} else {
+			c.optionErrs = append(c.optionErrs, errors.New("clock is required"))
+		}
+	}
+}
+
 // WithItemsMap configures to use shallow copy of cache from a given items map (use at your own caution)
 // TODO : Improve to handle edge cases like calling with capacity post this and also creating a linked list ?
 func WithItemsMap[K comparable, V any](itemsMap map[K]*list.Element) LRUOption[K, V] {
@@ -86,7 +207,7 @@ func WithItemsMap[K comparable, V any](itemsMap map[K]*list.Element) LRUOption[K
 }
 
 // NewLRUTTL creates an LRU cache with TTL based cleanup.
-// Capacity must be > 0. Provide options to configure TTL and cleanup interval.
+// Provide options to configure TTL and cleanup interval, invalid ones are all listed in the error.
 func NewLRUTTL[K comparable, V any](opts ...LRUOption[K, V]) (*LRUWithTTL[K, V], error) {
 
 	c := &LRUWithTTL[K, V]{
@@ -95,13 +216,20 @@ func NewLRUTTL[K comparable, V any](opts ...LRUOption[K, V]) (*LRUWithTTL[K, V],
 		items:           make(map[K]*list.Element, defaultCapacity),
 		defaultTTL:      defaultTTL,
 		cleanupInterval: defaultCleanupInterval,
+		cleanupBatch:    defaultCleanupBatch,
+		clock:           SystemClock{},
 		cleanupStop:     make(chan struct{}),
 		cleanupRunning:  true,
+		refreshing:      make(map[K]struct{}),
+		loading:         make(map[K]*loadCall[V]),
 	}
 
 	for _, o := range opts {
 		o(c)
 	}
+	if len(c.optionErrs) > 0 {
+		return nil, fmt.Errorf("invalid cache options: %w", errors.Join(c.optionErrs...))
+	}
 
 	if c.cleanupRunning {
 		c.StartCleanupDaemon()
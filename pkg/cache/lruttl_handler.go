@@ -1,36 +1,98 @@
 package cache
 
-import "time"
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
 
 // Len returns number of non-expired items.
 // Uses read lock since it only reads the map length
 func (c *LRUWithTTL[K, V]) Len() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return len(c.items)
+	return len(c.items) - c.staleItems
 }
 
 // Get returns value if present and not expired
 // Marks the element as most-recent
 func (c *LRUWithTTL[K, V]) Get(key K) (value V, ok bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	swept := c.sweepLocked(c.sweepPerOp)
 
 	var zero V
 	element, ok := c.items[key]
 	if !ok {
+		c.mu.Unlock()
+		c.stats.misses.Add(1)
+		c.expired(swept)
 		return zero, false
 	}
 	entry := element.Value.(*ttlEntry[K, V])
 
-	if isExpired(entry) {
-		c.ll.Remove(element)
-		delete(c.items, key)
+	if c.stale(entry) {
+		c.removeElement(element)
+		c.mu.Unlock()
+		c.stats.misses.Add(1)
+		c.expired(swept)
+		return zero, false
+	}
+	if c.isExpired(entry) {
+		c.removeElement(element)
+		c.mu.Unlock()
+		c.stats.misses.Add(1)
+		c.expired(append(swept, entry))
 		return zero, false
 	}
 
-	c.ll.MoveToFront(element)
-	return entry.value, true
+	c.accessed(element)
+	c.touch(entry)
+	refresh := c.shouldRefresh(entry)
+	value = entry.value // read under the lock, Set updates entries in place
+	c.mu.Unlock()
+	c.stats.hits.Add(1)
+	c.expired(swept)
+
+	if refresh {
+		go c.refresh(key)
+	}
+	return value, true
+}
+
+// GetMany looks up several keys under a single lock. Missing or expired keys are absent from the result.
+// Hits are marked as most-recent, same as Get.
+func (c *LRUWithTTL[K, V]) GetMany(keys []K) map[K]V {
+	c.mu.Lock()
+
+	out := make(map[K]V, len(keys))
+	var expiredEntries []*ttlEntry[K, V]
+	for _, key := range keys {
+		element, ok := c.items[key]
+		if !ok {
+			continue
+		}
+		entry := element.Value.(*ttlEntry[K, V])
+		if c.stale(entry) {
+			c.removeElement(element)
+			continue
+		}
+		if c.isExpired(entry) {
+			c.removeElement(element)
+			expiredEntries = append(expiredEntries, entry)
+			continue
+		}
+		c.accessed(element)
+		c.touch(entry)
+		out[key] = entry.value
+	}
+	c.mu.Unlock()
+
+	c.stats.hits.Add(uint64(len(out)))
+	c.stats.misses.Add(uint64(len(keys) - len(out)))
+	c.expired(expiredEntries)
+	return out
 }
 
 // GetAll returns a shallow copy of the current contents.
@@ -38,23 +100,131 @@ func (c *LRUWithTTL[K, V]) Get(key K) (value V, ok bool) {
 func (c *LRUWithTTL[K, V]) GetAll() map[K]V {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	out := make(map[K]V, len(c.items))
+	out := make(map[K]V, len(c.items)-c.staleItems)
 	for k, ele := range c.items {
-		out[k] = ele.Value.(*ttlEntry[K, V]).value
+		if entry := ele.Value.(*ttlEntry[K, V]); !c.stale(entry) {
+			out[k] = entry.value
+		}
 	}
 	return out
 }
 
+// Range calls fn for every non-expired entry, most recent first, until fn returns false.
+// Walks under a read lock without copying, so fn must not call back into the cache.
+func (c *LRUWithTTL[K, V]) Range(fn func(key K, value V) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for element := c.ll.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*ttlEntry[K, V])
+		if c.stale(entry) {
+			break // stale entries are all behind the current ones
+		}
+		if c.isExpired(entry) {
+			continue
+		}
+		if !fn(entry.key, entry.value) {
+			return
+		}
+	}
+}
+
 // Helper functions for testing, just returns the value based on key without moving them at front
+// Expired entries are reported as missing even if the cleanup daemon hasn't removed them yet
 func (c *LRUWithTTL[K, V]) Get_Exclusive(key K) (value V, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	element, ok := c.items[key]
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.peek(key)
 	if !ok {
 		var zero V
 		return zero, false
 	}
-	return element.Value.(*ttlEntry[K, V]).value, true
+	return entry.value, true
+}
+
+// GetWithExpiry returns the value along with its absolute expiry, without moving it to front.
+// A zero expiresAt means the entry never expires.
+func (c *LRUWithTTL[K, V]) GetWithExpiry(key K) (value V, expiresAt time.Time, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.peek(key)
+	if !ok {
+		var zero V
+		return zero, time.Time{}, false
+	}
+	return entry.value, entry.expiresAt, true
+}
+
+// peek looks up a non-expired entry. Caller must hold the lock.
+func (c *LRUWithTTL[K, V]) peek(key K) (*ttlEntry[K, V], bool) {
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*ttlEntry[K, V])
+	if c.stale(entry) || c.isExpired(entry) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// loadCall is a GetOrLoad loader call other misses on the key wait for.
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+var errLoaderPanicked = errors.New("cache loader panicked")
+
+// GetOrLoad returns the value for key, or loads it with loader on a miss. Concurrent misses share
+// the first one's loader call: they wait for it, until ctx is done, and get its value or error.
+func (c *LRUWithTTL[K, V]) GetOrLoad(ctx context.Context, key K, loader func() (V, int, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.peek(key); ok { // stored since the lookup
+		c.mu.Unlock()
+		return entry.value, nil
+	}
+	if call, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	call := &loadCall[V]{done: make(chan struct{}), err: errLoaderPanicked}
+	c.loading[key] = call
+	c.mu.Unlock()
+
+	c.load(key, call, loader)
+	return call.value, call.err
+}
+
+// load runs loader for call and stores its value, then releases the waiters, also when it panics.
+func (c *LRUWithTTL[K, V]) load(key K, call *loadCall[V], loader func() (V, int, error)) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.loading, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	value, ttlSeconds, err := loader()
+	call.value, call.err = value, err
+	if err != nil {
+		return
+	}
+	if ttlSeconds > 0 {
+		c.SetWithTTL(key, value, ttlSeconds)
+	} else if ttlSeconds == 0 {
+		c.Set(key, value)
+	}
 }
 
 // Delete removes the key from the cache (both the linked list node and the items map).
@@ -65,69 +235,232 @@ func (c *LRUWithTTL[K, V]) Delete(key K) {
 	if !ok {
 		return
 	}
-	c.ll.Remove(element)
-	delete(c.items, key)
+	c.removeElement(element)
 }
 
 // SetWithTTL using default TTL if expiresAt is not set
 func (c *LRUWithTTL[K, V]) Set(key K, value V) {
-	c.setWithTTLInternal(key, value, time.Now().Add(c.defaultTTL))
+	c.setWithTTLInternal(key, value, c.clock.Now().Add(c.defaultTTL))
+}
+
+// SetMany stores all entries with the default TTL under a single lock, e.g. for warmup.
+func (c *LRUWithTTL[K, V]) SetMany(entries map[K]V) {
+	expiresAt := c.clock.Now().Add(c.defaultTTL)
+
+	c.mu.Lock()
+	swept := c.sweepLocked(c.sweepPerOp)
+	var evictedEntries []*ttlEntry[K, V]
+	for key, value := range entries {
+		if evicted := c.setLocked(key, value, expiresAt); evicted != nil {
+			evictedEntries = append(evictedEntries, evicted)
+		}
+	}
+	c.mu.Unlock()
+
+	c.stats.evictions.Add(uint64(len(evictedEntries)))
+	c.notify(c.onEvict, evictedEntries...)
+	c.expired(swept)
 }
 
 // SetWithTTL stores value with a specific ttlSeconds
-// ttlSeconds = 0 explicitly means no expiry
-func (c *LRUWithTTL[K, V]) SetWithTTL(key K, value V, ttlSeconds int) {
+// ttlSeconds = 0 explicitly means no expiry, negative ones are rejected with ErrNegativeTTL
+func (c *LRUWithTTL[K, V]) SetWithTTL(key K, value V, ttlSeconds int) error {
 	if ttlSeconds > 0 {
-		expiresAt := time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+		expiresAt := c.clock.Now().Add(time.Duration(ttlSeconds) * time.Second)
 		c.setWithTTLInternal(key, value, expiresAt)
 	} else if ttlSeconds == 0 { // no expiry
 		c.setWithTTLInternal(key, value, time.Time{})
 	} else {
-		panic("ttlSeconds must be >= 0")
+		return fmt.Errorf("%w, got %d", ErrNegativeTTL, ttlSeconds)
 	}
+	return nil
 }
 
 // Actual setting
 func (c *LRUWithTTL[K, V]) setWithTTLInternal(key K, value V, expiresAt time.Time) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	swept := c.sweepLocked(c.sweepPerOp)
+	evicted := c.setLocked(key, value, expiresAt)
+	c.mu.Unlock()
 
+	if evicted != nil {
+		c.stats.evictions.Add(1)
+		c.notify(c.onEvict, evicted)
+	}
+	c.expired(swept)
+}
+
+// setLocked inserts or updates key and returns the entry evicted to make room, if any.
+// Caller must hold the write lock.
+func (c *LRUWithTTL[K, V]) setLocked(key K, value V, expiresAt time.Time) (evicted *ttlEntry[K, V]) {
 	// update if it's existing
 	if element, ok := c.items[key]; ok {
 		entry := element.Value.(*ttlEntry[K, V])
+		if c.stale(entry) {
+			c.staleItems--
+			c.staleBytes -= int64(entry.size)
+			entry.generation = c.generation
+		}
+		c.bytes += int64(c.sizeOf(key, value) - entry.size)
 		entry.value = value
+		entry.size = c.sizeOf(key, value)
+		entry.storedAt = c.clock.Now()
+		entry.lastAccess = entry.storedAt
+		entry.hits = 0
 		entry.expiresAt = expiresAt
-		c.ll.MoveToFront(element)
-		return
+		c.expiries.track(entry)
+		c.accessed(element)
+		return nil
 	}
 
 	// if its full, evict to create space
 	if len(c.items) >= c.capacity {
-		tail := c.ll.Back()
-		if tail == nil {
-			panic("capacity is 0")
+		if evicted = c.removeElement(c.victim()); c.stale(evicted) {
+			evicted = nil // invalidated already, not an eviction
 		}
-		entry := tail.Value.(*ttlEntry[K, V])
-		c.ll.Remove(tail)
-		delete(c.items, entry.key)
 	}
 
 	// insert new
+	now := c.clock.Now()
 	entry := &ttlEntry[K, V]{
-		key:       key,
-		value:     value,
-		expiresAt: expiresAt,
+		key:        key,
+		value:      value,
+		size:       c.sizeOf(key, value),
+		storedAt:   now,
+		expiresAt:  expiresAt,
+		heapIndex:  -1,
+		lastAccess: now,
+		generation: c.generation,
 	}
+	c.expiries.track(entry)
 	element := c.ll.PushFront(entry)
 	c.items[key] = element
+	c.bytes += int64(entry.size)
+	if c.policy != nil {
+		c.policy.Added(key)
+	}
+	return evicted
+}
+
+// victim returns the element to evict to make room: stale ones first, they're at the back of the
+// list, then the policy's pick, the least recently used without a policy. Caller must hold the
+// write lock.
+func (c *LRUWithTTL[K, V]) victim() *list.Element {
+	tail := c.ll.Back()
+	if tail == nil {
+		panic("capacity is 0")
+	}
+	if c.policy == nil || c.staleItems > 0 {
+		return tail
+	}
+	if key, ok := c.policy.Victim(); ok {
+		if element, ok := c.items[key]; ok {
+			return element
+		}
+	}
+	return tail
+}
+
+// accessed marks element as the most recent and records the access with the policy.
+// Caller must hold the write lock.
+func (c *LRUWithTTL[K, V]) accessed(element *list.Element) {
+	c.ll.MoveToFront(element)
+	if c.policy != nil {
+		c.policy.Accessed(element.Value.(*ttlEntry[K, V]).key)
+	}
+}
+
+// touch records a hit on entry for Inspect. Caller must hold the write lock.
+func (c *LRUWithTTL[K, V]) touch(entry *ttlEntry[K, V]) {
+	entry.hits++
+	entry.lastAccess = c.clock.Now()
+}
+
+// removeElement unlinks an element from both the list and the map, keeping byte usage in sync.
+// Caller must hold the write lock.
+func (c *LRUWithTTL[K, V]) removeElement(element *list.Element) *ttlEntry[K, V] {
+	entry := element.Value.(*ttlEntry[K, V])
+	c.ll.Remove(element)
+	delete(c.items, entry.key)
+	c.expiries.untrack(entry)
+	if c.policy != nil {
+		c.policy.Removed(entry.key)
+	}
+	c.bytes -= int64(entry.size)
+	if c.stale(entry) {
+		c.staleItems--
+		c.staleBytes -= int64(entry.size)
+	}
+	return entry
+}
+
+// sizeOf returns the configured size estimate for an entry, 0 without a sizer.
+func (c *LRUWithTTL[K, V]) sizeOf(key K, value V) int {
+	if c.sizer == nil {
+		return 0
+	}
+	return c.sizer(key, value)
+}
+
+// notify invokes an eviction/expiry callback if one is registered. Must be called without the lock.
+func (c *LRUWithTTL[K, V]) notify(fn func(key K, value V), entries ...*ttlEntry[K, V]) {
+	if fn == nil {
+		return
+	}
+	for _, entry := range entries {
+		fn(entry.key, entry.value)
+	}
+}
+
+// stale reports whether entry was stored before the last Invalidate. Caller must hold the lock.
+func (c *LRUWithTTL[K, V]) stale(entry *ttlEntry[K, V]) bool {
+	return entry.generation != c.generation
 }
 
 // isExpired checks whether an entry is expired. (expirytime - currenttime)
-func isExpired[K comparable, V any](entry *ttlEntry[K, V]) bool {
+func (c *LRUWithTTL[K, V]) isExpired(entry *ttlEntry[K, V]) bool {
 	if entry.expiresAt.IsZero() {
 		return false // zero time means no expiry
 	}
-	return time.Since(entry.expiresAt) > 0
+	return c.clock.Now().Sub(entry.expiresAt) > 0
+}
+
+// REFRESH AHEAD
+
+// shouldRefresh reports whether a hit on entry should trigger a background refetch and marks
+// the key as refreshing so only one refetch runs per key. Caller must hold the write lock.
+func (c *LRUWithTTL[K, V]) shouldRefresh(entry *ttlEntry[K, V]) bool {
+	if c.refreshLoader == nil || entry.expiresAt.IsZero() {
+		return false
+	}
+	if _, ok := c.refreshing[entry.key]; ok {
+		return false
+	}
+	ttl := entry.expiresAt.Sub(entry.storedAt)
+	if c.clock.Now().Sub(entry.storedAt) < time.Duration(float64(ttl)*c.refreshThreshold) {
+		return false
+	}
+	c.refreshing[entry.key] = struct{}{}
+	return true
+}
+
+// refresh reloads key and stores the fresh value. On error the current entry is left to expire.
+func (c *LRUWithTTL[K, V]) refresh(key K) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	}()
+
+	value, ttlSeconds, err := c.refreshLoader(key)
+	if err != nil {
+		return
+	}
+	if ttlSeconds > 0 {
+		c.SetWithTTL(key, value, ttlSeconds)
+	} else {
+		c.Set(key, value)
+	}
 }
 
 // CRONJOB
@@ -137,49 +470,90 @@ func (c *LRUWithTTL[K, V]) Close() {
 	c.StopCleanupDaemon()
 }
 
-// StartCleanupDaemon starts a background goroutine that periodically evicts expired items.
-// interval must be > 0 seconds.
+// StartCleanupDaemon starts a background goroutine that periodically evicts expired items, every
+// cleanup interval (the default one when unset).
 func (c *LRUWithTTL[K, V]) StartCleanupDaemon() {
-	if c.cleanupInterval <= 0 {
-		panic("cleanup interval must be > 0")
+	interval := c.cleanupInterval
+	if interval <= 0 {
+		interval = defaultCleanupInterval
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	// StopCleanupDaemon replaces the channel once closed, so the goroutine keeps the one it's
+	// stopped with
+	stop := c.cleanupStop
+	c.cleanupRunning = true
 
 	go func() {
-		ticker := time.NewTicker(c.cleanupInterval)
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
 				c.cleanupExpired()
-			case <-c.cleanupStop:
+			case <-stop:
 				return
 			}
 		}
 	}()
 }
 
-// cleanupExpired iterates through the linked list and removes expired entries and also deletes the entry from the map.
+// cleanupExpired pops entries off the expiry heap until it reaches one that hasn't expired,
+// removing each from the list and map, then stale ones. At most cleanupBatch entries are removed,
+// the rest are left to the next tick.
 func (c *LRUWithTTL[K, V]) cleanupExpired() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	expiredEntries := c.sweepLocked(c.cleanupBatch)
+	c.mu.Unlock()
 
-	current := c.ll.Front()
-	for current != nil {
-		next := current.Next()
-		entry := current.Value.(*ttlEntry[K, V])
+	c.expired(expiredEntries)
+}
 
-		expired := isExpired(entry)
-		if expired {
-			c.ll.Remove(current)
-			delete(c.items, entry.key)
+// sweepLocked removes up to limit expired entries (all of them when limit < 0) in expiry order,
+// then stale ones from the back of the list with what's left of limit.
+// Caller must hold the write lock.
+func (c *LRUWithTTL[K, V]) sweepLocked(limit int) []*ttlEntry[K, V] {
+	var expiredEntries []*ttlEntry[K, V]
+	for len(c.expiries) > 0 && c.isExpired(c.expiries[0]) && limit != 0 {
+		entry := c.expiries[0]
+		if element, ok := c.items[entry.key]; ok && element.Value == entry {
+			c.removeElement(element)
+		} else {
+			c.expiries.untrack(entry)
+		}
+		if !c.stale(entry) {
+			expiredEntries = append(expiredEntries, entry)
 		}
+		limit--
+	}
+	// stale entries are never moved to front, so they are all at the back
+	for c.staleItems > 0 && limit != 0 {
+		c.removeElement(c.ll.Back())
+		limit--
+	}
+	return expiredEntries
+}
+
+// Invalidate drops every entry at once without walking them: they're left behind a new generation,
+// no longer found nor counted, and reclaimed by the cleanup sweep or as they're evicted. Callbacks
+// don't fire for them.
+func (c *LRUWithTTL[K, V]) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.staleItems = len(c.items)
+	c.staleBytes = c.bytes
+}
 
-		current = next
+// expired records and reports entries removed for TTL. Must be called without the lock.
+func (c *LRUWithTTL[K, V]) expired(entries []*ttlEntry[K, V]) {
+	if len(entries) == 0 {
+		return
 	}
+	c.stats.expirations.Add(uint64(len(entries)))
+	c.notify(c.onExpire, entries...)
 }
 
 func (c *LRUWithTTL[K, V]) StopCleanupDaemon() {
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Partitioned splits keys over independent caches, one per partition (e.g. per host), so each
+// has its own capacity and one partition's churn can't evict another's working set. The partitions
+// are fixed when it's built: keys naming any other partition share the overflow one, so keys (and
+// the clients they come from) can't create more.
+type Partitioned[K comparable, V any] struct {
+	partitionOf func(key K) string
+	overflow    string
+	partitions  map[string]Cache[K, V] // never modified once built
+}
+
+// NewPartitioned builds a cache routing each key to the partition partitionOf names when it's one
+// of names, to overflow otherwise. newPartition builds each of them, setting up their expiration
+// (cleanup daemon or lazy).
+func NewPartitioned[K comparable, V any](partitionOf func(key K) string, names []string, overflow string, newPartition func(name string) (Cache[K, V], error)) (*Partitioned[K, V], error) {
+	p := &Partitioned[K, V]{
+		partitionOf: partitionOf,
+		overflow:    overflow,
+		partitions:  map[string]Cache[K, V]{},
+	}
+	for _, name := range append(slices.Clone(names), overflow) {
+		if _, ok := p.partitions[name]; ok {
+			continue
+		}
+		c, err := newPartition(name)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("cache partition %s: %w", name, err)
+		}
+		p.partitions[name] = c
+	}
+	return p, nil
+}
+
+// partition returns the cache for key.
+func (p *Partitioned[K, V]) partition(key K) Cache[K, V] {
+	if c, ok := p.partitions[p.partitionOf(key)]; ok {
+		return c
+	}
+	return p.partitions[p.overflow]
+}
+
+// snapshot returns the partitions, sorted by name.
+func (p *Partitioned[K, V]) snapshot() []Cache[K, V] {
+	caches := make([]Cache[K, V], 0, len(p.partitions))
+	for _, name := range p.Partitions() {
+		caches = append(caches, p.partitions[name])
+	}
+	return caches
+}
+
+// Partitions lists the partition names, sorted.
+func (p *Partitioned[K, V]) Partitions() []string {
+	return slices.Sorted(maps.Keys(p.partitions))
+}
+
+// Partition returns the cache backing partition name.
+func (p *Partitioned[K, V]) Partition(name string) (Cache[K, V], bool) {
+	c, ok := p.partitions[name]
+	return c, ok
+}
+
+// Purge drops every entry of partition name, reporting whether it exists.
+func (p *Partitioned[K, V]) Purge(name string) bool {
+	c, ok := p.partitions[name]
+	if ok {
+		c.Invalidate()
+	}
+	return ok
+}
+
+// Invalidate drops the entries of every partition, keeping the partitions. Invalidate a single
+// one through Partition, or Purge it.
+func (p *Partitioned[K, V]) Invalidate() {
+	for _, c := range p.snapshot() {
+		c.Invalidate()
+	}
+}
+
+func (p *Partitioned[K, V]) Get(key K) (V, bool) {
+	return p.partition(key).Get(key)
+}
+
+func (p *Partitioned[K, V]) GetWithExpiry(key K) (V, time.Time, bool) {
+	return p.partition(key).GetWithExpiry(key)
+}
+
+func (p *Partitioned[K, V]) Inspect(key K) (EntryInfo, bool) {
+	return p.partition(key).Inspect(key)
+}
+
+func (p *Partitioned[K, V]) Set(key K, value V) {
+	p.partition(key).Set(key, value)
+}
+
+func (p *Partitioned[K, V]) SetWithTTL(key K, value V, ttlSeconds int) error {
+	if ttlSeconds < 0 {
+		return fmt.Errorf("%w, got %d", ErrNegativeTTL, ttlSeconds)
+	}
+	return p.partition(key).SetWithTTL(key, value, ttlSeconds)
+}
+
+func (p *Partitioned[K, V]) GetOrLoad(ctx context.Context, key K, loader func() (V, int, error)) (V, error) {
+	return p.partition(key).GetOrLoad(ctx, key, loader)
+}
+
+func (p *Partitioned[K, V]) Delete(key K) {
+	p.partition(key).Delete(key)
+}
+
+func (p *Partitioned[K, V]) GetMany(keys []K) map[K]V {
+	out := make(map[K]V, len(keys))
+	for _, key := range keys {
+		if v, ok := p.Get(key); ok {
+			out[key] = v
+		}
+	}
+	return out
+}
+
+func (p *Partitioned[K, V]) SetMany(entries map[K]V) {
+	for key, value := range entries {
+		p.Set(key, value)
+	}
+}
+
+func (p *Partitioned[K, V]) Len() int {
+	n := 0
+	for _, c := range p.snapshot() {
+		n += c.Len()
+	}
+	return n
+}
+
+func (p *Partitioned[K, V]) GetAll() map[K]V {
+	out := map[K]V{}
+	for _, c := range p.snapshot() {
+		maps.Copy(out, c.GetAll())
+	}
+	return out
+}
+
+// Range visits partitions in name order, each in its own order.
+func (p *Partitioned[K, V]) Range(fn func(key K, value V) bool) {
+	for _, c := range p.snapshot() {
+		stop := false
+		c.Range(func(key K, value V) bool {
+			if !fn(key, value) {
+				stop = true
+			}
+			return !stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// Stats sums the partitions' stats.
+func (p *Partitioned[K, V]) Stats() Stats {
+	var total Stats
+	for _, c := range p.snapshot() {
+		s := c.Stats()
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+		total.Evictions += s.Evictions
+		total.Expirations += s.Expirations
+		total.Items += s.Items
+		total.Bytes += s.Bytes
+	}
+	return total
+}
+
+// StartCleanupDaemon starts cleanup in every partition.
+func (p *Partitioned[K, V]) StartCleanupDaemon() {
+	for _, c := range p.snapshot() {
+		c.StartCleanupDaemon()
+	}
+}
+
+func (p *Partitioned[K, V]) StopCleanupDaemon() {
+	for _, c := range p.snapshot() {
+		c.StopCleanupDaemon()
+	}
+}
+
+func (p *Partitioned[K, V]) Close() {
+	for _, c := range p.snapshot() {
+		c.Close()
+	}
+}
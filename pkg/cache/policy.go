@@ -0,0 +1,97 @@
+package cache
+
+import "container/list"
+
+// EvictionPolicy picks the entry LRUWithTTL evicts to make room. The cache keeps the storage,
+// TTLs, stats and callbacks and tells the policy what happens to its keys; the policy only orders
+// them. Methods are called under the cache lock, so implementations needn't be safe for
+// concurrent use, but one policy can't be shared between caches.
+type EvictionPolicy[K comparable] interface {
+	// Added records a key newly stored.
+	Added(key K)
+	// Accessed records a hit on key, or its value being replaced.
+	Accessed(key K)
+	// Removed forgets key, whether evicted, expired or deleted.
+	Removed(key K)
+	// Victim returns the key to evict next, false when it tracks none.
+	Victim() (K, bool)
+}
+
+// lfuPolicy evicts the least frequently used key, the least recently used one among those with
+// the same count. Counts are kept in buckets of ascending frequency so every call is O(1).
+type lfuPolicy[K comparable] struct {
+	buckets *list.List // of *lfuBucket, ascending frequency
+	items   map[K]*list.Element
+}
+
+type lfuBucket[K comparable] struct {
+	freq uint64
+	keys *list.List // of *lfuItem, most recent first
+}
+
+type lfuItem[K comparable] struct {
+	key    K
+	bucket *list.Element
+}
+
+// NewLFUPolicy returns a least frequently used eviction policy, for workloads where a few hot
+// keys should survive scans of many cold ones.
+func NewLFUPolicy[K comparable]() EvictionPolicy[K] {
+	return &lfuPolicy[K]{buckets: list.New(), items: make(map[K]*list.Element)}
+}
+
+func (p *lfuPolicy[K]) Added(key K) {
+	if _, ok := p.items[key]; ok {
+		p.Accessed(key)
+		return
+	}
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket[K]).freq != 1 {
+		front = p.buckets.PushFront(&lfuBucket[K]{freq: 1, keys: list.New()})
+	}
+	p.items[key] = front.Value.(*lfuBucket[K]).keys.PushFront(&lfuItem[K]{key: key, bucket: front})
+}
+
+func (p *lfuPolicy[K]) Accessed(key K) {
+	element, ok := p.items[key]
+	if !ok {
+		return
+	}
+	item := element.Value.(*lfuItem[K])
+	current := item.bucket
+	bucket := current.Value.(*lfuBucket[K])
+
+	next := current.Next()
+	if next == nil || next.Value.(*lfuBucket[K]).freq != bucket.freq+1 {
+		next = p.buckets.InsertAfter(&lfuBucket[K]{freq: bucket.freq + 1, keys: list.New()}, current)
+	}
+	bucket.keys.Remove(element)
+	if bucket.keys.Len() == 0 {
+		p.buckets.Remove(current)
+	}
+	item.bucket = next
+	p.items[key] = next.Value.(*lfuBucket[K]).keys.PushFront(item)
+}
+
+func (p *lfuPolicy[K]) Removed(key K) {
+	element, ok := p.items[key]
+	if !ok {
+		return
+	}
+	item := element.Value.(*lfuItem[K])
+	bucket := item.bucket.Value.(*lfuBucket[K])
+	bucket.keys.Remove(element)
+	if bucket.keys.Len() == 0 {
+		p.buckets.Remove(item.bucket)
+	}
+	delete(p.items, key)
+}
+
+func (p *lfuPolicy[K]) Victim() (K, bool) {
+	front := p.buckets.Front()
+	if front == nil {
+		var zero K
+		return zero, false
+	}
+	return front.Value.(*lfuBucket[K]).keys.Back().Value.(*lfuItem[K]).key, true
+}
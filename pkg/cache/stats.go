@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of cache activity.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`   // removed to make room (capacity)
+	Expirations uint64 `json:"expirations"` // removed because TTL passed
+	Items       int    `json:"items"`
+	Bytes       int64  `json:"bytes"` // as reported by the configured sizer
+}
+
+// EntryInfo is the metadata of one entry, see Inspect.
+type EntryInfo struct {
+	Size       int       `json:"size"` // as reported by the configured sizer
+	Hits       uint64    `json:"hits"` // Get and GetMany hits since stored or last updated
+	StoredAt   time.Time `json:"storedAt"`
+	LastAccess time.Time `json:"lastAccess"` // last hit, StoredAt when never hit
+	ExpiresAt  time.Time `json:"expiresAt"`  // zero means no expiry
+}
+
+// HitRatio returns hits / (hits + misses), 0 when there were no lookups.
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// counters are updated atomically so they don't need the cache lock.
+type counters struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+// Stats returns a snapshot of the cache counters and current usage.
+func (c *LRUWithTTL[K, V]) Stats() Stats {
+	c.mu.RLock()
+	items, bytes := len(c.items)-c.staleItems, c.bytes-c.staleBytes
+	c.mu.RUnlock()
+
+	return Stats{
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		Evictions:   c.stats.evictions.Load(),
+		Expirations: c.stats.expirations.Load(),
+		Items:       items,
+		Bytes:       bytes,
+	}
+}
+
+// Inspect returns the metadata of key's entry without moving it to front or counting a hit.
+func (c *LRUWithTTL[K, V]) Inspect(key K) (EntryInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.peek(key)
+	if !ok {
+		return EntryInfo{}, false
+	}
+	return EntryInfo{
+		Size:       entry.size,
+		Hits:       entry.hits,
+		StoredAt:   entry.storedAt,
+		LastAccess: entry.lastAccess,
+		ExpiresAt:  entry.expiresAt,
+	}, true
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"maps"
+	"math"
+	"time"
+)
+
+// Tiered fronts a back cache (large or shared, e.g. a remote one) with a front cache (small and
+// fast, e.g. in memory). Reads try the front first and fall back to the back, writes go to the
+// tiers the WritePolicy picks. The back is the authority: Len, GetAll and Range report its view.
+type Tiered[K comparable, V any] struct {
+	front Cache[K, V]
+	back  Cache[K, V]
+
+	promotion PromotionPolicy
+	write     WritePolicy
+}
+
+// PromotionPolicy decides whether back tier hits are copied to the front tier.
+type PromotionPolicy int
+
+const (
+	// PromoteOnHit copies back tier hits to the front, with the back entry's remaining TTL
+	PromoteOnHit PromotionPolicy = iota
+	// PromoteNever leaves the front to writes only
+	PromoteNever
+)
+
+// WritePolicy decides which tiers Set and friends write to.
+type WritePolicy int
+
+const (
+	// WriteThrough writes both tiers
+	WriteThrough WritePolicy = iota
+	// WriteAround writes the back tier and drops the key from the front, which gets it back when
+	// promoted, so written-once entries don't churn the front
+	WriteAround
+)
+
+type TieredOption[K comparable, V any] func(*Tiered[K, V])
+
+// WithPromotion sets when back tier hits are promoted, PromoteOnHit by default.
+func WithPromotion[K comparable, V any](policy PromotionPolicy) TieredOption[K, V] {
+	return func(t *Tiered[K, V]) {
+		t.promotion = policy
+	}
+}
+
+// WithWritePolicy sets which tiers writes go to, WriteThrough by default.
+func WithWritePolicy[K comparable, V any](policy WritePolicy) TieredOption[K, V] {
+	return func(t *Tiered[K, V]) {
+		t.write = policy
+	}
+}
+
+// NewTiered builds a cache reading through front to back.
+func NewTiered[K comparable, V any](front, back Cache[K, V], opts ...TieredOption[K, V]) *Tiered[K, V] {
+	t := &Tiered[K, V]{front: front, back: back}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// promote copies a back tier entry expiring at expiresAt to the front, unless it's about to expire.
+func (t *Tiered[K, V]) promote(key K, value V, expiresAt time.Time) {
+	if t.promotion != PromoteOnHit {
+		return
+	}
+	if ttl, ok := remainingTTL(expiresAt); ok {
+		t.front.SetWithTTL(key, value, ttl)
+	}
+}
+
+// remainingTTL returns the seconds left until expiresAt as SetWithTTL takes them, 0 for no expiry,
+// false when less than a second is left.
+func remainingTTL(expiresAt time.Time) (int, bool) {
+	if expiresAt.IsZero() {
+		return 0, true
+	}
+	ttl := int(math.Floor(time.Until(expiresAt).Seconds()))
+	return ttl, ttl > 0
+}
+
+func (t *Tiered[K, V]) Get(key K) (V, bool) {
+	if value, ok := t.front.Get(key); ok {
+		return value, true
+	}
+	value, ok := t.back.Get(key)
+	if ok && t.promotion == PromoteOnHit {
+		if _, expiresAt, found := t.back.GetWithExpiry(key); found {
+			t.promote(key, value, expiresAt)
+		}
+	}
+	return value, ok
+}
+
+func (t *Tiered[K, V]) GetWithExpiry(key K) (V, time.Time, bool) {
+	if value, expiresAt, ok := t.front.GetWithExpiry(key); ok {
+		return value, expiresAt, true
+	}
+	return t.back.GetWithExpiry(key)
+}
+
+func (t *Tiered[K, V]) Inspect(key K) (EntryInfo, bool) {
+	if info, ok := t.front.Inspect(key); ok {
+		return info, true
+	}
+	return t.back.Inspect(key)
+}
+
+func (t *Tiered[K, V]) Set(key K, value V) {
+	t.back.Set(key, value)
+	if t.write == WriteThrough {
+		t.front.Set(key, value)
+	} else {
+		t.front.Delete(key)
+	}
+}
+
+func (t *Tiered[K, V]) SetWithTTL(key K, value V, ttlSeconds int) error {
+	if err := t.back.SetWithTTL(key, value, ttlSeconds); err != nil {
+		return err
+	}
+	if t.write == WriteThrough {
+		return t.front.SetWithTTL(key, value, ttlSeconds)
+	}
+	t.front.Delete(key)
+	return nil
+}
+
+// GetOrLoad shares one load per key through the front tier. A miss there reads the back tier
+// (promoting per policy) before calling loader, whose value is written per the write policy.
+func (t *Tiered[K, V]) GetOrLoad(ctx context.Context, key K, loader func() (V, int, error)) (V, error) {
+	return t.front.GetOrLoad(ctx, key, func() (V, int, error) {
+		if value, expiresAt, ok := t.back.GetWithExpiry(key); ok {
+			if ttl, ok := remainingTTL(expiresAt); ok && t.promotion == PromoteOnHit {
+				return value, ttl, nil
+			}
+			return value, -1, nil
+		}
+
+		value, ttlSeconds, err := loader()
+		if err != nil || ttlSeconds < 0 {
+			return value, ttlSeconds, err
+		}
+		if ttlSeconds > 0 {
+			err = t.back.SetWithTTL(key, value, ttlSeconds)
+		} else {
+			t.back.Set(key, value)
+		}
+		if err != nil || t.write != WriteThrough {
+			return value, -1, err
+		}
+		return value, ttlSeconds, nil
+	})
+}
+
+func (t *Tiered[K, V]) Delete(key K) {
+	t.front.Delete(key)
+	t.back.Delete(key)
+}
+
+// Invalidate drops the entries of both tiers.
+func (t *Tiered[K, V]) Invalidate() {
+	t.front.Invalidate()
+	t.back.Invalidate()
+}
+
+func (t *Tiered[K, V]) GetMany(keys []K) map[K]V {
+	out := t.front.GetMany(keys)
+	var missing []K
+	for _, key := range keys {
+		if _, ok := out[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return out
+	}
+	for key, value := range t.back.GetMany(missing) {
+		out[key] = value
+		if _, expiresAt, ok := t.back.GetWithExpiry(key); ok {
+			t.promote(key, value, expiresAt)
+		}
+	}
+	return out
+}
+
+func (t *Tiered[K, V]) SetMany(entries map[K]V) {
+	t.back.SetMany(entries)
+	if t.write == WriteThrough {
+		t.front.SetMany(entries)
+		return
+	}
+	for key := range entries {
+		t.front.Delete(key)
+	}
+}
+
+func (t *Tiered[K, V]) Len() int {
+	return t.back.Len()
+}
+
+// GetAll returns the back tier's contents, with the front's values where both hold a key.
+func (t *Tiered[K, V]) GetAll() map[K]V {
+	out := t.back.GetAll()
+	maps.Copy(out, t.front.GetAll())
+	return out
+}
+
+// Range visits the back tier's entries.
+func (t *Tiered[K, V]) Range(fn func(key K, value V) bool) {
+	t.back.Range(fn)
+}
+
+// Stats sums both tiers' stats. Each tier counts its own lookups, so a back tier hit is also a
+// front tier miss, and an entry held by both counts twice in Items and Bytes.
+func (t *Tiered[K, V]) Stats() Stats {
+	front, back := t.front.Stats(), t.back.Stats()
+	return Stats{
+		Hits:        front.Hits + back.Hits,
+		Misses:      front.Misses + back.Misses,
+		Evictions:   front.Evictions + back.Evictions,
+		Expirations: front.Expirations + back.Expirations,
+		Items:       front.Items + back.Items,
+		Bytes:       front.Bytes + back.Bytes,
+	}
+}
+
+func (t *Tiered[K, V]) StartCleanupDaemon() {
+	t.front.StartCleanupDaemon()
+	t.back.StartCleanupDaemon()
+}
+
+func (t *Tiered[K, V]) StopCleanupDaemon() {
+	t.front.StopCleanupDaemon()
+	t.back.StopCleanupDaemon()
+}
+
+func (t *Tiered[K, V]) Close() {
+	t.front.Close()
+	t.back.Close()
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Registry holds a client per upstream so backends get their own connection pool, TLS settings
+// and timeouts instead of sharing one client.
+type Registry struct {
+	mu      sync.RWMutex
+	clients map[string]*http.Client
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		clients: make(map[string]*http.Client),
+	}
+}
+
+// Register adds the client for the upstream name, replacing any previous one.
+func (r *Registry) Register(name string, c *http.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[name] = c
+}
+
+// Get returns the client registered for the upstream name.
+func (r *Registry) Get(name string) (*http.Client, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.clients[name]
+	return c, ok
+}
+
+// CloseIdleConnections closes idle connections of every registered client, e.g. once a reload
+// has replaced the registry.
+func (r *Registry) CloseIdleConnections() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.clients {
+		c.CloseIdleConnections()
+	}
+}
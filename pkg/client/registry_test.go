@@ -0,0 +1,25 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	api := NewClient(WithTransport(NewTransport(WithMaxIdleConnsPerHost(10))))
+	static := NewClient(WithTransport(NewTransport(WithMaxIdleConnsPerHost(2))))
+	registry.Register("api", api)
+	registry.Register("static", static)
+
+	got, ok := registry.Get("api")
+	assert.True(t, ok)
+	assert.Same(t, api, got)
+	got, _ = registry.Get("static")
+	assert.NotSame(t, api.Transport, got.Transport)
+
+	_, ok = registry.Get("missing")
+	assert.False(t, ok)
+	registry.CloseIdleConnections()
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+)
+
+const (
+	defaultResolverTTL      = 30
+	defaultResolverCapacity = 1024
+)
+
+// Resolver caches upstream DNS lookups so high request rates don't turn into DNS traffic, entries
+// expire after the TTL and are looked up again on next use to pick up backend IP changes.
+type Resolver struct {
+	ttlSeconds int
+	roundRobin bool
+	lookup     func(ctx context.Context, host string) ([]string, error)
+	hosts      *cache.LRUWithTTL[string, *resolvedHost]
+}
+
+// resolvedHost holds the addresses of a host, next rotates the first address tried.
+type resolvedHost struct {
+	addrs []string
+	next  atomic.Uint32
+}
+
+type ResolverOption func(*Resolver)
+
+// WithResolverTTL sets how long lookups are cached, in seconds.
+func WithResolverTTL(ttlSeconds int) ResolverOption {
+	return func(r *Resolver) {
+		r.ttlSeconds = ttlSeconds
+	}
+}
+
+// WithRoundRobin spreads connections over all A/AAAA records instead of preferring the first.
+func WithRoundRobin(roundRobin bool) ResolverOption {
+	return func(r *Resolver) {
+		r.roundRobin = roundRobin
+	}
+}
+
+// WithLookup replaces the system resolver lookup.
+func WithLookup(lookup func(ctx context.Context, host string) ([]string, error)) ResolverOption {
+	return func(r *Resolver) {
+		r.lookup = lookup
+	}
+}
+
+func NewResolver(opts ...ResolverOption) (*Resolver, error) {
+	r := &Resolver{
+		ttlSeconds: defaultResolverTTL,
+		lookup:     net.DefaultResolver.LookupHost,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	hosts, err := cache.NewLRUTTL(
+		cache.WithCapacity[string, *resolvedHost](defaultResolverCapacity),
+		cache.WithDefaultTTL[string, *resolvedHost](r.ttlSeconds),
+		cache.WithLazyExpiration[string, *resolvedHost](cache.DefaultSweepPerOp),
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.hosts = hosts
+	return r, nil
+}
+
+// Resolve returns the addresses of host in the order they should be tried.
+func (r *Resolver) Resolve(ctx context.Context, host string) ([]string, error) {
+	resolved, ok := r.hosts.Get(host)
+	if !ok {
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		resolved = &resolvedHost{addrs: addrs}
+		r.hosts.Set(host, resolved)
+	}
+	if !r.roundRobin || len(resolved.addrs) == 1 {
+		return resolved.addrs, nil
+	}
+
+	start := int(resolved.next.Add(1)-1) % len(resolved.addrs)
+	ordered := make([]string, 0, len(resolved.addrs))
+	ordered = append(ordered, resolved.addrs[start:]...)
+	return append(ordered, resolved.addrs[:start]...), nil
+}
+
+// DialContext wraps dial so hostnames are resolved through the cache, each address is tried in
+// turn until one connects.
+func (r *Resolver) DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := r.Resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		// none connected, the backend may have moved so look it up again next time
+		r.hosts.Delete(host)
+		return nil, errors.Join(errs...)
+	}
+}
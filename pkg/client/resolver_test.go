@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver_CachesLookups(t *testing.T) {
+	lookups := 0
+	r, err := NewResolver(WithLookup(func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}))
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+
+	for range 3 {
+		addrs, err := r.Resolve(context.Background(), "api.internal")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, addrs)
+	}
+	assert.Equal(t, 1, lookups)
+}
+
+func TestResolver_RoundRobin(t *testing.T) {
+	r, _ := NewResolver(WithRoundRobin(true), WithLookup(func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil
+	}))
+
+	var firsts []string
+	for range 4 {
+		addrs, _ := r.Resolve(context.Background(), "api.internal")
+		firsts = append(firsts, addrs[0])
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"}, firsts)
+}
+
+func TestResolver_DialFallsBackAndReresolves(t *testing.T) {
+	lookups := 0
+	r, _ := NewResolver(WithLookup(func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}))
+
+	var dialed []string
+	dial := r.DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr == "10.0.0.2:80" {
+			conn, _ := net.Pipe()
+			return conn, nil
+		}
+		return nil, errors.New("refused")
+	})
+
+	conn, err := dial(context.Background(), "tcp", "api.internal:80")
+	assert.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, dialed)
+
+	// IP literals skip the resolver
+	_, err = dial(context.Background(), "tcp", "10.0.0.9:80")
+	assert.Error(t, err)
+	assert.Equal(t, 1, lookups)
+}
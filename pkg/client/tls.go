@@ -0,0 +1,59 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// WithTLSClientConfig sets the TLS config used for HTTPS upstreams. Options applied after it
+// (WithRootCAs, WithClientCertificate, ...) adjust a clone, the passed config is not modified.
+func WithTLSClientConfig(cfg *tls.Config) TransportOption {
+	return func(t *transport) {
+		t.TLSClientConfig = cfg.Clone()
+	}
+}
+
+// WithRootCAs verifies upstream certificates against pool instead of the system roots.
+func WithRootCAs(pool *x509.CertPool) TransportOption {
+	return func(t *transport) {
+		tlsConfig(t).RootCAs = pool
+	}
+}
+
+// WithClientCertificate presents cert to upstreams requiring mutual TLS.
+func WithClientCertificate(cert tls.Certificate) TransportOption {
+	return func(t *transport) {
+		cfg := tlsConfig(t)
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+}
+
+// WithServerName overrides the name used for SNI and certificate verification.
+func WithServerName(name string) TransportOption {
+	return func(t *transport) {
+		tlsConfig(t).ServerName = name
+	}
+}
+
+// WithInsecureSkipVerify disables upstream certificate verification, for testing only.
+func WithInsecureSkipVerify(skip bool) TransportOption {
+	return func(t *transport) {
+		tlsConfig(t).InsecureSkipVerify = skip
+	}
+}
+
+// WithALPN sets the protocols offered during the TLS handshake. With HTTP/2 enabled the transport
+// still adds h2 and http/1.1 when missing.
+func WithALPN(protos ...string) TransportOption {
+	return func(t *transport) {
+		tlsConfig(t).NextProtos = protos
+	}
+}
+
+// tlsConfig returns the transport's TLS config, creating it on first use.
+func tlsConfig(t *transport) *tls.Config {
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	return t.TLSClientConfig
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSOptions(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	pool := x509.NewCertPool()
+	transport := NewTransport(
+		WithTLSClientConfig(base),
+		WithRootCAs(pool),
+		WithServerName("internal.example"),
+		WithClientCertificate(tls.Certificate{}),
+	)
+
+	cfg := transport.TLSClientConfig
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Same(t, pool, cfg.RootCAs)
+	assert.Equal(t, "internal.example", cfg.ServerName)
+	assert.Len(t, cfg.Certificates, 1)
+	// the caller's config is left untouched
+	assert.Empty(t, base.ServerName)
+}
+
+func TestTLSOptions_WithoutBaseConfig(t *testing.T) {
+	transport := NewTransport(WithInsecureSkipVerify(true))
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+	assert.Nil(t, NewTransport().TLSClientConfig)
+}
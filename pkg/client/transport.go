@@ -1,34 +1,137 @@
 package client
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"time"
 )
 
-type TransportOption func(*http.Transport)
+// transport is the transport under construction along with the dialer it connects with.
+type transport struct {
+	*http.Transport
+	dialer *net.Dialer
+	// unixSocket, when set, is dialed for every connection instead of the request's host
+	unixSocket string
+	resolver   *Resolver
+	// dialContext replaces the dialer when set
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+type TransportOption func(*transport)
 
 func WithMaxIdleConns(maxIdleConns int) TransportOption {
-	return func(t *http.Transport) {
+	return func(t *transport) {
 		t.MaxIdleConns = maxIdleConns
 	}
 }
 
 func WithMaxIdleConnsPerHost(maxIdleConnsPerHost int) TransportOption {
-	return func(t *http.Transport) {
+	return func(t *transport) {
 		t.MaxIdleConnsPerHost = maxIdleConnsPerHost
 	}
 }
 
 func WithIdleConnTimeout(timeout time.Duration) TransportOption {
-	return func(t *http.Transport) {
+	return func(t *transport) {
 		t.IdleConnTimeout = timeout
 	}
 }
 
+// WithDisableCompression stops the transport from requesting gzip and transparently decoding it,
+// so upstream bodies reach clients (and the cache) with the encoding the upstream chose.
+func WithDisableCompression(disable bool) TransportOption {
+	return func(t *transport) {
+		t.DisableCompression = disable
+	}
+}
+
+// WithForceAttemptHTTP2 negotiates HTTP/2 with TLS upstreams even though the dialer and TLS
+// config are customized, which otherwise leaves the transport on HTTP/1.1.
+func WithForceAttemptHTTP2(force bool) TransportOption {
+	return func(t *transport) {
+		t.ForceAttemptHTTP2 = force
+	}
+}
+
+// WithDisableHTTP2 pins upstream connections to HTTP/1.1, for backends that negotiate h2 badly.
+func WithDisableHTTP2(disable bool) TransportOption {
+	return func(t *transport) {
+		if !disable {
+			t.Protocols = nil
+			return
+		}
+		t.Protocols = &http.Protocols{}
+		t.Protocols.SetHTTP1(true)
+	}
+}
+
+// WithDialTimeout bounds how long connecting to an upstream may take, 0 leaves it to the OS.
+func WithDialTimeout(timeout time.Duration) TransportOption {
+	return func(t *transport) {
+		t.dialer.Timeout = timeout
+	}
+}
+
+// WithKeepAlive sets the TCP keep-alive period of upstream connections, negative disables it.
+func WithKeepAlive(period time.Duration) TransportOption {
+	return func(t *transport) {
+		t.dialer.KeepAlive = period
+	}
+}
+
+// WithLocalAddr binds upstream connections to a local address, e.g. to pick an egress interface.
+func WithLocalAddr(addr net.Addr) TransportOption {
+	return func(t *transport) {
+		t.dialer.LocalAddr = addr
+	}
+}
+
+// WithDialContext opens upstream connections with dial instead of the built-in dialer, e.g. to
+// guard against SSRF or tag connections. The dialer options (timeout, keep-alive, local address)
+// no longer apply, the resolver and unix socket options still resolve addr before it is called.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) TransportOption {
+	return func(t *transport) {
+		t.dialContext = dial
+	}
+}
+
+// WithUnixSocket connects to the upstream over the unix socket at path, whatever the request host.
+func WithUnixSocket(path string) TransportOption {
+	return func(t *transport) {
+		t.unixSocket = path
+	}
+}
+
+// WithResolver resolves upstream hostnames through r's cache instead of on every dial.
+func WithResolver(r *Resolver) TransportOption {
+	return func(t *transport) {
+		t.resolver = r
+	}
+}
+
 func NewTransport(opts ...TransportOption) *http.Transport {
-	transport := &http.Transport{}
+	t := &transport{
+		Transport: &http.Transport{},
+		dialer:    &net.Dialer{},
+	}
 	for _, opt := range opts {
-		opt(transport)
+		opt(t)
+	}
+	// dial is the hook (or the dialer), the resolver and unix socket sit in front of it
+	dial := t.dialContext
+	if dial == nil {
+		dial = t.dialer.DialContext
+	}
+	if t.resolver != nil {
+		dial = t.resolver.DialContext(dial)
+	}
+	if t.unixSocket != "" {
+		base := dial
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return base(ctx, "unix", t.unixSocket)
+		}
 	}
-	return transport
+	t.DialContext = dial
+	return t.Transport
 }
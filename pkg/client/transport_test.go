@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialerOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	transport := NewTransport(
+		WithDialTimeout(time.Second),
+		WithKeepAlive(-1),
+		WithLocalAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}),
+	)
+	conn, err := transport.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+}
+
+func TestWithDisableCompression(t *testing.T) {
+	assert.True(t, NewTransport(WithDisableCompression(true)).DisableCompression)
+	assert.False(t, NewTransport().DisableCompression)
+}
+
+func TestWithUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("over unix " + r.URL.Path))
+	}))
+
+	c := NewClient(WithTransport(NewTransport(WithUnixSocket(path))))
+	resp, err := c.Get("http://localhost/ping")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "over unix /ping", string(body))
+}
+
+func TestWithDialContext(t *testing.T) {
+	var dialed []string
+	r, _ := NewResolver(WithLookup(func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	}))
+	transport := NewTransport(
+		WithResolver(r),
+		WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+addr)
+			conn, _ := net.Pipe()
+			return conn, nil
+		}),
+	)
+
+	conn, err := transport.DialContext(context.Background(), "tcp", "api.internal:443")
+	assert.NoError(t, err)
+	conn.Close()
+	// the hook sees the resolved address
+	assert.Equal(t, []string{"tcp 10.0.0.1:443"}, dialed)
+}
+
+func TestHTTP2Options(t *testing.T) {
+	assert.True(t, NewTransport(WithForceAttemptHTTP2(true)).ForceAttemptHTTP2)
+
+	pinned := NewTransport(WithForceAttemptHTTP2(true), WithDisableHTTP2(true), WithALPN("http/1.1"))
+	assert.True(t, pinned.Protocols.HTTP1())
+	assert.False(t, pinned.Protocols.HTTP2())
+	assert.Equal(t, []string{"http/1.1"}, pinned.TLSClientConfig.NextProtos)
+
+	assert.Nil(t, NewTransport(WithDisableHTTP2(false)).Protocols)
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+	"github.com/ashpect/revproxy/pkg/geoip"
+	"github.com/ashpect/revproxy/pkg/script"
+)
+
+// Check goes beyond Validate and verifies the config against the environment: upstream
+// hostnames resolve and referenced certificate files load. Every problem is reported.
+func (c *SystemCfg) Check(ctx context.Context) error {
+	errs := []error{}
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, checkUpstreams(ctx, "", c.Upstreams)...)
+	for i, s := range c.Servers {
+		errs = append(errs, checkUpstreams(ctx, fmt.Sprintf("servers[%d] (%s).", i, s.Name), s.Upstreams)...)
+	}
+
+	if c.GeoIPCfg.Database != "" {
+		if db, err := geoip.Open(c.GeoIPCfg.Database); err != nil {
+			errs = append(errs, fmt.Errorf("geoip.database: %w", err))
+		} else {
+			db.Close()
+		}
+	}
+
+	if c.ScriptCfg.Path != "" {
+		if _, err := script.Load(c.ScriptCfg.Path); err != nil {
+			errs = append(errs, fmt.Errorf("script.path: %w", err))
+		}
+	}
+
+	if _, err := errorpage.Load(c.ErrorPageFiles(nil)); err != nil {
+		errs = append(errs, fmt.Errorf("errorPages: %w", err))
+	}
+	errs = append(errs, checkRoutes("", c.Routes)...)
+	for i, s := range c.Servers {
+		errs = append(errs, checkRoutes(fmt.Sprintf("servers[%d] (%s).", i, s.Name), s.Routes)...)
+	}
+
+	if c.TLSCfg.Enabled && c.TLSCfg.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(c.TLSCfg.CertFile, c.TLSCfg.KeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+	if c.TLSCfg.Enabled && c.TLSCfg.ClientCAFile != "" {
+		if err := loadCAFile(c.TLSCfg.ClientCAFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls.clientCAFile: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkUpstreams checks upstreams resolve and their TLS files load, fields are prefixed with prefix.
+func checkUpstreams(ctx context.Context, prefix string, upstreams []upstreamCfg) []error {
+	var errs []error
+	for i, u := range upstreams {
+		field := fmt.Sprintf("%supstreams[%d] (%s)", prefix, i, u.Name)
+		if u.Discovery != nil {
+			if u.Discovery.Type != "srv" {
+				continue
+			}
+			if _, _, err := net.DefaultResolver.LookupSRV(ctx, "", "", u.Discovery.Name); err != nil {
+				errs = append(errs, fmt.Errorf("%s.discovery: %w", field, err))
+			}
+		} else if err := resolveUpstream(ctx, u.URL); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+		if err := loadUpstreamTLS(u.TLS); err != nil {
+			errs = append(errs, fmt.Errorf("%s.tls: %w", field, err))
+		}
+	}
+	return errs
+}
+
+// checkRoutes checks the files routes serve from load, fields are prefixed with prefix.
+func checkRoutes(prefix string, routes []routeCfg) []error {
+	var errs []error
+	for i, r := range routes {
+		field := fmt.Sprintf("%sroutes[%d] (%s)", prefix, i, r.Name)
+		// only the route's own pages, the global ones are checked by Check
+		if _, err := errorpage.Load((&SystemCfg{}).ErrorPageFiles(&r)); err != nil {
+			errs = append(errs, fmt.Errorf("%s.errorPages: %w", field, err))
+		}
+		if r.Mock != nil && r.Mock.BodyFile != "" {
+			if _, err := os.Stat(r.Mock.BodyFile); err != nil {
+				errs = append(errs, fmt.Errorf("%s.mock.bodyFile: %w", field, err))
+			}
+		}
+		if r.Static == nil {
+			continue
+		}
+		if info, err := os.Stat(r.Static.Root); err != nil {
+			errs = append(errs, fmt.Errorf("%s.static.root: %w", field, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s.static.root: %s is not a directory", field, r.Static.Root))
+		}
+	}
+	return errs
+}
+
+// resolveUpstream checks the upstream host resolves to at least one address, or for unix://
+// upstreams that the socket exists.
+func resolveUpstream(ctx context.Context, rawURL string) error {
+	if path, ok := strings.CutPrefix(rawURL, "unix://"); ok {
+		_, err := os.Stat(path)
+		return err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("resolving %s: %w", host, err)
+	}
+	return nil
+}
+
+// loadUpstreamTLS checks the CA bundle and client key pair can be loaded.
+func loadUpstreamTLS(cfg upstreamTLSCfg) error {
+	if cfg.CAFile != "" {
+		if err := loadCAFile(cfg.CAFile); err != nil {
+			return err
+		}
+	}
+	if cfg.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCAFile checks path holds at least one PEM certificate.
+func loadCAFile(path string) error {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", path)
+	}
+	return nil
+}
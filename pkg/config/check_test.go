@@ -0,0 +1,118 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKeyPair writes a self-signed CA certificate and its key to dir, returning their paths.
+func writeKeyPair(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test Check reports upstreams that don't resolve and TLS files that don't load
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "server")
+	_, otherKeyFile := writeKeyPair(t, dir, "other")
+	emptyFile := filepath.Join(dir, "empty.pem")
+	writeFile(t, emptyFile, nil)
+
+	tests := []struct {
+		name   string
+		modify func(c *SystemCfg)
+		err    string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *SystemCfg) {},
+		},
+		{
+			name:   "unresolvable upstream",
+			modify: func(c *SystemCfg) { c.Upstreams = []upstreamCfg{{Name: "api", URL: "http://upstream.invalid/"}} },
+			err:    "upstreams[0] (api): resolving upstream.invalid",
+		},
+		{
+			name:   "unreadable CA file",
+			modify: func(c *SystemCfg) { c.TLSCfg.ClientCAFile = filepath.Join(dir, "missing.pem") },
+			err:    "tls.clientCAFile: open",
+		},
+		{
+			name:   "empty CA file",
+			modify: func(c *SystemCfg) { c.TLSCfg.ClientCAFile = emptyFile },
+			err:    "tls.clientCAFile: no certificates found in " + emptyFile,
+		},
+		{
+			name:   "mismatched key pair",
+			modify: func(c *SystemCfg) { c.TLSCfg.KeyFile = otherKeyFile },
+			err:    "tls: tls: private key does not match public key",
+		},
+		{
+			name: "upstream CA file",
+			modify: func(c *SystemCfg) {
+				c.Upstreams = []upstreamCfg{{Name: "api", URL: "http://127.0.0.1:9001/", TLS: upstreamTLSCfg{CAFile: emptyFile}}}
+			},
+			err: "upstreams[0] (api).tls: no certificates found in " + emptyFile,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := *defaultSystemCfg
+			config.ProxyCfg.UpstreamURL = "http://127.0.0.1:9000/"
+			config.TLSCfg.Enabled = true
+			config.TLSCfg.CertFile, config.TLSCfg.KeyFile, config.TLSCfg.ClientCAFile = certFile, keyFile, certFile
+			tt.modify(&config)
+
+			err := config.Check(context.Background())
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
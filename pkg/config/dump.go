@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Dump writes the effective config in format (toml, yaml or json) with every field tagged
+// `secret:"true"` redacted. The receiver is left untouched.
+func (c *SystemCfg) Dump(w io.Writer, format string) error {
+	clone, err := c.clone()
+	if err != nil {
+		return err
+	}
+	redact(reflect.ValueOf(clone).Elem())
+
+	switch format {
+	case "toml":
+		return toml.NewEncoder(w).Encode(clone)
+	case "yaml":
+		return yaml.NewEncoder(w).Encode(clone)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(clone)
+	default:
+		return fmt.Errorf("unsupported dump format %q", format)
+	}
+}
+
+// clone deep copies the config through its JSON form.
+func (c *SystemCfg) clone() (*SystemCfg, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	clone := &SystemCfg{}
+	return clone, json.Unmarshal(data, clone)
+}
+
+// redact blanks non-empty string fields (and string map values) tagged secret, walking nested
+// structs and slices.
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if v.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String {
+				if field.String() != "" {
+					field.SetString(redacted)
+				}
+				continue
+			}
+			if v.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.String {
+				for _, key := range field.MapKeys() {
+					field.SetMapIndex(key, reflect.ValueOf(redacted))
+				}
+				continue
+			}
+			redact(field)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			redact(v.Index(i))
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			redact(v.Elem())
+		}
+	}
+}
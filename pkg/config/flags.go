@@ -0,0 +1,89 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// setting is a config value that can be overridden from the command line and environment.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *SystemCfg, value string) error
+}
+
+// settings overridable without a config file. Precedence: flags > env > file > defaults.
+var settings = []setting{
+	{"listen", "REVPROXY_LISTEN_ADDR", "address to listen on, e.g. :8000", func(c *SystemCfg, v string) error {
+		c.ListenAddr = v
+		return nil
+	}},
+	{"upstream", "REVPROXY_UPSTREAM_URL", "upstream URL, shorthand for an upstream named default", func(c *SystemCfg, v string) error {
+		c.ProxyCfg.UpstreamURL = v
+		return nil
+	}},
+	{"cache-capacity", "REVPROXY_CACHE_CAPACITY", "max number of cached responses", func(c *SystemCfg, v string) error {
+		return setInt(&c.CacheCfg.CacheCapacity, v)
+	}},
+	{"cache-ttl", "REVPROXY_CACHE_TTL", "default cache TTL in seconds", func(c *SystemCfg, v string) error {
+		return setInt(&c.CacheCfg.DefaultTTL, v)
+	}},
+	{"cache-enabled", "REVPROXY_CACHE_ENABLED", "enable response caching (true/false)", func(c *SystemCfg, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		c.CacheCfg.Enabled = enabled
+		return err
+	}},
+	{"faults", "REVPROXY_FAULTS", "inject the routes' configured faults (true/false)", func(c *SystemCfg, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		c.FaultCfg.Enabled = enabled
+		return err
+	}},
+	{"log-level", "REVPROXY_LOG_LEVEL", "log level: debug, info or error", func(c *SystemCfg, v string) error {
+		c.LogLevel = v
+		return nil
+	}},
+}
+
+func setInt(dst *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+// registerSettingFlags adds a string flag per setting, returning the parsed values by flag name.
+func registerSettingFlags(fs *flag.FlagSet) map[string]*string {
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	return values
+}
+
+// applyOverrides layers environment variables and then explicitly passed flags on top of config.
+func applyOverrides(config *SystemCfg, fs *flag.FlagSet, values map[string]*string) error {
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(config, v); err != nil {
+				return fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if setErr := s.set(config, *values[s.flag]); setErr != nil {
+					err = fmt.Errorf("flag -%s: %w", s.flag, setErr)
+				}
+			}
+		}
+	})
+	return err
+}
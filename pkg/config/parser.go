@@ -1,35 +1,283 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 var defaultSystemCfg = &SystemCfg{
 	ListenAddr: ":8000",
 	ProxyCfg: proxyCfg{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     10 * time.Second,
+		MaxIdleConns:           100,
+		MaxIdleConnsPerHost:    100,
+		IdleConnTimeout:        Duration{10 * time.Second},
+		HTTP2:                  true,
+		BufferBudget:           64 << 20,
+		DialTimeout:            Duration{30 * time.Second},
+		DNSCacheTTL:            Duration{30 * time.Second},
+		KeepAlive:              Duration{30 * time.Second},
+		Forwarded:              "x-forwarded",
+		ClientIP:               "remote",
+		MergeSlashes:           true,
+		ResolveDots:            true,
+		RejectEncodedTraversal: true,
 	},
 	CacheCfg: cacheCfg{
 		Enabled:       true,
 		CacheCapacity: 100,
 		DefaultTTL:    60,
+		MaxBodySize:   1 << 20,
+	},
+	WarmCfg: warmCfg{
+		Concurrency: 4,
+		OnStart:     true,
+	},
+	TLSCfg: tlsCfg{
+		MinVersion: "1.2",
+		ALPN:       []string{"h2", "http/1.1"},
+	},
+	ServerCfg: serverCfg{
+		ReadHeaderTimeout: Duration{10 * time.Second},
+		ReadTimeout:       Duration{60 * time.Second},
+		IdleTimeout:       Duration{120 * time.Second},
+		MaxHeaderBytes:    1 << 20,
+		ShutdownTimeout:   Duration{30 * time.Second},
+	},
+	GeoIPCfg: geoipCfg{
+		Header: "X-Country-Code",
+	},
+	OverrideCfg: overrideCfg{
+		Header: "X-Revproxy-Upstream",
+	},
+	ScriptCfg: scriptCfg{
+		Timeout: Duration{100 * time.Millisecond},
+	},
+	MetricsCfg: metricsCfg{
+		Interval:    Duration{60 * time.Second},
+		ServiceName: "revproxy",
+		LabelLimit:  100,
+	},
+	RecordCfg: recordCfg{
+		Format:      "jsonl",
+		Sample:      1,
+		MaxBodySize: 64 << 10,
+	},
+	AccessLogCfg: accessLogCfg{
+		Format:     "combined",
+		MaxBackups: 5,
+	},
+	AdminCfg: adminCfg{
+		Enabled:    false,
+		ListenAddr: "127.0.0.1:8001",
 	},
 }
 
+const defaultConfigFile = "config.toml"
+
+// LoadConfig loads config from the process command line, see Load.
 func LoadConfig() (*SystemCfg, error) {
-	configFile := flag.String("config", "config.toml", "location of config file")
-	flag.Parse()
-	config := defaultSystemCfg
+	return Load(os.Args[1:], nil)
+}
+
+// Load parses args and builds the config with precedence flags > env > file > defaults.
+// The config file is optional unless -config is passed explicitly.
+// register, if set, can add caller specific flags (e.g. -t) to the same flag set.
+func Load(args []string, register func(fs *flag.FlagSet)) (*SystemCfg, error) {
+	fs := flag.NewFlagSet("revproxy", flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile, "location of config file (env REVPROXY_CONFIG)")
+	configFormat := fs.String("config-format", "", "config file format: toml, yaml or json (detected from extension if empty)")
+	values := registerSettingFlags(fs)
+	if register != nil {
+		register(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
-	if _, err := toml.DecodeFile(*configFile, config); err != nil {
-		log.Fatal(err)
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	if env, ok := os.LookupEnv("REVPROXY_CONFIG"); ok && !explicit {
+		*configFile, explicit = env, true
 	}
 
+	config := defaultConfig()
+	if err := decodeWithIncludes(*configFile, *configFormat, config, map[string]bool{}); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("decoding %s: %w", *configFile, err)
+		}
+	}
+	if err := applyOverrides(config, fs, values); err != nil {
+		return nil, err
+	}
+	if err := config.resolveReferences(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", *configFile, err)
+	}
+	config.normalize()
+
 	return config, nil
 }
+
+// defaultConfig returns a fresh copy of the defaults.
+func defaultConfig() *SystemCfg {
+	config := *defaultSystemCfg
+	return &config
+}
+
+// Default returns the defaults a config file is decoded over, for configs built in code.
+func Default() *SystemCfg {
+	return defaultConfig()
+}
+
+// DecodeFile decodes the config file at path (and its includes) over c, the format is detected
+// from the extension.
+func (c *SystemCfg) DecodeFile(path string) error {
+	return decodeWithIncludes(path, "", c, map[string]bool{})
+}
+
+// Complete prepares a config built in code the way Load prepares a file: references are resolved,
+// it is validated and derived values are filled in.
+func (c *SystemCfg) Complete() error {
+	if err := c.resolveReferences(); err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.normalize()
+	return nil
+}
+
+const defaultUpstreamName = "default"
+
+// normalize fills derived values in a validated config: the legacy proxy.upstreamURL becomes
+// the first upstream, and unset weights default to 1.
+func (c *SystemCfg) normalize() {
+	if c.ProxyCfg.UpstreamURL != "" {
+		legacy := upstreamCfg{Name: defaultUpstreamName, URL: c.ProxyCfg.UpstreamURL}
+		c.Upstreams = append([]upstreamCfg{legacy}, c.Upstreams...)
+		c.ProxyCfg.UpstreamURL = ""
+	}
+	for i := range c.Upstreams {
+		if c.Upstreams[i].Weight == 0 {
+			c.Upstreams[i].Weight = 1
+		}
+		if d := c.Upstreams[i].Discovery; d != nil {
+			if d.Scheme == "" {
+				d.Scheme = "http"
+			}
+			if d.Interval.Duration == 0 {
+				d.Interval = Duration{30 * time.Second}
+			}
+		}
+	}
+}
+
+// decodeFile decodes path into config on top of whatever values config already holds.
+func decodeFile(path, format string, config *SystemCfg) error {
+	if format == "" {
+		format = formatFromExt(path)
+	}
+
+	switch format {
+	case "toml":
+		_, err := toml.DecodeFile(path, config)
+		return err
+	case "yaml", "json":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if format == "yaml" {
+			return yaml.Unmarshal(data, config)
+		}
+		return json.Unmarshal(data, config)
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// decodeWithIncludes decodes path and then every fragment matched by its include globs, in order.
+// Fragments override scalar values, while list sections (upstreams, routes, experiments, servers) are appended, so
+// per-service files can each contribute their own entries. visited guards against include cycles.
+func decodeWithIncludes(path, format string, config *SystemCfg, visited map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visited[abs] {
+		return fmt.Errorf("include cycle through %s", path)
+	}
+	visited[abs] = true
+
+	lists := config.takeLists()
+	config.Include = nil
+	if err := decodeFile(path, format, config); err != nil {
+		return err
+	}
+	config.prependLists(lists)
+
+	for _, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("include %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if err := decodeWithIncludes(match, "", config, visited); err != nil {
+				return fmt.Errorf("include %s: %w", match, err)
+			}
+		}
+	}
+	return nil
+}
+
+// listSections are the config sections that includes append to rather than replace.
+type listSections struct {
+	upstreams   []upstreamCfg
+	routes      []routeCfg
+	experiments []experimentCfg
+	servers     []serverBlockCfg
+}
+
+// takeLists detaches the list sections so decoding a fragment starts them empty.
+func (c *SystemCfg) takeLists() listSections {
+	lists := listSections{upstreams: c.Upstreams, routes: c.Routes, experiments: c.Experiments, servers: c.Servers}
+	c.Upstreams, c.Routes, c.Experiments, c.Servers = nil, nil, nil, nil
+	return lists
+}
+
+// prependLists puts previously taken entries back in front of newly decoded ones.
+func (c *SystemCfg) prependLists(lists listSections) {
+	c.Upstreams = append(lists.upstreams, c.Upstreams...)
+	c.Routes = append(lists.routes, c.Routes...)
+	c.Experiments = append(lists.experiments, c.Experiments...)
+	c.Servers = append(lists.servers, c.Servers...)
+}
+
+// formatFromExt detects the config format from the file extension, defaulting to toml.
+func formatFromExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	default:
+		return "toml"
+	}
+}
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the same config decodes identically from toml, yaml and json
+func TestDecodeFile_formats(t *testing.T) {
+	files := map[string]string{
+		"config.toml": `
+listenaddr = ":9999"
+[proxy]
+upstreamURL = "http://localhost:9000/"
+idleConnTimeout = "5s"
+[cache]
+cacheCapacity = 7
+`,
+		"config.yaml": `
+listenaddr: ":9999"
+proxy:
+  upstreamURL: "http://localhost:9000/"
+  idleConnTimeout: 5s
+cache:
+  cacheCapacity: 7
+`,
+		"config.json": `{
+  "listenaddr": ":9999",
+  "proxy": {"upstreamURL": "http://localhost:9000/", "idleConnTimeout": "5s"},
+  "cache": {"cacheCapacity": 7}
+}`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		config := &SystemCfg{CacheCfg: cacheCfg{DefaultTTL: 60}}
+		if err := decodeFile(path, "", config); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		assert.Equal(t, ":9999", config.ListenAddr, name)
+		assert.Equal(t, "http://localhost:9000/", config.ProxyCfg.UpstreamURL, name)
+		assert.Equal(t, 5*time.Second, config.ProxyCfg.IdleConnTimeout.Duration, name)
+		assert.Equal(t, 7, config.CacheCfg.CacheCapacity, name)
+		assert.Equal(t, 60, config.CacheCfg.DefaultTTL, name) // defaults survive
+	}
+}
+
+// Test precedence flags > env > file > defaults, and that the default config file is optional
+func TestLoad_precedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	content := `
+listenaddr = ":7000"
+[proxy]
+upstreamURL = "http://file:9000/"
+[cache]
+cacheCapacity = 5
+defaultTTL = 10
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("REVPROXY_CACHE_CAPACITY", "50")
+	t.Setenv("REVPROXY_LISTEN_ADDR", ":7001")
+	config, err := Load([]string{"-config", path, "-listen", ":7002"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ":7002", config.ListenAddr)        // flag beats env
+	assert.Equal(t, 50, config.CacheCfg.CacheCapacity) // env beats file
+	assert.Equal(t, 10, config.CacheCfg.DefaultTTL)    // file beats default
+	assert.Equal(t, 100, config.ProxyCfg.MaxIdleConns) // default
+	assert.Equal(t, "http://file:9000/", config.DefaultUpstream().URL)
+
+	// no config file needed for one-off runs
+	t.Chdir(dir)
+	os.Remove(path)
+	config, err = Load([]string{"-upstream", "http://flag:9000/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http://flag:9000/", config.DefaultUpstream().URL)
+
+	_, err = Load([]string{"-config", path}, nil)
+	assert.Error(t, err)
+}
+
+// Test include fragments append list sections and override scalars
+func TestLoad_include(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": `
+include = ["routes.d/*.toml", "routes.d/*.yaml"]
+[proxy]
+upstreamURL = "http://localhost:9000/"
+[cache]
+defaultTTL = 10
+[[routes]]
+name = "main"
+upstream = "default"
+[routes.match]
+pathPrefix = "/main"
+`,
+		"routes.d/a.toml": `
+[cache]
+defaultTTL = 20
+[[upstreams]]
+name = "a"
+url = "http://a:9000/"
+[[routes]]
+name = "a"
+upstream = "a"
+[routes.match]
+pathPrefix = "/a"
+`,
+		"routes.d/b.yaml": `
+routes:
+  - name: b
+    upstream: default
+    match:
+      pathPrefix: /b
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := Load([]string{"-config", filepath.Join(dir, "config.toml")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 20, config.CacheCfg.DefaultTTL)
+	assert.Len(t, config.Upstreams, 2)
+	var routes []string
+	for _, r := range config.Routes {
+		routes = append(routes, r.Name)
+	}
+	assert.Equal(t, []string{"main", "a", "b"}, routes)
+}
+
+// Test [[servers]] blocks inherit the shared sections and replace or override their own
+func TestServerConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+listenaddr = ":8080"
+[proxy]
+upstreamURL = "http://localhost:9000/"
+[cache]
+cacheCapacity = 100
+defaultTTL = 60
+[accessLog]
+path = "/var/log/revproxy/access.log"
+[[servers]]
+name = "api"
+listenaddr = ":8081"
+accessLog = "/var/log/revproxy/api.log"
+[[servers.upstreams]]
+name = "api"
+url = "http://localhost:9001/"
+[servers.cache]
+defaultTTL = 5
+[[servers]]
+name = "static"
+listenaddr = ":8082"
+[servers.cache]
+enabled = false
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load([]string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := config.ServerConfigs()
+	assert.Len(t, servers, 3)
+	main, api, static := servers[0], servers[1], servers[2]
+	assert.Equal(t, "default", main.ServerName)
+	assert.Equal(t, ":8080", main.ListenAddr)
+	assert.Equal(t, "http://localhost:9000/", main.DefaultUpstream().URL)
+
+	assert.Equal(t, "api", api.ServerName)
+	assert.Equal(t, ":8081", api.ListenAddr)
+	assert.Equal(t, "http://localhost:9001/", api.DefaultUpstream().URL)
+	assert.Len(t, api.Upstreams, 1)
+	assert.Equal(t, 100, api.CacheCfg.CacheCapacity)
+	assert.Equal(t, 5, api.CacheCfg.DefaultTTL)
+	assert.Equal(t, "/var/log/revproxy/api.log", api.AccessLogCfg.Path)
+
+	assert.Equal(t, "http://localhost:9000/", static.DefaultUpstream().URL)
+	assert.False(t, static.CacheCfg.Enabled)
+	assert.Empty(t, static.AccessLogCfg.Path)
+	assert.True(t, main.CacheCfg.Enabled)
+
+	var out strings.Builder
+	assert.NoError(t, config.Summary(&out))
+	assert.Contains(t, out.String(), "\nserver api\nlisten  :8081\n")
+}
+
+// Test dumps redact secrets without touching the loaded config
+func TestDump_redacts(t *testing.T) {
+	config := defaultConfig()
+	config.TLSCfg.ACME.Email = "ops@example.com"
+	config.MetricsCfg.OTLPHeaders = map[string]string{"X-Api-Key": "key-1234"}
+	config.Upstreams = []upstreamCfg{{Name: "default", URL: "http://localhost:9000/"}}
+
+	for _, format := range []string{"toml", "yaml", "json"} {
+		var out strings.Builder
+		assert.NoError(t, config.Dump(&out, format), format)
+		assert.Contains(t, out.String(), "REDACTED", format)
+		assert.NotContains(t, out.String(), "ops@example.com", format)
+		assert.NotContains(t, out.String(), "key-1234", format)
+		assert.Contains(t, out.String(), "http://localhost:9000/", format)
+	}
+	assert.Equal(t, "ops@example.com", config.TLSCfg.ACME.Email)
+	assert.Equal(t, "key-1234", config.MetricsCfg.OTLPHeaders["X-Api-Key"])
+}
+
+// Test the summary lists upstreams and routes in match order with their targets
+func TestSummary(t *testing.T) {
+	config := defaultConfig()
+	config.Upstreams = []upstreamCfg{
+		{Name: "default", URL: "http://localhost:9000/"},
+		{Name: "api", URL: "http://localhost:9001/", Weight: 3},
+	}
+	config.Routes = []routeCfg{
+		{Name: "api", Match: routeMatchCfg{Host: "api.example.com", PathPrefix: "/v1/"}, Upstream: "api", Methods: []string{"GET"}},
+		{Name: "assets", Match: routeMatchCfg{PathPrefix: "/assets/"}, Static: &staticCfg{Root: "./public"}},
+		{Name: "health", Match: routeMatchCfg{PathPrefix: "/healthz"}, Mock: &mockCfg{Status: 204}},
+	}
+
+	var out strings.Builder
+	assert.NoError(t, config.Summary(&out))
+	summary := out.String()
+	assert.Contains(t, summary, "weight 3")
+	assert.Regexp(t, `api +host=api.example.com path=/v1/\* +-> api +methods GET\n`, summary)
+	assert.Contains(t, summary, "-> static ./public")
+	assert.Contains(t, summary, "-> mock 204")
+	assert.Regexp(t, `\(unmatched\) +\* +-> default\n`, summary)
+	assert.Less(t, strings.Index(summary, "assets"), strings.Index(summary, "health"))
+}
+
+// Test ${ENV:...} and ${FILE:...} references are resolved
+func TestResolveReferences(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "email")
+	os.WriteFile(secret, []byte("ops@example.com\n"), 0o600)
+	t.Setenv("UPSTREAM_HOST", "api.internal")
+
+	config := defaultConfig()
+	config.TLSCfg.ACME.Email = "${FILE:" + secret + "}"
+	config.Upstreams = []upstreamCfg{{Name: "api", URL: "http://${ENV:UPSTREAM_HOST}:9000/"}}
+	config.MetricsCfg.OTLPHeaders = map[string]string{"Authorization": "Bearer ${ENV:OTEL_TOKEN}", "X-Team": "edge"}
+	t.Setenv("OTEL_TOKEN", "token-1234")
+	assert.NoError(t, config.resolveReferences())
+	assert.Equal(t, "ops@example.com", config.TLSCfg.ACME.Email)
+	assert.Equal(t, "http://api.internal:9000/", config.Upstreams[0].URL)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer token-1234", "X-Team": "edge"}, config.MetricsCfg.OTLPHeaders)
+
+	config.MetricsCfg.OTLPHeaders["X-Api-Key"] = "${ENV:REVPROXY_TEST_UNSET}"
+	assert.ErrorContains(t, config.resolveReferences(), "metrics.otlpHeaders.X-Api-Key: environment variable REVPROXY_TEST_UNSET is not set")
+	delete(config.MetricsCfg.OTLPHeaders, "X-Api-Key")
+
+	config.ListenAddr = "${ENV:REVPROXY_TEST_UNSET}"
+	assert.ErrorContains(t, config.resolveReferences(), "listenaddr: environment variable REVPROXY_TEST_UNSET is not set")
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// referencePattern matches ${ENV:NAME} and ${FILE:/path} references inside config strings.
+var referencePattern = regexp.MustCompile(`\$\{(ENV|FILE):([^}]+)\}`)
+
+// resolveReferences replaces ${ENV:NAME} with the environment variable and ${FILE:/path} with
+// the trimmed file contents in every string value, so credentials don't have to live in the
+// config file itself (e.g. Docker/Kubernetes secrets mounted under /run/secrets).
+func (c *SystemCfg) resolveReferences() error {
+	var errs []error
+	walkStrings(reflect.ValueOf(c).Elem(), "", func(path string, value reflect.Value) {
+		resolved := referencePattern.ReplaceAllStringFunc(value.String(), func(ref string) string {
+			match := referencePattern.FindStringSubmatch(ref)
+			kind, name := match[1], match[2]
+			switch kind {
+			case "ENV":
+				if v, ok := os.LookupEnv(name); ok {
+					return v
+				}
+				errs = append(errs, fmt.Errorf("%s: environment variable %s is not set", path, name))
+			case "FILE":
+				data, err := os.ReadFile(name)
+				if err == nil {
+					return strings.TrimSpace(string(data))
+				}
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+			return ref
+		})
+		value.SetString(resolved)
+	})
+	return errors.Join(errs...)
+}
+
+// walkStrings calls fn for every settable string reachable from v, with its config path. Map
+// values aren't addressable, so each is walked as a copy and set back.
+func walkStrings(v reflect.Value, path string, fn func(path string, value reflect.Value)) {
+	switch v.Kind() {
+	case reflect.String:
+		fn(path, v)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if path != "" {
+				name = path + "." + name
+			}
+			walkStrings(v.Field(i), name, fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			walkStrings(value, fmt.Sprintf("%s.%v", path, key), fn)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), path, fn)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"regexp"
+	"slices"
+)
+
+// defaultServerName names the top-level server once [[servers]] are configured.
+const defaultServerName = "default"
+
+// serverNamePattern keeps server names usable in admin paths, metric labels and socket names.
+var serverNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ServerConfigs returns the config of each proxy the process runs: the top-level one, named
+// default, followed by one per [[servers]] block. Without blocks it is just c, unnamed.
+func (c *SystemCfg) ServerConfigs() []*SystemCfg {
+	if len(c.Servers) == 0 {
+		return []*SystemCfg{c}
+	}
+	main := *c
+	main.Servers = nil
+	main.ServerName = defaultServerName
+	configs := []*SystemCfg{&main}
+	for i := range c.Servers {
+		server := c.server(&c.Servers[i])
+		server.normalize()
+		configs = append(configs, server)
+	}
+	return configs
+}
+
+// server derives the config of block from c. Traffic recording stays with the top-level server.
+func (c *SystemCfg) server(block *serverBlockCfg) *SystemCfg {
+	s := *c
+	s.Servers = nil
+	s.ServerName = block.Name
+	s.ListenAddr, s.ListenSocketMode = block.ListenAddr, block.ListenSocketMode
+	s.Upstreams = slices.Clone(c.Upstreams)
+	if len(block.Upstreams) > 0 {
+		// the proxy.upstreamURL shorthand is a top-level upstream too
+		s.Upstreams, s.ProxyCfg.UpstreamURL = slices.Clone(block.Upstreams), ""
+	}
+	if len(block.Routes) > 0 {
+		s.Routes = slices.Clone(block.Routes)
+	}
+
+	if block.Cache.Enabled != nil {
+		s.CacheCfg.Enabled = *block.Cache.Enabled
+	}
+	if block.Cache.CacheCapacity > 0 {
+		s.CacheCfg.CacheCapacity = block.Cache.CacheCapacity
+	}
+	if block.Cache.DefaultTTL > 0 {
+		s.CacheCfg.DefaultTTL = block.Cache.DefaultTTL
+	}
+	if block.Cache.PartitionBy != "" {
+		s.CacheCfg.PartitionBy = block.Cache.PartitionBy
+	}
+
+	s.AccessLogCfg.Path = block.AccessLog
+	s.RecordCfg.Path = ""
+	return &s
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Summary writes a human readable overview of what the config serves: listeners, cache,
+// upstreams and the routes in match order, then the same for each [[servers]] block. Secrets
+// aren't part of it.
+func (c *SystemCfg) Summary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	listen := c.ListenAddr
+	if c.TLSCfg.Enabled {
+		if c.TLSCfg.ACME.Enabled {
+			listen += " (tls, acme: " + strings.Join(c.TLSCfg.ACME.Domains, ", ") + ")"
+		} else {
+			listen += " (tls)"
+		}
+	}
+	fmt.Fprintf(tw, "listen\t%s\n", listen)
+	if c.AdminCfg.Enabled {
+		fmt.Fprintf(tw, "admin\t%s\n", c.AdminCfg.ListenAddr)
+	}
+	if cacheCfg := c.CacheCfg; cacheCfg.Enabled {
+		cache := fmt.Sprintf("%d entries, default ttl %ds", cacheCfg.CacheCapacity, cacheCfg.DefaultTTL)
+		if cacheCfg.PartitionBy != "" {
+			cache += ", partitioned by " + cacheCfg.PartitionBy
+		}
+		fmt.Fprintf(tw, "cache\t%s\n", cache)
+	} else {
+		fmt.Fprintf(tw, "cache\tdisabled\n")
+	}
+
+	fmt.Fprintf(tw, "\nupstreams\n")
+	for _, u := range c.Upstreams {
+		target := u.URL
+		if u.Discovery != nil {
+			target = u.Discovery.Type + " " + u.Discovery.Name
+		}
+		weight := ""
+		if u.Weight > 1 {
+			weight = fmt.Sprintf("weight %d", u.Weight)
+		}
+		row(tw, "  "+u.Name, target, weight)
+	}
+
+	fmt.Fprintf(tw, "\nroutes (first match wins)\n")
+	for _, r := range c.Routes {
+		row(tw, "  "+r.Name, describeMatch(r.Match), "-> "+c.routeTarget(r), describeRouteExtras(r))
+	}
+	row(tw, "  (unmatched)", "*", "-> "+c.DefaultUpstream().Name)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, server := range c.ServerConfigs()[1:] {
+		fmt.Fprintf(w, "\nserver %s\n", server.ServerName)
+		if err := server.Summary(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// row writes cells as one aligned line, leaving out trailing empty ones so it isn't padded.
+func row(w io.Writer, cells ...string) {
+	for len(cells) > 1 && cells[len(cells)-1] == "" {
+		cells = cells[:len(cells)-1]
+	}
+	fmt.Fprintln(w, strings.Join(cells, "\t"))
+}
+
+// describeMatch renders a route's conditions, * when it matches everything.
+func describeMatch(m routeMatchCfg) string {
+	var parts []string
+	if m.Host != "" {
+		parts = append(parts, "host="+m.Host)
+	}
+	if m.PathPrefix != "" {
+		parts = append(parts, "path="+m.PathPrefix+"*")
+	}
+	if len(m.Countries) > 0 {
+		parts = append(parts, "countries="+strings.Join(m.Countries, ","))
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.Query)) {
+		parts = append(parts, "query."+name+"="+m.Query[name])
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.Experiments)) {
+		parts = append(parts, "experiment."+name+"="+m.Experiments[name])
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+// routeTarget names what answers a route: its upstream, or the local files or mock response.
+func (c *SystemCfg) routeTarget(r routeCfg) string {
+	switch {
+	case r.Static != nil:
+		return "static " + r.Static.Root
+	case r.Mock != nil:
+		return fmt.Sprintf("mock %d", r.Mock.Status)
+	case r.Upstream == "":
+		return c.DefaultUpstream().Name
+	default:
+		return r.Upstream
+	}
+}
+
+// describeRouteExtras lists the settings changing how a route is served beyond its target.
+func describeRouteExtras(r routeCfg) string {
+	var parts []string
+	if len(r.Methods) > 0 {
+		parts = append(parts, "methods "+strings.Join(r.Methods, ","))
+	}
+	if len(r.Middleware) > 0 {
+		parts = append(parts, "middleware "+strings.Join(r.Middleware, ","))
+	}
+	if r.Cache.Enabled != nil && !*r.Cache.Enabled {
+		parts = append(parts, "not cached")
+	}
+	if r.Options != nil {
+		parts = append(parts, "answers OPTIONS")
+	}
+	if r.Fault != nil {
+		parts = append(parts, "faults")
+	}
+	return strings.Join(parts, "; ")
+}
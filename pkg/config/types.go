@@ -1,22 +1,631 @@
 package config
 
-import "time"
+import (
+	"io/fs"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Duration wraps time.Duration so "10s" style strings decode the same way from TOML, YAML and JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
 
 type proxyCfg struct {
-	UpstreamURL         string        `toml:"upstreamURL"`
-	MaxIdleConns        int           `toml:"maxIdleConn"`
-	MaxIdleConnsPerHost int           `toml:"maxIdleConnPerHost"`
-	IdleConnTimeout     time.Duration `toml:"idleConnTimeout"`
+	// UpstreamURL is shorthand for a single upstream named "default", prefer [[upstreams]]
+	UpstreamURL         string   `toml:"upstreamURL" yaml:"upstreamURL" json:"upstreamURL"`
+	MaxIdleConns        int      `toml:"maxIdleConn" yaml:"maxIdleConn" json:"maxIdleConn"`
+	MaxIdleConnsPerHost int      `toml:"maxIdleConnPerHost" yaml:"maxIdleConnPerHost" json:"maxIdleConnPerHost"`
+	IdleConnTimeout     Duration `toml:"idleConnTimeout" yaml:"idleConnTimeout" json:"idleConnTimeout"`
+	// DisableCompression turns off Go's transparent gzip negotiation with upstreams
+	DisableCompression bool `toml:"disableCompression" yaml:"disableCompression" json:"disableCompression"`
+	// HTTP2 negotiates HTTP/2 with TLS upstreams, upstreams can opt out with disableHTTP2
+	HTTP2 bool `toml:"http2" yaml:"http2" json:"http2"`
+	// DialTimeout bounds connecting to an upstream, 0 leaves it to the OS
+	DialTimeout Duration `toml:"dialTimeout" yaml:"dialTimeout" json:"dialTimeout"`
+	// KeepAlive is the TCP keep-alive period of upstream connections, negative disables it
+	KeepAlive Duration `toml:"keepAlive" yaml:"keepAlive" json:"keepAlive"`
+	// BufferBudget (bytes) caps response bodies buffered for caching at once, 0 means unlimited
+	BufferBudget int64 `toml:"bufferBudget" yaml:"bufferBudget" json:"bufferBudget"`
+	// FlushInterval flushes streamed responses at least this often, negative after every write
+	FlushInterval Duration `toml:"flushInterval" yaml:"flushInterval" json:"flushInterval"`
+	// DNSCacheTTL caches upstream DNS lookups for this long, 0 resolves on every new connection
+	DNSCacheTTL Duration `toml:"dnsCacheTTL" yaml:"dnsCacheTTL" json:"dnsCacheTTL"`
+	// DNSRoundRobin spreads new connections over all resolved addresses of an upstream host
+	DNSRoundRobin bool `toml:"dnsRoundRobin" yaml:"dnsRoundRobin" json:"dnsRoundRobin"`
+	// LocalAddr is the local IP upstream connections are made from
+	LocalAddr string `toml:"localAddr" yaml:"localAddr" json:"localAddr"`
+	// DenyMethods are rejected with 405 on every route, e.g. ["TRACE"]
+	DenyMethods []string `toml:"denyMethods" yaml:"denyMethods" json:"denyMethods"`
+	// Forwarded selects the headers telling upstreams about the client: x-forwarded
+	// (X-Forwarded-For, -Host and -Proto), rfc7239 (Forwarded) or both
+	Forwarded string `toml:"forwarded" yaml:"forwarded" json:"forwarded"`
+	// TrustedProxies are the proxies in front of this one, whose forwarding headers are extended
+	// rather than replaced
+	TrustedProxies []string `toml:"trustedProxies" yaml:"trustedProxies" json:"trustedProxies"`
+	// ClientIP is how the client IP is found, for logs, rate limits, ACLs and X-Real-IP: remote,
+	// x-forwarded-for, x-real-ip or proxy-protocol, the last three trusting TrustedProxies
+	ClientIP string `toml:"clientIP" yaml:"clientIP" json:"clientIP"`
+	// NormalizeURL re-encodes the path and query sent upstream rather than forwarding the client's
+	// escaping (e.g. %2F) as is
+	NormalizeURL bool `toml:"normalizeURL" yaml:"normalizeURL" json:"normalizeURL"`
+	// MergeSlashes, ResolveDots and RejectEncodedTraversal normalize request paths before routing
+	// and cache keys, see middleware.NormalizePath
+	MergeSlashes           bool `toml:"mergeSlashes" yaml:"mergeSlashes" json:"mergeSlashes"`
+	ResolveDots            bool `toml:"resolveDots" yaml:"resolveDots" json:"resolveDots"`
+	RejectEncodedTraversal bool `toml:"rejectEncodedTraversal" yaml:"rejectEncodedTraversal" json:"rejectEncodedTraversal"`
+	// ConnectPorts are the ports CONNECT requests may tunnel to, for explicit-proxy clients. None
+	// disables CONNECT
+	ConnectPorts []int `toml:"connectPorts" yaml:"connectPorts" json:"connectPorts"`
+	// ConnectHosts are the only hosts CONNECT may tunnel to, internal ones included. When empty,
+	// any host with a public address
+	ConnectHosts []string `toml:"connectHosts" yaml:"connectHosts" json:"connectHosts"`
+}
+
+// TrustedNetworks parses TrustedProxies, skipping invalid entries (rejected by Validate).
+func (p *proxyCfg) TrustedNetworks() []*net.IPNet {
+	return parseCIDRs(p.TrustedProxies)
 }
 
 type SystemCfg struct {
-	ListenAddr string   `toml:"listenaddr"`
-	ProxyCfg   proxyCfg `toml:"proxy"`
-	CacheCfg   cacheCfg `toml:"cache"`
+	// Include lists glob patterns (relative to the including file) of config fragments merged in order
+	Include    []string `toml:"include" yaml:"include" json:"include"`
+	ListenAddr string   `toml:"listenaddr" yaml:"listenaddr" json:"listenaddr"`
+	// ListenSocketMode is the octal permission of a unix:// listenaddr socket, e.g. "0660"
+	ListenSocketMode string `toml:"listenSocketMode" yaml:"listenSocketMode" json:"listenSocketMode"`
+	// LogLevel is debug, info or error; empty keeps the build default (debug with -tags debug)
+	LogLevel     string       `toml:"loglevel" yaml:"loglevel" json:"loglevel"`
+	ProxyCfg     proxyCfg     `toml:"proxy" yaml:"proxy" json:"proxy"`
+	CacheCfg     cacheCfg     `toml:"cache" yaml:"cache" json:"cache"`
+	WarmCfg      warmCfg      `toml:"warm" yaml:"warm" json:"warm"`
+	ServerCfg    serverCfg    `toml:"server" yaml:"server" json:"server"`
+	AdminCfg     adminCfg     `toml:"admin" yaml:"admin" json:"admin"`
+	TLSCfg       tlsCfg       `toml:"tls" yaml:"tls" json:"tls"`
+	RateLimitCfg rateLimitCfg `toml:"ratelimit" yaml:"ratelimit" json:"ratelimit"`
+	DockerCfg    dockerCfg    `toml:"docker" yaml:"docker" json:"docker"`
+	GeoIPCfg     geoipCfg     `toml:"geoip" yaml:"geoip" json:"geoip"`
+	OverrideCfg  overrideCfg  `toml:"upstreamOverride" yaml:"upstreamOverride" json:"upstreamOverride"`
+	ScriptCfg    scriptCfg    `toml:"script" yaml:"script" json:"script"`
+	FaultCfg     faultCfg     `toml:"faults" yaml:"faults" json:"faults"`
+	RecordCfg    recordCfg    `toml:"record" yaml:"record" json:"record"`
+	AccessLogCfg accessLogCfg `toml:"accessLog" yaml:"accessLog" json:"accessLog"`
+	MetricsCfg   metricsCfg   `toml:"metrics" yaml:"metrics" json:"metrics"`
+	TracingCfg   tracingCfg   `toml:"tracing" yaml:"tracing" json:"tracing"`
+	// Upstreams are referenced by name from routing/load balancing. The first one serves unrouted traffic.
+	Upstreams []upstreamCfg `toml:"upstreams" yaml:"upstreams" json:"upstreams"`
+	// Routes are matched in order, requests matching none go to the first upstream with global settings
+	Routes []routeCfg `toml:"routes" yaml:"routes" json:"routes"`
+	// Experiments assign visitors to A/B buckets, routes can match on the bucket
+	Experiments []experimentCfg `toml:"experiments" yaml:"experiments" json:"experiments"`
+	// ErrorPages are template files by status (e.g. "502") for the errors the proxy answers itself
+	ErrorPages map[string]string `toml:"errorPages" yaml:"errorPages" json:"errorPages"`
+	// Servers are further proxies run by the process on their own listeners, see ServerConfigs
+	Servers []serverBlockCfg `toml:"servers" yaml:"servers" json:"servers"`
+
+	// ServerName is set on the configs returned by ServerConfigs to label their metrics, empty
+	// without [[servers]]
+	ServerName string `toml:"-" yaml:"-" json:"-"`
+}
+
+// serverBlockCfg is a proxy served next to the top-level one, like an nginx server block, with its
+// own listener and response cache. Upstreams and routes replace the top-level ones when set, cache
+// settings override them, every other section is shared.
+type serverBlockCfg struct {
+	Name       string `toml:"name" yaml:"name" json:"name"`
+	ListenAddr string `toml:"listenaddr" yaml:"listenaddr" json:"listenaddr"`
+	// ListenSocketMode is the octal permission of a unix:// listenaddr socket, e.g. "0660"
+	ListenSocketMode string           `toml:"listenSocketMode" yaml:"listenSocketMode" json:"listenSocketMode"`
+	Upstreams        []upstreamCfg    `toml:"upstreams" yaml:"upstreams" json:"upstreams"`
+	Routes           []routeCfg       `toml:"routes" yaml:"routes" json:"routes"`
+	Cache            serverBlockCache `toml:"cache" yaml:"cache" json:"cache"`
+	// AccessLog is the path of this server's access log in the [accessLog] format, none when empty
+	AccessLog string `toml:"accessLog" yaml:"accessLog" json:"accessLog"`
+}
+
+// serverBlockCache overrides the [cache] settings of a server block, zero values keep them.
+type serverBlockCache struct {
+	Enabled       *bool  `toml:"enabled" yaml:"enabled" json:"enabled"`
+	CacheCapacity int    `toml:"cacheCapacity" yaml:"cacheCapacity" json:"cacheCapacity"`
+	DefaultTTL    int    `toml:"defaultTTL" yaml:"defaultTTL" json:"defaultTTL"`
+	PartitionBy   string `toml:"partitionBy" yaml:"partitionBy" json:"partitionBy"`
+}
+
+type upstreamCfg struct {
+	Name            string `toml:"name" yaml:"name" json:"name"`
+	URL             string `toml:"url" yaml:"url" json:"url"`
+	Weight          int    `toml:"weight" yaml:"weight" json:"weight"`
+	HealthCheckPath string `toml:"healthCheckPath" yaml:"healthCheckPath" json:"healthCheckPath"`
+	// Timeout bounds a whole exchange with this upstream, 0 keeps the client default (30s)
+	Timeout Duration `toml:"timeout" yaml:"timeout" json:"timeout"`
+	// DialTimeout and MaxIdleConnsPerHost override the [proxy] settings when > 0
+	DialTimeout         Duration `toml:"dialTimeout" yaml:"dialTimeout" json:"dialTimeout"`
+	MaxIdleConnsPerHost int      `toml:"maxIdleConnPerHost" yaml:"maxIdleConnPerHost" json:"maxIdleConnPerHost"`
+	// Discovery finds the backends dynamically instead of the static url
+	Discovery *discoveryCfg `toml:"discovery" yaml:"discovery" json:"discovery"`
+	// DisableHTTP2 pins this upstream to HTTP/1.1 regardless of proxy.http2
+	DisableHTTP2 bool           `toml:"disableHTTP2" yaml:"disableHTTP2" json:"disableHTTP2"`
+	TLS          upstreamTLSCfg `toml:"tls" yaml:"tls" json:"tls"`
+}
+
+type discoveryCfg struct {
+	// Type is the discovery mechanism: srv or consul
+	Type string `toml:"type" yaml:"type" json:"type"`
+	// Name is the service to look up, for srv the record name e.g. "_http._tcp.api.internal"
+	Name string `toml:"name" yaml:"name" json:"name"`
+	// Scheme backends are reached over, http or https
+	Scheme string `toml:"scheme" yaml:"scheme" json:"scheme"`
+	// Interval between lookups, for consul the retry delay after a failed watch
+	Interval Duration `toml:"interval" yaml:"interval" json:"interval"`
+	// Address of the Consul HTTP API, defaults to http://127.0.0.1:8500
+	Address string `toml:"address" yaml:"address" json:"address"`
+	// Tag only keeps Consul instances carrying it
+	Tag   string `toml:"tag" yaml:"tag" json:"tag"`
+	Token string `toml:"token" yaml:"token" json:"token" secret:"true"`
+}
+
+type upstreamTLSCfg struct {
+	InsecureSkipVerify bool   `toml:"insecureSkipVerify" yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
+	CAFile             string `toml:"caFile" yaml:"caFile" json:"caFile"`
+	CertFile           string `toml:"certFile" yaml:"certFile" json:"certFile"`
+	KeyFile            string `toml:"keyFile" yaml:"keyFile" json:"keyFile"`
+	ServerName         string `toml:"serverName" yaml:"serverName" json:"serverName"`
+	// ALPN lists the protocols offered in the handshake, e.g. ["http/1.1"]
+	ALPN []string `toml:"alpn" yaml:"alpn" json:"alpn"`
+}
+
+type routeCfg struct {
+	Name       string        `toml:"name" yaml:"name" json:"name"`
+	Match      routeMatchCfg `toml:"match" yaml:"match" json:"match"`
+	Upstream   string        `toml:"upstream" yaml:"upstream" json:"upstream"`
+	Cache      routeCacheCfg `toml:"cache" yaml:"cache" json:"cache"`
+	Timeout    Duration      `toml:"timeout" yaml:"timeout" json:"timeout"`
+	Middleware []string      `toml:"middleware" yaml:"middleware" json:"middleware"`
+	// RateLimit overrides the [ratelimit] per-IP limits for this route
+	RateLimit *routeRateLimitCfg `toml:"ratelimit" yaml:"ratelimit" json:"ratelimit"`
+	// Methods, when set, are the only methods accepted, others get 405. GET implies HEAD
+	Methods []string `toml:"methods" yaml:"methods" json:"methods"`
+	// DenyMethods are rejected with 405 in addition to proxy.denyMethods
+	DenyMethods []string `toml:"denyMethods" yaml:"denyMethods" json:"denyMethods"`
+	// Static serves files from a local directory instead of proxying to an upstream
+	Static *staticCfg `toml:"static" yaml:"static" json:"static"`
+	// Mock answers with a canned response instead of proxying to an upstream
+	Mock *mockCfg `toml:"mock" yaml:"mock" json:"mock"`
+	// Fault injects delays and errors into this route's traffic while faults.enabled is set
+	Fault *routeFaultCfg `toml:"fault" yaml:"fault" json:"fault"`
+	// ErrorPages override the global error pages by status
+	ErrorPages map[string]string `toml:"errorPages" yaml:"errorPages" json:"errorPages"`
+	// AccessLog samples this route's lines in the [accessLog]
+	AccessLog *routeAccessLogCfg `toml:"accessLog" yaml:"accessLog" json:"accessLog"`
+	// Options answers OPTIONS requests, CORS preflights included, without the upstream
+	Options *routeOptionsCfg `toml:"options" yaml:"options" json:"options"`
+}
+
+// routeOptionsCfg is the Allow and CORS headers of locally answered OPTIONS requests.
+type routeOptionsCfg struct {
+	// Allow is the Allow header, the route's methods when empty
+	Allow []string `toml:"allow" yaml:"allow" json:"allow"`
+	// AllowOrigins are the origins whose preflights succeed, "*" for any
+	AllowOrigins []string `toml:"allowOrigins" yaml:"allowOrigins" json:"allowOrigins"`
+	// AllowMethods is Access-Control-Allow-Methods, Allow when empty
+	AllowMethods     []string `toml:"allowMethods" yaml:"allowMethods" json:"allowMethods"`
+	AllowHeaders     []string `toml:"allowHeaders" yaml:"allowHeaders" json:"allowHeaders"`
+	AllowCredentials bool     `toml:"allowCredentials" yaml:"allowCredentials" json:"allowCredentials"`
+	// MaxAge is how long browsers may cache a preflight
+	MaxAge Duration `toml:"maxAge" yaml:"maxAge" json:"maxAge"`
+}
+
+// routeAccessLogCfg keeps busy routes, e.g. health checks, from drowning the access log.
+type routeAccessLogCfg struct {
+	// Sample logs 1 in Sample successful requests, errors (4xx/5xx) are always logged
+	Sample int `toml:"sample" yaml:"sample" json:"sample"`
+	// Slow requests, taking at least this long, are always logged. 0 samples them like the others
+	Slow Duration `toml:"slow" yaml:"slow" json:"slow"`
+}
+
+// routeFaultCfg picks the faults injected into a percentage of a route's requests.
+type routeFaultCfg struct {
+	Delay        Duration `toml:"delay" yaml:"delay" json:"delay"`
+	DelayPercent float64  `toml:"delayPercent" yaml:"delayPercent" json:"delayPercent"`
+	// AbortStatus answers AbortPercent of requests with this status instead of proxying them
+	AbortStatus  int     `toml:"abortStatus" yaml:"abortStatus" json:"abortStatus"`
+	AbortPercent float64 `toml:"abortPercent" yaml:"abortPercent" json:"abortPercent"`
+	// ResetPercent of requests get their connection dropped without a response
+	ResetPercent float64 `toml:"resetPercent" yaml:"resetPercent" json:"resetPercent"`
+}
+
+type staticCfg struct {
+	// Root is the directory files are served from, request paths map onto it unchanged
+	Root string `toml:"root" yaml:"root" json:"root"`
+	// Index is served for directory requests, index.html when empty
+	Index string `toml:"index" yaml:"index" json:"index"`
+	// SPA serves the root index for paths matching no file (and having no extension)
+	SPA bool `toml:"spa" yaml:"spa" json:"spa"`
+	// Dotfiles serves paths with a segment starting with ".", e.g. .well-known/, refused otherwise
+	Dotfiles bool `toml:"dotfiles" yaml:"dotfiles" json:"dotfiles"`
+}
+
+type mockCfg struct {
+	// Status of the response, 200 when 0
+	Status  int               `toml:"status" yaml:"status" json:"status"`
+	Headers map[string]string `toml:"headers" yaml:"headers" json:"headers"`
+	// Body is the response body, or BodyFile the path of a file holding it
+	Body     string `toml:"body" yaml:"body" json:"body"`
+	BodyFile string `toml:"bodyFile" yaml:"bodyFile" json:"bodyFile"`
+	// Template renders the body as a Go text/template with .Method, .Host, .Path, .Query, .Header
+	// and .Now of the request
+	Template bool `toml:"template" yaml:"template" json:"template"`
+}
+
+type routeRateLimitCfg struct {
+	PerIPRate  float64 `toml:"perIPRate" yaml:"perIPRate" json:"perIPRate"`
+	PerIPBurst int     `toml:"perIPBurst" yaml:"perIPBurst" json:"perIPBurst"`
+}
+
+type routeMatchCfg struct {
+	Host       string `toml:"host" yaml:"host" json:"host"`
+	PathPrefix string `toml:"pathPrefix" yaml:"pathPrefix" json:"pathPrefix"`
+	// Countries matches clients located in any of these ISO 3166-1 alpha-2 codes, needs [geoip]
+	Countries []string `toml:"countries" yaml:"countries" json:"countries"`
+	// Experiments matches visitors assigned to the given bucket of each experiment (name = bucket)
+	Experiments map[string]string `toml:"experiments" yaml:"experiments" json:"experiments"`
+	// Query matches requests with these query parameters, an empty value matches any value
+	Query map[string]string `toml:"query" yaml:"query" json:"query"`
+}
+
+type experimentCfg struct {
+	Name string `toml:"name" yaml:"name" json:"name"`
+	// Cookie persists the assignment, revproxy_<name> when empty
+	Cookie string `toml:"cookie" yaml:"cookie" json:"cookie"`
+	// Header tells upstreams the bucket, X-Experiment-<name> when empty
+	Header string `toml:"header" yaml:"header" json:"header"`
+	// MaxAge is the cookie lifetime, 30 days when 0
+	MaxAge Duration `toml:"maxAge" yaml:"maxAge" json:"maxAge"`
+	// Buckets split new visitors by percentage, the percentages must add up to 100
+	Buckets []bucketCfg `toml:"buckets" yaml:"buckets" json:"buckets"`
+}
+
+type bucketCfg struct {
+	Name    string `toml:"name" yaml:"name" json:"name"`
+	Percent int    `toml:"percent" yaml:"percent" json:"percent"`
+}
+
+type routeCacheCfg struct {
+	// Enabled overrides cache.enabled for this route when set
+	Enabled *bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+	// TTL (seconds) used when upstream sends no max-age, 0 uses cache.defaultTTL
+	TTL int `toml:"ttl" yaml:"ttl" json:"ttl"`
+}
+
+// CacheEnabled reports whether responses on this route are cached, given the global setting.
+func (r *routeCfg) CacheEnabled(global bool) bool {
+	if r.Cache.Enabled != nil {
+		return *r.Cache.Enabled
+	}
+	return global
+}
+
+// ErrorPageFiles returns the error page templates by status for route, the global ones when nil,
+// with the route's own replacing them.
+func (c *SystemCfg) ErrorPageFiles(route *routeCfg) map[int]string {
+	files := map[int]string{}
+	pages := []map[string]string{c.ErrorPages}
+	if route != nil {
+		pages = append(pages, route.ErrorPages)
+	}
+	for _, byStatus := range pages {
+		for status, path := range byStatus {
+			if code, err := strconv.Atoi(status); err == nil {
+				files[code] = path
+			}
+		}
+	}
+	return files
+}
+
+// SocketMode parses ListenSocketMode, 0 when unset.
+func (c *SystemCfg) SocketMode() fs.FileMode {
+	mode, _ := strconv.ParseUint(c.ListenSocketMode, 8, 32)
+	return fs.FileMode(mode)
+}
+
+// Upstream looks up an upstream by name.
+func (c *SystemCfg) Upstream(name string) (*upstreamCfg, bool) {
+	for i := range c.Upstreams {
+		if c.Upstreams[i].Name == name {
+			return &c.Upstreams[i], true
+		}
+	}
+	return nil, false
+}
+
+// DefaultUpstream returns the upstream serving unrouted traffic.
+func (c *SystemCfg) DefaultUpstream() *upstreamCfg {
+	return &c.Upstreams[0]
+}
+
+// AddRoute appends an upstream serving rawURL and a route sending host/pathPrefix to it, both
+// called name. Used for routes discovered at runtime, e.g. from Docker labels.
+func (c *SystemCfg) AddRoute(name, rawURL, host, pathPrefix string) {
+	c.AddUpstream(name, rawURL)
+	c.AddRouteTo(name, name, host, pathPrefix)
+}
+
+// AddUpstream appends an upstream serving rawURL.
+func (c *SystemCfg) AddUpstream(name, rawURL string) {
+	c.Upstreams = append(c.Upstreams, upstreamCfg{Name: name, URL: rawURL, Weight: 1})
+}
+
+// AddRouteTo appends a route sending host/pathPrefix to the upstream named upstream.
+func (c *SystemCfg) AddRouteTo(name, upstream, host, pathPrefix string) {
+	c.Routes = append(c.Routes, routeCfg{
+		Name:     name,
+		Match:    routeMatchCfg{Host: host, PathPrefix: pathPrefix},
+		Upstream: upstream,
+	})
+}
+
+// dockerCfg creates routes from the labels of local containers (revproxy.enable, revproxy.host,
+// revproxy.pathPrefix, revproxy.port), after the configured routes.
+type dockerCfg struct {
+	Enabled bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+	// Endpoint is the daemon address, defaults to unix:///var/run/docker.sock
+	Endpoint string `toml:"endpoint" yaml:"endpoint" json:"endpoint"`
+	// Network picks the container IP when containers join several networks
+	Network string `toml:"network" yaml:"network" json:"network"`
+}
+
+// scriptCfg runs Lua hooks on every request, see package script for the hooks a script defines.
+type scriptCfg struct {
+	// Path of the Lua script, empty disables scripting. It is reloaded with the config
+	Path string `toml:"path" yaml:"path" json:"path"`
+	// Timeout bounds each hook call
+	Timeout Duration `toml:"timeout" yaml:"timeout" json:"timeout"`
+}
+
+// faultCfg is the switch for the routes' fault injection, so chaos settings can stay in the config
+// and be turned on only for a test run.
+type faultCfg struct {
+	Enabled bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+}
+
+// metricsCfg exports request, cache and upstream metrics to an OpenTelemetry collector.
+type metricsCfg struct {
+	// OTLPEndpoint is the collector's OTLP/HTTP URL, e.g. http://otel-collector:4318, empty disables
+	// the export
+	OTLPEndpoint string `toml:"otlpEndpoint" yaml:"otlpEndpoint" json:"otlpEndpoint"`
+	// OTLPHeaders are sent with every export, e.g. an API key
+	OTLPHeaders map[string]string `toml:"otlpHeaders" yaml:"otlpHeaders" json:"otlpHeaders" secret:"true"`
+	// Interval between exports
+	Interval    Duration `toml:"interval" yaml:"interval" json:"interval"`
+	ServiceName string   `toml:"serviceName" yaml:"serviceName" json:"serviceName"`
+	// LabelLimit caps the distinct route, upstream and backend address values reported each, later
+	// ones are reported as _other
+	LabelLimit int `toml:"labelLimit" yaml:"labelLimit" json:"labelLimit"`
+}
+
+// tracingCfg decides which trace context headers (traceparent, tracestate, baggage, b3) cross the
+// proxy. They are propagated unchanged by default.
+type tracingCfg struct {
+	// Strip removes these formats' headers from every request, e.g. ["baggage"]. b3 includes X-B3-*
+	Strip []string `toml:"strip" yaml:"strip" json:"strip"`
+	// TrustCIDRs are the clients whose trace headers are propagated, empty trusts every client
+	TrustCIDRs []string `toml:"trustCIDRs" yaml:"trustCIDRs" json:"trustCIDRs"`
+	// Generate starts a trace for requests without one, "w3c" (traceparent) or "b3", empty doesn't
+	Generate string `toml:"generate" yaml:"generate" json:"generate"`
+}
+
+// Enabled reports whether the trace headers are handled at all.
+func (t *tracingCfg) Enabled() bool {
+	return len(t.Strip) > 0 || len(t.TrustCIDRs) > 0 || t.Generate != ""
+}
+
+// TrustNetworks parses TrustCIDRs, skipping invalid entries (rejected by Validate), nil when every
+// client is trusted.
+func (t *tracingCfg) TrustNetworks() []*net.IPNet {
+	return parseCIDRs(t.TrustCIDRs)
+}
+
+// recordCfg writes a sample of the traffic to a file for offline debugging and contract analysis.
+type recordCfg struct {
+	// Path of the recording, empty disables recording
+	Path string `toml:"path" yaml:"path" json:"path"`
+	// Format is jsonl, one HAR entry per line appended to the file, or har, rewritten on each start
+	Format string `toml:"format" yaml:"format" json:"format"`
+	// Sample is the fraction (0-1] of requests recorded
+	Sample float64 `toml:"sample" yaml:"sample" json:"sample"`
+	// MaxBodySize (bytes) cuts recorded request and response bodies, 0 records no bodies
+	MaxBodySize int64 `toml:"maxBodySize" yaml:"maxBodySize" json:"maxBodySize"`
+	// RedactHeaders are recorded as REDACTED, on top of Authorization, Cookie and Set-Cookie
+	RedactHeaders []string `toml:"redactHeaders" yaml:"redactHeaders" json:"redactHeaders"`
+}
+
+// accessLogCfg writes a line per request to a file. SIGUSR1 reopens it, so logrotate can move it
+// away without a restart.
+type accessLogCfg struct {
+	// Path of the access log, empty disables it
+	Path string `toml:"path" yaml:"path" json:"path"`
+	// Format is combined (the Apache/nginx combined log format) or json
+	Format string `toml:"format" yaml:"format" json:"format"`
+	// MaxSize (bytes) rotates the file to path.1, path.2, ... before it grows past it, 0 never rotates
+	MaxSize int64 `toml:"maxSize" yaml:"maxSize" json:"maxSize"`
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int `toml:"maxBackups" yaml:"maxBackups" json:"maxBackups"`
+}
+
+// geoipCfg resolves client countries from a MaxMind database, for routing on match.countries,
+// blocking and telling upstreams.
+type geoipCfg struct {
+	// Database is the path of a GeoIP2/GeoLite2 Country or City .mmdb file, empty disables GeoIP
+	Database string `toml:"database" yaml:"database" json:"database"`
+	// Header carries the client's country code to upstreams
+	Header string `toml:"header" yaml:"header" json:"header"`
+	// BlockCountries rejects clients from these countries with 403
+	BlockCountries []string `toml:"blockCountries" yaml:"blockCountries" json:"blockCountries"`
 }
 
 type cacheCfg struct {
-	Enabled       bool `toml:"enabled"`
-	CacheCapacity int  `toml:"cacheCapacity"`
-	DefaultTTL    int  `toml:"defaultTTL"`
+	Enabled       bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+	CacheCapacity int  `toml:"cacheCapacity" yaml:"cacheCapacity" json:"cacheCapacity"`
+	DefaultTTL    int  `toml:"defaultTTL" yaml:"defaultTTL" json:"defaultTTL"`
+	// MaxBodySize (bytes) caps cached bodies, larger responses are streamed without caching, 0 disables
+	MaxBodySize int64 `toml:"maxBodySize" yaml:"maxBodySize" json:"maxBodySize"`
+	// RefreshAhead refetches hot entries once this fraction of their TTL has elapsed, 0 disables
+	RefreshAhead float64 `toml:"refreshAhead" yaml:"refreshAhead" json:"refreshAhead"`
+	// EvictionPolicy picks the entry evicted when the cache is full: "lru" (default) or "lfu"
+	EvictionPolicy string `toml:"evictionPolicy" yaml:"evictionPolicy" json:"evictionPolicy"`
+	// LazyExpiration drops the cleanup goroutine and expires entries incrementally on Get/Set
+	LazyExpiration bool `toml:"lazyExpiration" yaml:"lazyExpiration" json:"lazyExpiration"`
+	// StaleRetention (seconds) keeps entries past expiry for clients sending Cache-Control: max-stale
+	StaleRetention int `toml:"staleRetention" yaml:"staleRetention" json:"staleRetention"`
+	// LockTimeout makes concurrent misses on a key wait this long for the first one to fill it, 0 disables
+	LockTimeout Duration `toml:"lockTimeout" yaml:"lockTimeout" json:"lockTimeout"`
+	// TemporaryRedirects also caches 302/307 responses carrying an explicit max-age, 301/308 always are
+	TemporaryRedirects bool `toml:"temporaryRedirects" yaml:"temporaryRedirects" json:"temporaryRedirects"`
+	// SliceSize (bytes) caches range requests as aligned slices of this size, 0 proxies them uncached
+	SliceSize int64 `toml:"sliceSize" yaml:"sliceSize" json:"sliceSize"`
+	// IgnoreHost keys responses by URL alone, sharing them between the hosts served; keys include
+	// the host by default so virtual hosts can't be served each other's responses
+	IgnoreHost bool `toml:"ignoreHost" yaml:"ignoreHost" json:"ignoreHost"`
+	// PartitionBy splits the cache per "host" or "route", each partition with its own capacity
+	PartitionBy string `toml:"partitionBy" yaml:"partitionBy" json:"partitionBy"`
+	// PartitionCapacity is the capacity of each partition, cacheCapacity when 0
+	PartitionCapacity int `toml:"partitionCapacity" yaml:"partitionCapacity" json:"partitionCapacity"`
+	// Partitions overrides the capacity of individual partitions by name
+	Partitions map[string]int `toml:"partitions" yaml:"partitions" json:"partitions"`
+}
+
+// PartitionCapacityOf returns the capacity of partition name.
+func (c *cacheCfg) PartitionCapacityOf(name string) int {
+	if capacity, ok := c.Partitions[name]; ok {
+		return capacity
+	}
+	if c.PartitionCapacity > 0 {
+		return c.PartitionCapacity
+	}
+	return c.CacheCapacity
+}
+
+// warmCfg fills the cache from a list of URLs at startup and on POST /cache/warm (admin), so a
+// fresh instance doesn't meet peak traffic with a cold cache.
+type warmCfg struct {
+	// URLs are absolute (the host selects the route) or paths, fetched through the proxy
+	URLs []string `toml:"urls" yaml:"urls" json:"urls"`
+	// BaseURL is the scheme and host paths are fetched with, cache keys hold the host
+	BaseURL string `toml:"baseURL" yaml:"baseURL" json:"baseURL"`
+	// Concurrency bounds the requests in flight
+	Concurrency int `toml:"concurrency" yaml:"concurrency" json:"concurrency"`
+	// OnStart warms in the background once the proxy is serving
+	OnStart bool `toml:"onStart" yaml:"onStart" json:"onStart"`
+}
+
+// ResolvedURLs returns URLs with paths resolved against BaseURL, unchanged without one.
+func (w *warmCfg) ResolvedURLs() []string {
+	base, err := url.Parse(w.BaseURL)
+	if w.BaseURL == "" || err != nil {
+		return w.URLs
+	}
+	urls := make([]string, 0, len(w.URLs))
+	for _, rawURL := range w.URLs {
+		if u, err := url.Parse(rawURL); err == nil && u.Host == "" {
+			rawURL = base.ResolveReference(u).String()
+		}
+		urls = append(urls, rawURL)
+	}
+	return urls
+}
+
+type tlsCfg struct {
+	Enabled  bool   `toml:"enabled" yaml:"enabled" json:"enabled"`
+	CertFile string `toml:"certFile" yaml:"certFile" json:"certFile"`
+	KeyFile  string `toml:"keyFile" yaml:"keyFile" json:"keyFile"`
+	// ClientCAFile enables mTLS: client certificates must be signed by one of these CAs
+	ClientCAFile string   `toml:"clientCAFile" yaml:"clientCAFile" json:"clientCAFile"`
+	MinVersion   string   `toml:"minVersion" yaml:"minVersion" json:"minVersion"`
+	ALPN         []string `toml:"alpn" yaml:"alpn" json:"alpn"`
+	ACME         acmeCfg  `toml:"acme" yaml:"acme" json:"acme"`
+}
+
+type acmeCfg struct {
+	Enabled      bool     `toml:"enabled" yaml:"enabled" json:"enabled"`
+	Email        string   `toml:"email" yaml:"email" json:"email" secret:"true"`
+	Domains      []string `toml:"domains" yaml:"domains" json:"domains"`
+	CacheDir     string   `toml:"cacheDir" yaml:"cacheDir" json:"cacheDir"`
+	DirectoryURL string   `toml:"directoryURL" yaml:"directoryURL" json:"directoryURL"`
+}
+
+type rateLimitCfg struct {
+	Enabled bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+	// GlobalRPS caps all proxied traffic, 0 means unlimited
+	GlobalRPS   float64 `toml:"globalRPS" yaml:"globalRPS" json:"globalRPS"`
+	GlobalBurst int     `toml:"globalBurst" yaml:"globalBurst" json:"globalBurst"`
+	// PerIPRate caps requests per second from each client IP, 0 means unlimited
+	PerIPRate   float64  `toml:"perIPRate" yaml:"perIPRate" json:"perIPRate"`
+	PerIPBurst  int      `toml:"perIPBurst" yaml:"perIPBurst" json:"perIPBurst"`
+	ExemptCIDRs []string `toml:"exemptCIDRs" yaml:"exemptCIDRs" json:"exemptCIDRs"`
+}
+
+// ExemptNetworks parses ExemptCIDRs, skipping invalid entries (rejected by Validate).
+func (r *rateLimitCfg) ExemptNetworks() []*net.IPNet {
+	return parseCIDRs(r.ExemptCIDRs)
+}
+
+// overrideCfg lets developers send single requests to their own machine with a header, for
+// debugging against production traffic. Only clients in AllowCIDRs can use it.
+type overrideCfg struct {
+	Enabled bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+	// Header carries the target URL, e.g. X-Revproxy-Upstream: http://10.1.2.3:3000
+	Header     string   `toml:"header" yaml:"header" json:"header"`
+	AllowCIDRs []string `toml:"allowCIDRs" yaml:"allowCIDRs" json:"allowCIDRs"`
+}
+
+// AllowNetworks parses AllowCIDRs, skipping invalid entries (rejected by Validate).
+func (o *overrideCfg) AllowNetworks() []*net.IPNet {
+	return parseCIDRs(o.AllowCIDRs)
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// serverCfg hardens the proxy and admin http.Servers, 0 disables a timeout.
+type serverCfg struct {
+	ReadHeaderTimeout Duration `toml:"readHeaderTimeout" yaml:"readHeaderTimeout" json:"readHeaderTimeout"`
+	ReadTimeout       Duration `toml:"readTimeout" yaml:"readTimeout" json:"readTimeout"`
+	// WriteTimeout also cuts off long streamed responses, so it is off by default
+	WriteTimeout Duration `toml:"writeTimeout" yaml:"writeTimeout" json:"writeTimeout"`
+	// IdleTimeout closes keep-alive connections idle this long, ReadTimeout when 0
+	IdleTimeout    Duration `toml:"idleTimeout" yaml:"idleTimeout" json:"idleTimeout"`
+	MaxHeaderBytes int      `toml:"maxHeaderBytes" yaml:"maxHeaderBytes" json:"maxHeaderBytes"`
+	// DisableKeepAlives closes client connections after each response
+	DisableKeepAlives bool `toml:"disableKeepAlives" yaml:"disableKeepAlives" json:"disableKeepAlives"`
+	// MaxRequestsPerConn closes HTTP/1 client connections after this many requests, 0 means no cap
+	MaxRequestsPerConn int `toml:"maxRequestsPerConn" yaml:"maxRequestsPerConn" json:"maxRequestsPerConn"`
+	// ShutdownTimeout is how long SIGTERM waits for requests in flight, 0 stops without waiting
+	ShutdownTimeout Duration `toml:"shutdownTimeout" yaml:"shutdownTimeout" json:"shutdownTimeout"`
+}
+
+type adminCfg struct {
+	Enabled    bool   `toml:"enabled" yaml:"enabled" json:"enabled"`
+	ListenAddr string `toml:"listenaddr" yaml:"listenaddr" json:"listenaddr"`
 }
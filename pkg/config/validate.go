@@ -0,0 +1,654 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks the parsed config and returns every problem found joined into one error,
+// so bad values are reported up front instead of panicking deep inside option functions.
+func (c *SystemCfg) Validate() error {
+	var errs []error
+	add := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+
+	if err := validateListenAddr(c.ListenAddr); err != nil {
+		add("listenaddr", "%v", err)
+	}
+	if c.ListenSocketMode != "" {
+		if mode, err := strconv.ParseUint(c.ListenSocketMode, 8, 32); err != nil || mode > 0o777 {
+			add("listenSocketMode", "must be an octal permission like 0660, got %q", c.ListenSocketMode)
+		}
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "error":
+	default:
+		add("loglevel", "must be debug, info or error, got %q", c.LogLevel)
+	}
+
+	// proxy
+	if c.ProxyCfg.UpstreamURL != "" {
+		if err := validateUpstreamURL(c.ProxyCfg.UpstreamURL); err != nil {
+			add("proxy.upstreamURL", "%v", err)
+		}
+	}
+	if c.ProxyCfg.MaxIdleConns < 0 {
+		add("proxy.maxIdleConn", "must be >= 0, got %d", c.ProxyCfg.MaxIdleConns)
+	}
+	if c.ProxyCfg.MaxIdleConnsPerHost < 0 {
+		add("proxy.maxIdleConnPerHost", "must be >= 0, got %d", c.ProxyCfg.MaxIdleConnsPerHost)
+	}
+	if c.ProxyCfg.IdleConnTimeout.Duration < 0 {
+		add("proxy.idleConnTimeout", "must be >= 0, got %s", c.ProxyCfg.IdleConnTimeout)
+	}
+	if c.ProxyCfg.BufferBudget < 0 {
+		add("proxy.bufferBudget", "must be >= 0, got %d", c.ProxyCfg.BufferBudget)
+	}
+	if c.ProxyCfg.DialTimeout.Duration < 0 {
+		add("proxy.dialTimeout", "must be >= 0, got %s", c.ProxyCfg.DialTimeout)
+	}
+	if d := c.ProxyCfg.DNSCacheTTL.Duration; d < 0 || (d > 0 && d < time.Second) {
+		add("proxy.dnsCacheTTL", "must be 0 or at least 1s, got %s", c.ProxyCfg.DNSCacheTTL)
+	}
+	if c.ProxyCfg.LocalAddr != "" && net.ParseIP(c.ProxyCfg.LocalAddr) == nil {
+		add("proxy.localAddr", "must be an IP address, got %q", c.ProxyCfg.LocalAddr)
+	}
+	validateMethods("proxy.denyMethods", c.ProxyCfg.DenyMethods, add)
+	if f := c.ProxyCfg.Forwarded; f != "x-forwarded" && f != "rfc7239" && f != "both" {
+		add("proxy.forwarded", "must be x-forwarded, rfc7239 or both, got %q", f)
+	}
+	for i, cidr := range c.ProxyCfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add(fmt.Sprintf("proxy.trustedProxies[%d]", i), "%v", err)
+		}
+	}
+	switch ip := c.ProxyCfg.ClientIP; ip {
+	case "remote":
+	case "x-forwarded-for", "x-real-ip", "proxy-protocol":
+		if len(c.ProxyCfg.TrustedProxies) == 0 {
+			add("proxy.clientIP", "%s requires proxy.trustedProxies", ip)
+		}
+	default:
+		add("proxy.clientIP", "must be remote, x-forwarded-for, x-real-ip or proxy-protocol, got %q", ip)
+	}
+	for i, port := range c.ProxyCfg.ConnectPorts {
+		if port < 1 || port > 65535 {
+			add(fmt.Sprintf("proxy.connectPorts[%d]", i), "must be a port between 1 and 65535, got %d", port)
+		}
+	}
+	if len(c.ProxyCfg.ConnectHosts) > 0 && len(c.ProxyCfg.ConnectPorts) == 0 {
+		add("proxy.connectHosts", "requires proxy.connectPorts")
+	}
+	for i, host := range c.ProxyCfg.ConnectHosts {
+		if host == "" || strings.ContainsAny(host, "/[]") {
+			add(fmt.Sprintf("proxy.connectHosts[%d]", i), "must be a host name or IP address without port, got %q", host)
+		}
+	}
+
+	// upstreams
+	if c.ProxyCfg.UpstreamURL == "" && len(c.Upstreams) == 0 {
+		add("upstreams", "at least one upstream is required (proxy.upstreamURL or [[upstreams]])")
+	}
+	names := map[string]bool{}
+	if c.ProxyCfg.UpstreamURL != "" {
+		names[defaultUpstreamName] = true
+	}
+	for i, u := range c.Upstreams {
+		field := fmt.Sprintf("upstreams[%d]", i)
+		if u.Name == "" {
+			add(field+".name", "is required")
+		} else if names[u.Name] {
+			add(field+".name", "duplicate upstream name %q", u.Name)
+		}
+		names[u.Name] = true
+		if u.Discovery != nil {
+			validateDiscovery(field+".discovery", u.Discovery, add)
+		} else if err := validateUpstreamURL(u.URL); err != nil {
+			add(field+".url", "%v", err)
+		}
+		if u.Weight < 0 {
+			add(field+".weight", "must be >= 0, got %d", u.Weight)
+		}
+		if u.Timeout.Duration < 0 {
+			add(field+".timeout", "must be >= 0, got %s", u.Timeout)
+		}
+		if u.DialTimeout.Duration < 0 {
+			add(field+".dialTimeout", "must be >= 0, got %s", u.DialTimeout)
+		}
+		if u.MaxIdleConnsPerHost < 0 {
+			add(field+".maxIdleConnPerHost", "must be >= 0, got %d", u.MaxIdleConnsPerHost)
+		}
+		if (u.TLS.CertFile == "") != (u.TLS.KeyFile == "") {
+			add(field+".tls", "certFile and keyFile must be set together")
+		}
+	}
+
+	// experiments, before routes which reference their buckets
+	experimentBuckets := map[string]map[string]bool{}
+	for i, e := range c.Experiments {
+		field := fmt.Sprintf("experiments[%d]", i)
+		if e.Name == "" {
+			add(field+".name", "is required")
+		} else if experimentBuckets[e.Name] != nil {
+			add(field+".name", "duplicate experiment name %q", e.Name)
+		}
+		if e.MaxAge.Duration < 0 {
+			add(field+".maxAge", "must be >= 0, got %s", e.MaxAge)
+		}
+		buckets, total := map[string]bool{}, 0
+		for j, b := range e.Buckets {
+			bucketField := fmt.Sprintf("%s.buckets[%d]", field, j)
+			if b.Name == "" {
+				add(bucketField+".name", "is required")
+			} else if buckets[b.Name] {
+				add(bucketField+".name", "duplicate bucket name %q", b.Name)
+			}
+			buckets[b.Name] = true
+			if b.Percent <= 0 {
+				add(bucketField+".percent", "must be > 0, got %d", b.Percent)
+			}
+			total += b.Percent
+		}
+		if total != 100 {
+			add(field+".buckets", "percentages must add up to 100, got %d", total)
+		}
+		experimentBuckets[e.Name] = buckets
+	}
+
+	// routes
+	type matchKey struct{ host, pathPrefix, countries, experiments, query string }
+	seenMatches := map[matchKey]int{}
+	routeNames := map[string]bool{}
+	for i, r := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if r.Name == "" {
+			add(field+".name", "is required")
+		} else if routeNames[r.Name] {
+			add(field+".name", "duplicate route name %q", r.Name)
+		}
+		routeNames[r.Name] = true
+		if r.Match.PathPrefix != "" && !strings.HasPrefix(r.Match.PathPrefix, "/") {
+			add(field+".match.pathPrefix", "must start with /, got %q", r.Match.PathPrefix)
+		}
+		for j, country := range r.Match.Countries {
+			if !isCountryCode(country) {
+				add(fmt.Sprintf("%s.match.countries[%d]", field, j), "must be a two-letter ISO country code, got %q", country)
+			}
+		}
+		if len(r.Match.Countries) > 0 && c.GeoIPCfg.Database == "" {
+			add(field+".match.countries", "needs geoip.database")
+		}
+		var experiments []string
+		for _, name := range slices.Sorted(maps.Keys(r.Match.Experiments)) {
+			bucket := r.Match.Experiments[name]
+			if buckets, ok := experimentBuckets[name]; !ok {
+				add(field+".match.experiments", "unknown experiment %q", name)
+			} else if !buckets[bucket] {
+				add(field+".match.experiments", "experiment %q has no bucket %q", name, bucket)
+			}
+			experiments = append(experiments, name+"="+bucket)
+		}
+		var query []string
+		for _, name := range slices.Sorted(maps.Keys(r.Match.Query)) {
+			if name == "" {
+				add(field+".match.query", "parameter names must not be empty")
+			}
+			query = append(query, name+"="+r.Match.Query[name])
+		}
+		key := matchKey{
+			strings.ToLower(r.Match.Host), r.Match.PathPrefix,
+			strings.ToUpper(strings.Join(r.Match.Countries, ",")), strings.Join(experiments, ","), strings.Join(query, "&"),
+		}
+		if prev, ok := seenMatches[key]; ok {
+			add(field+".match", "conflicts with routes[%d] (same conditions)", prev)
+		} else {
+			seenMatches[key] = i
+		}
+		if f := r.Fault; f != nil {
+			percents := []struct {
+				name    string
+				percent float64
+			}{{"delayPercent", f.DelayPercent}, {"abortPercent", f.AbortPercent}, {"resetPercent", f.ResetPercent}}
+			for _, p := range percents {
+				if p.percent < 0 || p.percent > 100 {
+					add(field+".fault."+p.name, "must be between 0 and 100, got %v", p.percent)
+				}
+			}
+			if f.Delay.Duration < 0 {
+				add(field+".fault.delay", "must be >= 0, got %v", f.Delay.Duration)
+			}
+			if f.AbortPercent > 0 && (f.AbortStatus < 100 || f.AbortStatus > 599) {
+				add(field+".fault.abortStatus", "must be an HTTP status, got %d", f.AbortStatus)
+			}
+		}
+		if m := r.Mock; m != nil {
+			if m.Status != 0 && (m.Status < 100 || m.Status > 599) {
+				add(field+".mock.status", "must be an HTTP status, got %d", m.Status)
+			}
+			if m.Body != "" && m.BodyFile != "" {
+				add(field+".mock", "body and bodyFile are mutually exclusive")
+			}
+			if r.Static != nil {
+				add(field+".mock", "must not be set on a static route")
+			}
+			if r.Upstream != "" {
+				add(field+".upstream", "must be empty for a mock route")
+			}
+		} else if r.Static != nil {
+			if r.Static.Root == "" {
+				add(field+".static.root", "is required")
+			}
+			if r.Upstream != "" {
+				add(field+".upstream", "must be empty for a static route")
+			}
+			if strings.Contains(r.Static.Index, "/") {
+				add(field+".static.index", "must be a file name, got %q", r.Static.Index)
+			}
+		} else if r.Upstream == "" {
+			add(field+".upstream", "is required")
+		} else if !names[r.Upstream] {
+			add(field+".upstream", "unknown upstream %q", r.Upstream)
+		}
+		if r.Cache.TTL < 0 {
+			add(field+".cache.ttl", "must be >= 0, got %d", r.Cache.TTL)
+		}
+		if r.Timeout.Duration < 0 {
+			add(field+".timeout", "must be >= 0, got %s", r.Timeout)
+		}
+		if r.RateLimit != nil && (r.RateLimit.PerIPRate < 0 || r.RateLimit.PerIPBurst < 0) {
+			add(field+".ratelimit", "perIPRate and perIPBurst must be >= 0")
+		}
+		if l := r.AccessLog; l != nil {
+			if l.Sample < 1 {
+				add(field+".accessLog.sample", "must be >= 1, got %d", l.Sample)
+			}
+			if l.Slow.Duration < 0 {
+				add(field+".accessLog.slow", "must be >= 0, got %s", l.Slow)
+			}
+		}
+		validateErrorPages(field+".errorPages", r.ErrorPages, add)
+		validateMethods(field+".methods", r.Methods, add)
+		validateMethods(field+".denyMethods", r.DenyMethods, add)
+		if o := r.Options; o != nil {
+			validateMethods(field+".options.allow", o.Allow, add)
+			validateMethods(field+".options.allowMethods", o.AllowMethods, add)
+			for j, origin := range o.AllowOrigins {
+				if origin != "*" && !strings.Contains(origin, "://") {
+					add(fmt.Sprintf("%s.options.allowOrigins[%d]", field, j), "must be * or scheme://host[:port], got %q", origin)
+				}
+			}
+			if o.MaxAge.Duration < 0 {
+				add(field+".options.maxAge", "must be >= 0, got %s", o.MaxAge)
+			}
+		}
+	}
+
+	// ratelimit
+	rl := c.RateLimitCfg
+	if rl.GlobalRPS < 0 || rl.GlobalBurst < 0 || rl.PerIPRate < 0 || rl.PerIPBurst < 0 {
+		add("ratelimit", "rates and bursts must be >= 0")
+	}
+	for i, cidr := range rl.ExemptCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add(fmt.Sprintf("ratelimit.exemptCIDRs[%d]", i), "%v", err)
+		}
+	}
+
+	// cache
+	if c.CacheCfg.CacheCapacity <= 0 {
+		add("cache.cacheCapacity", "must be > 0, got %d", c.CacheCfg.CacheCapacity)
+	}
+	if c.CacheCfg.DefaultTTL < 0 {
+		add("cache.defaultTTL", "must be >= 0, got %d", c.CacheCfg.DefaultTTL)
+	}
+	switch c.CacheCfg.EvictionPolicy {
+	case "", "lru", "lfu":
+	default:
+		add("cache.evictionPolicy", "must be lru or lfu, got %q", c.CacheCfg.EvictionPolicy)
+	}
+	switch c.CacheCfg.PartitionBy {
+	case "", "host", "route":
+	default:
+		add("cache.partitionBy", "must be host, route or empty, got %q", c.CacheCfg.PartitionBy)
+	}
+	if c.CacheCfg.PartitionCapacity < 0 {
+		add("cache.partitionCapacity", "must be >= 0, got %d", c.CacheCfg.PartitionCapacity)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.CacheCfg.Partitions)) {
+		if capacity := c.CacheCfg.Partitions[name]; capacity <= 0 {
+			add("cache.partitions."+name, "must be > 0, got %d", capacity)
+		}
+	}
+	if c.CacheCfg.LockTimeout.Duration < 0 {
+		add("cache.lockTimeout", "must be >= 0, got %s", c.CacheCfg.LockTimeout)
+	}
+	if c.CacheCfg.StaleRetention < 0 {
+		add("cache.staleRetention", "must be >= 0, got %d", c.CacheCfg.StaleRetention)
+	}
+	if c.CacheCfg.SliceSize < 0 {
+		add("cache.sliceSize", "must be >= 0, got %d", c.CacheCfg.SliceSize)
+	}
+	if c.CacheCfg.MaxBodySize < 0 {
+		add("cache.maxBodySize", "must be >= 0, got %d", c.CacheCfg.MaxBodySize)
+	}
+	if c.CacheCfg.RefreshAhead < 0 || c.CacheCfg.RefreshAhead >= 1 {
+		add("cache.refreshAhead", "must be in [0, 1), got %v", c.CacheCfg.RefreshAhead)
+	}
+
+	// tls
+	if c.TLSCfg.Enabled {
+		hasCert := c.TLSCfg.CertFile != "" || c.TLSCfg.KeyFile != ""
+		switch {
+		case hasCert && c.TLSCfg.ACME.Enabled:
+			add("tls", "use either certFile/keyFile or acme, not both")
+		case !hasCert && !c.TLSCfg.ACME.Enabled:
+			add("tls", "enabled without certFile/keyFile or acme")
+		case hasCert && (c.TLSCfg.CertFile == "" || c.TLSCfg.KeyFile == ""):
+			add("tls", "certFile and keyFile must be set together")
+		}
+		switch c.TLSCfg.MinVersion {
+		case "1.0", "1.1", "1.2", "1.3":
+		default:
+			add("tls.minVersion", "must be 1.0, 1.1, 1.2 or 1.3, got %q", c.TLSCfg.MinVersion)
+		}
+		if c.TLSCfg.ACME.Enabled {
+			if len(c.TLSCfg.ACME.Domains) == 0 {
+				add("tls.acme.domains", "at least one domain is required")
+			}
+			if c.TLSCfg.ACME.CacheDir == "" {
+				add("tls.acme.cacheDir", "is required to persist certificates")
+			}
+		}
+	}
+
+	// server
+	for _, timeout := range []struct {
+		field string
+		value Duration
+	}{
+		{"server.readHeaderTimeout", c.ServerCfg.ReadHeaderTimeout},
+		{"server.readTimeout", c.ServerCfg.ReadTimeout},
+		{"server.writeTimeout", c.ServerCfg.WriteTimeout},
+		{"server.idleTimeout", c.ServerCfg.IdleTimeout},
+		{"server.shutdownTimeout", c.ServerCfg.ShutdownTimeout},
+	} {
+		if timeout.value.Duration < 0 {
+			add(timeout.field, "must be >= 0, got %s", timeout.value)
+		}
+	}
+	if c.ServerCfg.MaxHeaderBytes < 0 {
+		add("server.maxHeaderBytes", "must be >= 0, got %d", c.ServerCfg.MaxHeaderBytes)
+	}
+	if c.ServerCfg.MaxRequestsPerConn < 0 {
+		add("server.maxRequestsPerConn", "must be >= 0, got %d", c.ServerCfg.MaxRequestsPerConn)
+	}
+
+	// docker
+	if c.DockerCfg.Enabled && c.DockerCfg.Endpoint != "" {
+		endpoint := c.DockerCfg.Endpoint
+		if !strings.HasPrefix(endpoint, "unix:///") && !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			add("docker.endpoint", "must be a unix:///path socket or an http(s):// URL, got %q", endpoint)
+		}
+	}
+
+	// geoip
+	for i, country := range c.GeoIPCfg.BlockCountries {
+		if !isCountryCode(country) {
+			add(fmt.Sprintf("geoip.blockCountries[%d]", i), "must be a two-letter ISO country code, got %q", country)
+		}
+	}
+	if len(c.GeoIPCfg.BlockCountries) > 0 && c.GeoIPCfg.Database == "" {
+		add("geoip.blockCountries", "needs geoip.database")
+	}
+	if c.GeoIPCfg.Database != "" && c.GeoIPCfg.Header == "" {
+		add("geoip.header", "is required")
+	}
+
+	// warm
+	if c.WarmCfg.Concurrency < 1 {
+		add("warm.concurrency", "must be >= 1, got %d", c.WarmCfg.Concurrency)
+	}
+	if base, err := url.Parse(c.WarmCfg.BaseURL); c.WarmCfg.BaseURL != "" && (err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "") {
+		add("warm.baseURL", "must be an http or https URL with a host, got %q", c.WarmCfg.BaseURL)
+	}
+	for i, rawURL := range c.WarmCfg.URLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			add(fmt.Sprintf("warm.urls[%d]", i), "%v", err)
+		} else if !strings.HasPrefix(u.Path, "/") || (u.Host == "") != (u.Scheme == "") {
+			add(fmt.Sprintf("warm.urls[%d]", i), "must be an absolute URL or a path starting with /, got %q", rawURL)
+		} else if u.Host == "" && c.WarmCfg.BaseURL == "" {
+			add(fmt.Sprintf("warm.urls[%d]", i), "path %q needs warm.baseURL for its host", rawURL)
+		}
+	}
+
+	// script
+	if c.ScriptCfg.Timeout.Duration < 0 {
+		add("script.timeout", "must be >= 0, got %v", c.ScriptCfg.Timeout.Duration)
+	}
+
+	// errorPages
+	validateErrorPages("errorPages", c.ErrorPages, add)
+
+	// metrics
+	if endpoint := c.MetricsCfg.OTLPEndpoint; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("metrics.otlpEndpoint", "must be an http(s) URL, got %q", endpoint)
+		}
+		if c.MetricsCfg.Interval.Duration <= 0 {
+			add("metrics.interval", "must be > 0, got %v", c.MetricsCfg.Interval.Duration)
+		}
+		if c.MetricsCfg.LabelLimit <= 0 {
+			add("metrics.labelLimit", "must be > 0, got %d", c.MetricsCfg.LabelLimit)
+		}
+	}
+
+	// tracing
+	for i, format := range c.TracingCfg.Strip {
+		if !slices.Contains([]string{"traceparent", "tracestate", "baggage", "b3"}, strings.ToLower(format)) {
+			add(fmt.Sprintf("tracing.strip[%d]", i), "must be traceparent, tracestate, baggage or b3, got %q", format)
+		}
+	}
+	for i, cidr := range c.TracingCfg.TrustCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add(fmt.Sprintf("tracing.trustCIDRs[%d]", i), "%v", err)
+		}
+	}
+	if g := c.TracingCfg.Generate; g != "" && g != "w3c" && g != "b3" {
+		add("tracing.generate", "must be w3c or b3, got %q", g)
+	}
+
+	// record
+	if f := c.RecordCfg.Format; f != "jsonl" && f != "har" {
+		add("record.format", "must be jsonl or har, got %q", f)
+	}
+	if s := c.RecordCfg.Sample; s <= 0 || s > 1 {
+		add("record.sample", "must be in (0, 1], got %v", s)
+	}
+	if c.RecordCfg.MaxBodySize < 0 {
+		add("record.maxBodySize", "must be >= 0, got %d", c.RecordCfg.MaxBodySize)
+	}
+
+	// accessLog
+	if f := c.AccessLogCfg.Format; f != "combined" && f != "json" {
+		add("accessLog.format", "must be combined or json, got %q", f)
+	}
+	if c.AccessLogCfg.MaxSize < 0 {
+		add("accessLog.maxSize", "must be >= 0, got %d", c.AccessLogCfg.MaxSize)
+	}
+	if c.AccessLogCfg.MaxBackups < 0 {
+		add("accessLog.maxBackups", "must be >= 0, got %d", c.AccessLogCfg.MaxBackups)
+	}
+
+	// upstreamOverride
+	if c.OverrideCfg.Enabled {
+		if len(c.OverrideCfg.AllowCIDRs) == 0 {
+			add("upstreamOverride.allowCIDRs", "at least one network is required when enabled")
+		}
+		if c.OverrideCfg.Header == "" {
+			add("upstreamOverride.header", "is required")
+		}
+	}
+	for i, cidr := range c.OverrideCfg.AllowCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add(fmt.Sprintf("upstreamOverride.allowCIDRs[%d]", i), "%v", err)
+		}
+	}
+
+	// admin
+	if c.AdminCfg.Enabled {
+		if err := validateListenAddr(c.AdminCfg.ListenAddr); err != nil {
+			add("admin.listenaddr", "%v", err)
+		}
+	}
+
+	// servers
+	// "proxy" and "admin" name the activated sockets of the top-level listeners
+	servers := map[string]bool{defaultServerName: true, "proxy": true, "admin": true}
+	addrs := map[string]bool{c.ListenAddr: true}
+	if c.AdminCfg.Enabled {
+		addrs[c.AdminCfg.ListenAddr] = true
+	}
+	for i, s := range c.Servers {
+		field := fmt.Sprintf("servers[%d]", i)
+		switch {
+		case s.Name == "":
+			add(field+".name", "is required")
+		case !serverNamePattern.MatchString(s.Name):
+			add(field+".name", "%q must be letters, digits, '-' or '_'", s.Name)
+		case s.Name == defaultServerName:
+			add(field+".name", "%q names the top-level server", s.Name)
+		case s.Name == "proxy" || s.Name == "admin":
+			add(field+".name", "%q is reserved", s.Name)
+		case servers[s.Name]:
+			add(field+".name", "duplicate server %q", s.Name)
+		}
+		servers[s.Name] = true
+		if err := validateListenAddr(s.ListenAddr); err != nil {
+			add(field+".listenaddr", "%v", err)
+		} else if addrs[s.ListenAddr] {
+			add(field+".listenaddr", "%s is listened on by another server", s.ListenAddr)
+		}
+		addrs[s.ListenAddr] = true
+		if s.Cache.CacheCapacity < 0 {
+			add(field+".cache.cacheCapacity", "must be >= 0, got %d", s.Cache.CacheCapacity)
+		}
+		if s.Cache.DefaultTTL < 0 {
+			add(field+".cache.defaultTTL", "must be >= 0, got %d", s.Cache.DefaultTTL)
+		}
+	}
+	// the blocks' own sections are checked as part of the config they make up, once the shared
+	// sections are known to be valid so their problems aren't repeated per server
+	if len(errs) == 0 {
+		for i := range c.Servers {
+			if err := c.server(&c.Servers[i]).Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("servers[%d] (%s): %w", i, c.Servers[i].Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateListenAddr checks addr is host:port with a valid port (host may be empty),
+// or a unix:///path/to.sock socket address.
+func validateListenAddr(addr string) error {
+	if strings.HasPrefix(addr, "unix://") {
+		if !strings.HasPrefix(strings.TrimPrefix(addr, "unix://"), "/") {
+			return fmt.Errorf("unix socket path must be absolute, got %q", addr)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// validateUpstreamURL checks rawURL is an absolute http(s) URL.
+func validateDiscovery(field string, d *discoveryCfg, add func(field, format string, args ...any)) {
+	switch d.Type {
+	case "srv", "consul":
+	default:
+		add(field+".type", "must be srv or consul, got %q", d.Type)
+	}
+	if d.Name == "" {
+		add(field+".name", "is required")
+	}
+	if d.Scheme != "" && d.Scheme != "http" && d.Scheme != "https" {
+		add(field+".scheme", "must be http or https, got %q", d.Scheme)
+	}
+	if d.Interval.Duration < 0 {
+		add(field+".interval", "must be >= 0, got %s", d.Interval)
+	}
+}
+
+func validateUpstreamURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("is required")
+	}
+	if strings.HasPrefix(rawURL, "unix://") {
+		if !strings.HasPrefix(strings.TrimPrefix(rawURL, "unix://"), "/") {
+			return fmt.Errorf("unix socket path must be absolute, got %q", rawURL)
+		}
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http, https or unix, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", rawURL)
+	}
+	return nil
+}
+
+// validateMethods checks each entry is an HTTP method name.
+func validateMethods(field string, methods []string, add func(field, format string, args ...any)) {
+	for i, method := range methods {
+		if method == "" || strings.IndexFunc(method, func(c rune) bool { return (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') }) >= 0 {
+			add(fmt.Sprintf("%s[%d]", field, i), "must be an HTTP method, got %q", method)
+		}
+	}
+}
+
+func validateErrorPages(field string, pages map[string]string, add func(field, format string, args ...any)) {
+	for _, status := range slices.Sorted(maps.Keys(pages)) {
+		if code, err := strconv.Atoi(status); err != nil || code < 400 || code > 599 {
+			add(field, "keys must be 4xx or 5xx statuses, got %q", status)
+		}
+		if pages[status] == "" {
+			add(field+"."+status, "is required")
+		}
+	}
+}
+
+// isCountryCode reports whether code looks like an ISO 3166-1 alpha-2 code.
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
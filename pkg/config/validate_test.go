@@ -0,0 +1,440 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test a valid config passes and every problem is reported at once
+func TestValidate(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	assert.NoError(t, config.Validate())
+
+	config.ListenAddr = ":99999"
+	config.ProxyCfg.UpstreamURL = "localhost:9000"
+	config.CacheCfg.CacheCapacity = 0
+	config.CacheCfg.DefaultTTL = -1
+
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, "listenaddr: invalid port")
+	assert.ErrorContains(t, err, "proxy.upstreamURL: scheme must be http, https or unix")
+	assert.ErrorContains(t, err, "cache.cacheCapacity: must be > 0")
+	assert.ErrorContains(t, err, "cache.defaultTTL: must be >= 0")
+}
+
+// Test upstream definitions are validated and the legacy upstreamURL is folded in
+func TestValidate_upstreams(t *testing.T) {
+	config := *defaultSystemCfg
+	assert.ErrorContains(t, config.Validate(), "at least one upstream is required")
+
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Upstreams = []upstreamCfg{
+		{Name: "default", URL: "http://localhost:9001/"},
+		{Name: "api", URL: "ftp://localhost"},
+		{Name: "api", URL: "http://localhost:9002/", TLS: upstreamTLSCfg{CertFile: "cert.pem"}},
+	}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `upstreams[0].name: duplicate upstream name "default"`)
+	assert.ErrorContains(t, err, "upstreams[1].url: scheme must be http, https or unix")
+	assert.ErrorContains(t, err, `upstreams[2].name: duplicate upstream name "api"`)
+	assert.ErrorContains(t, err, "upstreams[2].tls: certFile and keyFile must be set together")
+
+	config.Upstreams = []upstreamCfg{{Name: "api", URL: "http://localhost:9002/", Weight: 3}}
+	assert.NoError(t, config.Validate())
+	config.normalize()
+	assert.Equal(t, "default", config.DefaultUpstream().Name)
+	assert.Equal(t, 1, config.DefaultUpstream().Weight)
+	api, ok := config.Upstream("api")
+	assert.True(t, ok)
+	assert.Equal(t, 3, api.Weight)
+}
+
+// Test routes must reference known upstreams and not shadow each other
+func TestValidate_routes(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Routes = []routeCfg{
+		{Name: "api", Match: routeMatchCfg{PathPrefix: "/api"}, Upstream: "default"},
+		{Name: "api2", Match: routeMatchCfg{PathPrefix: "/api"}, Upstream: "default"},
+		{Name: "bad", Match: routeMatchCfg{PathPrefix: "static"}, Upstream: "missing"},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "routes[1].match: conflicts with routes[0]")
+	assert.ErrorContains(t, err, `routes[2].match.pathPrefix: must start with /`)
+	assert.ErrorContains(t, err, `routes[2].upstream: unknown upstream "missing"`)
+
+	config.Routes = config.Routes[:1]
+	assert.NoError(t, config.Validate())
+
+	config.ProxyCfg.DenyMethods = []string{"TRACE"}
+	config.Routes[0].Methods = []string{"GET", "GET /"}
+	assert.ErrorContains(t, config.Validate(), `routes[0].methods[1]: must be an HTTP method, got "GET /"`)
+}
+
+// Test static routes need a root and no upstream
+func TestValidate_staticRoutes(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Routes = []routeCfg{
+		{Name: "site", Static: &staticCfg{Root: "/srv/www", SPA: true}},
+		{Name: "bad", Match: routeMatchCfg{PathPrefix: "/docs"}, Upstream: "default", Static: &staticCfg{Index: "a/b.html"}},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "routes[1].static.root: is required")
+	assert.ErrorContains(t, err, "routes[1].upstream: must be empty for a static route")
+	assert.ErrorContains(t, err, `routes[1].static.index: must be a file name`)
+	assert.NotContains(t, err.Error(), "routes[0]")
+}
+
+// Test country codes are checked and need a GeoIP database
+func TestValidate_geoip(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.GeoIPCfg.BlockCountries = []string{"KP", "North Korea"}
+	config.Routes = []routeCfg{
+		{Name: "eu", Match: routeMatchCfg{Countries: []string{"DE", "FR"}}, Upstream: "default"},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, `geoip.blockCountries[1]: must be a two-letter ISO country code, got "North Korea"`)
+	assert.ErrorContains(t, err, "geoip.blockCountries: needs geoip.database")
+	assert.ErrorContains(t, err, "routes[0].match.countries: needs geoip.database")
+
+	config.GeoIPCfg.Database = "/var/lib/GeoIP/GeoLite2-Country.mmdb"
+	config.GeoIPCfg.BlockCountries = []string{"KP"}
+	assert.NoError(t, config.Validate())
+}
+
+// Test experiment buckets add up to 100 and routes reference existing buckets
+func TestValidate_experiments(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Experiments = []experimentCfg{
+		{Name: "checkout", Buckets: []bucketCfg{{Name: "control", Percent: 50}, {Name: "new", Percent: 40}}},
+	}
+	config.Routes = []routeCfg{
+		{Name: "new", Match: routeMatchCfg{Experiments: map[string]string{"checkout": "next"}}, Upstream: "default"},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "experiments[0].buckets: percentages must add up to 100, got 90")
+	assert.ErrorContains(t, err, `routes[0].match.experiments: experiment "checkout" has no bucket "next"`)
+
+	config.Experiments[0].Buckets[1].Percent = 50
+	config.Routes[0].Match.Experiments["checkout"] = "new"
+	assert.NoError(t, config.Validate())
+}
+
+// Test warm URLs are absolute URLs or paths, which need a base URL
+func TestValidate_warm(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.WarmCfg.URLs = []string{"/", "https://example.com/home?lang=en", "products", "example.com/x"}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, `warm.urls[0]: path "/" needs warm.baseURL for its host`)
+	assert.ErrorContains(t, err, `warm.urls[2]: must be an absolute URL or a path starting with /, got "products"`)
+	assert.ErrorContains(t, err, `warm.urls[3]: must be an absolute URL or a path starting with /`)
+	assert.NotContains(t, err.Error(), "warm.urls[1]")
+
+	config.WarmCfg.URLs = []string{"/", "/products?page=2", "https://example.com/home"}
+	config.WarmCfg.BaseURL = "shop.example.com"
+	assert.ErrorContains(t, config.Validate(), `warm.baseURL: must be an http or https URL with a host, got "shop.example.com"`)
+	config.WarmCfg.BaseURL = "https://shop.example.com"
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, []string{"https://shop.example.com/", "https://shop.example.com/products?page=2", "https://example.com/home"}, config.WarmCfg.ResolvedURLs())
+}
+
+// Test cache partitioning settings
+func TestValidate_cachePartitions(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.CacheCfg.PartitionBy = "host"
+	config.CacheCfg.Partitions = map[string]int{"example.com": 100}
+	assert.NoError(t, config.Validate())
+
+	config.CacheCfg.PartitionBy = "tenant"
+	config.CacheCfg.Partitions = map[string]int{"example.com": 0}
+	config.CacheCfg.EvictionPolicy = "arc"
+	err := config.Validate()
+	assert.ErrorContains(t, err, `cache.evictionPolicy: must be lru or lfu, got "arc"`)
+	assert.ErrorContains(t, err, `cache.partitionBy: must be host, route or empty, got "tenant"`)
+	assert.ErrorContains(t, err, "cache.partitions.example.com: must be > 0, got 0")
+}
+
+// Test route fault percentages and abort status
+func TestValidate_faults(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Routes = []routeCfg{{Name: "api", Upstream: "default", Match: routeMatchCfg{PathPrefix: "/api"}, Fault: &routeFaultCfg{
+		DelayPercent: 150,
+		AbortPercent: 10,
+	}}}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "routes[0].fault.delayPercent: must be between 0 and 100, got 150")
+	assert.ErrorContains(t, err, "routes[0].fault.abortStatus: must be an HTTP status, got 0")
+}
+
+// Test mock routes need no upstream and a valid status
+func TestValidate_mock(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Routes = []routeCfg{{Name: "users", Match: routeMatchCfg{PathPrefix: "/api/users"}, Mock: &mockCfg{Body: "[]"}}}
+	assert.NoError(t, config.Validate())
+
+	config.Routes[0].Upstream = "default"
+	config.Routes[0].Mock = &mockCfg{Status: 1000, Body: "[]", BodyFile: "users.json"}
+	err := config.Validate()
+	assert.ErrorContains(t, err, "routes[0].mock.status: must be an HTTP status, got 1000")
+	assert.ErrorContains(t, err, "routes[0].mock: body and bodyFile are mutually exclusive")
+	assert.ErrorContains(t, err, "routes[0].upstream: must be empty for a mock route")
+}
+
+// Test error pages are keyed by error statuses
+func TestValidate_errorPages(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.ErrorPages = map[string]string{"502": "/etc/revproxy/502.html"}
+	config.Routes = []routeCfg{{Name: "api", Upstream: "default", ErrorPages: map[string]string{"504": "/etc/revproxy/api-504.json"}}}
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, map[int]string{502: "/etc/revproxy/502.html", 504: "/etc/revproxy/api-504.json"}, config.ErrorPageFiles(&config.Routes[0]))
+
+	config.ErrorPages = map[string]string{"200": "/etc/revproxy/ok.html", "503": ""}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `errorPages: keys must be 4xx or 5xx statuses, got "200"`)
+	assert.ErrorContains(t, err, "errorPages.503: is required")
+}
+
+// Test the OTLP endpoint is an http(s) URL
+func TestValidate_metrics(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.MetricsCfg.OTLPEndpoint = "http://otel-collector:4318"
+	assert.NoError(t, config.Validate())
+
+	config.MetricsCfg.OTLPEndpoint = "otel-collector:4318"
+	config.MetricsCfg.Interval = Duration{}
+	config.MetricsCfg.LabelLimit = 0
+	err := config.Validate()
+	assert.ErrorContains(t, err, `metrics.otlpEndpoint: must be an http(s) URL, got "otel-collector:4318"`)
+	assert.ErrorContains(t, err, "metrics.interval: must be > 0, got 0s")
+	assert.ErrorContains(t, err, "metrics.labelLimit: must be > 0, got 0")
+}
+
+// Test trace header formats, trusted networks and generated format
+func TestValidate_tracing(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.TracingCfg.Strip = []string{"baggage", "B3"}
+	config.TracingCfg.TrustCIDRs = []string{"10.0.0.0/8"}
+	config.TracingCfg.Generate = "w3c"
+	assert.NoError(t, config.Validate())
+
+	config.TracingCfg.Strip = []string{"x-request-id"}
+	config.TracingCfg.TrustCIDRs = []string{"10.0.0.0"}
+	config.TracingCfg.Generate = "jaeger"
+	err := config.Validate()
+	assert.ErrorContains(t, err, `tracing.strip[0]: must be traceparent, tracestate, baggage or b3, got "x-request-id"`)
+	assert.ErrorContains(t, err, "tracing.trustCIDRs[0]")
+	assert.ErrorContains(t, err, `tracing.generate: must be w3c or b3, got "jaeger"`)
+}
+
+// Test the forwarding headers and trusted proxies
+func TestValidate_forwarded(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.ProxyCfg.Forwarded = "both"
+	config.ProxyCfg.TrustedProxies = []string{"10.0.0.0/8", "fd00::/8"}
+	config.ProxyCfg.ClientIP = "proxy-protocol"
+	assert.NoError(t, config.Validate())
+
+	config.ProxyCfg.Forwarded = "x-real-ip"
+	config.ProxyCfg.TrustedProxies = []string{"10.0.0.1"}
+	config.ProxyCfg.ClientIP = "cf-connecting-ip"
+	err := config.Validate()
+	assert.ErrorContains(t, err, `proxy.forwarded: must be x-forwarded, rfc7239 or both, got "x-real-ip"`)
+	assert.ErrorContains(t, err, "proxy.trustedProxies[0]")
+	assert.ErrorContains(t, err, `proxy.clientIP: must be remote, x-forwarded-for, x-real-ip or proxy-protocol, got "cf-connecting-ip"`)
+
+	config.ProxyCfg.TrustedProxies = nil
+	config.ProxyCfg.ClientIP = "x-forwarded-for"
+	assert.ErrorContains(t, config.Validate(), "proxy.clientIP: x-forwarded-for requires proxy.trustedProxies")
+
+	config.ProxyCfg.ClientIP = "remote"
+	config.ProxyCfg.ConnectPorts = []int{443, 0}
+	config.ProxyCfg.ConnectHosts = []string{"internal.example.com", ""}
+	err = config.Validate()
+	assert.ErrorContains(t, err, "proxy.connectPorts[1]: must be a port between 1 and 65535, got 0")
+	assert.ErrorContains(t, err, `proxy.connectHosts[1]: must be a host name or IP address without port, got ""`)
+
+	config.ProxyCfg.ConnectPorts = nil
+	config.ProxyCfg.ConnectHosts = []string{"internal.example.com"}
+	assert.ErrorContains(t, config.Validate(), "proxy.connectHosts: requires proxy.connectPorts")
+}
+
+// Test recording format and sample rate
+func TestValidate_record(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.RecordCfg.Path = "/tmp/traffic.har"
+	config.RecordCfg.Format = "har"
+	assert.NoError(t, config.Validate())
+
+	config.RecordCfg.Format = "pcap"
+	config.RecordCfg.Sample = 0
+	err := config.Validate()
+	assert.ErrorContains(t, err, `record.format: must be jsonl or har, got "pcap"`)
+	assert.ErrorContains(t, err, "record.sample: must be in (0, 1], got 0")
+}
+
+// Test access log format and rotation limits
+func TestValidate_accessLog(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.AccessLogCfg.Path = "/var/log/revproxy/access.log"
+	config.AccessLogCfg.MaxSize = 100 << 20
+	assert.NoError(t, config.Validate())
+
+	config.Routes = []routeCfg{{Name: "health", Upstream: "default", AccessLog: &routeAccessLogCfg{Sample: 100}}}
+	assert.NoError(t, config.Validate())
+
+	config.AccessLogCfg.Format = "clf"
+	config.AccessLogCfg.MaxBackups = -1
+	config.Routes[0].AccessLog = &routeAccessLogCfg{Slow: Duration{-time.Second}}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `accessLog.format: must be combined or json, got "clf"`)
+	assert.ErrorContains(t, err, "accessLog.maxBackups: must be >= 0, got -1")
+	assert.ErrorContains(t, err, "routes[0].accessLog.sample: must be >= 1, got 0")
+	assert.ErrorContains(t, err, "routes[0].accessLog.slow: must be >= 0, got -1s")
+}
+
+// Test locally answered OPTIONS
+func TestValidate_options(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Routes = []routeCfg{{Name: "api", Upstream: "default", Options: &routeOptionsCfg{
+		Allow:        []string{"GET", "POST"},
+		AllowOrigins: []string{"https://app.example.com", "*"},
+		MaxAge:       Duration{time.Hour},
+	}}}
+	assert.NoError(t, config.Validate())
+
+	config.Routes[0].Options = &routeOptionsCfg{
+		AllowMethods: []string{"GET,POST"},
+		AllowOrigins: []string{"app.example.com"},
+		MaxAge:       Duration{-time.Second},
+	}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `routes[0].options.allowMethods[0]: must be an HTTP method, got "GET,POST"`)
+	assert.ErrorContains(t, err, `routes[0].options.allowOrigins[0]: must be * or scheme://host[:port], got "app.example.com"`)
+	assert.ErrorContains(t, err, "routes[0].options.maxAge: must be >= 0, got -1s")
+}
+
+// Test unix socket listen addresses and socket modes
+func TestValidate_unixListener(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.ListenAddr = "unix:///var/run/revproxy.sock"
+	config.ListenSocketMode = "0660"
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, "-rw-rw----", config.SocketMode().String())
+
+	config.ListenAddr = "unix://relative.sock"
+	config.ListenSocketMode = "rw"
+	err := config.Validate()
+	assert.ErrorContains(t, err, "listenaddr: unix socket path must be absolute")
+	assert.ErrorContains(t, err, "listenSocketMode: must be an octal permission")
+}
+
+// Test server blocks need a unique name and listener, and their own sections are validated
+func TestValidate_servers(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.Servers = []serverBlockCfg{{Name: "api", ListenAddr: ":8081"}}
+	assert.NoError(t, config.Validate())
+
+	config.Servers = []serverBlockCfg{
+		{Name: "api", ListenAddr: ":8081"},
+		{Name: "api", ListenAddr: config.ListenAddr},
+		{Name: "default", ListenAddr: ":8082", Cache: serverBlockCache{DefaultTTL: -1}},
+		{Name: "admin", ListenAddr: ":8083"},
+		{Name: "api v2", ListenAddr: ":8084"},
+	}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `servers[1].name: duplicate server "api"`)
+	assert.ErrorContains(t, err, "servers[1].listenaddr: :8000 is listened on by another server")
+	assert.ErrorContains(t, err, `servers[2].name: "default" names the top-level server`)
+	assert.ErrorContains(t, err, "servers[2].cache.defaultTTL: must be >= 0, got -1")
+	assert.ErrorContains(t, err, `servers[3].name: "admin" is reserved`)
+	assert.ErrorContains(t, err, `servers[4].name: "api v2" must be letters, digits, '-' or '_'`)
+
+	config.Servers = []serverBlockCfg{{
+		Name:       "api",
+		ListenAddr: ":8081",
+		Routes:     []routeCfg{{Name: "v1", Match: routeMatchCfg{PathPrefix: "/v1"}, Upstream: "missing"}},
+	}}
+	assert.ErrorContains(t, config.Validate(), "servers[0] (api): routes[0].upstream:")
+}
+
+// Test unix socket upstreams
+func TestValidate_unixUpstream(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "unix:///var/run/app.sock"
+	assert.NoError(t, config.Validate())
+
+	config.ProxyCfg.UpstreamURL = "unix://app.sock"
+	assert.ErrorContains(t, config.Validate(), "proxy.upstreamURL: unix socket path must be absolute")
+}
+
+// Test the tls section needs exactly one certificate source
+func TestValidate_tls(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	config.TLSCfg.Enabled = true
+	assert.ErrorContains(t, config.Validate(), "tls: enabled without certFile/keyFile or acme")
+
+	config.TLSCfg.CertFile, config.TLSCfg.KeyFile = "cert.pem", "key.pem"
+	assert.NoError(t, config.Validate())
+
+	config.TLSCfg.ACME = acmeCfg{Enabled: true}
+	config.TLSCfg.MinVersion = "1.4"
+	err := config.Validate()
+	assert.ErrorContains(t, err, "tls: use either certFile/keyFile or acme, not both")
+	assert.ErrorContains(t, err, "tls.minVersion: must be 1.0, 1.1, 1.2 or 1.3")
+	assert.ErrorContains(t, err, "tls.acme.domains: at least one domain is required")
+}
+
+// Test server timeouts and limits can't be negative
+func TestValidate_server(t *testing.T) {
+	config := *defaultSystemCfg
+	config.ProxyCfg.UpstreamURL = "http://localhost:9000/"
+	assert.NoError(t, config.Validate())
+
+	config.ServerCfg.ReadHeaderTimeout = Duration{-time.Second}
+	config.ServerCfg.MaxHeaderBytes = -1
+	config.ServerCfg.MaxRequestsPerConn = -1
+	err := config.Validate()
+	assert.ErrorContains(t, err, "server.readHeaderTimeout: must be >= 0, got -1s")
+	assert.ErrorContains(t, err, "server.maxHeaderBytes: must be >= 0, got -1")
+	assert.ErrorContains(t, err, "server.maxRequestsPerConn: must be >= 0, got -1")
+}
+
+// Test upstreams using discovery need no url but a valid discovery section
+func TestValidate_discovery(t *testing.T) {
+	config := *defaultSystemCfg
+	config.Upstreams = []upstreamCfg{{Name: "api", Discovery: &discoveryCfg{Type: "srv", Name: "_http._tcp.api.internal"}}}
+	assert.NoError(t, config.Validate())
+
+	config.Upstreams = []upstreamCfg{{Name: "api", Discovery: &discoveryCfg{Type: "zookeeper", Scheme: "ftp"}}}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `upstreams[0].discovery.type: must be srv or consul, got "zookeeper"`)
+	assert.ErrorContains(t, err, "upstreams[0].discovery.name: is required")
+	assert.ErrorContains(t, err, `upstreams[0].discovery.scheme: must be http or https, got "ftp"`)
+}
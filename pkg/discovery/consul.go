@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultConsulAddress = "http://127.0.0.1:8500"
+
+// Consul discovers the instances of a Consul service that pass their health checks. It uses
+// blocking queries, so Discover returns as soon as the catalog changes (or after wait).
+type Consul struct {
+	address string
+	service string
+	scheme  string
+	tag     string
+	token   string
+	wait    time.Duration
+	client  *http.Client
+
+	index uint64
+}
+
+type ConsulOption func(*Consul)
+
+// WithConsulAddress sets the Consul HTTP API address, defaults to http://127.0.0.1:8500.
+func WithConsulAddress(address string) ConsulOption {
+	return func(c *Consul) {
+		c.address = address
+	}
+}
+
+// WithConsulTag only keeps instances carrying tag.
+func WithConsulTag(tag string) ConsulOption {
+	return func(c *Consul) {
+		c.tag = tag
+	}
+}
+
+// WithConsulToken sends an ACL token with each query.
+func WithConsulToken(token string) ConsulOption {
+	return func(c *Consul) {
+		c.token = token
+	}
+}
+
+// WithConsulWait bounds how long a blocking query waits for a change.
+func WithConsulWait(wait time.Duration) ConsulOption {
+	return func(c *Consul) {
+		c.wait = wait
+	}
+}
+
+// WithConsulClient replaces the HTTP client used to reach Consul.
+func WithConsulClient(client *http.Client) ConsulOption {
+	return func(c *Consul) {
+		c.client = client
+	}
+}
+
+// NewConsul discovers the passing instances of service, backends are reached over scheme.
+func NewConsul(service, scheme string, opts ...ConsulOption) *Consul {
+	c := &Consul{
+		address: defaultConsulAddress,
+		service: service,
+		scheme:  scheme,
+		wait:    5 * time.Minute,
+		client:  &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// consulServiceEntry is the part of a /v1/health/service entry we use.
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (c *Consul) Discover(ctx context.Context) ([]*url.URL, error) {
+	query := url.Values{"passing": {"true"}}
+	if c.tag != "" {
+		query.Set("tag", c.tag)
+	}
+	if c.index > 0 {
+		query.Set("index", strconv.FormatUint(c.index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(c.wait.Seconds())))
+	}
+	endpoint := fmt.Sprintf("%s/v1/health/service/%s?%s", c.address, url.PathEscape(c.service), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned status %d for service %s", resp.StatusCode, c.service)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding consul response: %w", err)
+	}
+	// a lower or missing index means the catalog was reset, start over without blocking
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if index < c.index {
+		index = 0
+	}
+	c.index = index
+
+	backends := make([]*url.URL, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		backends = append(backends, &url.URL{
+			Scheme: c.scheme,
+			Host:   net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)),
+		})
+	}
+	return backends, nil
+}
+
+func (c *Consul) blocksUntilChange() {}
@@ -0,0 +1,40 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsul_Discover(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		assert.Equal(t, "/v1/health/service/users", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Consul-Token"))
+		w.Header().Set("X-Consul-Index", "42")
+		w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "10.1.0.2", "Port": 9090}}
+		]`))
+	}))
+	defer server.Close()
+
+	consul := NewConsul("users", "http", WithConsulAddress(server.URL), WithConsulToken("secret"), WithConsulTag("v2"))
+	backends, err := consul.Discover(context.Background())
+	assert.NoError(t, err)
+	var hosts []string
+	for _, u := range backends {
+		hosts = append(hosts, u.Host)
+	}
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.1.0.2:9090"}, hosts)
+
+	// the next query blocks on the index returned by the first
+	_, err = consul.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "passing=true&tag=v2", queries[0])
+	assert.Equal(t, "index=42&passing=true&tag=v2&wait=300s", queries[1])
+}
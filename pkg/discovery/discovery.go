@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/balancer"
+)
+
+// Discoverer finds the current backends of a service.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]*url.URL, error)
+}
+
+// blocking is implemented by discoverers whose Discover waits for the next change itself (Consul
+// blocking queries), Watch calls those back to back instead of on the interval.
+type blocking interface {
+	blocksUntilChange()
+}
+
+// Refresh runs d once and replaces the pool's backends with the result. On error the pool keeps
+// its previous backends.
+func Refresh(ctx context.Context, d Discoverer, pool *balancer.Pool) error {
+	backends, err := d.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	pool.Set(backends)
+	return nil
+}
+
+// Watch refreshes pool from d every interval until ctx is done. Blocking discoverers are
+// refreshed continuously and only wait interval after an error.
+func Watch(ctx context.Context, d Discoverer, pool *balancer.Pool, interval time.Duration) {
+	_, isBlocking := d.(blocking)
+	first := interval
+	if isBlocking {
+		first = 0
+	}
+
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := interval
+		if err := Refresh(ctx, d, pool); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("discovery for upstream %s failed, keeping %d backends: %v", pool.Name(), len(pool.Backends()), err)
+		} else if isBlocking {
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
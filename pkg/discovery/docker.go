@@ -0,0 +1,243 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/client"
+)
+
+const (
+	defaultDockerEndpoint = "unix:///var/run/docker.sock"
+	dockerLabelPrefix     = "revproxy."
+)
+
+// DockerService is a route derived from the labels of a running container:
+//
+//	revproxy.enable=true        opt the container in
+//	revproxy.host=example.com   match requests for this host
+//	revproxy.pathPrefix=/api    match requests under this path
+//	revproxy.port=8080          container port to proxy to, defaults to the lowest exposed port
+//	revproxy.name=web           route/upstream name, defaults to the container name
+type DockerService struct {
+	Name       string
+	Host       string
+	PathPrefix string
+	URL        *url.URL
+}
+
+// Docker watches the local Docker daemon for containers labelled for revproxy.
+type Docker struct {
+	endpoint string
+	network  string
+	interval time.Duration
+	client   *http.Client
+}
+
+type DockerOption func(*Docker)
+
+// WithDockerEndpoint sets the daemon address, a unix:// socket or an http(s):// URL.
+func WithDockerEndpoint(endpoint string) DockerOption {
+	return func(d *Docker) {
+		d.endpoint = endpoint
+	}
+}
+
+// WithDockerNetwork picks the container IP on network when containers join several.
+func WithDockerNetwork(network string) DockerOption {
+	return func(d *Docker) {
+		d.network = network
+	}
+}
+
+// WithDockerInterval sets the delay before reconnecting to the event stream after an error.
+func WithDockerInterval(interval time.Duration) DockerOption {
+	return func(d *Docker) {
+		d.interval = interval
+	}
+}
+
+func NewDocker(opts ...DockerOption) *Docker {
+	d := &Docker{
+		endpoint: defaultDockerEndpoint,
+		interval: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if path, ok := strings.CutPrefix(d.endpoint, "unix://"); ok {
+		d.client = client.NewClient(client.WithTimeout(0), client.WithTransport(client.NewTransport(client.WithUnixSocket(path))))
+		d.endpoint = "http://docker"
+	} else {
+		d.client = client.NewClient(client.WithTimeout(0))
+	}
+	return d
+}
+
+// dockerContainer is the part of a /containers/json entry we use.
+type dockerContainer struct {
+	ID              string `json:"Id"`
+	Names           []string
+	Labels          map[string]string
+	Ports           []struct{ PrivatePort int }
+	NetworkSettings struct {
+		Networks map[string]struct{ IPAddress string }
+	}
+}
+
+// Services lists the services of the running containers opted in with revproxy.enable=true.
+func (d *Docker) Services(ctx context.Context) ([]DockerService, error) {
+	filters := url.QueryEscape(`{"label":["revproxy.enable=true"],"status":["running"]}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+"/containers/json?filters="+filters, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("docker returned status %d listing containers", resp.StatusCode)
+	}
+
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("decoding docker containers: %w", err)
+	}
+	services := make([]DockerService, 0, len(containers))
+	for _, container := range containers {
+		service, err := d.service(container)
+		if err != nil {
+			log.Printf("docker container %s skipped: %v", container.ID, err)
+			continue
+		}
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+func (d *Docker) service(container dockerContainer) (DockerService, error) {
+	label := func(name string) string { return container.Labels[dockerLabelPrefix+name] }
+
+	name := label("name")
+	if name == "" && len(container.Names) > 0 {
+		name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	if name == "" {
+		return DockerService{}, fmt.Errorf("no name")
+	}
+	if label("host") == "" && label("pathPrefix") == "" {
+		return DockerService{}, fmt.Errorf("needs %shost or %spathPrefix", dockerLabelPrefix, dockerLabelPrefix)
+	}
+
+	port := 0
+	if raw := label("port"); raw != "" {
+		p, err := strconv.Atoi(raw)
+		if err != nil {
+			return DockerService{}, fmt.Errorf("invalid port %q", raw)
+		}
+		port = p
+	} else {
+		for _, p := range container.Ports {
+			if port == 0 || p.PrivatePort < port {
+				port = p.PrivatePort
+			}
+		}
+	}
+	if port == 0 {
+		return DockerService{}, fmt.Errorf("no port exposed, set %sport", dockerLabelPrefix)
+	}
+
+	ip := ""
+	if network, ok := container.NetworkSettings.Networks[d.network]; ok && d.network != "" {
+		ip = network.IPAddress
+	} else if d.network == "" {
+		names := make([]string, 0, len(container.NetworkSettings.Networks))
+		for name := range container.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if addr := container.NetworkSettings.Networks[name].IPAddress; addr != "" {
+				ip = addr
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return DockerService{}, fmt.Errorf("no IP address on network %q", d.network)
+	}
+
+	return DockerService{
+		Name:       name,
+		Host:       label("host"),
+		PathPrefix: label("pathPrefix"),
+		URL:        &url.URL{Scheme: "http", Host: net.JoinHostPort(ip, strconv.Itoa(port))},
+	}, nil
+}
+
+// Watch lists services and calls onChange with them, then again whenever a container starts or
+// stops, until ctx is done. Errors are logged and retried after the interval.
+func (d *Docker) Watch(ctx context.Context, onChange func([]DockerService)) {
+	for {
+		if err := d.watchEvents(ctx, onChange); err != nil && ctx.Err() == nil {
+			log.Printf("docker discovery: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.interval):
+		}
+	}
+}
+
+// watchEvents subscribes to container events and relists on each one until the stream ends.
+func (d *Docker) watchEvents(ctx context.Context, onChange func([]DockerService)) error {
+	filters := url.QueryEscape(`{"type":["container"],"event":["start","die","stop","kill","pause","unpause"]}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+"/events?filters="+filters, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker returned status %d subscribing to events", resp.StatusCode)
+	}
+
+	// list once subscribed so no change between the two is missed
+	relist := func() error {
+		services, err := d.Services(ctx)
+		if err != nil {
+			return err
+		}
+		onChange(services)
+		return nil
+	}
+	if err := relist(); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct{}
+		if err := decoder.Decode(&event); err != nil {
+			return fmt.Errorf("event stream: %w", err)
+		}
+		if err := relist(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocker_Services(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/json", r.URL.Path)
+		assert.Contains(t, r.URL.Query().Get("filters"), "revproxy.enable=true")
+		w.Write([]byte(`[
+			{"Id": "b", "Names": ["/web"], "Labels": {"revproxy.enable": "true", "revproxy.host": "example.com"},
+			 "Ports": [{"PrivatePort": 8080}, {"PrivatePort": 80}],
+			 "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.2"}, "web": {"IPAddress": "172.20.0.2"}}}},
+			{"Id": "a", "Names": ["/api-1"], "Labels": {"revproxy.enable": "true", "revproxy.name": "api", "revproxy.pathPrefix": "/api", "revproxy.port": "9000"},
+			 "NetworkSettings": {"Networks": {"web": {"IPAddress": "172.20.0.3"}}}},
+			{"Id": "c", "Names": ["/nomatch"], "Labels": {"revproxy.enable": "true"},
+			 "Ports": [{"PrivatePort": 80}], "NetworkSettings": {"Networks": {"web": {"IPAddress": "172.20.0.4"}}}}
+		]`))
+	}))
+	defer server.Close()
+
+	docker := NewDocker(WithDockerEndpoint(server.URL), WithDockerNetwork("web"))
+	services, err := docker.Services(context.Background())
+	assert.NoError(t, err)
+	// sorted by name, the container without host or pathPrefix is skipped
+	assert.Len(t, services, 2)
+	assert.Equal(t, "api", services[0].Name)
+	assert.Equal(t, "/api", services[0].PathPrefix)
+	assert.Equal(t, "http://172.20.0.3:9000", services[0].URL.String())
+	assert.Equal(t, "web", services[1].Name)
+	assert.Equal(t, "example.com", services[1].Host)
+	assert.Equal(t, "http://172.20.0.2:80", services[1].URL.String())
+}
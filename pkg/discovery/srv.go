@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SRV discovers backends from a DNS SRV record such as a Consul or Kubernetes headless service
+// name. Only the records of the lowest priority are used, higher ones are failovers.
+type SRV struct {
+	name   string
+	scheme string
+	lookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+type SRVOption func(*SRV)
+
+// WithSRVLookup replaces the system resolver lookup.
+func WithSRVLookup(lookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)) SRVOption {
+	return func(s *SRV) {
+		s.lookup = lookup
+	}
+}
+
+// NewSRV discovers the SRV record name (e.g. "_http._tcp.api.internal"), backends are reached
+// over scheme.
+func NewSRV(name, scheme string, opts ...SRVOption) *SRV {
+	s := &SRV{
+		name:   name,
+		scheme: scheme,
+		lookup: net.DefaultResolver.LookupSRV,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *SRV) Discover(ctx context.Context) ([]*url.URL, error) {
+	_, records, err := s.lookup(ctx, "", "", s.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no SRV records for %s", s.name)
+	}
+
+	// records come sorted by priority
+	priority := records[0].Priority
+	var backends []*url.URL
+	for _, record := range records {
+		if record.Priority != priority {
+			break
+		}
+		host := strings.TrimSuffix(record.Target, ".")
+		backends = append(backends, &url.URL{
+			Scheme: s.scheme,
+			Host:   net.JoinHostPort(host, strconv.Itoa(int(record.Port))),
+		})
+	}
+	return backends, nil
+}
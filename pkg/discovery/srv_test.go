@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/balancer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSRV_Discover(t *testing.T) {
+	srv := NewSRV("_http._tcp.api.internal", "http", WithSRVLookup(func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_http._tcp.api.internal", name)
+		return "", []*net.SRV{
+			{Target: "api-0.internal.", Port: 8080, Priority: 10},
+			{Target: "api-1.internal.", Port: 8081, Priority: 10},
+			{Target: "api-dr.internal.", Port: 8080, Priority: 20},
+		}, nil
+	}))
+
+	backends, err := srv.Discover(context.Background())
+	assert.NoError(t, err)
+	var hosts []string
+	for _, u := range backends {
+		assert.Equal(t, "http", u.Scheme)
+		hosts = append(hosts, u.Host)
+	}
+	assert.Equal(t, []string{"api-0.internal:8080", "api-1.internal:8081"}, hosts)
+}
+
+func TestRefresh_KeepsBackendsOnError(t *testing.T) {
+	calls := 0
+	srv := NewSRV("_http._tcp.api.internal", "http", WithSRVLookup(func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		calls++
+		if calls > 1 {
+			return "", nil, errors.New("SERVFAIL")
+		}
+		return "", []*net.SRV{{Target: "api-0.internal.", Port: 80}}, nil
+	}))
+	pool := balancer.NewPool("api")
+
+	assert.NoError(t, Refresh(context.Background(), srv, pool))
+	assert.Len(t, pool.Backends(), 1)
+	assert.Error(t, Refresh(context.Background(), srv, pool))
+	assert.Len(t, pool.Backends(), 1)
+}
@@ -0,0 +1,157 @@
+// Package errorpage renders the error responses the proxy generates itself (upstream failures,
+// rate limiting, ...) from templates instead of plain text. Handlers report errors with Error,
+// which uses the pages Middleware put in the request context and falls back to http.Error.
+package errorpage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+)
+
+// Data is what a page template is executed with, e.g. {{.Status}} {{.Message}} {{.RequestID}}.
+type Data struct {
+	Status     int
+	StatusText string
+	// Message is the short error text http.Error would have sent, e.g. "upstream timeout"
+	Message string
+	// Code is the machine-readable reason of a 503, e.g. "no_backends"
+	Code string
+	// RequestID is the request's X-Request-Id
+	RequestID string
+	Route     string
+	Upstream  string
+	// RetryAfter is the response's Retry-After, when the client is told when to retry
+	RetryAfter string
+}
+
+type template interface {
+	Execute(w io.Writer, data any) error
+}
+
+type page struct {
+	contentType string
+	template    template
+}
+
+// Pages are the error page templates by status for a route.
+type Pages struct {
+	pages    map[int]page
+	route    string
+	upstream string
+}
+
+type Option func(*Pages)
+
+// WithRoute names the route and upstream the pages are rendered for.
+func WithRoute(route, upstream string) Option {
+	return func(p *Pages) {
+		p.route, p.upstream = route, upstream
+	}
+}
+
+// Load parses the template files by status. The content type follows the file extension, and
+// .html templates escape the values they interpolate.
+func Load(files map[int]string, opts ...Option) (*Pages, error) {
+	p := &Pages{pages: map[int]page{}}
+	for _, opt := range opts {
+		opt(p)
+	}
+	for status, path := range files {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ext := filepath.Ext(path)
+		contentType := mime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		var tmpl template
+		if ext == ".html" || ext == ".htm" {
+			tmpl, err = htmltemplate.New(filepath.Base(path)).Parse(string(source))
+		} else {
+			tmpl, err = texttemplate.New(filepath.Base(path)).Parse(string(source))
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.pages[status] = page{contentType: contentType, template: tmpl}
+	}
+	return p, nil
+}
+
+type contextKey struct{}
+
+// Middleware makes the pages the ones Error renders for requests passing through it.
+func (p *Pages) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, p)))
+	})
+}
+
+// Error answers r with status, rendering the page configured for it and otherwise message as
+// plain text like http.Error.
+func Error(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !render(w, r, Data{Status: status, Message: message}) {
+		http.Error(w, message, status)
+	}
+}
+
+// Unavailable answers r with 503 and a Retry-After, for failures clients should retry later
+// rather than right away. Without a 503 page the body is JSON carrying code, a machine-readable
+// reason like "no_backends".
+func Unavailable(w http.ResponseWriter, r *http.Request, code, message string, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	if render(w, r, Data{Status: http.StatusServiceUnavailable, Code: code, Message: message}) {
+		return
+	}
+	body, _ := json.Marshal(struct {
+		Error      string `json:"error"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retryAfter"`
+	}{code, message, int(math.Ceil(retryAfter.Seconds()))})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(append(body, '\n'))
+}
+
+// render writes the page configured for data.Status, if any, and reports whether it did.
+func render(w http.ResponseWriter, r *http.Request, data Data) bool {
+	p, _ := r.Context().Value(contextKey{}).(*Pages)
+	if p == nil {
+		return false
+	}
+	page, ok := p.pages[data.Status]
+	if !ok {
+		return false
+	}
+
+	data.StatusText = http.StatusText(data.Status)
+	data.RequestID = r.Header.Get("X-Request-Id")
+	data.Route, data.Upstream = p.route, p.upstream
+	data.RetryAfter = w.Header().Get("Retry-After")
+	var buf bytes.Buffer
+	if err := page.template.Execute(&buf, data); err != nil {
+		log.Printf("error page %d: %v", data.Status, err)
+		return false
+	}
+	w.Header().Set("Content-Type", page.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(data.Status)
+	w.Write(buf.Bytes())
+	return true
+}
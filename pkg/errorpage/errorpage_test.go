@@ -0,0 +1,91 @@
+package errorpage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test configured statuses render their template and others fall back to plain text
+func TestError(t *testing.T) {
+	pages, err := Load(map[int]string{
+		http.StatusBadGateway:      writeFile(t, "502.html", `<p>{{.Message}} from {{.Upstream}} ({{.Route}}), request {{.RequestID}}</p>`),
+		http.StatusTooManyRequests: writeFile(t, "429.json", `{"error":"{{.StatusText}}","retryAfter":{{.RetryAfter}}}`),
+	}, WithRoute("api", "backend"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(status int, message string) *httptest.ResponseRecorder {
+		h := pages.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "3")
+			}
+			Error(w, r, message, status)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.Header.Set("X-Request-Id", "<abc>")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.StatusBadGateway, "upstream error")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "<p>upstream error from backend (api), request &lt;abc&gt;</p>", rec.Body.String())
+
+	rec = serve(http.StatusTooManyRequests, "rate limit exceeded")
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"error":"Too Many Requests","retryAfter":3}`, rec.Body.String())
+
+	rec = serve(http.StatusGatewayTimeout, "upstream timeout")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Equal(t, "upstream timeout\n", rec.Body.String())
+
+	// without the middleware Error is http.Error
+	rec = httptest.NewRecorder()
+	Error(rec, httptest.NewRequest(http.MethodGet, "/", nil), "upstream error", http.StatusBadGateway)
+	assert.Equal(t, "upstream error\n", rec.Body.String())
+}
+
+// Test unreadable and invalid templates fail to load
+func TestLoad_errors(t *testing.T) {
+	_, err := Load(map[int]string{502: filepath.Join(t.TempDir(), "missing.html")})
+	assert.Error(t, err)
+	_, err = Load(map[int]string{502: writeFile(t, "502.html", "{{.Message")})
+	assert.Error(t, err)
+}
+
+// Test 503s carry a Retry-After and the error code, in a page or in the default JSON body
+func TestUnavailable(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Unavailable(rec, httptest.NewRequest(http.MethodGet, "/", nil), "no_backends", "no backends available", 5*time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"no_backends","message":"no backends available","retryAfter":5}`, rec.Body.String())
+
+	pages, err := Load(map[int]string{503: writeFile(t, "503.txt", "{{.Code}}, retry in {{.RetryAfter}}s")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	pages.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Unavailable(w, r, "no_backends", "no backends available", 5*time.Second)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "no_backends, retry in 5s", rec.Body.String())
+}
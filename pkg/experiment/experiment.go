@@ -0,0 +1,42 @@
+package experiment
+
+import (
+	"context"
+	"maps"
+)
+
+// Bucket is a variant of an experiment receiving Percent of new visitors.
+type Bucket struct {
+	Name    string
+	Percent int
+}
+
+// Pick returns the bucket a roll in [0, 100) falls into, "" when the percentages don't cover it.
+func Pick(buckets []Bucket, roll int) string {
+	for _, bucket := range buckets {
+		if roll < bucket.Percent {
+			return bucket.Name
+		}
+		roll -= bucket.Percent
+	}
+	return ""
+}
+
+type assignmentsKey struct{}
+
+// NewContext returns ctx recording that the visitor is in bucket of experiment, keeping earlier
+// assignments to other experiments.
+func NewContext(ctx context.Context, experiment, bucket string) context.Context {
+	assignments := map[string]string{experiment: bucket}
+	if parent, ok := ctx.Value(assignmentsKey{}).(map[string]string); ok {
+		assignments = maps.Clone(parent)
+		assignments[experiment] = bucket
+	}
+	return context.WithValue(ctx, assignmentsKey{}, assignments)
+}
+
+// FromContext returns the visitor's bucket in experiment, "" when not assigned.
+func FromContext(ctx context.Context, experiment string) string {
+	assignments, _ := ctx.Value(assignmentsKey{}).(map[string]string)
+	return assignments[experiment]
+}
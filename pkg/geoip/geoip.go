@@ -0,0 +1,62 @@
+package geoip
+
+import (
+	"context"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB resolves client IPs to countries from a MaxMind GeoIP2/GeoLite2 Country or City database.
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// record is the part of a Country/City database record we use.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Open memory-maps the database at path.
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of ip's country, or "" when unknown. Addresses
+// without a country (e.g. anycast) fall back to the country the network is registered in.
+func (db *DB) Country(ip net.IP) string {
+	var rec record
+	if err := db.reader.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	if rec.Country.ISOCode != "" {
+		return rec.Country.ISOCode
+	}
+	return rec.RegisteredCountry.ISOCode
+}
+
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
+
+type countryKey struct{}
+
+// NewContext returns ctx carrying the client's country code.
+func NewContext(ctx context.Context, country string) context.Context {
+	return context.WithValue(ctx, countryKey{}, country)
+}
+
+// FromContext returns the country code stored by NewContext, "" when none.
+func FromContext(ctx context.Context) string {
+	country, _ := ctx.Value(countryKey{}).(string)
+	return country
+}
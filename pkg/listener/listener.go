@@ -0,0 +1,83 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixPrefix = "unix://"
+
+type listenConfig struct {
+	socketMode fs.FileMode
+}
+
+type ListenOption func(*listenConfig)
+
+// WithSocketMode sets the file permissions of unix sockets, 0 keeps the umask default.
+func WithSocketMode(mode fs.FileMode) ListenOption {
+	return func(c *listenConfig) {
+		c.socketMode = mode
+	}
+}
+
+// IsUnix reports whether addr is a unix socket address (unix:///path/to.sock).
+func IsUnix(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
+
+// SocketPath returns the filesystem path of a unix:// address.
+func SocketPath(addr string) string {
+	return strings.TrimPrefix(addr, unixPrefix)
+}
+
+// Listen opens a TCP listener for host:port addresses or a unix socket for unix:// ones.
+// A stale socket file left by a previous run is removed before binding.
+func Listen(addr string, opts ...ListenOption) (net.Listener, error) {
+	cfg := &listenConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if !IsUnix(addr) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := SocketPath(addr)
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.socketMode != 0 {
+		if err := os.Chmod(path, cfg.socketMode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("chmod %s: %w", path, err)
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes path if it is a socket nobody is listening on.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,52 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test unix sockets get their mode applied and stale socket files are replaced
+func TestListen_unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+
+	ln, err := Listen("unix://"+path, WithSocketMode(0o600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// in use
+	_, err = Listen("unix://" + path)
+	assert.ErrorContains(t, err, "already in use")
+
+	// stale after the listener goes away without cleanup
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = Listen("unix://" + path)
+	assert.NoError(t, err)
+	ln.Close()
+}
+
+// Test readiness is sent to the notify socket
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	assert.NoError(t, Notify("READY=1"))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
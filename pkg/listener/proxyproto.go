@@ -0,0 +1,172 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds reading the PROXY header of a connection.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts PROXY protocol v2 headers.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocol wraps ln to read the PROXY protocol header (v1 or v2) that load balancers like
+// HAProxy or AWS NLB send ahead of each connection, so the connections' RemoteAddr is the client's
+// rather than the balancer's. Only connections from the trusted networks must start with one,
+// others are passed as is. The header is read on the connection's first Read or RemoteAddr, off
+// the accept loop.
+func ProxyProtocol(ln net.Listener, trusted []*net.IPNet) net.Listener {
+	return &proxyProtoListener{Listener: ln, trusted: trusted}
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return conn, nil
+	}
+	for _, network := range l.trusted {
+		if network.Contains(addr.IP) {
+			return &proxyProtoConn{Conn: conn}, nil
+		}
+	}
+	return conn, nil
+}
+
+// proxyProtoConn reads the PROXY header before the connection's data.
+type proxyProtoConn struct {
+	net.Conn
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			c.err = fmt.Errorf("PROXY protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr is the client the header names, the balancer when it names none (a health check).
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source address, nil for the UNKNOWN and
+// LOCAL connections balancers make on their own account.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if !bytes.HasPrefix(start, []byte("PROXY ")) {
+		return nil, errors.New("missing header")
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1 reads "PROXY TCP4|TCP6 src dst srcport dstport\r\n" or "PROXY UNKNOWN ...\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // the longest v1 header
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid v1 source %s %s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads the binary header, only TCP over IPv4 and IPv6 addresses are used.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+	command, family := header[12]&0x0f, header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if command == 0 { // LOCAL
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil
+}
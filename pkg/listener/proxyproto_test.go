@@ -0,0 +1,92 @@
+package listener
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test connections from trusted balancers take the client address of their PROXY header
+func TestProxyProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	ln = ProxyProtocol(ln, []*net.IPNet{loopback})
+	defer ln.Close()
+
+	v2 := append([]byte{}, proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 198, 51, 100, 7, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 4711)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		remote string
+		err    bool
+	}{
+		{"v1", "PROXY TCP4 203.0.113.7 10.0.0.1 4711 443\r\n", "203.0.113.7:4711", false},
+		{"v1 ipv6", "PROXY TCP6 2001:db8::1 2001:db8::2 4711 443\r\n", "[2001:db8::1]:4711", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "127.0.0.1:", false},
+		{"v2", string(v2), "198.51.100.7:4711", false},
+		{"missing", "GET / HTTP/1.1\r\n", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write([]byte(tc.header + "hello\n"))
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "hello\n", line)
+			assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), tc.remote), conn.RemoteAddr().String())
+		})
+	}
+}
+
+// Test untrusted connections are passed through without a header
+func TestProxyProtocol_untrusted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = ProxyProtocol(ln, nil)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 4711 443\r\n"))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "PROXY ", string(buf))
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+}
@@ -0,0 +1,71 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sdListenFdsStart is the first file descriptor passed by systemd socket activation.
+const sdListenFdsStart = 3
+
+// SystemdListeners returns the sockets passed by systemd (LISTEN_FDS), keyed by their
+// FileDescriptorName= (systemd defaults it to the socket unit name). Returns an empty map
+// when the process was not socket activated. The env vars are unset so children don't inherit them.
+func SystemdListeners() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := map[string]net.Listener{}
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return listeners, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return listeners, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < count; i++ {
+		fd := sdListenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(file)
+		file.Close() // FileListener dups the fd
+		if err != nil {
+			return nil, fmt.Errorf("systemd fd %d (%s): %w", fd, name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// Notify sends a state string (e.g. "READY=1", "STOPPING=1") to the systemd notify socket.
+// It's a no-op when NOTIFY_SOCKET isn't set, i.e. not running under Type=notify.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // abstract namespace
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
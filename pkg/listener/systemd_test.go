@@ -0,0 +1,83 @@
+package listener
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test sockets handed over by systemd are returned by name, unnamed ones by fd number
+func TestSystemdListeners(t *testing.T) {
+	var files []*os.File
+	var want []string
+	for _, name := range []string{"proxy", "4"} { // fd 4 has no name
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		file, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		files = append(files, file)
+		want = append(want, name+"="+ln.Addr().String())
+	}
+
+	// the fds are inherited by a child, where they're 3 and 4, and LISTEN_PID has to be its pid
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdListeners_child$")
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), "REVPROXY_SYSTEMD_CHILD=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=proxy:")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child: %v\n%s", err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	slices.Sort(want)
+	assert.Equal(t, append(want, "env="), lines[:len(lines)-1]) // the last line is PASS
+}
+
+// TestSystemdListeners_child runs in the child of TestSystemdListeners.
+func TestSystemdListeners_child(t *testing.T) {
+	if os.Getenv("REVPROXY_SYSTEMD_CHILD") == "" {
+		return
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := SystemdListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(listeners)) {
+		fmt.Printf("%s=%s\n", name, listeners[name].Addr())
+	}
+	fmt.Printf("env=%s%s%s\n", os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+}
+
+// Test sockets meant for another process are ignored and the env vars are cleared either way
+func TestSystemdListeners_pidMismatch(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "proxy")
+
+	listeners, err := SystemdListeners()
+	assert.NoError(t, err)
+	assert.Empty(t, listeners)
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_, ok := os.LookupEnv(name)
+		assert.False(t, ok, name)
+	}
+
+	// not socket activated at all
+	listeners, err = SystemdListeners()
+	assert.NoError(t, err)
+	assert.Empty(t, listeners)
+}
@@ -0,0 +1,121 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsVersions maps config names to tls versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var defaultALPN = []string{"h2", "http/1.1"}
+
+type tlsOptions struct {
+	certFile, keyFile string
+	clientCAFile      string
+	minVersion        string
+	alpn              []string
+	acme              *autocert.Manager
+}
+
+type TLSOption func(*tlsOptions)
+
+// WithCertificate serves a static certificate/key pair.
+func WithCertificate(certFile, keyFile string) TLSOption {
+	return func(o *tlsOptions) {
+		o.certFile, o.keyFile = certFile, keyFile
+	}
+}
+
+// WithClientCA requires and verifies client certificates signed by the CAs in caFile (mTLS).
+func WithClientCA(caFile string) TLSOption {
+	return func(o *tlsOptions) {
+		o.clientCAFile = caFile
+	}
+}
+
+// WithMinVersion sets the minimum TLS version: 1.0, 1.1, 1.2 or 1.3. Defaults to 1.2.
+func WithMinVersion(version string) TLSOption {
+	return func(o *tlsOptions) {
+		o.minVersion = version
+	}
+}
+
+// WithALPN sets the advertised ALPN protocols. Defaults to h2 and http/1.1.
+func WithALPN(protocols []string) TLSOption {
+	return func(o *tlsOptions) {
+		o.alpn = protocols
+	}
+}
+
+// WithACME obtains certificates for domains from an ACME CA (Let's Encrypt by default) using
+// the TLS-ALPN-01 challenge, caching them in cacheDir. directoryURL may be empty.
+func WithACME(email string, domains []string, cacheDir, directoryURL string) TLSOption {
+	return func(o *tlsOptions) {
+		o.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Email:      email,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+		}
+		if directoryURL != "" {
+			o.acme.Client = &acme.Client{DirectoryURL: directoryURL}
+		}
+	}
+}
+
+// ServerTLSConfig builds the tls.Config for serving HTTPS from the given options.
+func ServerTLSConfig(opts ...TLSOption) (*tls.Config, error) {
+	o := &tlsOptions{minVersion: "1.2", alpn: defaultALPN}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	minVersion, ok := tlsVersions[o.minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown tls version %q", o.minVersion)
+	}
+	cfg := &tls.Config{
+		MinVersion: minVersion,
+		NextProtos: o.alpn,
+	}
+
+	switch {
+	case o.acme != nil:
+		cfg.GetCertificate = o.acme.GetCertificate
+		cfg.NextProtos = append(cfg.NextProtos, acme.ALPNProto)
+	case o.certFile != "":
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	default:
+		return nil, errors.New("tls needs a certificate or ACME")
+	}
+
+	if o.clientCAFile != "" {
+		pem, err := os.ReadFile(o.clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,123 @@
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+)
+
+// writeKeyPair writes a self-signed CA certificate for cn and its key to dir, returning their paths.
+func writeKeyPair(t *testing.T, dir, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// Test a static certificate is served with the default min version and ALPN, and both can be set
+func TestServerTLSConfig(t *testing.T) {
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), "server")
+
+	cfg, err := ServerTLSConfig(WithCertificate(certFile, keyFile))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, []string{"h2", "http/1.1"}, cfg.NextProtos)
+	assert.Len(t, cfg.Certificates, 1)
+	assert.Nil(t, cfg.GetCertificate)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	cfg, err = ServerTLSConfig(WithCertificate(certFile, keyFile), WithMinVersion("1.3"), WithALPN([]string{"http/1.1"}))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	assert.Equal(t, []string{"http/1.1"}, cfg.NextProtos)
+
+	_, err = ServerTLSConfig(WithCertificate(certFile, keyFile), WithMinVersion("1.4"))
+	assert.ErrorContains(t, err, `unknown tls version "1.4"`)
+	_, err = ServerTLSConfig(WithCertificate(certFile, filepath.Join(t.TempDir(), "missing.key")))
+	assert.Error(t, err)
+	_, err = ServerTLSConfig()
+	assert.ErrorContains(t, err, "tls needs a certificate or ACME")
+}
+
+// Test a client CA requires and verifies client certificates
+func TestServerTLSConfig_clientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "server")
+	caFile, _ := writeKeyPair(t, dir, "client-ca")
+
+	cfg, err := ServerTLSConfig(WithCertificate(certFile, keyFile), WithClientCA(caFile))
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	if assert.NotNil(t, cfg.ClientCAs) {
+		data, _ := os.ReadFile(caFile)
+		block, _ := pem.Decode(data)
+		ca, _ := x509.ParseCertificate(block.Bytes)
+		_, err = ca.Verify(x509.VerifyOptions{Roots: cfg.ClientCAs})
+		assert.NoError(t, err)
+	}
+
+	emptyFile := filepath.Join(dir, "empty.pem")
+	os.WriteFile(emptyFile, nil, 0o600)
+	_, err = ServerTLSConfig(WithCertificate(certFile, keyFile), WithClientCA(emptyFile))
+	assert.ErrorContains(t, err, "no certificates found in "+emptyFile)
+	_, err = ServerTLSConfig(WithCertificate(certFile, keyFile), WithClientCA(filepath.Join(dir, "missing.pem")))
+	assert.Error(t, err)
+}
+
+// Test ACME takes precedence over a static certificate and offers the TLS-ALPN-01 protocol
+func TestServerTLSConfig_acme(t *testing.T) {
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), "server")
+
+	cfg, err := ServerTLSConfig(
+		WithCertificate(certFile, keyFile),
+		WithACME("ops@example.com", []string{"example.com"}, t.TempDir(), ""),
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, cfg.GetCertificate)
+	assert.Empty(t, cfg.Certificates)
+	assert.Equal(t, []string{"h2", "http/1.1", acme.ALPNProto}, cfg.NextProtos)
+	// the default ALPN isn't modified
+	assert.Equal(t, []string{"h2", "http/1.1"}, defaultALPN)
+
+	// only the configured domains get certificates
+	_, err = cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.Error(t, err)
+}
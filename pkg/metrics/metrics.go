@@ -0,0 +1,416 @@
+// Package metrics records request, cache and upstream metrics with OpenTelemetry instruments and
+// exports them to an OTel collector (OTLP over HTTP). Names and attributes follow the OTel HTTP
+// semantic conventions where there is one.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/ashpect/revproxy/pkg/stats"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	meterName = "github.com/ashpect/revproxy"
+
+	defaultLabelLimit = 100
+	// otherLabel replaces the route, upstream and address values past the label limit
+	otherLabel = "_other"
+)
+
+// Metrics holds the instruments. It is a proxy.Observer, and Middleware measures the requests.
+// Request, cache and upstream metrics carry the revproxy.route and revproxy.upstream the request
+// was routed to, see Route.
+type Metrics struct {
+	// provider is the exporting provider of NewOTLP, shut down with the metrics
+	provider *sdkmetric.MeterProvider
+
+	// routes, upstreams and addresses bound the values of their attributes
+	routes    *labelLimiter
+	upstreams *labelLimiter
+	addresses *labelLimiter
+
+	requestDuration  metric.Float64Histogram
+	activeRequests   metric.Int64UpDownCounter
+	cacheLookups     metric.Int64Counter
+	upstreamDuration metric.Float64Histogram
+	phaseDuration    metric.Float64Histogram
+	servedBytes      metric.Int64Counter
+
+	// health reports the backends' health for the gauges of WithHealth
+	health func() map[string]stats.UpstreamHealth
+	// cacheStats reports the response cache usage for the gauges of WithCacheStats
+	cacheStats func() (cache.Stats, bool)
+	// server labels every measurement when set by WithServer
+	server attribute.KeyValue
+}
+
+type Option func(*Metrics)
+
+// WithLabelLimit caps the distinct values of each of the route, upstream and server.address
+// attributes, 100 by default. Later values are reported as _other, so routes and backends coming
+// and going with discovery can't grow the number of series without bound.
+func WithLabelLimit(limit int) Option {
+	return func(m *Metrics) {
+		m.routes.limit, m.upstreams.limit, m.addresses.limit = limit, limit, limit
+	}
+}
+
+// WithHealth observes the backends' health returned by health at each collection, as the gauges
+// revproxy.upstream.up (1 up, 0 down), revproxy.upstream.consecutive_failures and
+// revproxy.upstream.state.duration by server.address.
+func WithHealth(health func() map[string]stats.UpstreamHealth) Option {
+	return func(m *Metrics) {
+		m.health = health
+	}
+}
+
+// WithCacheStats observes the response cache usage returned by stats at each collection, as the
+// gauges revproxy.cache.size (approximate bytes) and revproxy.cache.items. Nothing is reported
+// while stats returns false, e.g. with the cache disabled.
+func WithCacheStats(stats func() (cache.Stats, bool)) Option {
+	return func(m *Metrics) {
+		m.cacheStats = stats
+	}
+}
+
+// WithServer labels every measurement with revproxy.server=name, telling apart the proxies run
+// by one process.
+func WithServer(name string) Option {
+	return func(m *Metrics) {
+		m.server = attribute.String("revproxy.server", name)
+	}
+}
+
+// attrs are the attributes of a measurement, with the server's when set.
+func (m *Metrics) attrs(attrs ...attribute.KeyValue) metric.MeasurementOption {
+	if m.server.Valid() {
+		attrs = append(attrs, m.server)
+	}
+	return metric.WithAttributes(attrs...)
+}
+
+// New creates the instruments on provider's meter.
+func New(provider metric.MeterProvider, opts ...Option) (*Metrics, error) {
+	meter := provider.Meter(meterName)
+	m := &Metrics{
+		routes:    newLabelLimiter(defaultLabelLimit),
+		upstreams: newLabelLimiter(defaultLabelLimit),
+		addresses: newLabelLimiter(defaultLabelLimit),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	var err error
+	if m.requestDuration, err = meter.Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of requests served by the proxy")); err != nil {
+		return nil, err
+	}
+	if m.activeRequests, err = meter.Int64UpDownCounter("http.server.active_requests",
+		metric.WithUnit("{request}"), metric.WithDescription("Requests being served")); err != nil {
+		return nil, err
+	}
+	if m.cacheLookups, err = meter.Int64Counter("revproxy.cache.lookups",
+		metric.WithUnit("{lookup}"), metric.WithDescription("Response cache lookups by result")); err != nil {
+		return nil, err
+	}
+	if m.upstreamDuration, err = meter.Float64Histogram("http.client.request.duration",
+		metric.WithUnit("s"), metric.WithDescription("Time to the response headers of upstream requests")); err != nil {
+		return nil, err
+	}
+	if m.phaseDuration, err = meter.Float64Histogram("revproxy.upstream.phase.duration",
+		metric.WithUnit("s"), metric.WithDescription("Upstream request phases: dns, connect, tls, ttfb and transfer")); err != nil {
+		return nil, err
+	}
+	if m.servedBytes, err = meter.Int64Counter("revproxy.response.body.size",
+		metric.WithUnit("By"), metric.WithDescription("Response body bytes served from the cache or upstream")); err != nil {
+		return nil, err
+	}
+	if m.health != nil {
+		if err := m.observeHealth(meter); err != nil {
+			return nil, err
+		}
+	}
+	if m.cacheStats != nil {
+		if err := m.observeCache(meter); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) observeHealth(meter metric.Meter) error {
+	up, err := meter.Int64ObservableGauge("revproxy.upstream.up",
+		metric.WithUnit("1"), metric.WithDescription("Whether the backend is up, down after consecutive failed requests"))
+	if err != nil {
+		return err
+	}
+	failures, err := meter.Int64ObservableGauge("revproxy.upstream.consecutive_failures",
+		metric.WithUnit("{request}"), metric.WithDescription("Failed requests to the backend since its last success"))
+	if err != nil {
+		return err
+	}
+	inState, err := meter.Float64ObservableGauge("revproxy.upstream.state.duration",
+		metric.WithUnit("s"), metric.WithDescription("Time since the backend went up or down"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for address, h := range m.health() {
+			attrs := m.attrs(attribute.String("server.address", m.addresses.value(address)))
+			var isUp int64
+			if h.State == "up" {
+				isUp = 1
+			}
+			o.ObserveInt64(up, isUp, attrs)
+			o.ObserveInt64(failures, int64(h.ConsecutiveFailures), attrs)
+			o.ObserveFloat64(inState, h.InState, attrs)
+		}
+		return nil
+	}, up, failures, inState)
+	return err
+}
+
+func (m *Metrics) observeCache(meter metric.Meter) error {
+	size, err := meter.Int64ObservableGauge("revproxy.cache.size",
+		metric.WithUnit("By"), metric.WithDescription("Approximate bytes held by the response cache, bodies and headers"))
+	if err != nil {
+		return err
+	}
+	items, err := meter.Int64ObservableGauge("revproxy.cache.items",
+		metric.WithUnit("{entry}"), metric.WithDescription("Entries in the response cache"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		if stats, ok := m.cacheStats(); ok {
+			o.ObserveInt64(size, stats.Bytes, m.attrs())
+			o.ObserveInt64(items, int64(stats.Items), m.attrs())
+		}
+		return nil
+	}, size, items)
+	return err
+}
+
+// Middleware measures the requests passing through it. Active requests are counted by method only,
+// the route isn't known yet when they start.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routed := &labels{}
+		ctx := context.WithValue(r.Context(), labelsKey{}, routed)
+		method := attribute.String("http.request.method", methodAttr(r.Method))
+		m.activeRequests.Add(ctx, 1, m.attrs(method))
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			m.activeRequests.Add(ctx, -1, m.attrs(method))
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.requestDuration.Record(ctx, time.Since(start).Seconds(), m.attrs(
+				method, attribute.Int("http.response.status_code", status), routeAttr(routed), upstreamAttr(routed)))
+		}()
+		next.ServeHTTP(sw, r.WithContext(ctx))
+	})
+}
+
+// labels are the route and upstream a request was routed to, empty until it reaches a Route.
+type labels struct {
+	route, upstream string
+}
+
+type labelsKey struct{}
+
+func labelsFromContext(ctx context.Context) *labels {
+	if l, ok := ctx.Value(labelsKey{}).(*labels); ok {
+		return l
+	}
+	return &labels{}
+}
+
+func routeAttr(l *labels) attribute.KeyValue {
+	return attribute.String("revproxy.route", l.route)
+}
+
+func upstreamAttr(l *labels) attribute.KeyValue {
+	return attribute.String("revproxy.upstream", l.upstream)
+}
+
+// Route labels the metrics of the requests passing through it with route and upstream, empty for
+// routes served without one. It wraps each route's handler, inside Middleware.
+func (m *Metrics) Route(route, upstream string) func(http.Handler) http.Handler {
+	route, upstream = m.routes.value(route), m.upstreams.value(upstream)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l, ok := r.Context().Value(labelsKey{}).(*labels); ok {
+				l.route, l.upstream = route, upstream
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CacheLookup implements proxy.Observer.
+func (m *Metrics) CacheLookup(r *http.Request, result proxy.CacheResult) {
+	routed := labelsFromContext(r.Context())
+	m.cacheLookups.Add(r.Context(), 1, m.attrs(
+		attribute.String("revproxy.cache.result", string(result)), routeAttr(routed), upstreamAttr(routed)))
+}
+
+// UpstreamExchange implements proxy.Observer.
+func (m *Metrics) UpstreamExchange(r *http.Request, upstream *url.URL, status int, err error, elapsed time.Duration) {
+	routed := labelsFromContext(r.Context())
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", methodAttr(r.Method)),
+		attribute.String("server.address", m.addresses.value(upstream.Host)),
+		routeAttr(routed),
+		upstreamAttr(routed),
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error.type", strconv.Itoa(proxy.UpstreamErrorStatus(r, err))))
+	} else {
+		attrs = append(attrs, attribute.Int("http.response.status_code", status))
+	}
+	m.upstreamDuration.Record(r.Context(), elapsed.Seconds(), m.attrs(attrs...))
+}
+
+// ResponseServed implements proxy.ServedObserver.
+func (m *Metrics) ResponseServed(r *http.Request, source proxy.ServedSource, bytes int64) {
+	routed := labelsFromContext(r.Context())
+	m.servedBytes.Add(r.Context(), bytes, m.attrs(
+		attribute.String("revproxy.response.source", string(source)), routeAttr(routed), upstreamAttr(routed)))
+}
+
+// UpstreamPhases implements proxy.PhaseObserver. Phases a request skipped, e.g. connecting over
+// a reused connection, aren't recorded.
+func (m *Metrics) UpstreamPhases(r *http.Request, upstream *url.URL, phases proxy.Phases) {
+	routed := labelsFromContext(r.Context())
+	address := attribute.String("server.address", m.addresses.value(upstream.Host))
+	for _, phase := range []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"dns", phases.DNS},
+		{"connect", phases.Connect},
+		{"tls", phases.TLS},
+		{"ttfb", phases.TTFB},
+		{"transfer", phases.Transfer},
+	} {
+		if phase.duration <= 0 {
+			continue
+		}
+		m.phaseDuration.Record(r.Context(), phase.duration.Seconds(), m.attrs(
+			attribute.String("revproxy.phase", phase.name), address, routeAttr(routed), upstreamAttr(routed)))
+	}
+}
+
+// methodAttr keeps the method attribute to the known methods, others are _OTHER.
+func methodAttr(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "_OTHER"
+}
+
+// labelLimiter passes the first limit distinct values of an attribute and folds later ones into
+// _other.
+type labelLimiter struct {
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	limit int
+}
+
+func newLabelLimiter(limit int) *labelLimiter {
+	return &labelLimiter{seen: map[string]struct{}{}, limit: limit}
+}
+
+func (l *labelLimiter) value(v string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.limit {
+		return otherLabel
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+// NewOTLP records metrics pushed to the OTLP/HTTP endpoint every interval, /v1/metrics when the
+// URL has no path. Shutdown flushes and stops the exports.
+func NewOTLP(ctx context.Context, endpoint string, interval time.Duration, headers map[string]string, serviceName string, opts ...Option) (*Metrics, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(u.String()), otlpmetrichttp.WithHeaders(headers))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+	)
+	m, err := New(provider, opts...)
+	if err != nil {
+		provider.Shutdown(ctx)
+		return nil, err
+	}
+	m.provider = provider
+	return m, nil
+}
+
+// Shutdown exports what is left and stops the exporter started by NewOTLP.
+func (m *Metrics) Shutdown(ctx context.Context) error {
+	if m.provider == nil {
+		return nil
+	}
+	return m.provider.Shutdown(ctx)
+}
+
+// statusWriter captures the status written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streams.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/ashpect/revproxy/pkg/stats"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func newTestMetrics(t *testing.T) (*Metrics, *sdkmetric.ManualReader) {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	m, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, reader
+}
+
+// collect returns the metric named name.
+func collect(t *testing.T, reader *sdkmetric.ManualReader, name string) metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m.Data
+			}
+		}
+	}
+	t.Fatalf("no metric %s", name)
+	return nil
+}
+
+// Test requests are counted by method and status, unknown methods folded into _OTHER
+func TestMiddleware(t *testing.T) {
+	m, reader := newTestMetrics(t)
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/", nil),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+		httptest.NewRequest("PURGE", "/", nil),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	counts := map[string]uint64{}
+	for _, dp := range collect(t, reader, "http.server.request.duration").(metricdata.Histogram[float64]).DataPoints {
+		method, _ := dp.Attributes.Value("http.request.method")
+		status, _ := dp.Attributes.Value("http.response.status_code")
+		counts[method.AsString()+" "+status.Emit()] += dp.Count
+	}
+	assert.Equal(t, map[string]uint64{"GET 200": 1, "GET 404": 1, "_OTHER 200": 1}, counts)
+
+	for _, dp := range collect(t, reader, "http.server.active_requests").(metricdata.Sum[int64]).DataPoints {
+		assert.Zero(t, dp.Value)
+	}
+}
+
+// Test cache lookups and upstream exchanges reported by the proxy are recorded
+func TestObserver(t *testing.T) {
+	m, reader := newTestMetrics(t)
+	var _ proxy.Observer = m
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.CacheLookup(r, proxy.CacheHit)
+	m.CacheLookup(r, proxy.CacheHit)
+	m.CacheLookup(r, proxy.CacheMiss)
+
+	lookups := map[string]int64{}
+	for _, dp := range collect(t, reader, "revproxy.cache.lookups").(metricdata.Sum[int64]).DataPoints {
+		result, _ := dp.Attributes.Value("revproxy.cache.result")
+		lookups[result.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{"hit": 2, "miss": 1}, lookups)
+
+	upstream, _ := url.Parse("http://10.0.0.1:8080")
+	m.UpstreamExchange(r, upstream, http.StatusOK, nil, 20*time.Millisecond)
+	m.UpstreamExchange(r, upstream, 0, errors.New("connection refused"), time.Millisecond)
+	points := collect(t, reader, "http.client.request.duration").(metricdata.Histogram[float64]).DataPoints
+	if assert.Len(t, points, 2) {
+		for _, dp := range points {
+			assert.True(t, dp.Attributes.HasValue("server.address"))
+			if errorType, ok := dp.Attributes.Value("error.type"); ok {
+				assert.Equal(t, attribute.StringValue("502"), errorType)
+			}
+		}
+	}
+
+	var _ proxy.PhaseObserver = m
+	m.UpstreamPhases(r, upstream, proxy.Phases{Connect: time.Millisecond, TTFB: 30 * time.Millisecond, Transfer: time.Millisecond})
+	m.UpstreamPhases(r, upstream, proxy.Phases{TTFB: 10 * time.Millisecond, Transfer: time.Millisecond, Reused: true})
+	phases := map[string]uint64{}
+	for _, dp := range collect(t, reader, "revproxy.upstream.phase.duration").(metricdata.Histogram[float64]).DataPoints {
+		phase, _ := dp.Attributes.Value("revproxy.phase")
+		phases[phase.AsString()] = dp.Count
+	}
+	assert.Equal(t, map[string]uint64{"connect": 1, "ttfb": 2, "transfer": 2}, phases)
+
+	var _ proxy.ServedObserver = m
+	m.ResponseServed(r, proxy.ServedFromCache, 100)
+	m.ResponseServed(r, proxy.ServedFromCache, 50)
+	m.ResponseServed(r, proxy.ServedFromUpstream, 30)
+	served := map[string]int64{}
+	for _, dp := range collect(t, reader, "revproxy.response.body.size").(metricdata.Sum[int64]).DataPoints {
+		source, _ := dp.Attributes.Value("revproxy.response.source")
+		served[source.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{"cache": 150, "upstream": 30}, served)
+}
+
+// Test the backends' health is observed as gauges at collection
+func TestWithHealth(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	health := map[string]stats.UpstreamHealth{
+		"10.0.0.1:8080": {State: "up", InState: 60},
+		"10.0.0.2:8080": {State: "down", ConsecutiveFailures: 4, InState: 5},
+	}
+	_, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		WithHealth(func() map[string]stats.UpstreamHealth { return health }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gauge := func(name string) map[string]int64 {
+		t.Helper()
+		got := map[string]int64{}
+		for _, dp := range collect(t, reader, name).(metricdata.Gauge[int64]).DataPoints {
+			address, _ := dp.Attributes.Value("server.address")
+			got[address.AsString()] = dp.Value
+		}
+		return got
+	}
+	assert.Equal(t, map[string]int64{"10.0.0.1:8080": 1, "10.0.0.2:8080": 0}, gauge("revproxy.upstream.up"))
+	assert.Equal(t, map[string]int64{"10.0.0.1:8080": 0, "10.0.0.2:8080": 4}, gauge("revproxy.upstream.consecutive_failures"))
+	assert.Len(t, collect(t, reader, "revproxy.upstream.state.duration").(metricdata.Gauge[float64]).DataPoints, 2)
+}
+
+// Test the cache usage is observed as gauges, and nothing while there's no cache
+func TestWithCacheStats(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	enabled := true
+	_, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		WithCacheStats(func() (cache.Stats, bool) { return cache.Stats{Items: 3, Bytes: 4096}, enabled }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(4096), collect(t, reader, "revproxy.cache.size").(metricdata.Gauge[int64]).DataPoints[0].Value)
+	assert.Equal(t, int64(3), collect(t, reader, "revproxy.cache.items").(metricdata.Gauge[int64]).DataPoints[0].Value)
+
+	enabled = false
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			assert.Empty(t, m.Data.(metricdata.Gauge[int64]).DataPoints, m.Name)
+		}
+	}
+}
+
+// Test WithServer labels measurements and gauges with the server
+func TestWithServer(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), WithServer("api"),
+		WithCacheStats(func() (cache.Stats, bool) { return cache.Stats{Items: 1}, true }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CacheLookup(httptest.NewRequest(http.MethodGet, "/", nil), proxy.CacheHit)
+
+	lookup := collect(t, reader, "revproxy.cache.lookups").(metricdata.Sum[int64]).DataPoints[0]
+	server, _ := lookup.Attributes.Value("revproxy.server")
+	assert.Equal(t, "api", server.AsString())
+	items := collect(t, reader, "revproxy.cache.items").(metricdata.Gauge[int64]).DataPoints[0]
+	server, _ = items.Attributes.Value("revproxy.server")
+	assert.Equal(t, "api", server.AsString())
+}
+
+// Test metrics carry the route and upstream, and values past the label limit are folded into _other
+func TestRoute(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), WithLabelLimit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := url.Parse("http://10.0.0.1:8080")
+	routed := func(route string) http.Handler {
+		return m.Route(route, route+"-backend")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.CacheLookup(r, proxy.CacheMiss)
+			m.UpstreamExchange(r, upstream, http.StatusOK, nil, time.Millisecond)
+		}))
+	}
+	mux := http.NewServeMux()
+	for _, route := range []string{"api", "web", "docker-1"} {
+		mux.Handle("/"+route, routed(route))
+	}
+	h := m.Middleware(mux)
+	for _, path := range []string{"/api", "/web", "/docker-1", "/unrouted"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	routes := func(name string) map[string]string {
+		t.Helper()
+		got := map[string]string{}
+		var sets []attribute.Set
+		switch data := collect(t, reader, name).(type) {
+		case metricdata.Histogram[float64]:
+			for _, dp := range data.DataPoints {
+				sets = append(sets, dp.Attributes)
+			}
+		case metricdata.Sum[int64]:
+			for _, dp := range data.DataPoints {
+				sets = append(sets, dp.Attributes)
+			}
+		}
+		for _, set := range sets {
+			route, _ := set.Value("revproxy.route")
+			upstream, _ := set.Value("revproxy.upstream")
+			got[route.AsString()] = upstream.AsString()
+		}
+		return got
+	}
+	assert.Equal(t, map[string]string{"api": "api-backend", "web": "web-backend", "_other": "_other", "": ""},
+		routes("http.server.request.duration"))
+	assert.Equal(t, map[string]string{"api": "api-backend", "web": "web-backend", "_other": "_other"},
+		routes("revproxy.cache.lookups"))
+	assert.Equal(t, map[string]string{"api": "api-backend", "web": "web-backend", "_other": "_other"},
+		routes("http.client.request.duration"))
+}
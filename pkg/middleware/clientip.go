@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns how the client IP is derived from requests with strategy:
+//   - "remote" (or empty): the remote address, also when a PROXY protocol listener rewrote it
+//   - "x-forwarded-for": the rightmost X-Forwarded-For address that isn't a trusted proxy
+//   - "x-real-ip": the X-Real-IP header
+//
+// Headers are only believed when the remote address is one of the trusted proxies, other clients
+// could send anything. The remote address is used otherwise, or when the header is missing or
+// invalid.
+func ClientIP(strategy string, trusted []*net.IPNet) func(r *http.Request) string {
+	switch strategy {
+	case "x-forwarded-for":
+		return func(r *http.Request) string {
+			remote := RemoteIP(r)
+			if !inNetworks(remote, trusted) {
+				return remote
+			}
+			hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				ip := net.ParseIP(strings.TrimSpace(hops[i]))
+				if ip == nil {
+					return remote
+				}
+				if !inNetworks(ip.String(), trusted) {
+					return ip.String()
+				}
+			}
+			return remote
+		}
+	case "x-real-ip":
+		return func(r *http.Request) string {
+			remote := RemoteIP(r)
+			if !inNetworks(remote, trusted) {
+				return remote
+			}
+			if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+				return ip.String()
+			}
+			return remote
+		}
+	}
+	return RemoteIP
+}
+
+func inNetworks(ip string, networks []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test client IP strategies only believe headers from trusted proxies
+func TestClientIP(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{lb}
+	for _, tc := range []struct {
+		strategy string
+		remote   string
+		header   http.Header
+		want     string
+	}{
+		{"remote", "10.0.0.2:1000", http.Header{"X-Real-Ip": {"1.1.1.1"}}, "10.0.0.2"},
+		{"x-forwarded-for", "10.0.0.2:1000", http.Header{"X-Forwarded-For": {"6.6.6.6, 1.1.1.1", "10.0.0.3"}}, "1.1.1.1"},
+		{"x-forwarded-for", "2.2.2.2:1000", http.Header{"X-Forwarded-For": {"1.1.1.1"}}, "2.2.2.2"},
+		{"x-forwarded-for", "10.0.0.2:1000", http.Header{"X-Forwarded-For": {"10.0.0.4"}}, "10.0.0.2"},
+		{"x-forwarded-for", "10.0.0.2:1000", http.Header{"X-Forwarded-For": {"1.1.1.1, bogus"}}, "10.0.0.2"},
+		{"x-real-ip", "10.0.0.2:1000", http.Header{"X-Real-Ip": {"1.1.1.1"}}, "1.1.1.1"},
+		{"x-real-ip", "2.2.2.2:1000", http.Header{"X-Real-Ip": {"1.1.1.1"}}, "2.2.2.2"},
+		{"x-real-ip", "10.0.0.2:1000", nil, "10.0.0.2"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		for key, values := range tc.header {
+			req.Header[key] = values
+		}
+		assert.Equal(t, tc.want, ClientIP(tc.strategy, trusted)(req), "%s from %s", tc.strategy, tc.remote)
+	}
+}
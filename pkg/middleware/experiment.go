@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/experiment"
+)
+
+type abTest struct {
+	name    string
+	buckets []experiment.Bucket
+	cookie  string
+	header  string
+	maxAge  time.Duration
+	roll    func() int
+}
+
+type ExperimentOption func(*abTest)
+
+// WithExperimentCookie sets the cookie persisting the assignment, revproxy_<name> by default.
+func WithExperimentCookie(name string) ExperimentOption {
+	return func(ab *abTest) {
+		ab.cookie = name
+	}
+}
+
+// WithExperimentHeader sets the request header telling upstreams the bucket,
+// X-Experiment-<name> by default. Any value sent by the client is replaced.
+func WithExperimentHeader(header string) ExperimentOption {
+	return func(ab *abTest) {
+		ab.header = header
+	}
+}
+
+// WithExperimentMaxAge sets how long the assignment cookie lasts, 30 days by default.
+func WithExperimentMaxAge(maxAge time.Duration) ExperimentOption {
+	return func(ab *abTest) {
+		ab.maxAge = maxAge
+	}
+}
+
+// WithExperimentRoll replaces the random roll in [0, 100) used to assign new visitors.
+func WithExperimentRoll(roll func() int) ExperimentOption {
+	return func(ab *abTest) {
+		ab.roll = roll
+	}
+}
+
+// Experiment assigns visitors to one of buckets by their percentages, persists the assignment in
+// a cookie and exposes it to routing (see experiment.FromContext) and upstreams via a header.
+// Visitors whose cookie names an unknown bucket, e.g. after buckets changed, are reassigned.
+func Experiment(name string, buckets []experiment.Bucket, opts ...ExperimentOption) Middleware {
+	ab := &abTest{
+		name:    name,
+		buckets: buckets,
+		cookie:  "revproxy_" + name,
+		header:  "X-Experiment-" + name,
+		maxAge:  30 * 24 * time.Hour,
+		roll:    func() int { return rand.IntN(100) },
+	}
+	for _, opt := range opts {
+		opt(ab)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucket := ""
+			if c, err := r.Cookie(ab.cookie); err == nil && ab.known(c.Value) {
+				bucket = c.Value
+			} else if bucket = experiment.Pick(ab.buckets, ab.roll()); bucket != "" {
+				http.SetCookie(w, &http.Cookie{
+					Name:     ab.cookie,
+					Value:    bucket,
+					Path:     "/",
+					MaxAge:   int(ab.maxAge / time.Second),
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			r.Header.Del(ab.header)
+			if bucket != "" {
+				r.Header.Set(ab.header, bucket)
+				r = r.WithContext(experiment.NewContext(r.Context(), ab.name, bucket))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (ab *abTest) known(bucket string) bool {
+	return slices.ContainsFunc(ab.buckets, func(b experiment.Bucket) bool { return b.Name == bucket })
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/experiment"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test new visitors are assigned by roll and get a cookie, returning visitors keep their bucket
+// and unknown buckets are reassigned
+func TestExperiment(t *testing.T) {
+	roll := 0
+	var header, fromContext string
+	h := Experiment("checkout", []experiment.Bucket{{Name: "control", Percent: 90}, {Name: "new", Percent: 10}},
+		WithExperimentRoll(func() int { return roll }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, fromContext = r.Header.Get("X-Experiment-checkout"), experiment.FromContext(r.Context(), "checkout")
+	}))
+	serveWithCookie := func(value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			req.AddCookie(&http.Cookie{Name: "revproxy_checkout", Value: value})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	roll = 95
+	rec := serveWithCookie("")
+	assert.Equal(t, "new", header)
+	assert.Equal(t, "new", fromContext)
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "revproxy_checkout=new")
+
+	roll = 0
+	rec = serveWithCookie("new")
+	assert.Equal(t, "new", header)
+	assert.Empty(t, rec.Header().Get("Set-Cookie"))
+
+	rec = serveWithCookie("removed")
+	assert.Equal(t, "control", header)
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "revproxy_checkout=control")
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+	"github.com/ashpect/revproxy/pkg/utils"
+)
+
+type faultInjector struct {
+	delay        time.Duration
+	delayPercent float64
+	abortStatus  int
+	abortPercent float64
+	resetPercent float64
+	roll         func() float64
+}
+
+type FaultOption func(*faultInjector)
+
+// WithFaultDelay holds percent of requests for delay before passing them on.
+func WithFaultDelay(delay time.Duration, percent float64) FaultOption {
+	return func(f *faultInjector) {
+		f.delay, f.delayPercent = delay, percent
+	}
+}
+
+// WithFaultAbort answers percent of requests with status instead of passing them on.
+func WithFaultAbort(status int, percent float64) FaultOption {
+	return func(f *faultInjector) {
+		f.abortStatus, f.abortPercent = status, percent
+	}
+}
+
+// WithFaultReset drops the connection of percent of requests without a response.
+func WithFaultReset(percent float64) FaultOption {
+	return func(f *faultInjector) {
+		f.resetPercent = percent
+	}
+}
+
+// WithFaultRoll replaces the random roll in [0, 100) deciding whether a fault applies.
+func WithFaultRoll(roll func() float64) FaultOption {
+	return func(f *faultInjector) {
+		f.roll = roll
+	}
+}
+
+// Fault injects delays, error responses and dropped connections into a share of the traffic, to
+// check clients retry and time out the way they should. Each fault is rolled independently, a
+// delayed request may still be aborted.
+func Fault(opts ...FaultOption) Middleware {
+	f := &faultInjector{
+		roll: func() float64 { return rand.Float64() * 100 },
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if f.delay > 0 && f.hit(f.delayPercent) {
+				utils.Debug("fault: delaying %s %s by %v", r.Method, r.URL.Path, f.delay)
+				timer := time.NewTimer(f.delay)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+			if f.hit(f.resetPercent) {
+				utils.Debug("fault: resetting %s %s", r.Method, r.URL.Path)
+				// the server closes the connection without writing a response
+				panic(http.ErrAbortHandler)
+			}
+			if f.abortStatus != 0 && f.hit(f.abortPercent) {
+				utils.Debug("fault: aborting %s %s with %d", r.Method, r.URL.Path, f.abortStatus)
+				errorpage.Error(w, r, "fault injected", f.abortStatus)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (f *faultInjector) hit(percent float64) bool {
+	return percent > 0 && f.roll() < percent
+}
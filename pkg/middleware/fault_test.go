@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test faults apply when the roll falls under their percentage and pass requests on otherwise
+func TestFault(t *testing.T) {
+	roll := 0.0
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	h := Fault(
+		WithFaultDelay(20*time.Millisecond, 50),
+		WithFaultAbort(http.StatusServiceUnavailable, 10),
+		WithFaultRoll(func() float64 { return roll }),
+	)(ok)
+
+	// under both percentages, delayed then aborted
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// only delayed
+	roll = 30
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "ok", rec.Body.String())
+
+	// untouched
+	roll = 99
+	start = time.Now()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+// Test reset faults abort the handler so the server drops the connection
+func TestFault_Reset(t *testing.T) {
+	h := Fault(WithFaultReset(100))(http.NotFoundHandler())
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+	"github.com/ashpect/revproxy/pkg/geoip"
+)
+
+const defaultCountryHeader = "X-Country-Code"
+
+type geoIP struct {
+	lookup   func(ip net.IP) string
+	header   string
+	blocked  map[string]bool
+	clientIP func(r *http.Request) string
+}
+
+type GeoIPOption func(*geoIP)
+
+// WithCountryHeader sets the request header carrying the country to upstreams, X-Country-Code by
+// default. Any value sent by the client is replaced.
+func WithCountryHeader(header string) GeoIPOption {
+	return func(g *geoIP) {
+		g.header = header
+	}
+}
+
+// WithBlockedCountries rejects clients from the given countries (ISO codes) with 403.
+func WithBlockedCountries(countries ...string) GeoIPOption {
+	return func(g *geoIP) {
+		for _, country := range countries {
+			g.blocked[strings.ToUpper(country)] = true
+		}
+	}
+}
+
+// WithGeoClientIP sets how the client IP is derived from a request. Defaults to the remote address.
+func WithGeoClientIP(fn func(r *http.Request) string) GeoIPOption {
+	return func(g *geoIP) {
+		g.clientIP = fn
+	}
+}
+
+// GeoIP resolves the client's country with lookup, stores it in the request context for routing
+// (see geoip.FromContext) and passes it upstream in a header.
+func GeoIP(lookup func(ip net.IP) string, opts ...GeoIPOption) Middleware {
+	g := &geoIP{
+		lookup:   lookup,
+		header:   defaultCountryHeader,
+		blocked:  map[string]bool{},
+		clientIP: RemoteIP,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			country := ""
+			if ip := net.ParseIP(g.clientIP(r)); ip != nil {
+				country = g.lookup(ip)
+			}
+			if g.blocked[country] {
+				errorpage.Error(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			r = r.WithContext(geoip.NewContext(r.Context(), country))
+			r.Header.Del(g.header)
+			if country != "" {
+				r.Header.Set(g.header, country)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
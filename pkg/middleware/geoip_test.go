@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/geoip"
+	"github.com/stretchr/testify/assert"
+)
+
+func countries(ip net.IP) string {
+	switch ip.String() {
+	case "1.1.1.1":
+		return "DE"
+	case "2.2.2.2":
+		return "KP"
+	}
+	return ""
+}
+
+// Test the country is passed upstream in the header and context, replacing client values, and
+// blocked countries get a 403
+func TestGeoIP(t *testing.T) {
+	var header, fromContext string
+	h := GeoIP(countries, WithBlockedCountries("kp"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, fromContext = r.Header.Get("X-Country-Code"), geoip.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "1.1.1.1:1000"
+	req.Header.Set("X-Country-Code", "US")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "DE", header)
+	assert.Equal(t, "DE", fromContext)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "3.3.3.3:1000"
+	req.Header.Set("X-Country-Code", "US")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "", header, "spoofed header is dropped for unknown countries")
+
+	assert.Equal(t, http.StatusForbidden, serve(h, "2.2.2.2:1000").Code)
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+type connRequestsKey struct{}
+
+// RequestsPerConn caps the requests a keep-alive client connection serves: the last one is
+// answered with Connection: close, so the client reconnects rather than holding the connection
+// forever. Only HTTP/1 connections are capped. Its ConnContext must be set as the server's.
+type RequestsPerConn struct {
+	max int64
+}
+
+// NewRequestsPerConn caps connections at max requests, 0 means no cap.
+func NewRequestsPerConn(max int) *RequestsPerConn {
+	return &RequestsPerConn{max: int64(max)}
+}
+
+// ConnContext gives each connection its request counter, set it as the server's ConnContext.
+func (l *RequestsPerConn) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connRequestsKey{}, new(atomic.Int64))
+}
+
+func (l *RequestsPerConn) Middleware(next http.Handler) http.Handler {
+	if l.max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served, ok := r.Context().Value(connRequestsKey{}).(*atomic.Int64); ok && r.ProtoMajor == 1 {
+			if served.Add(1) >= l.max {
+				w.Header().Set("Connection", "close")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test a connection is closed after its last allowed request and the client reconnects
+func TestRequestsPerConn(t *testing.T) {
+	perConn := NewRequestsPerConn(2)
+	server := httptest.NewUnstartedServer(perConn.Middleware(ok()))
+	server.Config.ConnContext = perConn.ConnContext
+	server.Start()
+	defer server.Close()
+
+	var closed []bool
+	for range 3 {
+		resp, err := server.Client().Get(server.URL)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		closed = append(closed, resp.Close)
+	}
+	assert.Equal(t, []bool{false, true, false}, closed)
+}
+
+// Test no cap leaves the handler as is
+func TestRequestsPerConn_unlimited(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewRequestsPerConn(0).Middleware(ok()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, rec.Header().Get("Connection"))
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+)
+
+// knownMethods is what a deny-only filter advertises in Allow, minus the denied methods.
+var knownMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodOptions, http.MethodTrace,
+}
+
+type methodFilter struct {
+	allowed []string
+	denied  []string
+}
+
+type MethodOption func(*methodFilter)
+
+// WithAllowedMethods only lets the given methods through. Allowing GET also allows HEAD.
+func WithAllowedMethods(methods ...string) MethodOption {
+	return func(f *methodFilter) {
+		for _, method := range methods {
+			f.allowed = append(f.allowed, strings.ToUpper(method))
+		}
+		if slices.Contains(f.allowed, http.MethodGet) && !slices.Contains(f.allowed, http.MethodHead) {
+			f.allowed = append(f.allowed, http.MethodHead)
+		}
+	}
+}
+
+// WithDeniedMethods rejects the given methods, taking precedence over WithAllowedMethods.
+func WithDeniedMethods(methods ...string) MethodOption {
+	return func(f *methodFilter) {
+		for _, method := range methods {
+			f.denied = append(f.denied, strings.ToUpper(method))
+		}
+	}
+}
+
+// Methods rejects requests whose method isn't allowed with 405 and an Allow header listing the
+// methods that are.
+func Methods(opts ...MethodOption) Middleware {
+	f := &methodFilter{}
+	for _, opt := range opts {
+		opt(f)
+	}
+	permitted := f.allowed
+	if permitted == nil {
+		permitted = knownMethods
+	}
+	permitted = slices.DeleteFunc(slices.Clone(permitted), func(m string) bool { return slices.Contains(f.denied, m) })
+	allowHeader := strings.Join(permitted, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(f.denied, r.Method) || (f.allowed != nil && !slices.Contains(f.allowed, r.Method)) {
+				w.Header().Set("Allow", allowHeader)
+				errorpage.Error(w, r, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
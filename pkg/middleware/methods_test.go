@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveMethod(h http.Handler, method string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+	return rec
+}
+
+// Test allowed methods pass (GET implying HEAD) and others get 405 with the Allow header
+func TestMethods_allow(t *testing.T) {
+	h := Methods(WithAllowedMethods("get"), WithDeniedMethods("TRACE"))(ok())
+
+	assert.Equal(t, http.StatusOK, serveMethod(h, http.MethodGet).Code)
+	assert.Equal(t, http.StatusOK, serveMethod(h, http.MethodHead).Code)
+	rec := serveMethod(h, http.MethodPost)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD", rec.Header().Get("Allow"))
+}
+
+// Test a deny-only filter lets everything else through and advertises the rest
+func TestMethods_deny(t *testing.T) {
+	h := Methods(WithDeniedMethods("TRACE", "DELETE"))(ok())
+
+	assert.Equal(t, http.StatusOK, serveMethod(h, http.MethodPost).Code)
+	rec := serveMethod(h, http.MethodTrace)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD, POST, PUT, PATCH, OPTIONS", rec.Header().Get("Allow"))
+}
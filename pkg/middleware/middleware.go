@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Middleware wraps a handler with extra behavior.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps handler so the first middleware is the outermost one.
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Middleware{}
+)
+
+// Register makes a middleware available by name to the route config's middleware list.
+func Register(name string, m Middleware) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = m
+}
+
+// Lookup resolves middleware names in order, failing on the first unknown one.
+func Lookup(names ...string) ([]Middleware, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	out := make([]Middleware, 0, len(names))
+	for _, name := range names {
+		m, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q (registered: %v)", name, registeredNames())
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// registeredNames lists registry keys sorted. Caller must hold registryMu.
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
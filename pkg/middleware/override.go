@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/ashpect/revproxy/pkg/proxy"
+)
+
+const defaultOverrideHeader = "X-Revproxy-Upstream"
+
+type upstreamOverride struct {
+	header   string
+	allowed  []*net.IPNet
+	clientIP func(r *http.Request) string
+}
+
+type OverrideOption func(*upstreamOverride)
+
+// WithOverrideHeader sets the request header naming the override target, X-Revproxy-Upstream by
+// default.
+func WithOverrideHeader(header string) OverrideOption {
+	return func(o *upstreamOverride) {
+		o.header = header
+	}
+}
+
+// WithOverrideAllowed sets the client networks allowed to override, nobody by default.
+func WithOverrideAllowed(networks []*net.IPNet) OverrideOption {
+	return func(o *upstreamOverride) {
+		o.allowed = networks
+	}
+}
+
+// WithOverrideClientIP sets how the client IP is derived from a request. Defaults to the remote
+// address.
+func WithOverrideClientIP(fn func(r *http.Request) string) OverrideOption {
+	return func(o *upstreamOverride) {
+		o.clientIP = fn
+	}
+}
+
+// UpstreamOverride sends a request carrying the header, e.g. X-Revproxy-Upstream:
+// http://localhost:3000, to that URL instead of its route's upstream, so a developer can debug
+// against real traffic. Only allowed clients can override, the header is always stripped before
+// the request goes upstream and overridden requests bypass the cache.
+func UpstreamOverride(opts ...OverrideOption) Middleware {
+	o := &upstreamOverride{
+		header:   defaultOverrideHeader,
+		clientIP: RemoteIP,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := r.Header.Get(o.header)
+			if raw == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Header.Del(o.header)
+
+			ip := o.clientIP(r)
+			if !o.isAllowed(ip) {
+				log.Printf("upstream override from %s ignored: client not allowed", ip)
+				next.ServeHTTP(w, r)
+				return
+			}
+			target, err := url.Parse(raw)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				http.Error(w, "invalid "+o.header+", want an http(s) URL", http.StatusBadRequest)
+				return
+			}
+			log.Printf("upstream override: %s %s from %s sent to %s", r.Method, r.URL.Path, ip, target.Redacted())
+			next.ServeHTTP(w, r.WithContext(proxy.NewOverrideContext(r.Context(), target)))
+		})
+	}
+}
+
+func (o *upstreamOverride) isAllowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range o.allowed {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test only allowed clients can override, and the header never reaches the next handler
+func TestUpstreamOverride(t *testing.T) {
+	_, office, _ := net.ParseCIDR("10.0.0.0/8")
+	var header string
+	h := UpstreamOverride(WithOverrideAllowed([]*net.IPNet{office}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Revproxy-Upstream")
+	}))
+	serveOverride := func(remoteAddr, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Revproxy-Upstream", target)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serveOverride("10.1.2.3:1000", "http://localhost:3000").Code)
+	assert.Empty(t, header)
+	assert.Equal(t, http.StatusOK, serveOverride("1.1.1.1:1000", "http://localhost:3000").Code)
+	assert.Empty(t, header)
+	assert.Equal(t, http.StatusBadRequest, serveOverride("10.1.2.3:1000", "file:///etc/passwd").Code)
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+)
+
+type pathNormalizer struct {
+	mergeSlashes           bool
+	resolveDots            bool
+	rejectEncodedTraversal bool
+}
+
+type PathOption func(*pathNormalizer)
+
+// WithMergeSlashes collapses runs of slashes, /a//b is /a/b.
+func WithMergeSlashes(merge bool) PathOption {
+	return func(n *pathNormalizer) {
+		n.mergeSlashes = merge
+	}
+}
+
+// WithResolveDots removes . segments and resolves .. ones against the previous segment, never
+// above the root: /a/./b/../c is /a/c.
+func WithResolveDots(resolve bool) PathOption {
+	return func(n *pathNormalizer) {
+		n.resolveDots = resolve
+	}
+}
+
+// WithRejectEncodedTraversal rejects paths hiding a . or .. segment behind escaping, like
+// /%2e%2e/admin or /a/..%2fadmin, which a backend decoding the path could resolve.
+func WithRejectEncodedTraversal(reject bool) PathOption {
+	return func(n *pathNormalizer) {
+		n.rejectEncodedTraversal = reject
+	}
+}
+
+// NormalizePath rewrites request paths to their normal form before routing and cache keys see
+// them, so /public/../admin can't dodge the rules of /admin or be cached apart from it. The
+// client's escaping of the remaining segments is kept. Rejected paths get a 400.
+func NormalizePath(opts ...PathOption) Middleware {
+	n := &pathNormalizer{}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			escaped, ok := n.normalize(r.URL.EscapedPath())
+			if !ok {
+				errorpage.Error(w, r, "invalid path", http.StatusBadRequest)
+				return
+			}
+			if escaped != r.URL.EscapedPath() {
+				path, err := url.PathUnescape(escaped)
+				if err != nil {
+					errorpage.Error(w, r, "invalid path", http.StatusBadRequest)
+					return
+				}
+				r = r.Clone(r.Context())
+				r.URL.Path, r.URL.RawPath = path, escaped
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// normalize returns the normal form of the escaped path, false when it must be rejected.
+func (n *pathNormalizer) normalize(escaped string) (string, bool) {
+	if !strings.HasPrefix(escaped, "/") {
+		return escaped, true // e.g. * of OPTIONS *
+	}
+	segments := strings.Split(escaped[1:], "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if n.rejectEncodedTraversal && strings.Contains(segment, "%") && hidesDots(segment) {
+			return "", false
+		}
+		switch {
+		case segment == "" && n.mergeSlashes && !last:
+			continue
+		case segment == "." && n.resolveDots:
+			if last {
+				out = append(out, "")
+			}
+			continue
+		case segment == ".." && n.resolveDots:
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+			continue
+		}
+		out = append(out, segment)
+	}
+	return "/" + strings.Join(out, "/"), true
+}
+
+// hidesDots tells whether an escaped segment decodes to, or to a path with, a . or .. segment.
+func hidesDots(segment string) bool {
+	decoded, err := url.PathUnescape(segment)
+	if err != nil {
+		return true
+	}
+	for _, part := range strings.FieldsFunc(decoded, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == "." || part == ".." {
+			return true
+		}
+	}
+	return false
+}
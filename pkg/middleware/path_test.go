@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test paths are normalized before the next handler, keeping the client's escaping, and encoded
+// traversal is rejected
+func TestNormalizePath(t *testing.T) {
+	var got string
+	h := NormalizePath(WithMergeSlashes(true), WithResolveDots(true), WithRejectEncodedTraversal(true))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.EscapedPath() + " " + r.URL.Path
+		}))
+
+	for target, want := range map[string]string{
+		"/a//b/":            "/a/b/ /a/b/",
+		"/public/../admin":  "/admin /admin",
+		"/a/./b/.":          "/a/b/ /a/b/",
+		"/../../etc/passwd": "/etc/passwd /etc/passwd",
+		"/files/a%2Fb/../c": "/files/c /files/c",
+		"/files/a%2Fb/./c":  "/files/a%2Fb/c /files/a/b/c",
+		"/a/b/..":           "/a/ /a/",
+		"/a%20b?q=/../":     "/a%20b /a b",
+		"//":                "/ /",
+	} {
+		got = ""
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, target)
+		assert.Equal(t, want, got, target)
+	}
+
+	for _, target := range []string{"/%2e%2e/admin", "/a/..%2fadmin", "/a/%2E/b", "/a/..%5Cadmin"} {
+		got = ""
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+		assert.Empty(t, got, target)
+	}
+
+	// off, paths pass untouched
+	h = NormalizePath()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a//./%2e%2e/b", nil))
+	assert.Equal(t, "/a//./%2e%2e/b", got)
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type preflight struct {
+	allow       []string
+	origins     []string
+	methods     []string
+	headers     []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+type PreflightOption func(*preflight)
+
+// WithPreflightAllow sets the Allow header of OPTIONS responses. Allowing GET also allows HEAD,
+// and OPTIONS is always listed. No methods leaves Allow out.
+func WithPreflightAllow(methods ...string) PreflightOption {
+	return func(p *preflight) {
+		if len(methods) == 0 {
+			return
+		}
+		for _, method := range methods {
+			p.allow = append(p.allow, strings.ToUpper(method))
+		}
+		if slices.Contains(p.allow, http.MethodGet) && !slices.Contains(p.allow, http.MethodHead) {
+			p.allow = append(p.allow, http.MethodHead)
+		}
+		if !slices.Contains(p.allow, http.MethodOptions) {
+			p.allow = append(p.allow, http.MethodOptions)
+		}
+	}
+}
+
+// WithPreflightOrigins sets the origins whose CORS preflights succeed, "*" for any.
+func WithPreflightOrigins(origins ...string) PreflightOption {
+	return func(p *preflight) {
+		p.origins = origins
+	}
+}
+
+// WithPreflightMethods sets Access-Control-Allow-Methods, the Allow methods when unset.
+func WithPreflightMethods(methods ...string) PreflightOption {
+	return func(p *preflight) {
+		for _, method := range methods {
+			p.methods = append(p.methods, strings.ToUpper(method))
+		}
+	}
+}
+
+// WithPreflightHeaders sets Access-Control-Allow-Headers, the request headers browsers may send.
+func WithPreflightHeaders(headers ...string) PreflightOption {
+	return func(p *preflight) {
+		p.headers = headers
+	}
+}
+
+// WithPreflightCredentials lets browsers send cookies and authorization, the matching origin is
+// then echoed even when any is allowed.
+func WithPreflightCredentials(credentials bool) PreflightOption {
+	return func(p *preflight) {
+		p.credentials = credentials
+	}
+}
+
+// WithPreflightMaxAge sets how long browsers may cache a preflight, in whole seconds.
+func WithPreflightMaxAge(maxAge time.Duration) PreflightOption {
+	return func(p *preflight) {
+		p.maxAge = maxAge
+	}
+}
+
+// Preflight answers OPTIONS requests with 204 and the configured Allow header instead of passing
+// them on, saving browsers the upstream round trip before their cross-origin requests. CORS
+// preflights from allowed origins also get the Access-Control-Allow-* headers, others get none
+// and fail in the browser. Other methods go to next.
+func Preflight(opts ...PreflightOption) Middleware {
+	p := &preflight{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	methods := p.methods
+	if methods == nil {
+		methods = p.allow
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(p.allow) > 0 {
+				w.Header().Set("Allow", strings.Join(p.allow, ", "))
+			}
+			origin := r.Header.Get("Origin")
+			if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Origin")
+				if allowed := p.allowOrigin(origin); allowed != "" {
+					w.Header().Set("Access-Control-Allow-Origin", allowed)
+					if len(methods) > 0 {
+						w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+					}
+					if len(p.headers) > 0 {
+						w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.headers, ", "))
+					}
+					if p.credentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+					if p.maxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+					}
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, empty when not allowed.
+func (p *preflight) allowOrigin(origin string) string {
+	for _, allowed := range p.origins {
+		if allowed == "*" {
+			if p.credentials {
+				return origin // browsers reject * with credentials
+			}
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test OPTIONS is answered locally, with CORS headers only for preflights from allowed origins
+func TestPreflight(t *testing.T) {
+	h := Preflight(
+		WithPreflightAllow("get", "POST"),
+		WithPreflightOrigins("https://app.example.com"),
+		WithPreflightHeaders("Content-Type", "Authorization"),
+		WithPreflightCredentials(true),
+		WithPreflightMaxAge(10*time.Minute),
+	)(ok())
+
+	assert.Equal(t, http.StatusOK, serveMethod(h, http.MethodGet).Code)
+	rec := serveMethod(h, http.MethodOptions)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, POST, HEAD, OPTIONS", rec.Header().Get("Allow"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec = preflight("https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST, HEAD, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, Authorization", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "Origin", rec.Header().Get("Vary"))
+
+	rec = preflight("https://evil.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Methods"))
+}
+
+// Test any origin is allowed with *, echoed when credentials are allowed
+func TestPreflight_anyOrigin(t *testing.T) {
+	for _, credentials := range []bool{false, true} {
+		h := Preflight(WithPreflightOrigins("*"), WithPreflightMethods("put"), WithPreflightCredentials(credentials))(ok())
+		req := httptest.NewRequest(http.MethodOptions, "/", nil)
+		req.Header.Set("Origin", "https://a.example.com")
+		req.Header.Set("Access-Control-Request-Method", "PUT")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		want := "*"
+		if credentials {
+			want = "https://a.example.com"
+		}
+		assert.Equal(t, want, rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "PUT", rec.Header().Get("Access-Control-Allow-Methods"))
+		assert.Empty(t, rec.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+)
+
+// idleBucketTTL is how long a per-client bucket is kept after its last request.
+const idleBucketTTL = 10 * time.Minute
+
+// tokenBucket refills rate tokens per second up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow takes a token if one is available, otherwise returns how long until one is.
+func (b *tokenBucket) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// refund returns a token taken by allow.
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+type rateLimiter struct {
+	global *tokenBucket
+
+	perClientRate  float64
+	perClientBurst int
+	mu             sync.Mutex
+	clients        map[string]*tokenBucket
+	lastPrune      time.Time
+
+	exempt   []*net.IPNet
+	clientIP func(r *http.Request) string
+}
+
+type RateLimitOption func(*rateLimiter)
+
+// WithGlobalRate limits all requests through the middleware to rps with the given burst.
+// Middlewares built with the same option share its bucket, so one limit can span several routes.
+func WithGlobalRate(rps float64, burst int) RateLimitOption {
+	var global *tokenBucket
+	if rps > 0 {
+		global = newTokenBucket(rps, max(burst, 1))
+	}
+	return func(rl *rateLimiter) {
+		rl.global = global
+	}
+}
+
+// WithPerClientRate limits each client IP to rps with the given burst.
+func WithPerClientRate(rps float64, burst int) RateLimitOption {
+	return func(rl *rateLimiter) {
+		rl.perClientRate = rps
+		rl.perClientBurst = max(burst, 1)
+	}
+}
+
+// WithExempt skips limiting for clients inside any of the networks.
+func WithExempt(networks []*net.IPNet) RateLimitOption {
+	return func(rl *rateLimiter) {
+		rl.exempt = networks
+	}
+}
+
+// WithClientIP sets how the client IP is derived from a request. Defaults to the remote address.
+func WithClientIP(fn func(r *http.Request) string) RateLimitOption {
+	return func(rl *rateLimiter) {
+		rl.clientIP = fn
+	}
+}
+
+// RateLimit rejects requests over the configured rates with 429 and a Retry-After header.
+func RateLimit(opts ...RateLimitOption) Middleware {
+	rl := &rateLimiter{
+		clients:   map[string]*tokenBucket{},
+		lastPrune: time.Now(),
+		clientIP:  RemoteIP,
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := rl.clientIP(r)
+			if !rl.isExempt(ip) {
+				if ok, retryAfter := rl.allow(ip); !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)+1))
+					errorpage.Error(w, r, "rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow takes a token from the client's bucket and the global one, or from neither: the client's
+// token is refunded when the global bucket rejects the request.
+func (rl *rateLimiter) allow(ip string) (bool, time.Duration) {
+	var client *tokenBucket
+	if rl.perClientRate > 0 {
+		client = rl.clientBucket(ip)
+		if ok, retryAfter := client.allow(); !ok {
+			return false, retryAfter
+		}
+	}
+	if rl.global != nil {
+		if ok, retryAfter := rl.global.allow(); !ok {
+			if client != nil {
+				client.refund()
+			}
+			return false, retryAfter
+		}
+	}
+	return true, 0
+}
+
+// clientBucket returns the bucket for ip, pruning idle buckets now and then.
+func (rl *rateLimiter) clientBucket(ip string) *tokenBucket {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastPrune) > idleBucketTTL {
+		for key, bucket := range rl.clients {
+			bucket.mu.Lock()
+			idle := now.Sub(bucket.last) > idleBucketTTL
+			bucket.mu.Unlock()
+			if idle {
+				delete(rl.clients, key)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	bucket, ok := rl.clients[ip]
+	if !ok {
+		bucket = newTokenBucket(rl.perClientRate, rl.perClientBurst)
+		rl.clients[ip] = bucket
+	}
+	return bucket
+}
+
+func (rl *rateLimiter) isExempt(ip string) bool {
+	return inNetworks(ip, rl.exempt)
+}
+
+// RemoteIP returns the host part of the request's remote address.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
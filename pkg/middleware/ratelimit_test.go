@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ok() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func serve(h http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// Test per-client buckets are independent and exempt networks bypass limits
+func TestRateLimit_perClient(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	h := RateLimit(WithPerClientRate(1, 2), WithExempt([]*net.IPNet{internal}))(ok())
+
+	assert.Equal(t, http.StatusOK, serve(h, "1.1.1.1:1000").Code)
+	assert.Equal(t, http.StatusOK, serve(h, "1.1.1.1:1001").Code)
+	rec := serve(h, "1.1.1.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve(h, "2.2.2.2:1000").Code)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve(h, "10.1.2.3:1000").Code)
+	}
+}
+
+// Test the global bucket is shared across clients
+func TestRateLimit_global(t *testing.T) {
+	h := RateLimit(WithGlobalRate(1, 1))(ok())
+	assert.Equal(t, http.StatusOK, serve(h, "1.1.1.1:1000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, "2.2.2.2:1000").Code)
+}
+
+// Test a request the global bucket rejects doesn't use up the client's token, and one the client's
+// bucket rejects doesn't use up a global token
+func TestRateLimit_globalRejectionRefundsClient(t *testing.T) {
+	h := RateLimit(WithPerClientRate(0.001, 1), WithGlobalRate(20, 1))(ok())
+
+	assert.Equal(t, http.StatusOK, serve(h, "2.2.2.2:1000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, "1.1.1.1:1000").Code)
+	// once the global bucket refills, the client still has its token
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve(h, "1.1.1.1:1000").Code)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, "1.1.1.1:1000").Code)
+	assert.Equal(t, http.StatusOK, serve(h, "3.3.3.3:1000").Code)
+}
+
+// Test middlewares built with the same global rate option share its bucket
+func TestRateLimit_sharedGlobal(t *testing.T) {
+	global := WithGlobalRate(0.001, 1)
+	a, b := RateLimit(global)(ok()), RateLimit(global)(ok())
+	assert.Equal(t, http.StatusOK, serve(a, "1.1.1.1:1000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(b, "2.2.2.2:1000").Code)
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// traceHeaders are the headers of each trace context format, by the name used in the config. b3
+// covers both the single b3 header and the multi-header X-B3-* form.
+var traceHeaders = map[string][]string{
+	"traceparent": {"Traceparent"},
+	"tracestate":  {"Tracestate"},
+	"baggage":     {"Baggage"},
+	"b3":          {"B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled", "X-B3-Flags"},
+}
+
+type trace struct {
+	strip    []string
+	trusted  []*net.IPNet
+	generate string
+	clientIP func(r *http.Request) string
+}
+
+type TraceOption func(*trace)
+
+// WithTraceStrip removes the headers of formats (traceparent, tracestate, baggage, b3) from every
+// request.
+func WithTraceStrip(formats ...string) TraceOption {
+	return func(t *trace) {
+		for _, format := range formats {
+			t.strip = append(t.strip, traceHeaders[strings.ToLower(format)]...)
+		}
+	}
+}
+
+// WithTraceTrusted sets the client networks whose trace headers are propagated. The trace headers
+// of other clients are removed, so they can't join or steer the traces. Every client is trusted by
+// default.
+func WithTraceTrusted(networks []*net.IPNet) TraceOption {
+	return func(t *trace) {
+		t.trusted = networks
+	}
+}
+
+// WithTraceGenerate starts a trace for requests arriving without a valid one, in format: "w3c" (a
+// traceparent) or "b3" (a single b3 header).
+func WithTraceGenerate(format string) TraceOption {
+	return func(t *trace) {
+		t.generate = format
+	}
+}
+
+// WithTraceClientIP sets how the client IP is derived from a request. Defaults to the remote
+// address.
+func WithTraceClientIP(fn func(r *http.Request) string) TraceOption {
+	return func(t *trace) {
+		t.clientIP = fn
+	}
+}
+
+// Trace decides which trace context and correlation headers reach the upstreams: they are
+// propagated unchanged unless stripped, or the client isn't trusted, and a trace can be started
+// for requests that carry none. This makes the proxy the boundary of the traces it serves.
+func Trace(opts ...TraceOption) Middleware {
+	t := &trace{clientIP: RemoteIP}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t.trusted != nil && !t.isTrusted(t.clientIP(r)) {
+				for _, headers := range traceHeaders {
+					for _, header := range headers {
+						r.Header.Del(header)
+					}
+				}
+			}
+			for _, header := range t.strip {
+				r.Header.Del(header)
+			}
+
+			switch t.generate {
+			case "w3c":
+				if !validTraceparent(r.Header.Get("Traceparent")) {
+					// tracestate belongs to the traceparent it came with
+					r.Header.Del("Tracestate")
+					r.Header.Set("Traceparent", "00-"+randomHex(16)+"-"+randomHex(8)+"-01")
+				}
+			case "b3":
+				if r.Header.Get("B3") == "" && r.Header.Get("X-B3-Traceid") == "" {
+					r.Header.Set("B3", randomHex(16)+"-"+randomHex(8)+"-1")
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (t *trace) isTrusted(ip string) bool {
+	return inNetworks(ip, t.trusted)
+}
+
+// validTraceparent checks a traceparent is version-trace_id-parent_id-flags with non-zero ids, as
+// the W3C Trace Context spec asks before propagating it.
+func validTraceparent(value string) bool {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return false
+	}
+	for i, size := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != size || strings.Trim(parts[i], "0123456789abcdef") != "" {
+			return false
+		}
+	}
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
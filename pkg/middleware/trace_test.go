@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// Test trace headers of untrusted clients and stripped formats don't reach the next handler
+func TestTrace_strip(t *testing.T) {
+	_, office, _ := net.ParseCIDR("10.0.0.0/8")
+	var header http.Header
+	h := Trace(WithTraceStrip("baggage", "b3"), WithTraceTrusted([]*net.IPNet{office}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	serveTrace := func(remoteAddr string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Traceparent", testTraceparent)
+		req.Header.Set("Tracestate", "vendor=1")
+		req.Header.Set("Baggage", "user=42")
+		req.Header.Set("X-B3-TraceId", "4bf92f3577b34da6a3ce929d0e0e4736")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serveTrace("10.1.2.3:1000")
+	assert.Equal(t, testTraceparent, header.Get("Traceparent"))
+	assert.Equal(t, "vendor=1", header.Get("Tracestate"))
+	assert.Empty(t, header.Get("Baggage"))
+	assert.Empty(t, header.Get("X-B3-TraceId"))
+
+	serveTrace("1.1.1.1:1000")
+	assert.Empty(t, header.Get("Traceparent"))
+	assert.Empty(t, header.Get("Tracestate"))
+}
+
+// Test a trace is started for requests without a valid one, and valid ones are kept
+func TestTrace_generate(t *testing.T) {
+	var header http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	})
+	serveTrace := func(h http.Handler, name, value string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if name != "" {
+			req.Header.Set(name, value)
+			req.Header.Set("Tracestate", "vendor=1")
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w3c := Trace(WithTraceGenerate("w3c"))(next)
+	serveTrace(w3c, "Traceparent", testTraceparent)
+	assert.Equal(t, testTraceparent, header.Get("Traceparent"))
+	assert.Equal(t, "vendor=1", header.Get("Tracestate"))
+	serveTrace(w3c, "Traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	assert.True(t, validTraceparent(header.Get("Traceparent")))
+	assert.NotContains(t, header.Get("Traceparent"), "00f067aa0ba902b7")
+	assert.Empty(t, header.Get("Tracestate"))
+	serveTrace(w3c, "", "")
+	assert.True(t, validTraceparent(header.Get("Traceparent")))
+
+	b3 := Trace(WithTraceGenerate("b3"))(next)
+	serveTrace(b3, "B3", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1")
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1", header.Get("B3"))
+	serveTrace(b3, "", "")
+	assert.Regexp(t, `^[0-9a-f]{32}-[0-9a-f]{16}-1$`, header.Get("B3"))
+}
@@ -0,0 +1,120 @@
+// Package mock answers requests with a canned response, so clients can be developed against
+// endpoints that don't exist upstream yet.
+package mock
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// handler writes the same status, headers and body for every request, the body optionally
+// rendered as a template of the request.
+type handler struct {
+	status   int
+	header   http.Header
+	body     []byte
+	template *template.Template
+}
+
+type Option func(*handler) error
+
+// WithHeader adds a response header.
+func WithHeader(name, value string) Option {
+	return func(h *handler) error {
+		h.header.Add(name, value)
+		return nil
+	}
+}
+
+// WithBody sets the response body.
+func WithBody(body string) Option {
+	return func(h *handler) error {
+		h.body = []byte(body)
+		return nil
+	}
+}
+
+// WithBodyFile sets the response body to the contents of path, read once.
+func WithBodyFile(path string) Option {
+	return func(h *handler) error {
+		body, err := os.ReadFile(path)
+		h.body = body
+		return err
+	}
+}
+
+// WithTemplate renders the body as a text/template of the request for each response, see Data.
+// Applies to the body set before it.
+func WithTemplate() Option {
+	return func(h *handler) error {
+		tmpl, err := template.New("body").Option("missingkey=zero").Parse(string(h.body))
+		h.template = tmpl
+		return err
+	}
+}
+
+// Data is what a body template is executed with, e.g. {"id": "{{.Query.Get "id"}}"}.
+type Data struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Now    time.Time
+}
+
+// NewHandler answers with status, 200 when 0.
+func NewHandler(status int, opts ...Option) (http.Handler, error) {
+	h := &handler{status: status, header: http.Header{}}
+	if h.status == 0 {
+		h.status = http.StatusOK
+	}
+	for _, opt := range opts {
+		if err := opt(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := h.body
+	if h.template != nil {
+		var buf bytes.Buffer
+		err := h.template.Execute(&buf, Data{
+			Method: r.Method,
+			Host:   r.Host,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Header: r.Header,
+			Now:    time.Now(),
+		})
+		if err != nil {
+			log.Printf("mock %s: %v", r.URL.Path, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		body = buf.Bytes()
+	}
+
+	for name, values := range h.header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	if w.Header().Get("Content-Type") == "" && len(body) > 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(body))
+	}
+	if len(body) > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(h.status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
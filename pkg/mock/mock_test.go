@@ -0,0 +1,55 @@
+package mock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the configured status, headers and body are served, HEAD without the body
+func TestHandler(t *testing.T) {
+	h, err := NewHandler(http.StatusCreated, WithHeader("Content-Type", "application/json"), WithBody(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"id":1}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/orders", nil))
+	assert.Equal(t, "8", rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Body.String())
+
+	h, err = NewHandler(0)
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// Test templated bodies from a file see the request
+func TestHandler_template(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"id":"{{.Query.Get "id"}}","path":"{{.Path}}","team":"{{.Header.Get "X-Team"}}"}`), 0o644))
+	h, err := NewHandler(0, WithBodyFile(path), WithTemplate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/users?id=7", nil)
+	req.Header.Set("X-Team", "a")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, `{"id":"7","path":"/users","team":"a"}`, rec.Body.String())
+
+	_, err = NewHandler(0, WithBody("{{.Path"), WithTemplate())
+	assert.Error(t, err)
+	_, err = NewHandler(0, WithBodyFile(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+// Package plugin lets third-party packages add middleware to revproxy at compile time, instead of
+// forking the proxy. A plugin registers itself from init:
+//
+//	func init() {
+//		plugin.Register("auth", plugin.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+//			...
+//			next.ServeHTTP(w, r)
+//		}))
+//	}
+//
+// is compiled in with a blank import (see cmd/proxy/plugins.go) and enabled per route by naming it
+// in the route's middleware list.
+package plugin
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/ashpect/revproxy/pkg/middleware"
+)
+
+// Handler is a plugin middleware. It handles r, calling next to continue towards the upstream or
+// answering the request itself.
+type Handler interface {
+	ServeHTTP(w http.ResponseWriter, r *http.Request, next http.Handler)
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, next http.Handler)
+
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	f(w, r, next)
+}
+
+var (
+	mu      sync.Mutex
+	plugins = map[string]Handler{}
+)
+
+// Register makes h available by name to the route config's middleware list. It panics when the
+// name is taken or h is nil, like database/sql.Register, as both are build mistakes.
+func Register(name string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	if h == nil {
+		panic("plugin: Register handler is nil")
+	}
+	if _, dup := plugins[name]; dup {
+		panic(fmt.Sprintf("plugin: Register called twice for %q", name))
+	}
+	plugins[name] = h
+	middleware.Register(name, Middleware(h))
+}
+
+// Names lists the registered plugins, sorted.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Sorted(maps.Keys(plugins))
+}
+
+// Middleware adapts h to a middleware.
+func Middleware(h Handler) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r, next)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test a registered plugin is found by name through the middleware registry and wraps next
+func TestRegister(t *testing.T) {
+	Register("test-header", HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		w.Header().Set("X-Plugin", "test-header")
+		next.ServeHTTP(w, r)
+	}))
+	assert.Contains(t, Names(), "test-header")
+	assert.Panics(t, func() { Register("test-header", HandlerFunc(nil)) })
+
+	middlewares, err := middleware.Lookup("test-header")
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}), middlewares...).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "test-header", rec.Header().Get("X-Plugin"))
+	assert.Equal(t, "upstream", rec.Body.String())
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import "sync/atomic"
+
+// MemoryBudget caps the bytes buffered for caching across all in-flight responses. A capture that
+// can't reserve more drops its buffer and the response carries on stream-only, so bursts of large
+// responses can't exhaust memory.
+type MemoryBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// NewMemoryBudget returns a budget of limit bytes, 0 or less means unlimited.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Used returns the bytes currently reserved.
+func (b *MemoryBudget) Used() int64 {
+	return b.used.Load()
+}
+
+// reserve takes n bytes from the budget, reporting false without taking any when that would
+// exceed the limit.
+func (b *MemoryBudget) reserve(n int64) bool {
+	for {
+		used := b.used.Load()
+		if b.limit > 0 && used+n > b.limit {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+n) {
+			return true
+		}
+	}
+}
+
+func (b *MemoryBudget) release(n int64) {
+	b.used.Add(-n)
+}
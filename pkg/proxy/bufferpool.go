@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"bytes"
+	"sync"
+)
+
+// BufferPool hands out byte slices used to copy bodies, matching httputil.BufferPool so pools
+// can be shared with other proxies.
+type BufferPool interface {
+	Get() []byte
+	Put([]byte)
+}
+
+type syncBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool returns a sync.Pool backed BufferPool of size byte slices.
+func NewBufferPool(size int) BufferPool {
+	p := &syncBufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+func (p *syncBufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *syncBufferPool) Put(buf []byte) {
+	if cap(buf) < p.size {
+		return
+	}
+	buf = buf[:p.size]
+	p.pool.Put(&buf)
+}
+
+// defaultBufferPool is shared by proxies built without WithBufferPool.
+var defaultBufferPool = NewBufferPool(copyBufferSize)
+
+// capturePool recycles the buffers bodies are captured into before caching, the cached body
+// itself is an exact size copy so the buffer can go back to the pool.
+var capturePool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
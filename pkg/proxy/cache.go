@@ -1,7 +1,12 @@
 package proxy
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -10,4 +15,119 @@ type CachedResponse struct {
 	Header   http.Header
 	Body     []byte
 	CachedAt time.Time
+	// Source names the proxy that cached the response, refresh-ahead refetches it through the same
+	Source string
+	// Variants are other content-codings of the same URL, see withVariant
+	Variants []*CachedResponse
+}
+
+// Fetch retrieves the resource for a cache key directly from upstream, returning the response
+// to cache and the ttl to store it with (0 for the cache default). Used by refresh-ahead: every
+// encoding variant of the entry cached under key is refreshed and they are kept together.
+func (p *proxy) Fetch(key string) (*CachedResponse, int, error) {
+	_, rawURL := SplitPartitionKey(key)
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	if strings.HasPrefix(target.Fragment, "slice=") {
+		return nil, 0, fmt.Errorf("refresh of %s: slices aren't refreshed", key)
+	}
+
+	upstream, err := p.target(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	// refetched as a request for the cached URL, so upstream gets the Host and forwarding headers
+	// a miss would send. Keys hold the host, unless they ignore it and the upstream's is used
+	in, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if in.Host == "" {
+		in.Host = upstream.Host
+	}
+
+	// peek, so the refresh doesn't count as a use of the entry
+	existing, _, found, err := p.cache.GetWithExpiry(context.Background(), key)
+	if err != nil || !found {
+		existing = nil
+	}
+	codings := []string{""}
+	if existing != nil {
+		codings = codings[:0]
+		for _, r := range existing.representations() {
+			codings = append(codings, contentCoding(r.Header))
+		}
+	}
+
+	entry := existing
+	for _, coding := range codings {
+		cached, varies, err := p.fetchRepresentation(key, in, upstream, coding)
+		if err != nil {
+			return nil, 0, err
+		}
+		if varies {
+			entry = entry.withVariant(cached)
+		} else {
+			entry = cached
+		}
+	}
+	if ttl := p.lifetime(entry); ttl > 0 {
+		return entry, ttl + p.staleRetention, nil
+	}
+	return entry, 0, nil
+}
+
+// fetchRepresentation fetches the coding ("" for identity) representation of the resource in
+// requests from upstream, reporting whether upstream varies it on Accept-Encoding.
+func (p *proxy) fetchRepresentation(key string, in *http.Request, upstream *url.URL, coding string) (*CachedResponse, bool, error) {
+	in = in.Clone(in.Context())
+	if coding == "" {
+		in.Header.Set("Accept-Encoding", "identity")
+	} else {
+		in.Header.Set("Accept-Encoding", coding)
+	}
+	req, err := p.buildUpstreamRequest(in, upstream)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { resp.Body.Close() }() // closes the body modifyResponse may have swapped in
+
+	removeHopByHopHeaders(resp.Header)
+	if p.modifyResponse != nil {
+		if err := p.modifyResponse(resp); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if !p.cacheableResponse(resp) {
+		return nil, false, fmt.Errorf("refresh of %s returned uncacheable status %d", key, resp.StatusCode)
+	}
+
+	capture := newBodyCapture(p.maxCacheBodySize, p.memoryBudget)
+	buf := p.bufferPool.Get()
+	_, err = io.CopyBuffer(capture, resp.Body, buf)
+	p.bufferPool.Put(buf)
+	if err != nil {
+		capture.release()
+		return nil, false, err
+	}
+	body, ok := capture.Body()
+	if !ok {
+		return nil, false, fmt.Errorf("refresh of %s: body exceeds max cache body size or memory budget", key)
+	}
+
+	return &CachedResponse{
+		Status:   resp.StatusCode,
+		Header:   resp.Header,
+		Body:     body,
+		CachedAt: p.clock.Now(),
+		Source:   p.cacheSource,
+	}, variesOnEncoding(resp), nil
 }
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheDirectives parses the Cache-Control fields of header into lowercased directive names
+// mapped to their (unquoted) values, "" for directives without one.
+func cacheDirectives(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, field := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(field, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// freshnessLifetime returns how long (seconds) the upstream said a response stays fresh: max-age
+// or s-maxage, else Expires relative to Date as HTTP/1.0 origins send it (RFC 9111 4.2.1). 0 when
+// it said neither or the response is already stale at now.
+func freshnessLifetime(header http.Header, now time.Time) int {
+	if maxAge := parseMaxAge(header.Get("Cache-Control")); maxAge > 0 {
+		return maxAge
+	}
+	lifetime, _ := expiresLifetime(header, now)
+	return lifetime
+}
+
+// expiresLifetime returns the seconds between Date (or now) and Expires of a response without
+// max-age or s-maxage, ok false when it has no Expires. An invalid Expires, like "0", is in the
+// past.
+func expiresLifetime(header http.Header, now time.Time) (int, bool) {
+	expires := header.Get("Expires")
+	if expires == "" {
+		return 0, false
+	}
+	directives := cacheDirectives(header)
+	if _, ok := directives["max-age"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["s-maxage"]; ok {
+		return 0, false
+	}
+	at, err := http.ParseTime(expires)
+	if err != nil {
+		return 0, true
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	return max(int(at.Sub(date)/time.Second), 0), true
+}
+
+// lifetime returns the freshness lifetime (seconds) c is stored with: the upstream's, or the
+// proxy's cache TTL. 0 when the cache default TTL applies.
+func (p *proxy) lifetime(c *CachedResponse) int {
+	if ttl := freshnessLifetime(c.Header, c.CachedAt); ttl > 0 {
+		return ttl
+	}
+	return p.cacheTTL
+}
+
+// freshUntil returns when c stops being fresh, from the expiry the cache holds it until: entries
+// stored with their own lifetime are kept past it by the stale retention, the others are fresh
+// while found. Zero when it never goes stale.
+func (p *proxy) freshUntil(c *CachedResponse, expiresAt time.Time) time.Time {
+	if expiresAt.IsZero() || p.lifetime(c) == 0 {
+		return expiresAt
+	}
+	return expiresAt.Add(-time.Duration(p.staleRetention) * time.Second)
+}
+
+// expiry returns when the cache expires key's entry, zero when it doesn't or is gone.
+func (p *proxy) expiry(ctx context.Context, key string) time.Time {
+	_, expiresAt, _, _ := p.cache.GetWithExpiry(ctx, key)
+	return expiresAt
+}
+
+// satisfies reports whether the entry, fresh until freshUntil (zero for ever), may answer a
+// request with the given Cache-Control directives at now: max-age caps its age, min-fresh requires
+// freshness left, and stale entries are only served to max-stale requests, within its limit when
+// one is given (RFC 9111 5.2.1).
+func (c *CachedResponse) satisfies(directives map[string]string, freshUntil, now time.Time) bool {
+	if maxAge, ok := directiveDuration(directives, "max-age"); ok && now.Sub(c.CachedAt) > maxAge {
+		return false
+	}
+	if freshUntil.IsZero() {
+		return true
+	}
+	remaining := freshUntil.Sub(now)
+	if minFresh, ok := directiveDuration(directives, "min-fresh"); ok && remaining < minFresh {
+		return false
+	}
+	if remaining >= 0 {
+		return true
+	}
+	maxStale, ok := directives["max-stale"]
+	if !ok {
+		return false
+	}
+	if maxStale == "" {
+		return true
+	}
+	limit, ok := directiveDuration(directives, "max-stale")
+	return ok && -remaining <= limit
+}
+
+// directiveDuration parses a delta-seconds directive value.
+func directiveDuration(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
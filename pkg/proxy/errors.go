@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+)
+
+// StatusClientClosedRequest is the non-standard status (nginx's 499) recorded when the client
+// disconnected before the upstream answered.
+const StatusClientClosedRequest = 499
+
+// Unavailable is implemented by errors meaning an upstream can't take requests for a while, e.g.
+// a pool without backends. They are answered with 503 and a Retry-After so clients back off.
+type Unavailable interface {
+	error
+	// Code is a machine-readable reason, e.g. "no_backends"
+	Code() string
+	RetryAfter() time.Duration
+}
+
+// UpstreamErrorStatus maps a failed upstream exchange to a status: 499 when the client went away,
+// 503 for Unavailable errors, 504 for timeouts and 502 for everything else (refused connections,
+// DNS failures, resets, ...).
+func UpstreamErrorStatus(r *http.Request, err error) int {
+	if errors.Is(err, context.Canceled) && errors.Is(r.Context().Err(), context.Canceled) {
+		return StatusClientClosedRequest
+	}
+	var unavailable Unavailable
+	if errors.As(err, &unavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// defaultErrorHandler logs the failure and answers with UpstreamErrorStatus, nothing is written
+// to clients that already disconnected.
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := UpstreamErrorStatus(r, err)
+	switch status {
+	case StatusClientClosedRequest:
+		log.Printf("client closed request %s %s: %v", r.Method, r.URL.Path, err)
+		return
+	case http.StatusServiceUnavailable:
+		var unavailable Unavailable
+		errors.As(err, &unavailable)
+		log.Printf("upstream unavailable: %v", err)
+		errorpage.Unavailable(w, r, unavailable.Code(), unavailable.Error(), unavailable.RetryAfter())
+	case http.StatusGatewayTimeout:
+		log.Printf("upstream timeout: %v", err)
+		errorpage.Error(w, r, "upstream timeout", status)
+	default:
+		log.Printf("upstream request error: %v", err)
+		errorpage.Error(w, r, "upstream error", status)
+	}
+}
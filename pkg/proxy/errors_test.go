@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusBadGateway, UpstreamErrorStatus(req, errors.New("connection refused")))
+	assert.Equal(t, http.StatusGatewayTimeout, UpstreamErrorStatus(req, &url.Error{Op: "Get", Err: context.DeadlineExceeded}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, StatusClientClosedRequest, UpstreamErrorStatus(req.WithContext(ctx), &url.Error{Op: "Get", Err: context.Canceled}))
+}
+
+// Test a slow upstream past the proxy timeout is reported as 504
+func TestProxy_TimeoutIs504(t *testing.T) {
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}), WithTimeout(20*time.Millisecond))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
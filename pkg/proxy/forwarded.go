@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarding headers telling upstreams about the client, see WithForwardedHeaders.
+const (
+	// ForwardXForwarded sets the de facto X-Forwarded-For, -Host and -Proto
+	ForwardXForwarded = "x-forwarded"
+	// ForwardRFC7239 sets the standard Forwarded header
+	ForwardRFC7239 = "rfc7239"
+	// ForwardBoth sets both
+	ForwardBoth = "both"
+)
+
+// WithForwardedHeaders selects the headers telling upstreams the client address, host and
+// protocol: ForwardXForwarded (the default), ForwardRFC7239 or ForwardBoth. The headers not
+// selected are removed from the request so clients can't pass them on.
+func WithForwardedHeaders(headers string) ProxyOption {
+	return func(p *proxy) {
+		p.forwardedHeaders = headers
+	}
+}
+
+// WithTrustedProxies sets the networks of the proxies in front of this one. The forwarding headers
+// of requests coming from them are extended with this hop instead of replaced, so upstreams see
+// the whole chain. A malformed Forwarded header is replaced all the same.
+func WithTrustedProxies(networks []*net.IPNet) ProxyOption {
+	return func(p *proxy) {
+		p.trustedProxies = networks
+	}
+}
+
+// WithClientIP sets how the client IP sent upstream in X-Real-IP is derived from a request.
+// Defaults to the remote address.
+func WithClientIP(fn func(r *http.Request) string) ProxyOption {
+	return func(p *proxy) {
+		p.clientIP = fn
+	}
+}
+
+// setForwardedHeaders sets the forwarding headers and X-Real-IP of out, the upstream request for
+// req.
+func (p *proxy) setForwardedHeaders(out, req *http.Request) {
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		if req.RemoteAddr != "" { // empty for the proxy's own requests, like refresh-ahead
+			log.Printf("error splitting host port: %v", err)
+		}
+		client = ""
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	trusted := p.trustedPeer(client)
+
+	realIP := client
+	if p.clientIP != nil {
+		realIP = p.clientIP(req)
+	}
+	if realIP != "" {
+		out.Header.Set("X-Real-IP", realIP)
+	} else {
+		out.Header.Del("X-Real-IP")
+	}
+
+	if p.forwardedHeaders == ForwardRFC7239 {
+		for _, key := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+			out.Header.Del(key)
+		}
+	} else {
+		if !trusted || out.Header.Get("X-Forwarded-Host") == "" {
+			out.Header.Set("X-Forwarded-Host", req.Host)
+		}
+		if !trusted || out.Header.Get("X-Forwarded-Proto") == "" {
+			out.Header.Set("X-Forwarded-Proto", proto)
+		}
+		forwardedFor := client
+		if prior := out.Header.Values("X-Forwarded-For"); trusted && len(prior) > 0 && client != "" {
+			forwardedFor = strings.Join(prior, ", ") + ", " + client
+		}
+		if forwardedFor != "" {
+			out.Header.Set("X-Forwarded-For", forwardedFor)
+		} else {
+			out.Header.Del("X-Forwarded-For")
+		}
+	}
+
+	prior := out.Header.Values("Forwarded")
+	out.Header.Del("Forwarded")
+	if !trusted {
+		prior = nil
+	} else if _, err := parseForwarded(prior); err != nil {
+		log.Printf("dropping malformed Forwarded header from %s: %v", client, err)
+		prior = nil
+	}
+	if p.forwardedHeaders == ForwardRFC7239 || p.forwardedHeaders == ForwardBoth {
+		prior = append(prior, forwardedElement(client, req.Host, proto))
+	}
+	if len(prior) > 0 {
+		out.Header.Set("Forwarded", strings.Join(prior, ", "))
+	}
+}
+
+// trustedPeer tells whether ip, the address of the peer, is a trusted proxy.
+func (p *proxy) trustedPeer(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range p.trustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedElement is the Forwarded element of a hop from client, "unknown" when the address isn't
+// an IP (e.g. over a unix socket).
+func forwardedElement(client, host, proto string) string {
+	node := "unknown"
+	if ip := net.ParseIP(client); ip != nil {
+		node = ip.String()
+		if ip.To4() == nil {
+			node = `"[` + node + `]"`
+		}
+	}
+	pairs := []string{"for=" + node}
+	if host != "" {
+		pairs = append(pairs, "host="+forwardedValue(host))
+	}
+	pairs = append(pairs, "proto="+proto)
+	return strings.Join(pairs, ";")
+}
+
+// forwardedValue returns v as a token, or a quoted-string when it has other characters like the
+// colon of a port.
+func forwardedValue(v string) string {
+	for i := 0; i < len(v); i++ {
+		if !isTokenChar(v[i]) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// parseForwarded parses the values of Forwarded headers into their elements, the parameters of a
+// hop by lowercase name.
+func parseForwarded(values []string) ([]map[string]string, error) {
+	var elements []map[string]string
+	for _, value := range values {
+		element := map[string]string{}
+		s := value
+		for {
+			s = strings.TrimLeft(s, " \t")
+			i := 0
+			for i < len(s) && isTokenChar(s[i]) {
+				i++
+			}
+			if i == 0 || i == len(s) || s[i] != '=' {
+				return nil, fmt.Errorf("invalid pair in %q", value)
+			}
+			name := strings.ToLower(s[:i])
+			s = s[i+1:]
+
+			var val string
+			if strings.HasPrefix(s, `"`) {
+				var b strings.Builder
+				closed := false
+				for i = 1; i < len(s); i++ {
+					if s[i] == '\\' && i+1 < len(s) {
+						i++
+					} else if s[i] == '"' {
+						closed = true
+						break
+					}
+					b.WriteByte(s[i])
+				}
+				if !closed {
+					return nil, fmt.Errorf("unterminated quoted value in %q", value)
+				}
+				val, s = b.String(), s[i+1:]
+			} else {
+				i = 0
+				for i < len(s) && isTokenChar(s[i]) {
+					i++
+				}
+				if i == 0 {
+					return nil, fmt.Errorf("empty value in %q", value)
+				}
+				val, s = s[:i], s[i:]
+			}
+			if _, dup := element[name]; dup {
+				return nil, fmt.Errorf("duplicate %s in %q", name, value)
+			}
+			element[name] = val
+
+			s = strings.TrimLeft(s, " \t")
+			if s == "" {
+				elements = append(elements, element)
+				break
+			}
+			switch s[0] {
+			case ';':
+			case ',':
+				elements = append(elements, element)
+				element = map[string]string{}
+			default:
+				return nil, fmt.Errorf("unexpected %q in %q", s[0], value)
+			}
+			s = s[1:]
+		}
+	}
+	return elements, nil
+}
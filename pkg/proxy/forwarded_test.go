@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the forwarding headers selected are set, extended for trusted proxies and replaced otherwise
+func TestProxy_ForwardedHeaders(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, tc := range []struct {
+		name      string
+		headers   string
+		remote    string
+		incoming  http.Header
+		forwarded string
+		xff       string
+	}{
+		{
+			name:   "x-forwarded by default",
+			remote: "203.0.113.7:4711",
+			incoming: http.Header{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			xff: "203.0.113.7",
+		},
+		{
+			name:      "rfc7239 with ipv6 and a port in the host",
+			headers:   ForwardRFC7239,
+			remote:    "[2001:db8::1]:4711",
+			incoming:  http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			forwarded: `for="[2001:db8::1]";host="example.com:8080";proto=http`,
+		},
+		{
+			name:      "both extended from a trusted proxy",
+			headers:   ForwardBoth,
+			remote:    "10.0.0.2:4711",
+			incoming:  http.Header{"Forwarded": {"for=198.51.100.1;proto=https"}, "X-Forwarded-For": {"198.51.100.1"}},
+			forwarded: `for=198.51.100.1;proto=https, for=10.0.0.2;host="example.com:8080";proto=http`,
+			xff:       "198.51.100.1, 10.0.0.2",
+		},
+		{
+			name:      "malformed from a trusted proxy",
+			headers:   ForwardRFC7239,
+			remote:    "10.0.0.2:4711",
+			incoming:  http.Header{"Forwarded": {"for=198.51.100.1;;"}},
+			forwarded: `for=10.0.0.2;host="example.com:8080";proto=http`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got http.Header
+			p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}), WithForwardedHeaders(tc.headers), WithTrustedProxies([]*net.IPNet{trusted}))
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com:8080/", nil)
+			req.RemoteAddr = tc.remote
+			for key, values := range tc.incoming {
+				req.Header[key] = values
+			}
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.forwarded, got.Get("Forwarded"))
+			assert.Equal(t, tc.xff, got.Get("X-Forwarded-For"))
+			client, _, _ := net.SplitHostPort(tc.remote)
+			assert.Equal(t, client, got.Get("X-Real-IP"))
+		})
+	}
+}
+
+// Test X-Real-IP carries the client IP resolved by WithClientIP, whatever the client sent
+func TestProxy_RealIP(t *testing.T) {
+	var got string
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Real-IP")
+	}), WithClientIP(func(r *http.Request) string { return "198.51.100.1" }))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-IP", "6.6.6.6")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "198.51.100.1", got)
+}
+
+func TestParseForwarded(t *testing.T) {
+	elements, err := parseForwarded([]string{`for=192.0.2.60;Proto=http;by=203.0.113.43`, `for="[2001:db8:cafe::17]:4711", for=unknown`})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"for": "192.0.2.60", "proto": "http", "by": "203.0.113.43"},
+		{"for": "[2001:db8:cafe::17]:4711"},
+		{"for": "unknown"},
+	}, elements)
+
+	for _, bad := range []string{"for", "for=", `for="unterminated`, "for=a;for=b", "for=a,", "for=a b"} {
+		_, err := parseForwarded([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type overrideKey struct{}
+
+// overrideClient sends overridden requests. The upstream's own client may be bound to a unix
+// socket or carry upstream-specific TLS settings that don't fit an arbitrary target.
+var overrideClient = noRedirects(&http.Client{Timeout: 30 * time.Second, Transport: http.DefaultTransport})
+
+// NewOverrideContext returns ctx sending the request to target instead of the proxy's upstream,
+// bypassing the cache. Used by middleware.UpstreamOverride for debugging against live traffic.
+func NewOverrideContext(ctx context.Context, target *url.URL) context.Context {
+	return context.WithValue(ctx, overrideKey{}, target)
+}
+
+// overrideFromContext returns the target set by NewOverrideContext, nil when none.
+func overrideFromContext(ctx context.Context) *url.URL {
+	target, _ := ctx.Value(overrideKey{}).(*url.URL)
+	return target
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Phases are the durations of the phases of an upstream exchange. DNS, Connect and TLS are zero
+// when they didn't happen: on a reused connection, for an IP address upstream or over plain HTTP.
+type Phases struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is from the request written to the first response byte, the upstream's think time
+	TTFB time.Duration
+	// Transfer is from the first response byte to the end of the body
+	Transfer time.Duration
+	// Reused tells the request went over an idle connection
+	Reused bool
+}
+
+// PhaseObserver is an Observer also told the phases of each upstream exchange, once its response
+// body has been copied. Requests are only traced when an observer implements it.
+type PhaseObserver interface {
+	UpstreamPhases(r *http.Request, upstream *url.URL, phases Phases)
+}
+
+// phaseTrace collects the phase timestamps of one request. httptrace hooks may run on the dialing
+// goroutines, hence the lock.
+type phaseTrace struct {
+	mu                     sync.Mutex
+	dnsStart, dnsDone      time.Time
+	connectStart, connDone time.Time
+	tlsStart, tlsDone      time.Time
+	wroteRequest           time.Time
+	firstByte              time.Time
+	reused                 bool
+}
+
+// withPhaseTrace returns req traced into a new phaseTrace.
+func withPhaseTrace(req *http.Request) (*http.Request, *phaseTrace) {
+	t := &phaseTrace{}
+	set := func(field *time.Time, keepFirst bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !keepFirst || field.IsZero() {
+			*field = time.Now()
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { set(&t.dnsStart, true) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { set(&t.dnsDone, false) },
+		// several addresses may be dialed, the phase spans the first start to the last done
+		ConnectStart:         func(string, string) { set(&t.connectStart, true) },
+		ConnectDone:          func(string, string, error) { set(&t.connDone, false) },
+		TLSHandshakeStart:    func() { set(&t.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&t.tlsDone, false) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&t.wroteRequest, false) },
+		GotFirstResponseByte: func() { set(&t.firstByte, true) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.reused = info.Reused
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+// phases returns the phase durations, the transfer ending at end.
+func (t *phaseTrace) phases(end time.Time) Phases {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	between := func(start, done time.Time) time.Duration {
+		if start.IsZero() || done.IsZero() || done.Before(start) {
+			return 0
+		}
+		return done.Sub(start)
+	}
+	return Phases{
+		DNS:      between(t.dnsStart, t.dnsDone),
+		Connect:  between(t.connectStart, t.connDone),
+		TLS:      between(t.tlsStart, t.tlsDone),
+		TTFB:     between(t.wroteRequest, t.firstByte),
+		Transfer: between(t.firstByte, end),
+		Reused:   t.reused,
+	}
+}
@@ -1,7 +1,9 @@
 package proxy
 
 import (
-	"io"
+	"bytes"
+	"context"
+	"errors"
 	"log"
 	"net"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/errorpage"
 	"github.com/ashpect/revproxy/pkg/utils"
 )
 
@@ -18,9 +21,73 @@ type proxy struct {
 	upstream             *url.URL
 	client               *http.Client
 	preserveOriginalHost bool
-	cache                cache.Cache[string, *CachedResponse]
+	cache                cache.CacheCtx[string, *CachedResponse]
+	cacheTTL             int           // seconds, used when upstream sends no max-age; 0 means cache default
+	timeout              time.Duration // bounds the whole upstream exchange, 0 means client timeout only
+	flushInterval        time.Duration // 0 flushes when the copy buffer fills, negative after every write
+	maxCacheBodySize     int64         // bodies larger than this are streamed but not cached, 0 means no cap
+	bufferPool           BufferPool
+	errorHandler         func(http.ResponseWriter, *http.Request, error)
+	modifyResponse       func(*http.Response) error
+	rewriteRequest       func(*http.Request)
+	memoryBudget         *MemoryBudget
+	balancer             Balancer
+	staleRetention       int // seconds entries are kept past expiry for max-stale requests
+	cacheLockTimeout     time.Duration
+	cacheTempRedirects   bool
+	cachePartition       func(r *http.Request) string
+	cacheSource          string
+	cacheKeyHost         bool  // keys include the request host, so virtual hosts don't share entries
+	sliceSize            int64 // bytes, 0 proxies range requests uncached
+	observers            []Observer
+	phaseObservers       []PhaseObserver
+	servedObservers      []ServedObserver
+	forwardedHeaders     string
+	trustedProxies       []*net.IPNet
+	clientIP             func(r *http.Request) string
+	normalizeURL         bool
+	clock                cache.Clock // freshness and Age, timings use the wall clock
 }
 
+// Balancer picks the upstream for each request among several backends.
+type Balancer interface {
+	Next(r *http.Request) (*url.URL, error)
+}
+
+// Observer is told how requests were served, e.g. to record metrics. Its methods are called
+// concurrently.
+type Observer interface {
+	// CacheLookup reports the outcome of looking up a cacheable request
+	CacheLookup(r *http.Request, result CacheResult)
+	// UpstreamExchange reports an upstream request once its response headers arrived, or err
+	// when none came back. elapsed is the time to the response headers.
+	UpstreamExchange(r *http.Request, upstream *url.URL, status int, err error, elapsed time.Duration)
+}
+
+// CacheResult is the outcome of a cache lookup.
+type CacheResult string
+
+const (
+	CacheHit CacheResult = "hit"
+	// CacheStale is a hit on an expired entry the request accepted with max-stale
+	CacheStale CacheResult = "stale"
+	CacheMiss  CacheResult = "miss"
+)
+
+// ServedObserver is an Observer also told the body bytes of each response, once written, and
+// whether they came from the cache or upstream. Ranges answered from cached slices aren't reported.
+type ServedObserver interface {
+	ResponseServed(r *http.Request, source ServedSource, bytes int64)
+}
+
+// ServedSource is where the body of a response came from.
+type ServedSource string
+
+const (
+	ServedFromCache    ServedSource = "cache"
+	ServedFromUpstream ServedSource = "upstream"
+)
+
 type ProxyOption func(*proxy)
 
 func WithPreserveOriginalHost(preserve bool) ProxyOption {
@@ -35,9 +102,179 @@ func WithClient(client *http.Client) ProxyOption {
 	}
 }
 
-func WithCache(cache cache.Cache[string, *CachedResponse]) ProxyOption {
+// WithCache uses an in-memory cache for GET responses.
+func WithCache(c cache.Cache[string, *CachedResponse]) ProxyOption {
 	return func(p *proxy) {
-		p.cache = cache
+		p.cache = cache.WithContext(c)
+	}
+}
+
+// WithCacheCtx uses a context-aware (possibly remote) cache for GET responses.
+func WithCacheCtx(c cache.CacheCtx[string, *CachedResponse]) ProxyOption {
+	return func(p *proxy) {
+		p.cache = c
+	}
+}
+
+// WithCacheTTL overrides the cache default TTL (seconds) for responses without max-age.
+func WithCacheTTL(ttlSeconds int) ProxyOption {
+	return func(p *proxy) {
+		p.cacheTTL = ttlSeconds
+	}
+}
+
+// WithStaleRetention keeps cached entries seconds past their expiry, served only to requests
+// accepting stale responses with Cache-Control: max-stale.
+func WithStaleRetention(seconds int) ProxyOption {
+	return func(p *proxy) {
+		p.staleRetention = seconds
+	}
+}
+
+// WithCacheLock makes concurrent misses on a key wait up to timeout for the first request to
+// fill the cache instead of all going upstream. After the timeout they go upstream anyway.
+func WithCacheLock(timeout time.Duration) ProxyOption {
+	return func(p *proxy) {
+		p.cacheLockTimeout = timeout
+	}
+}
+
+// WithCacheTemporaryRedirects also caches 302 and 307 responses, only when they carry an
+// explicit max-age. 301 and 308 are always cacheable.
+func WithCacheTemporaryRedirects(enabled bool) ProxyOption {
+	return func(p *proxy) {
+		p.cacheTempRedirects = enabled
+	}
+}
+
+// WithCachePartition prefixes cache keys with the partition partition(r) names, e.g. the host,
+// see PartitionKey.
+func WithCachePartition(partition func(r *http.Request) string) ProxyOption {
+	return func(p *proxy) {
+		p.cachePartition = partition
+	}
+}
+
+// WithCacheSource names the proxy on the responses it caches, see CachedResponse.Source.
+func WithCacheSource(name string) ProxyOption {
+	return func(p *proxy) {
+		p.cacheSource = name
+	}
+}
+
+// WithCacheKeyHost(false) keys cached responses by URL alone, sharing them between every host the
+// proxy serves. Only safe when all hosts serve the same content; by default keys include the host.
+func WithCacheKeyHost(enabled bool) ProxyOption {
+	return func(p *proxy) {
+		p.cacheKeyHost = enabled
+	}
+}
+
+// WithSliceSize caches range requests as aligned slices of size bytes fetched with their own
+// Range requests, so seeking in large files hits the cache. 0 proxies range requests as is.
+func WithSliceSize(size int64) ProxyOption {
+	return func(p *proxy) {
+		p.sliceSize = size
+	}
+}
+
+// WithTimeout bounds each upstream exchange, on top of the client timeout.
+func WithTimeout(timeout time.Duration) ProxyOption {
+	return func(p *proxy) {
+		p.timeout = timeout
+	}
+}
+
+// WithFlushInterval flushes streamed response bodies to the client at least this often,
+// negative flushes after every write.
+func WithFlushInterval(interval time.Duration) ProxyOption {
+	return func(p *proxy) {
+		p.flushInterval = interval
+	}
+}
+
+// WithMaxCacheBodySize skips caching responses whose body is larger than size bytes.
+func WithMaxCacheBodySize(size int64) ProxyOption {
+	return func(p *proxy) {
+		p.maxCacheBodySize = size
+	}
+}
+
+// WithBufferPool copies bodies with buffers from pool instead of the shared default pool.
+func WithBufferPool(pool BufferPool) ProxyOption {
+	return func(p *proxy) {
+		p.bufferPool = pool
+	}
+}
+
+// WithErrorHandler replaces the default handling of failed upstream exchanges (log and 502),
+// e.g. to answer in JSON, record metrics or serve a fallback.
+func WithErrorHandler(handler func(w http.ResponseWriter, r *http.Request, err error)) ProxyOption {
+	return func(p *proxy) {
+		p.errorHandler = handler
+	}
+}
+
+// WithModifyResponse edits upstream responses before they are cached and written, like
+// httputil.ReverseProxy.ModifyResponse. An error is passed to the error handler instead.
+func WithModifyResponse(modify func(*http.Response) error) ProxyOption {
+	return func(p *proxy) {
+		p.modifyResponse = modify
+	}
+}
+
+// WithRewriteRequest adjusts each outgoing upstream request once it has been built (extra
+// headers, alternate paths), like httputil.ReverseProxy.Director.
+func WithRewriteRequest(rewrite func(*http.Request)) ProxyOption {
+	return func(p *proxy) {
+		p.rewriteRequest = rewrite
+	}
+}
+
+// WithMemoryBudget accounts bodies buffered for caching against budget, shared between proxies.
+// Once it is spent further responses are streamed without being cached.
+func WithMemoryBudget(budget *MemoryBudget) ProxyOption {
+	return func(p *proxy) {
+		p.memoryBudget = budget
+	}
+}
+
+// WithNormalizeURL re-encodes the path and query of upstream requests instead of forwarding them
+// as the client escaped them, e.g. an encoded slash is sent as a slash, see normalizeURL.
+func WithNormalizeURL(normalize bool) ProxyOption {
+	return func(p *proxy) {
+		p.normalizeURL = normalize
+	}
+}
+
+// WithClock sets the clock entries are stamped, aged and expired with, cache.SystemClock by
+// default. Pair it with the cache's cache.WithClock to control time in tests.
+func WithClock(clock cache.Clock) ProxyOption {
+	return func(p *proxy) {
+		if clock != nil {
+			p.clock = clock
+		}
+	}
+}
+
+// WithObserver reports cache lookups and upstream exchanges to o, after the observers added before
+// it.
+func WithObserver(o Observer) ProxyOption {
+	return func(p *proxy) {
+		p.observers = append(p.observers, o)
+		if po, ok := o.(PhaseObserver); ok {
+			p.phaseObservers = append(p.phaseObservers, po)
+		}
+		if so, ok := o.(ServedObserver); ok {
+			p.servedObservers = append(p.servedObservers, so)
+		}
+	}
+}
+
+// WithBalancer sends each request to the backend b picks instead of the fixed upstream.
+func WithBalancer(b Balancer) ProxyOption {
+	return func(p *proxy) {
+		p.balancer = b
 	}
 }
 
@@ -47,57 +284,196 @@ func NewProxy(upstream *url.URL, client *http.Client, opts ...ProxyOption) *prox
 		client:               client,
 		preserveOriginalHost: false,
 		cache:                nil,
+		bufferPool:           defaultBufferPool,
+		errorHandler:         defaultErrorHandler,
+		clock:                cache.SystemClock{},
+		cacheKeyHost:         true,
 	}
 
 	for _, opt := range opts {
 		opt(p)
 	}
+	p.client = noRedirects(p.client)
 
 	return p
 }
 
+// noRedirects returns a copy of client handing redirects back instead of following them, they are
+// the client's to follow (and the proxy's to cache).
+func noRedirects(client *http.Client) *http.Client {
+	if client == nil {
+		return nil
+	}
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c
+}
+
 func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Only cache GET requests
-	isCacheable := r.Method == http.MethodGet
+	// Upgrades (e.g. WebSocket) become tunnels that outlive the timeout and never touch the cache
+	upgrade := requestUpgrade(r)
+	if p.timeout > 0 && upgrade == "" {
+		ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// Only cache GET requests, HEAD is answered from them. Overridden ones never touch the cache
+	override := overrideFromContext(r.Context())
+	isCacheable := r.Method == http.MethodGet && override == nil && upgrade == ""
+	isHead := r.Method == http.MethodHead && override == nil && upgrade == ""
 	uniqueKey := p.getUniqueReqKey(r)
 
-	if isCacheable && p.cache != nil {
-		utils.Debug("Checking cache for key: %s", uniqueKey)
-		cachedResp, ok := p.cache.Get(uniqueKey)
-		if ok {
-			utils.Debug("Cache hit for key: %s", uniqueKey)
-			utils.Debug("Serving cached response for key: %s", uniqueKey)
-			p.serveCachedResponse(w, cachedResp)
-			utils.Debug("Cached response served for key: %s", uniqueKey)
+	// only-if-cached (RFC 9111 5.2.1.7) asks for a stored response or a 504, never upstream
+	directives := cacheDirectives(r.Header)
+	_, onlyIfCached := directives["only-if-cached"]
+	ranged := r.Header.Get("Range") != ""
+
+	if (isCacheable || isHead) && p.cache != nil {
+		// with the cache lock, concurrent misses on a key share one fill rather than all fetching
+		// it, range requests don't fill it
+		if isCacheable && !ranged && p.cacheLockTimeout > 0 && !onlyIfCached {
+			if p.serveOrFill(w, r, uniqueKey, directives) {
+				return
+			}
+		} else if p.serveFromCache(w, r, uniqueKey, directives) {
 			return
+		} else if isCacheable && ranged && p.sliceSize > 0 && !onlyIfCached && p.serveSliced(w, r, uniqueKey, directives) {
+			return
+		}
+	}
+	if onlyIfCached {
+		errorpage.Error(w, r, "not cached", http.StatusGatewayTimeout)
+		return
+	}
+
+	cachedResp, ttl := p.forward(w, r, uniqueKey, override, upgrade, isCacheable)
+	if cachedResp == nil {
+		return
+	}
+	var err error
+	if ttl > 0 {
+		err = p.cache.SetWithTTL(r.Context(), uniqueKey, cachedResp, ttl)
+	} else {
+		err = p.cache.Set(r.Context(), uniqueKey, cachedResp) // use default TTL
+	}
+	if err != nil {
+		log.Printf("cache set error for key %s: %v", uniqueKey, err)
+	} else {
+		utils.Debug("Cached response stored for key: %s", uniqueKey)
+	}
+}
+
+// errNotFilled is the loader error of a fill whose response wasn't stored, e.g. uncacheable.
+var errNotFilled = errors.New("response not cached")
+
+// serveOrFill answers r from the cache, or on a miss has the first request fetch the key for every
+// concurrent miss: it streams its response while filling the cache, the others wait up to the
+// lock timeout and are served what it stored. False when r still has to go upstream itself: the
+// cached response doesn't suit it, the fill stored nothing or the wait timed out.
+func (p *proxy) serveOrFill(w http.ResponseWriter, r *http.Request, key string, directives map[string]string) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), p.cacheLockTimeout)
+	defer cancel()
+	filler := false
+	cachedResp, err := p.cache.GetOrLoad(ctx, key, func() (*CachedResponse, int, error) {
+		filler = true
+		cachedResp, ttl := p.forward(w, r, key, nil, "", true)
+		if cachedResp == nil {
+			return nil, -1, errNotFilled
 		}
+		return cachedResp, ttl, nil
+	})
+	if filler {
+		return true
 	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			utils.Debug("Cache lock wait timed out for %s", r.URL)
+		}
+		return false
+	}
+	return p.serveCached(w, r, key, cachedResp, directives)
+}
+
+// forward proxies r to upstream (the override when set) and streams the response back. It returns
+// the response to cache under key with the ttl to store it with (0 for the cache default), nil when
+// it isn't cacheable.
+func (p *proxy) forward(w http.ResponseWriter, r *http.Request, uniqueKey string, override *url.URL, upgrade string, isCacheable bool) (*CachedResponse, int) {
 	utils.Debug("Cache miss for key: %s", uniqueKey)
-	outReq, err := p.buildUpstreamRequest(r)
+	if isCacheable && p.cache != nil {
+		for _, o := range p.observers {
+			o.CacheLookup(r, CacheMiss)
+		}
+	}
+	upstream, upstreamClient := override, overrideClient
+	if upstream == nil {
+		var err error
+		if upstream, err = p.target(r); err != nil {
+			p.errorHandler(w, r, err)
+			return nil, 0
+		}
+		upstreamClient = p.client
+	}
+	outReq, err := p.buildUpstreamRequest(r, upstream)
 	if err != nil {
-		http.Error(w, "bad upstream request", http.StatusInternalServerError)
+		errorpage.Error(w, r, "bad upstream request", http.StatusInternalServerError)
 		log.Printf("build upstream request error: %v", err)
-		return
+		return nil, 0
 	}
 
-	resp, err := p.client.Do(outReq)
-
-	// TODO : Better error handling
+	var trace *phaseTrace
+	if len(p.phaseObservers) > 0 {
+		outReq, trace = withPhaseTrace(outReq)
+	}
+	start := time.Now()
+	var resp *http.Response
+	if upgrade != "" {
+		resp, err = roundTripper(upstreamClient).RoundTrip(outReq)
+	} else {
+		resp, err = upstreamClient.Do(outReq)
+	}
+	if len(p.observers) > 0 {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		elapsed := time.Since(start)
+		for _, o := range p.observers {
+			o.UpstreamExchange(r, upstream, status, err, elapsed)
+		}
+	}
 	if err != nil {
-		http.Error(w, "upstream error", http.StatusBadGateway)
-		log.Printf("upstream request error: %v", err)
-		return
+		p.errorHandler(w, r, err)
+		return nil, 0
+	}
+	defer func() { resp.Body.Close() }() // closes the body modifyResponse may have swapped in
+	var copied time.Time
+	if trace != nil {
+		defer func() {
+			if copied.IsZero() {
+				copied = time.Now()
+			}
+			phases := trace.phases(copied)
+			for _, o := range p.phaseObservers {
+				o.UpstreamPhases(r, upstream, phases)
+			}
+		}()
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.serveUpgrade(w, r, resp)
+		return nil, 0
 	}
-	defer resp.Body.Close()
 
 	removeHopByHopHeaders(resp.Header)
 
-	// Read the response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("error reading response body: %v", err)
-		http.Error(w, "error reading response", http.StatusInternalServerError)
-		return
+	if p.modifyResponse != nil {
+		if err := p.modifyResponse(resp); err != nil {
+			p.errorHandler(w, r, err)
+			return nil, 0
+		}
 	}
 
 	// Copy headers to response writer
@@ -107,47 +483,181 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Create cached response and store in cache if cache is available and request is GET
-	ttl := parseMaxAge(resp.Header.Get("Cache-Control"))
-	if isCacheable && p.cache != nil {
-		cachedResp := &CachedResponse{
-			Status:   resp.StatusCode,
-			Header:   resp.Header.Clone(),
-			Body:     bodyBytes,
-			CachedAt: time.Now(),
-		}
-		utils.Debug("Caching response for key: %s with ttl: %d", uniqueKey, ttl)
-		if ttl > 0 {
-			p.cache.SetWithTTL(uniqueKey, cachedResp, ttl)
+	// Tee the body into the cache only when the response can be cached at all
+	var capture *bodyCapture
+	if isCacheable && p.cache != nil && p.cacheableResponse(resp) {
+		capture = newBodyCapture(p.maxCacheBodySize, p.memoryBudget)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	written, err := p.copyResponse(w, resp, capture)
+	copied = time.Now()
+	p.served(r, ServedFromUpstream, written)
+	if err != nil {
+		// a client disconnect cancels the request context, which aborts the upstream body read
+		if r.Context().Err() != nil {
+			log.Printf("client closed request %s %s during response body: %v", r.Method, r.URL.Path, err)
 		} else {
-			p.cache.Set(uniqueKey, cachedResp) // use default TTL
+			log.Printf("error copying response body: %v", err)
 		}
-		utils.Debug("Cached response stored for key: %s", uniqueKey)
+		if capture != nil {
+			capture.release()
+		}
+		return nil, 0
 	}
 
-	done := make(chan bool)
-	go func() {
-		select {
-		case <-time.Tick(10 * time.Millisecond):
-			w.(http.Flusher).Flush()
-		case <-done:
-			return
+	if capture == nil {
+		return nil, 0
+	}
+	body, ok := capture.Body()
+	if !ok {
+		utils.Debug("Response for key %s exceeds max cache body size or memory budget, not caching", uniqueKey)
+		return nil, 0
+	}
+	cachedResp := &CachedResponse{
+		Status:   resp.StatusCode,
+		Header:   resp.Header.Clone(),
+		Body:     body,
+		CachedAt: p.clock.Now(),
+		Source:   p.cacheSource,
+	}
+	ttl := p.lifetime(cachedResp)
+	utils.Debug("Caching response for key: %s with ttl: %d", uniqueKey, ttl)
+	// other encodings already cached are kept alongside this one, peeked so the merge isn't a hit
+	if variesOnEncoding(resp) {
+		existing, _, found, err := p.cache.GetWithExpiry(r.Context(), uniqueKey)
+		if err != nil || !found {
+			existing = nil
 		}
-	}()
-	w.WriteHeader(resp.StatusCode)
+		cachedResp = existing.withVariant(cachedResp)
+	}
+	if ttl > 0 {
+		return cachedResp, ttl + p.staleRetention
+	}
+	return cachedResp, 0
+}
+
+// serveFromCache writes the cached response for key if there is one the request accepts.
+func (p *proxy) serveFromCache(w http.ResponseWriter, r *http.Request, key string, directives map[string]string) bool {
+	utils.Debug("Checking cache for key: %s", key)
+	cachedResp, ok, err := p.cache.Get(r.Context(), key)
+	if err != nil {
+		log.Printf("cache get error for key %s: %v", key, err)
+	}
+	if !ok {
+		return false
+	}
+	return p.serveCached(w, r, key, cachedResp, directives)
+}
+
+// serveCached writes cachedResp, the entry stored for key, if it has a representation the request
+// accepts. Its freshness comes from the entry's expiry in the cache.
+func (p *proxy) serveCached(w http.ResponseWriter, r *http.Request, key string, cachedResp *CachedResponse, directives map[string]string) bool {
+	if cachedResp = cachedResp.variant(r.Header.Get("Accept-Encoding")); cachedResp == nil {
+		return false
+	}
+	now := p.clock.Now()
+	freshUntil := p.freshUntil(cachedResp, p.expiry(r.Context(), key))
+	if !cachedResp.satisfies(directives, freshUntil, now) {
+		utils.Debug("Cached response for key %s too old for the request's cache directives", key)
+		return false
+	}
+	utils.Debug("Cache hit for key: %s", key)
+	if len(p.observers) > 0 {
+		result := CacheHit
+		if !freshUntil.IsZero() && now.After(freshUntil) {
+			result = CacheStale
+		}
+		for _, o := range p.observers {
+			o.CacheLookup(r, result)
+		}
+	}
+	p.served(r, ServedFromCache, p.serveCachedResponse(w, r, cachedResp))
+	return true
+}
+
+func (p *proxy) served(r *http.Request, source ServedSource, bytes int64) {
+	for _, o := range p.servedObservers {
+		o.ResponseServed(r, source, bytes)
+	}
+}
+
+// cacheableResponse reports whether a GET response may be stored: a 200 or permanent redirect
+// (temporary ones with max-age when enabled) the upstream didn't mark no-store or private, and not
+// already known to exceed the body size cap.
+func (p *proxy) cacheableResponse(resp *http.Response) bool {
+	if noStore(resp.Header, p.clock.Now()) {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	case http.StatusFound, http.StatusTemporaryRedirect:
+		if !p.cacheTempRedirects || freshnessLifetime(resp.Header, p.clock.Now()) == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	return p.maxCacheBodySize <= 0 || resp.ContentLength <= p.maxCacheBodySize
+}
 
-	// Write the body to response writer
-	if _, err := w.Write(bodyBytes); err != nil {
-		log.Printf("error writing response body: %v", err)
+// noStore reports whether the upstream marked a response no-store, private or no-cache, which
+// would need revalidation on every use, or expired it already with a past Expires. HTTP/1.0
+// upstreams' Pragma: no-cache arrives as Cache-Control: no-cache from the transport.
+func noStore(header http.Header, now time.Time) bool {
+	if lifetime, ok := expiresLifetime(header, now); ok && lifetime == 0 {
+		return true
+	}
+	directives := cacheDirectives(header)
+	for _, name := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[name]; ok {
+			return true
+		}
 	}
-	close(done)
+	return false
 }
 
+// getUniqueReqKey returns the cache key of r: its URL, scheme-relative with the host (e.g.
+// //example.com/page?q=1) unless keys were made host-less, in its partition if any.
 func (p *proxy) getUniqueReqKey(r *http.Request) string {
-	return r.URL.String()
+	rawURL := r.URL.String()
+	if host := RequestHost(r); p.cacheKeyHost && host != "" {
+		rawURL = "//" + host + rawURL
+	}
+	if p.cachePartition != nil {
+		return PartitionKey(p.cachePartition(r), rawURL)
+	}
+	return rawURL
+}
+
+// RequestHost returns the lower-cased host of r, without port, as cache keys and host
+// partitions hold it.
+func RequestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// PartitionKey builds the cache key of rawURL in partition. The space can't occur in an escaped
+// URL, so SplitPartitionKey can take it apart again.
+func PartitionKey(partition, rawURL string) string {
+	return partition + " " + rawURL
+}
+
+// SplitPartitionKey returns the partition and URL of a cache key, "" partition for keys built
+// without one.
+func SplitPartitionKey(key string) (partition, rawURL string) {
+	if partition, rawURL, ok := strings.Cut(key, " "); ok {
+		return partition, rawURL
+	}
+	return "", key
 }
 
-func (p *proxy) serveCachedResponse(w http.ResponseWriter, cachedResp *CachedResponse) {
+// serveCachedResponse writes cachedResp, headers only for HEAD requests, and returns the body bytes
+// written.
+func (p *proxy) serveCachedResponse(w http.ResponseWriter, r *http.Request, cachedResp *CachedResponse) int64 {
 	// Copy headers to response writer
 	for key, values := range cachedResp.Header {
 		for _, value := range values {
@@ -155,25 +665,53 @@ func (p *proxy) serveCachedResponse(w http.ResponseWriter, cachedResp *CachedRes
 		}
 	}
 
+	w.Header().Set("Age", strconv.Itoa(int(p.clock.Now().Sub(cachedResp.CachedAt)/time.Second)))
+	// ranges are cut from the stored body rather than answered with all of it
+	if r.Header.Get("Range") != "" && cachedResp.Status == http.StatusOK {
+		w.Header().Del("Content-Length")
+		cw := &countingWriter{ResponseWriter: w}
+		http.ServeContent(cw, r, "", time.Time{}, bytes.NewReader(cachedResp.Body))
+		return cw.n
+	}
+	// the stored body is complete, so HEAD gets the length the GET would have
+	w.Header().Set("Content-Length", strconv.Itoa(len(cachedResp.Body)))
+
 	// Set status code and write body
 	w.WriteHeader(cachedResp.Status)
-	if _, err := w.Write(cachedResp.Body); err != nil {
+	if r.Method == http.MethodHead {
+		return 0
+	}
+	n, err := w.Write(cachedResp.Body)
+	if err != nil {
 		log.Printf("error writing cached response body: %v", err)
 	}
+	return int64(n)
 }
 
-func (p *proxy) buildUpstreamRequest(req *http.Request) (*http.Request, error) {
+// target returns the upstream for r, picked by the balancer when one is set.
+func (p *proxy) target(r *http.Request) (*url.URL, error) {
+	if p.balancer != nil {
+		return p.balancer.Next(r)
+	}
+	return p.upstream, nil
+}
+
+func (p *proxy) buildUpstreamRequest(req *http.Request, upstream *url.URL) (*http.Request, error) {
 	// TESTING
 	utils.PrintRequest(req, "Initial request")
 
-	// Clone keeps method, headers, body, context, etc.
+	// Clone keeps method, headers, body, context, etc. Sharing the client's context means a
+	// disconnect cancels the upstream exchange instead of finishing doomed work.
 	ctx := req.Context()
 	outReq := req.Clone(ctx)
 
 	// Rewrite URL to point to upstream
-	outReq.URL.Scheme = p.upstream.Scheme
-	outReq.URL.Host = p.upstream.Host
-	outReq.URL.Path = singleJoiningSlash(p.upstream.Path, req.URL.Path)
+	outReq.URL.Scheme = upstream.Scheme
+	outReq.URL.Host = upstream.Host
+	outReq.URL.Path, outReq.URL.RawPath = joinURLPath(upstream, req.URL)
+	if p.normalizeURL {
+		normalizeURL(outReq.URL)
+	}
 
 	// Required for http.Client.Do
 	outReq.RequestURI = ""
@@ -182,28 +720,24 @@ func (p *proxy) buildUpstreamRequest(req *http.Request) (*http.Request, error) {
 	if p.preserveOriginalHost {
 		outReq.Host = req.Host
 	} else {
-		outReq.Host = p.upstream.Host
+		outReq.Host = upstream.Host
 	}
 
 	removeHopByHopHeaders(outReq.Header)
-
-	// Add new header
-	outReq.Header.Set("X-Forwarded-Host", req.Host)
-	proto := "http"
-	if req.TLS != nil {
-		proto = "https"
+	// an upgrade handshake keeps the hop-by-hop headers asking for it, see serveUpgrade
+	if upgrade := requestUpgrade(req); upgrade != "" {
+		outReq.Header.Set("Connection", "Upgrade")
+		outReq.Header.Set("Upgrade", upgrade)
 	}
-	outReq.Header.Set("X-Forwarded-Proto", proto)
 
-	s, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		log.Printf("error splitting host port: %v", err)
-	} else {
-		outReq.Header.Set("X-Forwarded-For", s)
+	p.setForwardedHeaders(outReq, req)
+
+	if p.rewriteRequest != nil {
+		p.rewriteRequest(outReq)
 	}
 
 	// TESTING
-	utils.PrintRequestWithMetadata(outReq, "Final request", p.upstream, p.preserveOriginalHost)
+	utils.PrintRequestWithMetadata(outReq, "Final request", upstream, p.preserveOriginalHost)
 
 	return outReq, nil
 }
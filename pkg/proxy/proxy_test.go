@@ -0,0 +1,985 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(t *testing.T) cache.Cache[string, *CachedResponse] {
+	t.Helper()
+	c, err := cache.NewLRUTTL(cache.WithLazyExpiration[string, *CachedResponse](cache.DefaultSweepPerOp))
+	if err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	return c
+}
+
+func newTestProxy(t *testing.T, upstream http.Handler, opts ...ProxyOption) http.Handler {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	return NewProxy(u, server.Client(), opts...)
+}
+
+// Test bodies are cached when small enough and only streamed when over the cap
+func TestProxy_CacheBodySizeCap(t *testing.T) {
+	c := newTestCache(t)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", len(r.URL.Path))))
+	}), WithCache(c), WithMaxCacheBodySize(8))
+
+	for _, path := range []string{"/small", "/much-too-large"} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, len(path), rec.Body.Len())
+	}
+
+	_, ok := c.Get("//example.com/small")
+	assert.True(t, ok)
+	_, ok = c.Get("//example.com/much-too-large")
+	assert.False(t, ok)
+}
+
+// Test non-200 and no-store responses are not cached
+func TestProxy_CacheableResponse(t *testing.T) {
+	c := newTestCache(t)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/no-store" {
+			w.Header().Set("Cache-Control", "no-store")
+		} else if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("body"))
+	}), WithCache(c))
+
+	for _, path := range []string{"/no-store", "/missing"} {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		_, ok := c.Get("//example.com" + path)
+		assert.False(t, ok, path)
+	}
+}
+
+// Test chunks of a streamed response reach the client before the upstream finishes
+func TestProxy_Streams(t *testing.T) {
+	release := make(chan struct{})
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: second\n\n"))
+	}))
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	first := make([]byte, len("data: first\n\n"))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(resp.Body, first)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+		assert.Equal(t, "data: first\n\n", string(first))
+	case <-time.After(2 * time.Second):
+		t.Fatal("first event was not flushed before the upstream finished")
+	}
+	close(release)
+	rest, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "data: second\n\n", string(rest))
+}
+
+type countingPool struct {
+	BufferPool
+	gets, puts int
+}
+
+func (p *countingPool) Get() []byte  { p.gets++; return p.BufferPool.Get() }
+func (p *countingPool) Put(b []byte) { p.puts++; p.BufferPool.Put(b) }
+
+// Test body copies borrow from the configured pool and return the buffer, empty bodies still cache
+func TestProxy_BufferPool(t *testing.T) {
+	c := newTestCache(t)
+	pool := &countingPool{BufferPool: NewBufferPool(1024)}
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), WithCache(c), WithBufferPool(pool))
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/empty", nil))
+	assert.Equal(t, 1, pool.gets)
+	assert.Equal(t, 1, pool.puts)
+
+	cached, ok := c.Get("//example.com/empty")
+	assert.True(t, ok)
+	assert.Empty(t, cached.Body)
+}
+
+// Test failed upstream exchanges go through the error handler
+func TestProxy_ErrorHandler(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1:1")
+	var handled error
+	p := NewProxy(u, http.DefaultClient, WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"upstream unavailable"}`))
+	}))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Error(t, handled)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"error":"upstream unavailable"}`, rec.Body.String())
+}
+
+type observed struct {
+	mu        sync.Mutex
+	lookups   []CacheResult
+	exchanges []int
+}
+
+func (o *observed) CacheLookup(r *http.Request, result CacheResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lookups = append(o.lookups, result)
+}
+
+func (o *observed) UpstreamExchange(r *http.Request, upstream *url.URL, status int, err error, elapsed time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.exchanges = append(o.exchanges, status)
+}
+
+// Test the observer sees cache lookups and upstream exchanges
+func TestProxy_Observer(t *testing.T) {
+	o := &observed{}
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}), WithCache(newTestCache(t)), WithObserver(o))
+
+	for range 2 {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+	}
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/a", nil))
+	assert.Equal(t, []CacheResult{CacheMiss, CacheHit}, o.lookups)
+	assert.Equal(t, []int{200, 200}, o.exchanges)
+}
+
+type phased struct {
+	observed
+	phases []Phases
+}
+
+func (o *phased) UpstreamPhases(r *http.Request, upstream *url.URL, phases Phases) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.phases = append(o.phases, phases)
+}
+
+// Test phase observers get the connect, think and transfer times, no connect on a reused connection
+func TestProxy_Phases(t *testing.T) {
+	o := &phased{}
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("head"))
+		http.NewResponseController(w).Flush()
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("tail"))
+	}), WithObserver(o))
+
+	for range 2 {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if assert.Len(t, o.phases, 2) {
+		first, second := o.phases[0], o.phases[1]
+		assert.False(t, first.Reused)
+		assert.Positive(t, first.Connect)
+		assert.Zero(t, first.DNS)
+		assert.Zero(t, first.TLS)
+		assert.GreaterOrEqual(t, first.TTFB, 20*time.Millisecond)
+		assert.GreaterOrEqual(t, first.Transfer, 20*time.Millisecond)
+		assert.True(t, second.Reused)
+		assert.Zero(t, second.Connect)
+	}
+	assert.Len(t, o.exchanges, 2)
+}
+
+type served struct {
+	observed
+	bytes map[ServedSource]int64
+}
+
+func (o *served) ResponseServed(r *http.Request, source ServedSource, bytes int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.bytes[source] += bytes
+}
+
+// Test served observers get the body bytes of cached and upstream responses, none for HEAD
+func TestProxy_Served(t *testing.T) {
+	o := &served{bytes: map[ServedSource]int64{}}
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}), WithCache(newTestCache(t)), WithObserver(o))
+
+	for _, method := range []string{http.MethodGet, http.MethodGet, http.MethodGet, http.MethodHead} {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+	assert.Equal(t, map[ServedSource]int64{ServedFromUpstream: 5, ServedFromCache: 10}, o.bytes)
+}
+
+type unavailableBalancer struct{}
+
+func (unavailableBalancer) Next(r *http.Request) (*url.URL, error) { return nil, errUnavailable{} }
+
+type errUnavailable struct{}
+
+func (errUnavailable) Error() string             { return "no backends available" }
+func (errUnavailable) Code() string              { return "no_backends" }
+func (errUnavailable) RetryAfter() time.Duration { return 1500 * time.Millisecond }
+
+// Test upstreams that can't take requests answer 503 with a Retry-After and an error code
+func TestProxy_Unavailable(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1:1")
+	p := NewProxy(u, http.DefaultClient, WithBalancer(unavailableBalancer{}))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"no_backends","message":"no backends available","retryAfter":2}`, rec.Body.String())
+}
+
+// Test responses are modified before caching and modify errors go to the error handler
+func TestProxy_ModifyResponse(t *testing.T) {
+	c := newTestCache(t)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.2")
+		w.Write([]byte("body"))
+	}), WithCache(c), WithModifyResponse(func(resp *http.Response) error {
+		if resp.Request.URL.Path == "/reject" {
+			return errors.New("rejected")
+		}
+		resp.Header.Del("Server")
+		return nil
+	}))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.Empty(t, rec.Header().Get("Server"))
+	cached, _ := c.Get("//example.com/ok")
+	assert.Empty(t, cached.Header.Get("Server"))
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reject", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+// Test the rewrite hook sees the built upstream request
+func TestProxy_RewriteRequest(t *testing.T) {
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Tenant")))
+	}), WithRewriteRequest(func(r *http.Request) {
+		r.URL.Path = "/v2" + r.URL.Path
+		r.Header.Set("X-Tenant", "acme")
+	}))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, "/v2/users acme", rec.Body.String())
+}
+
+// Test the path and query reach the upstream as the client escaped them unless normalized
+func TestProxy_RawURL(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RequestURI
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/base")
+
+	for _, tc := range []struct {
+		normalize bool
+		want      string
+	}{
+		{false, "/base/a%2Fb;c/%41?y=2;x=1&b=%20"},
+		{true, "/base/a/b;c/A?b=+"},
+	} {
+		p := NewProxy(u, server.Client(), WithNormalizeURL(tc.normalize))
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a%2Fb;c/%41?y=2;x=1&b=%20", nil))
+		assert.Equal(t, tc.want, got)
+	}
+}
+
+// Test HTTP/1.0 upstreams are cached by Expires unless they send Pragma: no-cache, and HTTP/1.0
+// clients get their responses without chunking
+func TestProxy_HTTP10(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	var fetches atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				fetches.Add(1)
+				now := time.Now().UTC()
+				header := "Date: " + now.Format(http.TimeFormat) + "\r\nExpires: " + now.Add(time.Minute).Format(http.TimeFormat) + "\r\n"
+				if req.URL.Path == "/pragma" {
+					header += "Pragma: no-cache\r\n"
+				}
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\n"+header+"\r\n"+req.URL.Path)
+			}()
+		}
+	}()
+	u, _ := url.Parse("http://" + ln.Addr().String())
+	front := httptest.NewServer(NewProxy(u, &http.Client{}, WithCache(newTestCache(t))))
+	defer front.Close()
+
+	for _, path := range []string{"/expires", "/expires", "/pragma", "/pragma"} {
+		_, _, resp := dialHTTP(t, front.Listener.Addr().String(), "GET "+path+" HTTP/1.0\r\n\r\n")
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, path, string(body))
+		assert.Equal(t, "HTTP/1.0", resp.Proto)
+		assert.Empty(t, resp.TransferEncoding)
+	}
+	assert.Equal(t, int32(3), fetches.Load())
+}
+
+// Test a client disconnect mid-body cancels the upstream request
+func TestProxy_ClientDisconnectCancelsUpstream(t *testing.T) {
+	canceled := make(chan struct{})
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	buf := make([]byte, len("first chunk"))
+	io.ReadFull(resp.Body, buf)
+	resp.Body.Close()
+
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request was not canceled after the client disconnected")
+	}
+}
+
+// Test uncacheable responses of known length skip the pooled copy buffer
+func TestProxy_LargeBodyDirectCopy(t *testing.T) {
+	c := newTestCache(t)
+	pool := &countingPool{BufferPool: NewBufferPool(1024)}
+	large := strings.Repeat("x", 64*1024)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		w.Write([]byte(large))
+	}), WithCache(c), WithMaxCacheBodySize(1024), WithBufferPool(pool))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download", nil))
+	assert.Equal(t, len(large), rec.Body.Len())
+	assert.Equal(t, 0, pool.gets)
+	_, ok := c.Get("//example.com/download")
+	assert.False(t, ok)
+}
+
+// Test responses stream without caching once the shared memory budget is spent
+func TestProxy_MemoryBudget(t *testing.T) {
+	c := newTestCache(t)
+	budget := NewMemoryBudget(16)
+	assert.True(t, budget.reserve(10)) // held by another in-flight response
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}), WithCache(c), WithMemoryBudget(budget))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/over", nil))
+	assert.Equal(t, "0123456789", rec.Body.String())
+	_, ok := c.Get("//example.com/over")
+	assert.False(t, ok)
+	assert.Equal(t, int64(10), budget.Used())
+
+	budget.release(10)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fits", nil))
+	_, ok = c.Get("//example.com/fits")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), budget.Used())
+}
+
+// Test an override context sends the request to the override target and skips the cache
+func TestProxy_UpstreamOverride(t *testing.T) {
+	c := newTestCache(t)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("production"))
+	}), WithCache(c))
+	dev := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("dev " + r.URL.Path))
+	}))
+	defer dev.Close()
+	devURL, _ := url.Parse(dev.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req.WithContext(NewOverrideContext(req.Context(), devURL)))
+	assert.Equal(t, "dev /page", rec.Body.String())
+	_, ok := c.Get("//example.com/page")
+	assert.False(t, ok)
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, "production", rec.Body.String())
+}
+
+// Test gzip and identity variants of a URL are cached together and each client gets one it accepts
+func TestProxy_EncodingVariants(t *testing.T) {
+	c := newTestCache(t)
+	upstreamHits := 0
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Header().Set("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte("gzipped"))
+			return
+		}
+		w.Write([]byte("plain"))
+	}), WithCache(c))
+	get := func(acceptEncoding string) string {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	assert.Equal(t, "gzipped", get("gzip, deflate"))
+	assert.Equal(t, "plain", get("identity"))
+	assert.Equal(t, 2, upstreamHits)
+	// only the lookup of the second request finds the entry, merging the variant in isn't a hit
+	assert.Equal(t, uint64(1), c.Stats().Hits)
+
+	assert.Equal(t, "gzipped", get("br, gzip"))
+	assert.Equal(t, "plain", get("gzip;q=0"))
+	assert.Equal(t, 2, upstreamHits)
+
+	entry, ok := c.Get("//example.com/page")
+	assert.True(t, ok)
+	assert.Len(t, entry.Variants, 1)
+	assert.Equal(t, len("plain")+len("gzipped")+headerSize(entry.Header)+headerSize(entry.Variants[0].Header), entry.Size())
+}
+
+// Test refresh-ahead refetches every encoding variant of an entry and keeps them together
+func TestProxy_FetchKeepsVariants(t *testing.T) {
+	c := newTestCache(t)
+	var upstreamHits atomic.Int32
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit := strconv.Itoa(int(upstreamHits.Add(1)))
+		w.Header().Set("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte("gzipped" + hit))
+			return
+		}
+		w.Write([]byte("plain" + hit))
+	}), WithCache(c))
+	for _, acceptEncoding := range []string{"gzip", "identity"} {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	refreshed, _, err := p.(*proxy).Fetch("//example.com/page")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), upstreamHits.Load())
+	assert.Len(t, refreshed.Variants, 1)
+	assert.Equal(t, "plain3", string(refreshed.variant("identity").Body))
+	assert.Equal(t, "gzipped4", string(refreshed.variant("gzip").Body))
+}
+
+// Test refresh-ahead sends the cached request's Host and forwarding headers, like a miss does
+func TestProxy_FetchHost(t *testing.T) {
+	var hosts, forwardedHosts []string
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		forwardedHosts = append(forwardedHosts, r.Header.Get("X-Forwarded-Host"))
+		w.Write([]byte("page"))
+	}), WithCache(newTestCache(t)), WithPreserveOriginalHost(true))
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://site.example.com/page", nil))
+	_, _, err := p.(*proxy).Fetch("//site.example.com/page")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"site.example.com", "site.example.com"}, hosts)
+	assert.Equal(t, []string{"site.example.com", "site.example.com"}, forwardedHosts)
+}
+
+// Test an entry's size counts the bodies and headers of every variant
+func TestCachedResponse_Size(t *testing.T) {
+	entry := &CachedResponse{
+		Header: http.Header{"Content-Type": {"text/plain"}},
+		Body:   []byte("plain"),
+		Variants: []*CachedResponse{{
+			Header: http.Header{"Content-Encoding": {"gzip"}, "Vary": {"Accept-Encoding"}},
+			Body:   []byte("gzipped"),
+		}},
+	}
+	// "Content-Type: text/plain\r\n", "Content-Encoding: gzip\r\n" and "Vary: Accept-Encoding\r\n"
+	assert.Equal(t, 5+26+7+24+23, entry.Size())
+}
+
+// Test warming fetches every URL through the handler, caching them where live requests find them,
+// and reports failures
+func TestWarm(t *testing.T) {
+	upstreamHits := 0
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}), WithCache(newTestCache(t)))
+
+	urls := []string{"http://example.com/a", "http://example.com/b?page=2", "https://shop.example.com/c", "http://example.com/missing", "/relative"}
+	result := Warm(context.Background(), p, urls, 2)
+	assert.Equal(t, 5, result.Total)
+	assert.Equal(t, 3, result.OK)
+	assert.ElementsMatch(t, []string{"http://example.com/missing: status 404", "/relative: not an absolute URL"}, result.Failed)
+	assert.Equal(t, 4, upstreamHits)
+
+	for host, target := range map[string]string{"example.com": "/a", "Example.com:80": "/b?page=2", "shop.example.com": "/c"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, target)
+	}
+	assert.Equal(t, 4, upstreamHits)
+}
+
+// Test only-if-cached is answered from the cache or with a 504, never by upstream
+func TestProxy_OnlyIfCached(t *testing.T) {
+	upstreamHits := 0
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Write([]byte("fresh"))
+	}), WithCache(newTestCache(t)))
+	get := func(cacheControl string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		req.Header.Set("Cache-Control", cacheControl)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusGatewayTimeout, get("only-if-cached").Code)
+	assert.Equal(t, 0, upstreamHits)
+
+	get("")
+	rec := get("max-stale, only-if-cached")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "fresh", rec.Body.String())
+	assert.Equal(t, 1, upstreamHits)
+}
+
+// Test max-age, min-fresh and max-stale request directives against an entry's age and expiry
+func TestCachedResponse_Satisfies(t *testing.T) {
+	now := time.Now()
+	fresh := &CachedResponse{CachedAt: now.Add(-30 * time.Second)}
+	stale := &CachedResponse{CachedAt: now.Add(-90 * time.Second)}
+	freshUntil, staleSince := now.Add(30*time.Second), now.Add(-30*time.Second)
+	directives := func(value string) map[string]string {
+		return cacheDirectives(http.Header{"Cache-Control": {value}})
+	}
+
+	assert.True(t, fresh.satisfies(directives(""), freshUntil, now))
+	assert.True(t, fresh.satisfies(directives("max-age=60"), freshUntil, now))
+	assert.False(t, fresh.satisfies(directives("max-age=10"), freshUntil, now))
+	assert.True(t, fresh.satisfies(directives("min-fresh=20"), freshUntil, now))
+	assert.False(t, fresh.satisfies(directives("min-fresh=40"), freshUntil, now))
+
+	assert.False(t, stale.satisfies(directives(""), staleSince, now))
+	assert.True(t, stale.satisfies(directives("max-stale"), staleSince, now))
+	assert.True(t, stale.satisfies(directives("max-stale=60"), staleSince, now))
+	assert.False(t, stale.satisfies(directives("max-stale=10"), staleSince, now))
+	assert.False(t, stale.satisfies(directives("max-stale, max-age=60"), staleSince, now))
+	// entries the cache expires are fresh while found
+	assert.True(t, stale.satisfies(directives(""), time.Time{}, now))
+}
+
+// Test stale entries are kept for the retention window but only served to max-stale requests
+func TestProxy_StaleRetention(t *testing.T) {
+	c := newTestCache(t)
+	upstreamHits := 0
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("v" + strconv.Itoa(upstreamHits)))
+	}), WithCache(c), WithStaleRetention(300))
+	get := func(cacheControl string) string {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		req.Header.Set("Cache-Control", cacheControl)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	assert.Equal(t, "v1", get(""))
+	// age the entry past its freshness: cached 90s ago, so 270s of its retention are left
+	entry, _ := c.Get("//example.com/page")
+	aged := *entry
+	aged.CachedAt = time.Now().Add(-90 * time.Second)
+	c.SetWithTTL("//example.com/page", &aged, 270)
+
+	assert.Equal(t, "v1", get("max-stale=60"))
+	assert.Equal(t, "v2", get(""))
+}
+
+// Test concurrent misses wait for the first fill with the cache lock and upstream is hit once
+func TestProxy_CacheLock(t *testing.T) {
+	var upstreamHits atomic.Int32
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Write([]byte("filled"))
+	}), WithCache(newTestCache(t)), WithCacheLock(time.Second))
+
+	// an uncacheable fill sends the waiting requests upstream themselves
+	for path, hits := range map[string]int32{"/page": 1, "/private": 5} {
+		upstreamHits.Store(0)
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rec := httptest.NewRecorder()
+				p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.Equal(t, "filled", rec.Body.String())
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, hits, upstreamHits.Load(), path)
+	}
+}
+
+// Test cache keys carry the host unless made host-less, so virtual hosts don't share entries
+func TestProxy_CacheKeyHost(t *testing.T) {
+	for _, keyHost := range []bool{true, false} {
+		p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Host))
+		}), WithCache(newTestCache(t)), WithCacheKeyHost(keyHost), WithPreserveOriginalHost(true))
+
+		var bodies []string
+		for _, host := range []string{"a.example.com", "B.example.com:8080"} {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/page", nil)
+			r.Host = host
+			p.ServeHTTP(rec, r)
+			bodies = append(bodies, rec.Body.String())
+		}
+		if keyHost {
+			assert.Equal(t, []string{"a.example.com", "B.example.com:8080"}, bodies)
+		} else {
+			assert.Equal(t, []string{"a.example.com", "a.example.com"}, bodies)
+		}
+	}
+}
+
+// Test partitioned proxies keep the same URL apart per host
+func TestProxy_CachePartition(t *testing.T) {
+	c := newTestCache(t)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}), WithCache(c), WithCachePartition(func(r *http.Request) string { return r.Host }))
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		r := httptest.NewRequest(http.MethodGet, "/page", nil)
+		r.Host = host
+		p.ServeHTTP(httptest.NewRecorder(), r)
+		_, ok := c.Get(PartitionKey(host, "//"+host+"/page"))
+		assert.True(t, ok, host)
+	}
+
+	partition, rawURL := SplitPartitionKey(PartitionKey("a.example.com", "/page?q=a b"))
+	assert.Equal(t, "a.example.com", partition)
+	assert.Equal(t, "/page?q=a b", rawURL)
+	partition, rawURL = SplitPartitionKey("/page")
+	assert.Equal(t, "", partition)
+	assert.Equal(t, "/page", rawURL)
+}
+
+// Test HEAD is answered from a cached GET with its length, and goes upstream on a miss
+func TestProxy_HeadFromCachedGet(t *testing.T) {
+	var methods []string
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Write([]byte("hello"))
+	}), WithCache(newTestCache(t)))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	serve(http.MethodHead, "/page")
+	assert.Equal(t, []string{http.MethodHead}, methods)
+
+	serve(http.MethodGet, "/page")
+	rec := serve(http.MethodHead, "/page")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Content-Length"))
+	assert.NotEmpty(t, rec.Header().Get("Age"))
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, []string{http.MethodHead, http.MethodGet}, methods)
+}
+
+// Test Age and expiry follow the injected clock, without sleeping
+func TestProxy_Clock(t *testing.T) {
+	clock := cache.NewManualClock(time.Now())
+	c, err := cache.NewLRUTTL(cache.WithLazyExpiration[string, *CachedResponse](cache.DefaultSweepPerOp),
+		cache.WithClock[string, *CachedResponse](clock))
+	if err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	upstreamHits := 0
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}), WithCache(c), WithClock(clock))
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+		return rec
+	}
+
+	get()
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, "30", get().Header().Get("Age"))
+	assert.Equal(t, 1, upstreamHits)
+
+	clock.Advance(31 * time.Second)
+	assert.Empty(t, get().Header().Get("Age"))
+	assert.Equal(t, 2, upstreamHits)
+}
+
+// Test range requests are cut from a cached response instead of getting all of it
+func TestProxy_RangeFromCache(t *testing.T) {
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}), WithCache(newTestCache(t)))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/file", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/file", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 2-4/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "234", rec.Body.String())
+}
+
+// Test concurrent range requests on an uncached slice share one upstream fetch
+func TestProxy_SlicesShared(t *testing.T) {
+	var fetches atomic.Int32
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}), WithCache(newTestCache(t)), WithSliceSize(16))
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/video", nil)
+			req.Header.Set("Range", "bytes=2-5")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+			assert.Equal(t, "2345", rec.Body.String())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+// Test a shared slice fetch carries on for the waiting requests when the first one's client leaves
+func TestProxy_SliceFetchOutlivesClient(t *testing.T) {
+	var fetches atomic.Int32
+	fetching, release := make(chan struct{}), make(chan struct{})
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			close(fetching)
+		}
+		<-release
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}), WithCache(newTestCache(t)), WithSliceSize(16))
+	get := func(ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/video", nil).WithContext(ctx)
+		req.Header.Set("Range", "bytes=2-5")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go get(ctx)
+	<-fetching
+	waiter := make(chan string)
+	go func() { waiter <- get(context.Background()).Body.String() }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	close(release)
+	assert.Equal(t, "2345", <-waiter)
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+// Test slice mode fetches aligned slices once and assembles ranges from them
+func TestProxy_Slices(t *testing.T) {
+	var ranges []string
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}), WithCache(newTestCache(t)), WithSliceSize(4))
+	get := func(byteRange string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/video", nil)
+		req.Header.Set("Range", byteRange)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("bytes=3-8")
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 3-8/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "6", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "345678", rec.Body.String())
+	assert.Equal(t, []string{"bytes=0-3", "bytes=4-7", "bytes=8-11"}, ranges)
+
+	rec = get("bytes=5-")
+	assert.Equal(t, "bytes 5-9/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "56789", rec.Body.String())
+	assert.Len(t, ranges, 3)
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, get("bytes=20-").Code)
+}
+
+// Test slices over the max cache body size aren't cached, the range is proxied as is
+func TestProxy_SlicesOverMaxBodySize(t *testing.T) {
+	var ranges []string
+	c := newTestCache(t)
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}), WithCache(c), WithSliceSize(4), WithMaxCacheBodySize(3))
+
+	req := httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Range", "bytes=3-8")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 3-8/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "345678", rec.Body.String())
+	assert.Equal(t, []string{"bytes=0-3", "bytes=3-8"}, ranges)
+	assert.Equal(t, 0, c.Len())
+}
+
+// Test redirects reach the client unfollowed, permanent ones are cached and temporary ones only
+// with max-age when enabled
+func TestProxy_CacheRedirects(t *testing.T) {
+	upstreamHits := 0
+	p := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/found":
+			w.Header().Set("Cache-Control", "max-age=60")
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/found-uncached":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		default:
+			w.Write([]byte("new"))
+		}
+	}), WithCache(newTestCache(t)), WithCacheTemporaryRedirects(true))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	for _, path := range []string{"/moved", "/found", "/found-uncached"} {
+		upstreamHits = 0
+		for range 2 {
+			rec := get(path)
+			assert.Equal(t, "/new", rec.Header().Get("Location"), path)
+			assert.NotEqual(t, http.StatusOK, rec.Code, path)
+		}
+		if path == "/found-uncached" {
+			assert.Equal(t, 2, upstreamHits, path)
+		} else {
+			assert.Equal(t, 1, upstreamHits, path)
+		}
+	}
+}
+
+// Test Purge drops a key with its slices and leaves the rest
+func TestPurge(t *testing.T) {
+	c := newTestCache(t)
+	for _, key := range []string{"/video", sliceKey("/video", 0), sliceKey("/video", 1), "/video2"} {
+		c.Set(key, &CachedResponse{Status: http.StatusOK})
+	}
+
+	assert.True(t, Purge(c, "/video"))
+	assert.False(t, Purge(c, "/video"))
+	assert.Equal(t, 1, c.Len())
+	_, ok := c.Get("/video2")
+	assert.True(t, ok)
+}
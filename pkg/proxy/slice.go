@@ -0,0 +1,326 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/cache"
+	"github.com/ashpect/revproxy/pkg/utils"
+)
+
+// sliceKey is the cache key of slice index of the resource at key. Server request URLs carry no
+// fragment, so it can't clash with the key of a whole resource.
+func sliceKey(key string, index int64) string {
+	return key + "#slice=" + strconv.FormatInt(index, 10)
+}
+
+// Purge drops the cached response for key along with its slices, reporting whether any existed.
+func Purge(c cache.Cache[string, *CachedResponse], key string) bool {
+	return purgeMatching(c, func(k string) bool {
+		return k == key || strings.HasPrefix(k, key+"#slice=")
+	}) > 0
+}
+
+// PurgePrefix drops the cached responses whose keys start with prefix, slices included, and
+// returns how many keys were dropped.
+func PurgePrefix(c cache.Cache[string, *CachedResponse], prefix string) int {
+	return purgeMatching(c, func(k string) bool { return strings.HasPrefix(k, prefix) })
+}
+
+func purgeMatching(c cache.Cache[string, *CachedResponse], match func(key string) bool) int {
+	keys := []string{}
+	c.Range(func(k string, _ *CachedResponse) bool {
+		if match(k) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	for _, k := range keys {
+		c.Delete(k)
+	}
+	return len(keys)
+}
+
+// parseByteRange parses a single "bytes=start-end" or "bytes=start-" range, end is -1 when open.
+// Suffix and multiple ranges aren't sliced.
+func parseByteRange(header string) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if !found || err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if last = strings.TrimSpace(last); last == "" {
+		return start, -1, true
+	}
+	end, err = strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// contentRangeTotal returns the complete length from a "bytes first-last/total" Content-Range.
+func contentRangeTotal(contentRange string) (int64, bool) {
+	_, total, found := strings.Cut(contentRange, "/")
+	n, err := strconv.ParseInt(total, 10, 64)
+	return n, found && err == nil && n >= 0
+}
+
+// serveSliced answers a range request from fixed-size slices of the resource, each fetched from
+// upstream with its own aligned Range request and cached on its own (like nginx's slice module),
+// so seeking in large files is served from the cache. It returns false when the request can't be
+// sliced and should be proxied as is.
+func (p *proxy) serveSliced(w http.ResponseWriter, r *http.Request, key string, directives map[string]string) bool {
+	if r.Header.Get("If-Range") != "" {
+		return false
+	}
+	start, end, ok := parseByteRange(r.Header.Get("Range"))
+	if !ok {
+		return false
+	}
+
+	firstIndex := start / p.sliceSize
+	first, resp, err := p.slice(r, key, firstIndex, directives)
+	if errors.Is(err, errSliceTooLarge) {
+		utils.Debug("%v, proxying the range request as is", err)
+		return false
+	}
+	if err != nil {
+		p.errorHandler(w, r, err)
+		return true
+	}
+	if resp != nil {
+		// upstream didn't answer with a slice (no range support, 416, ...), pass it through
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		written, err := p.copyResponse(w, resp, nil)
+		if err != nil {
+			log.Printf("error copying response body: %v", err)
+		}
+		p.served(r, ServedFromUpstream, written)
+		return true
+	}
+
+	total, _ := contentRangeTotal(first.Header.Get("Content-Range"))
+	if start >= total {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	if end < 0 || end >= total {
+		end = total - 1
+	}
+
+	for key, values := range first.Header {
+		if key == "Content-Range" || key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	s := first
+	for index := firstIndex; index <= end/p.sliceSize; index++ {
+		if index != firstIndex {
+			s, resp, err = p.slice(r, key, index, directives)
+			if resp != nil {
+				resp.Body.Close()
+				err = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+			} else if err == nil {
+				if t, _ := contentRangeTotal(s.Header.Get("Content-Range")); t != total {
+					err = fmt.Errorf("length changed from %d to %d", total, t)
+				}
+			}
+			// headers are sent, all that's left is cutting the response short
+			if err != nil {
+				log.Printf("slice %d of %s: %v", index, key, err)
+				return true
+			}
+		}
+		offset := index * p.sliceSize
+		from, to := max(start-offset, 0), min(end-offset+1, int64(len(s.Body)))
+		if _, err := w.Write(s.Body[from:to]); err != nil {
+			return true
+		}
+	}
+	return true
+}
+
+// errSliceTooLarge is the error of a slice fetch whose body exceeds the max cache body size or
+// the memory budget, so it isn't cached.
+var errSliceTooLarge = errors.New("body exceeds max cache body size or memory budget")
+
+// errNotSliced is the loader error of a slice fetch that got a whole response instead of a 206.
+var errNotSliced = errors.New("upstream didn't answer with a slice")
+
+// slice returns slice index of the resource at key from the cache, or fetches and caches it.
+// Concurrent misses on a slice share one fetch. A non-206 upstream response is returned unread
+// instead, for the caller to close.
+func (p *proxy) slice(r *http.Request, key string, index int64, directives map[string]string) (*CachedResponse, *http.Response, error) {
+	skey := sliceKey(key, index)
+	var whole *http.Response
+	load := func() (*CachedResponse, int, error) {
+		// the requests waiting on the slice share this fetch, so this one's client going away
+		// mustn't cut it short
+		fetchReq, cancel := p.detach(r)
+		cached, resp, err := p.fetchSlice(fetchReq, key, index)
+		if err != nil {
+			cancel()
+			return nil, 0, err
+		}
+		if resp != nil {
+			resp.Body = cancelOnClose{resp.Body, cancel}
+			whole = resp
+			return nil, 0, errNotSliced
+		}
+		cancel()
+		return cached, p.sliceTTL(cached), nil
+	}
+
+	cached, err := p.cache.GetOrLoad(r.Context(), skey, load)
+	switch {
+	case whole != nil:
+		return nil, whole, nil
+	case errors.Is(err, errNotSliced):
+		// the shared fetch got the whole resource, this request reads its own
+		return p.fetchSlice(r, key, index)
+	case err != nil:
+		return nil, nil, err
+	case cached.satisfies(directives, p.freshUntil(cached, p.expiry(r.Context(), skey)), p.clock.Now()):
+		return cached, nil, nil
+	}
+
+	// too old for the request's cache directives, refetch and replace it
+	cached, ttl, err := load()
+	if whole != nil {
+		return nil, whole, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if ttl > 0 {
+		err = p.cache.SetWithTTL(r.Context(), skey, cached, ttl)
+	} else if ttl == 0 {
+		err = p.cache.Set(r.Context(), skey, cached)
+	}
+	if err != nil {
+		log.Printf("cache set error for key %s: %v", skey, err)
+	}
+	return cached, nil, nil
+}
+
+// detach returns r with a context no client disconnect cancels, bounded by the proxy timeout, for
+// upstream fetches shared by several requests. cancel releases it.
+func (p *proxy) detach(r *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithoutCancel(r.Context()), context.CancelFunc(func() {})
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+	}
+	return r.WithContext(ctx), cancel
+}
+
+// cancelOnClose releases a detached fetch's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// sliceTTL returns the ttl to store a fetched slice with, as GetOrLoad takes it: -1 when the
+// upstream marked it uncacheable, 0 for the cache default.
+func (p *proxy) sliceTTL(cached *CachedResponse) int {
+	if noStore(cached.Header, cached.CachedAt) {
+		return -1
+	}
+	ttl := p.lifetime(cached)
+	if ttl == 0 {
+		return 0
+	}
+	return ttl + p.staleRetention
+}
+
+// fetchSlice fetches slice index of the resource at key from upstream, without caching it. A
+// non-206 response is returned unread instead.
+func (p *proxy) fetchSlice(r *http.Request, key string, index int64) (*CachedResponse, *http.Response, error) {
+	upstream, err := p.target(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	outReq, err := p.buildUpstreamRequest(r, upstream)
+	if err != nil {
+		return nil, nil, err
+	}
+	// slices are shared by every client, so fetch them unconditionally and unencoded
+	for _, header := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		outReq.Header.Del(header)
+	}
+	offset := index * p.sliceSize
+	outReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+p.sliceSize-1))
+	outReq.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := p.client.Do(outReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	removeHopByHopHeaders(resp.Header)
+	if p.modifyResponse != nil {
+		if err := p.modifyResponse(resp); err != nil {
+			resp.Body.Close()
+			return nil, nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, resp, nil
+	}
+	defer resp.Body.Close()
+
+	total, ok := contentRangeTotal(resp.Header.Get("Content-Range"))
+	if !ok {
+		return nil, nil, fmt.Errorf("slice %d of %s: invalid Content-Range %q", index, key, resp.Header.Get("Content-Range"))
+	}
+	capture := newBodyCapture(p.maxCacheBodySize, p.memoryBudget)
+	buf := p.bufferPool.Get()
+	_, err = io.CopyBuffer(capture, io.LimitReader(resp.Body, p.sliceSize+1), buf)
+	p.bufferPool.Put(buf)
+	if err != nil {
+		capture.release()
+		return nil, nil, err
+	}
+	body, ok := capture.Body()
+	if !ok {
+		return nil, nil, fmt.Errorf("slice %d of %s: %w", index, key, errSliceTooLarge)
+	}
+	if want := min(p.sliceSize, total-offset); int64(len(body)) != want && offset < total {
+		return nil, nil, fmt.Errorf("slice %d of %s: got %d bytes, expected %d", index, key, len(body), want)
+	}
+
+	return &CachedResponse{
+		Status:   resp.StatusCode,
+		Header:   resp.Header.Clone(),
+		Body:     body,
+		CachedAt: p.clock.Now(),
+	}, nil, nil
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const copyBufferSize = 32 * 1024
+
+// flushIntervalFor returns how often the response body is flushed to the client. Event streams
+// and bodies of unknown length are flushed after every write so they aren't held back.
+func (p *proxy) flushIntervalFor(resp *http.Response) time.Duration {
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return -1
+	}
+	if resp.ContentLength == -1 {
+		return -1
+	}
+	return p.flushInterval
+}
+
+// copyResponse streams the upstream body to w and returns the bytes written. capture, when set, receives a copy for the cache.
+// Responses that are neither cached nor periodically flushed (typically large downloads over the
+// cache size cap) are handed straight to the ResponseWriter, which avoids our buffering and lets
+// the runtime use sendfile/splice where the platform allows.
+func (p *proxy) copyResponse(w http.ResponseWriter, resp *http.Response, capture *bodyCapture) (int64, error) {
+	interval := p.flushIntervalFor(resp)
+	if capture == nil && interval == 0 {
+		return io.Copy(w, resp.Body)
+	}
+
+	var dst io.Writer = w
+	if interval != 0 {
+		mlw := &maxLatencyWriter{dst: w, flush: http.NewResponseController(w).Flush, latency: interval}
+		defer mlw.stop()
+		dst = mlw
+	}
+	if capture != nil {
+		dst = io.MultiWriter(dst, capture)
+	}
+
+	buf := p.bufferPool.Get()
+	defer p.bufferPool.Put(buf)
+	return io.CopyBuffer(dst, resp.Body, buf)
+}
+
+// countingWriter counts the body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// maxLatencyWriter flushes writes at most latency after they happen, negative flushes every write.
+// A single timer is armed per pending flush instead of a ticker per request.
+type maxLatencyWriter struct {
+	dst     io.Writer
+	flush   func() error
+	latency time.Duration
+
+	mu           sync.Mutex
+	t            *time.Timer
+	flushPending bool
+}
+
+func (m *maxLatencyWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.dst.Write(p)
+	if m.latency < 0 {
+		m.flush()
+		return n, err
+	}
+	if m.flushPending {
+		return n, err
+	}
+	if m.t == nil {
+		m.t = time.AfterFunc(m.latency, m.delayedFlush)
+	} else {
+		m.t.Reset(m.latency)
+	}
+	m.flushPending = true
+	return n, err
+}
+
+func (m *maxLatencyWriter) delayedFlush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.flushPending { // stopped or already flushed
+		return
+	}
+	m.flush()
+	m.flushPending = false
+}
+
+func (m *maxLatencyWriter) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushPending = false
+	if m.t != nil {
+		m.t.Stop()
+	}
+}
+
+// bodyCapture buffers a copy of the body for the cache in a pooled buffer, giving up once it
+// exceeds limit bytes or the memory budget runs out. It never fails a write so the client stream
+// is unaffected.
+type bodyCapture struct {
+	buf      *bytes.Buffer
+	limit    int64
+	overflow bool
+	budget   *MemoryBudget // nil means unlimited
+	reserved int64
+}
+
+func newBodyCapture(limit int64, budget *MemoryBudget) *bodyCapture {
+	return &bodyCapture{buf: capturePool.Get().(*bytes.Buffer), limit: limit, budget: budget}
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	if c.overflow {
+		return len(p), nil
+	}
+	if c.limit > 0 && int64(c.buf.Len()+len(p)) > c.limit {
+		c.giveUp()
+		return len(p), nil
+	}
+	if c.budget != nil {
+		if !c.budget.reserve(int64(len(p))) {
+			c.giveUp()
+			return len(p), nil
+		}
+		c.reserved += int64(len(p))
+	}
+	return c.buf.Write(p)
+}
+
+// giveUp switches to stream-only, handing back the buffer and its budget right away.
+func (c *bodyCapture) giveUp() {
+	c.overflow = true
+	c.release()
+}
+
+// Body returns a copy of the captured body, false when it was given up, and releases the buffer.
+// The capture must not be used afterwards.
+func (c *bodyCapture) Body() ([]byte, bool) {
+	defer c.release()
+	if c.overflow {
+		return nil, false
+	}
+	return append([]byte{}, c.buf.Bytes()...), true
+}
+
+func (c *bodyCapture) release() {
+	if c.budget != nil && c.reserved > 0 {
+		c.budget.release(c.reserved)
+		c.reserved = 0
+	}
+	if c.buf == nil {
+		return
+	}
+	c.buf.Reset()
+	capturePool.Put(c.buf)
+	c.buf = nil
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/errorpage"
+	"github.com/ashpect/revproxy/pkg/utils"
+)
+
+// upgradeType returns the protocol a request or response switches to with Connection: Upgrade,
+// e.g. websocket, empty when it doesn't.
+func upgradeType(header http.Header) string {
+	for _, value := range header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return header.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// requestUpgrade returns the protocol r asks to switch to. HTTP/1.0 requests can't switch, their
+// Upgrade is ignored (RFC 9110 7.8).
+func requestUpgrade(r *http.Request) string {
+	if !r.ProtoAtLeast(1, 1) {
+		return ""
+	}
+	return upgradeType(r.Header)
+}
+
+// roundTripper is the transport of client. Upgrades skip http.Client, whose timeout would cut the
+// tunnel and whose body wrapper hides the connection.
+func roundTripper(client *http.Client) http.RoundTripper {
+	if client.Transport != nil {
+		return client.Transport
+	}
+	return http.DefaultTransport
+}
+
+// serveUpgrade completes a protocol switch the upstream accepted with 101 Switching Protocols: the
+// response is relayed, then bytes both ways until either side closes.
+func (p *proxy) serveUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	asked, switched := requestUpgrade(r), upgradeType(resp.Header)
+	if asked == "" || !strings.EqualFold(asked, switched) {
+		log.Printf("upstream switched %s %s to %q, client asked for %q", r.Method, r.URL.Path, switched, asked)
+		errorpage.Error(w, r, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		log.Printf("upstream connection of %s %s isn't writable after switching protocols", r.Method, r.URL.Path)
+		errorpage.Error(w, r, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	conn, buffered, err := hijack(w)
+	if err != nil {
+		log.Printf("can't switch %s %s to %s: %v", r.Method, r.URL.Path, switched, err)
+		errorpage.Error(w, r, "protocol switch unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	header := resp.Header.Clone()
+	removeHopByHopHeaders(header)
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", switched)
+	fmt.Fprintf(buffered, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	header.Write(buffered)
+	buffered.WriteString("\r\n")
+	if err := buffered.Flush(); err != nil {
+		log.Printf("error writing switching protocols response: %v", err)
+		conn.Close()
+		backend.Close()
+		return
+	}
+
+	up, down := tunnel(conn, buffered.Reader, backend)
+	p.served(r, ServedFromUpstream, down)
+	utils.Debug("%s tunnel for %s closed after %d bytes up, %d down", switched, r.URL.Path, up, down)
+}
+
+// errTargetNotPublic refuses CONNECT tunnels to addresses that aren't publicly routable.
+var errTargetNotPublic = errors.New("CONNECT target isn't a public address")
+
+// Connect answers CONNECT requests for clients using this as an explicit proxy, tunneling to the
+// requested host when its port is one of ports and refusing with 403 otherwise. With hosts, only
+// those hosts are tunneled to; without, any host whose address is public, so clients can't reach
+// loopback, private or link-local services (e.g. cloud metadata) through the proxy. Other requests
+// go to next. Only HTTP/1 clients can tunnel.
+func Connect(next http.Handler, ports []int, hosts []string, dialTimeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ProtoMajor != 1 {
+			errorpage.Error(w, r, "CONNECT requires HTTP/1.1", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		host, port, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			errorpage.Error(w, r, "invalid CONNECT target", http.StatusBadRequest)
+			return
+		}
+		if n, err := strconv.Atoi(port); err != nil || !slices.Contains(ports, n) {
+			errorpage.Error(w, r, "CONNECT port not allowed", http.StatusForbidden)
+			return
+		}
+		if len(hosts) > 0 && !slices.ContainsFunc(hosts, func(allowed string) bool { return strings.EqualFold(allowed, host) }) {
+			errorpage.Error(w, r, "CONNECT host not allowed", http.StatusForbidden)
+			return
+		}
+
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		if len(hosts) == 0 {
+			// checked on the address dialed, so names resolving to internal addresses are caught
+			dialer.Control = func(_, address string, _ syscall.RawConn) error {
+				if addrPort, err := netip.ParseAddrPort(address); err != nil || !publicAddr(addrPort.Addr()) {
+					return errTargetNotPublic
+				}
+				return nil
+			}
+		}
+		backend, err := dialer.DialContext(r.Context(), "tcp", r.Host)
+		if errors.Is(err, errTargetNotPublic) {
+			errorpage.Error(w, r, "CONNECT host not allowed", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("CONNECT %s: %v", r.Host, err)
+			errorpage.Error(w, r, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		conn, buffered, err := hijack(w)
+		if err != nil {
+			backend.Close()
+			log.Printf("CONNECT %s: %v", r.Host, err)
+			errorpage.Error(w, r, "tunnel unsupported", http.StatusInternalServerError)
+			return
+		}
+		buffered.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+		if err := buffered.Flush(); err != nil {
+			log.Printf("CONNECT %s: %v", r.Host, err)
+			conn.Close()
+			backend.Close()
+			return
+		}
+
+		up, down := tunnel(conn, buffered.Reader, backend)
+		utils.Debug("CONNECT tunnel to %s closed after %d bytes up, %d down", r.Host, up, down)
+	})
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), also used for cloud metadata.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether addr is publicly routable: not loopback, private, link-local,
+// multicast, unspecified or shared address space.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// hijack takes over the client connection of w, clearing the server's read and write deadlines
+// that would otherwise cut the tunnel.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, buffered, nil
+}
+
+// tunnel copies bytes both ways between the client connection and backend until either side is
+// done, then closes both. buffered holds what the server read from the client ahead of the hijack.
+func tunnel(client net.Conn, buffered *bufio.Reader, backend io.ReadWriteCloser) (up, down int64) {
+	closeBoth := sync.OnceFunc(func() {
+		client.Close()
+		backend.Close()
+	})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		up, _ = io.Copy(backend, io.MultiReader(io.LimitReader(buffered, int64(buffered.Buffered())), client))
+	}()
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		down, _ = io.Copy(client, backend)
+	}()
+	wg.Wait()
+	return up, down
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dialHTTP sends a raw HTTP/1.1 request to addr and returns the connection and response
+func dialHTTP(t *testing.T, addr, request string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, request)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("response: %v", err)
+	}
+	return conn, br, resp
+}
+
+// Test a non-WebSocket upgrade reaches the upstream and becomes a tunnel once switched
+func TestProxy_Upgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		line, _ := brw.ReadString('\n')
+		io.WriteString(conn, "echo "+line)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	p := NewProxy(u, upstream.Client(), WithCache(newTestCache(t)), WithTimeout(20*time.Millisecond))
+	front := httptest.NewServer(p)
+	defer front.Close()
+	addr := front.Listener.Addr().String()
+
+	conn, br, resp := dialHTTP(t, addr, "GET /chat HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive, Upgrade\r\nUpgrade: echo\r\n\r\n")
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	time.Sleep(50 * time.Millisecond) // past the proxy timeout, which tunnels ignore
+	io.WriteString(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "echo hello\n", line)
+	conn.Close()
+
+	// without Connection: Upgrade the header is hop-by-hop and dropped
+	_, _, resp = dialHTTP(t, addr, "GET /chat HTTP/1.1\r\nHost: example.com\r\nUpgrade: echo\r\n\r\n")
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+
+	// nor can HTTP/1.0 requests switch
+	_, _, resp = dialHTTP(t, addr, "GET /chat HTTP/1.0\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+}
+
+// Test CONNECT tunnels to allowed ports only and other methods pass through
+func TestConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				io.WriteString(conn, "echo "+line)
+			}()
+		}
+	}()
+	target := ln.Addr().String()
+	_, port, _ := net.SplitHostPort(target)
+	allowed, _ := strconv.Atoi(port)
+
+	front := httptest.NewServer(Connect(http.NotFoundHandler(), []int{allowed}, []string{"127.0.0.1"}, time.Second))
+	defer front.Close()
+	addr := front.Listener.Addr().String()
+
+	conn, br, resp := dialHTTP(t, addr, fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	io.WriteString(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "echo hello\n", line)
+
+	_, _, resp = dialHTTP(t, addr, "CONNECT 127.0.0.1:22 HTTP/1.1\r\nHost: 127.0.0.1:22\r\n\r\n")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, _, resp = dialHTTP(t, addr, fmt.Sprintf("CONNECT example.com:%d HTTP/1.1\r\nHost: example.com:%d\r\n\r\n", allowed, allowed))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, _, resp = dialHTTP(t, addr, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// Test CONNECT without allowed hosts refuses loopback, private and link-local targets
+func TestConnect_internalTargets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	allowed, _ := strconv.Atoi(port)
+
+	front := httptest.NewServer(Connect(http.NotFoundHandler(), []int{allowed}, nil, time.Second))
+	defer front.Close()
+	addr := front.Listener.Addr().String()
+
+	for _, host := range []string{"127.0.0.1", "localhost", "[::1]", "10.0.0.1", "169.254.169.254", "100.100.100.200"} {
+		target := host + ":" + port
+		_, _, resp := dialHTTP(t, addr, fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target))
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, host)
+	}
+
+	for addr, public := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"::ffff:127.0.0.1": false,
+		"192.168.1.1":      false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"0.0.0.0":          false,
+		"224.0.0.1":        false,
+	} {
+		assert.Equal(t, public, publicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -1,6 +1,9 @@
 package proxy
 
-import "strings"
+import (
+	"net/url"
+	"strings"
+)
 
 // singleJoiningSlash joins two paths with exactly one slash between them.
 func singleJoiningSlash(a, b string) string {
@@ -16,3 +19,30 @@ func singleJoiningSlash(a, b string) string {
 		return a + b
 	}
 }
+
+// joinURLPath joins the upstream and request paths, keeping the request's escaping (e.g. an
+// encoded slash) in the raw path so the upstream gets it as the client sent it.
+func joinURLPath(a, b *url.URL) (path, rawPath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	aPath, bPath := a.EscapedPath(), b.EscapedPath()
+	aSlash, bSlash := strings.HasSuffix(aPath, "/"), strings.HasPrefix(bPath, "/")
+	switch {
+	case aSlash && bSlash:
+		return a.Path + b.Path[1:], aPath + bPath[1:]
+	case !aSlash && !bSlash:
+		return a.Path + "/" + b.Path, aPath + "/" + bPath
+	}
+	return a.Path + b.Path, aPath + bPath
+}
+
+// normalizeURL drops the client's escaping of u's path and re-encodes its query, keys sorted and
+// pairs that don't parse (e.g. separated by semicolons) dropped.
+func normalizeURL(u *url.URL) {
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		query, _ := url.ParseQuery(u.RawQuery)
+		u.RawQuery = query.Encode()
+	}
+}
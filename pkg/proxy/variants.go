@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Encoding variants: when upstream varies on Accept-Encoding, the gzip, br, ... and identity
+// representations of a URL are stored together in one cache entry (the identity one first when
+// present, the others in Variants) and each client is served one it accepts.
+
+// Size returns the approximate bytes held by the entry: bodies and headers, all variants included.
+func (c *CachedResponse) Size() int {
+	size := len(c.Body) + headerSize(c.Header)
+	for _, variant := range c.Variants {
+		size += len(variant.Body) + headerSize(variant.Header)
+	}
+	return size
+}
+
+// headerSize estimates the bytes of header as written on the wire, "Name: value\r\n" per value.
+func headerSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
+}
+
+// variant returns the representation to serve for the request's Accept-Encoding, preferring a
+// compressed one, nil when none is acceptable.
+func (c *CachedResponse) variant(acceptEncoding string) *CachedResponse {
+	var identity *CachedResponse
+	for _, candidate := range c.representations() {
+		coding := contentCoding(candidate.Header)
+		if !acceptsCoding(acceptEncoding, coding) {
+			continue
+		}
+		if coding != "" {
+			return candidate
+		}
+		identity = candidate
+	}
+	return identity
+}
+
+// withVariant returns a new entry holding c's representations plus v, replacing the one with the
+// same coding. c may be nil and is not modified, it can be in use by concurrent readers.
+func (c *CachedResponse) withVariant(v *CachedResponse) *CachedResponse {
+	byCoding := map[string]*CachedResponse{}
+	if c != nil {
+		for _, r := range c.representations() {
+			byCoding[contentCoding(r.Header)] = r
+		}
+	}
+	byCoding[contentCoding(v.Header)] = v
+
+	primary := v
+	if identity, ok := byCoding[""]; ok {
+		primary = identity
+	}
+	entry := *primary
+	entry.Variants = nil
+	for _, coding := range slices.Sorted(maps.Keys(byCoding)) {
+		if r := byCoding[coding]; r != primary {
+			stripped := *r
+			stripped.Variants = nil
+			entry.Variants = append(entry.Variants, &stripped)
+		}
+	}
+	return &entry
+}
+
+func (c *CachedResponse) representations() []*CachedResponse {
+	return append([]*CachedResponse{c}, c.Variants...)
+}
+
+// variesOnEncoding reports whether resp is one of several encodings of the resource, either
+// announced by Vary or given away by a Content-Encoding.
+func variesOnEncoding(resp *http.Response) bool {
+	if contentCoding(resp.Header) != "" {
+		return true
+	}
+	for _, vary := range resp.Header.Values("Vary") {
+		for _, field := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contentCoding returns the lowercased Content-Encoding, "" for identity.
+func contentCoding(header http.Header) string {
+	coding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if coding == "identity" {
+		return ""
+	}
+	return coding
+}
+
+// acceptsCoding reports whether an Accept-Encoding value allows coding, "" meaning identity.
+// Identity is acceptable unless refused with q=0, other codings must be listed (or matched by
+// *) with q > 0. Without Accept-Encoding only identity is served.
+func acceptsCoding(acceptEncoding, coding string) bool {
+	name := coding
+	if name == "" {
+		name = "identity"
+	}
+	explicit, wildcard := -1.0, -1.0
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		token, params, _ := strings.Cut(entry, ";")
+		token = strings.ToLower(strings.TrimSpace(token))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch token {
+		case name:
+			explicit = q
+		case "*":
+			wildcard = q
+		}
+	}
+	switch {
+	case explicit >= 0:
+		return explicit > 0
+	case coding == "":
+		return wildcard != 0
+	default:
+		return wildcard > 0
+	}
+}
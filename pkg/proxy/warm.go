@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// WarmResult summarizes a cache warming run.
+type WarmResult struct {
+	Total  int      `json:"total"`
+	OK     int      `json:"ok"`
+	Failed []string `json:"failed"`
+}
+
+// Warm fetches each URL through handler, normally the full proxy handler so routing, limits and
+// caching apply as for client traffic, with at most concurrency requests in flight. URLs are
+// absolute, their host selects the route and is part of the cache key. Requests ask for gzip like
+// browsers do, so the variant most clients accept is the one warmed.
+func Warm(ctx context.Context, handler http.Handler, urls []string, concurrency int) WarmResult {
+	result := WarmResult{Total: len(urls), Failed: []string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(concurrency, 1))
+
+	for _, rawURL := range urls {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			result.Failed = append(result.Failed, rawURL+": "+ctx.Err().Error())
+			mu.Unlock()
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			err := warmOne(ctx, handler, rawURL)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed = append(result.Failed, rawURL+": "+err.Error())
+			} else {
+				result.OK++
+			}
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+func warmOne(ctx context.Context, handler http.Handler, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return errors.New("not an absolute URL")
+	}
+	// shaped like a server-side request, URL without scheme/host, so cache keys match live traffic
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.RequestURI(), nil)
+	if err != nil {
+		return err
+	}
+	req.Host = u.Host
+	req.RequestURI = u.RequestURI()
+	if u.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{}
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	w := &discardResponseWriter{header: http.Header{}}
+	handler.ServeHTTP(w, req)
+	switch w.status {
+	case 0, http.StatusOK, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("status %d", w.status)
+	}
+	return nil
+}
+
+// discardResponseWriter records the status and drops the body.
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardResponseWriter) Header() http.Header { return w.header }
+
+func (w *discardResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
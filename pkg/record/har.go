@@ -0,0 +1,110 @@
+package record
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Entry is one request/response pair, in the HAR 1.2 entry format.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total duration in milliseconds
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	Cache    struct{} `json:"cache"`
+	Timings  Timings  `json:"timings"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Encoding is base64 for bodies that aren't text
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	// Truncated is set when the body was cut at the recorder's size limit (not part of HAR)
+	Truncated bool `json:"_truncated,omitempty"`
+}
+
+// Timings are in milliseconds: wait is the time to the response headers, receive the body.
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Header converts HAR headers back to an http.Header.
+func Header(headers []NameValue) http.Header {
+	h := http.Header{}
+	for _, nv := range headers {
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
+
+// nameValues lists header sorted by name, values of redacted names replaced.
+func nameValues(header http.Header, redact map[string]bool) []NameValue {
+	out := []NameValue{}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, value := range header[name] {
+			if redact[name] {
+				value = redacted
+			}
+			out = append(out, NameValue{Name: name, Value: value})
+		}
+	}
+	return out
+}
+
+func queryString(u *url.URL) []NameValue {
+	out := []NameValue{}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		out = append(out, NameValue{Name: name, Value: value})
+	}
+	return out
+}
@@ -0,0 +1,298 @@
+// Package record writes sampled request/response pairs to a file for offline debugging and
+// contract analysis, as HAR or as JSON lines of HAR entries. Sensitive headers are redacted and
+// bodies cut at a size limit.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const redacted = "REDACTED"
+
+// DefaultRedactHeaders are always redacted, on top of WithRedactHeaders.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Recorder appends entries to a file. Its methods are safe for concurrent use.
+type Recorder struct {
+	format      string
+	sample      float64
+	maxBodySize int64
+	redact      map[string]bool
+	roll        func() float64
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	entries int
+}
+
+type Option func(*Recorder)
+
+// WithSample records only this fraction (0-1] of the requests, all by default.
+func WithSample(fraction float64) Option {
+	return func(r *Recorder) {
+		r.sample = fraction
+	}
+}
+
+// WithMaxBodySize keeps at most size bytes of each body, 64KiB by default. 0 records no bodies.
+func WithMaxBodySize(size int64) Option {
+	return func(r *Recorder) {
+		r.maxBodySize = size
+	}
+}
+
+// WithRedactHeaders replaces the values of these headers with REDACTED, in addition to
+// DefaultRedactHeaders.
+func WithRedactHeaders(names ...string) Option {
+	return func(r *Recorder) {
+		for _, name := range names {
+			r.redact[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+}
+
+// WithRoll replaces the random roll in [0, 1) sampling requests.
+func WithRoll(roll func() float64) Option {
+	return func(r *Recorder) {
+		r.roll = roll
+	}
+}
+
+// Open creates the recording at path. format is "jsonl", appending one entry per line, or "har",
+// which truncates the file and completes the HAR document on Close.
+func Open(path, format string, opts ...Option) (*Recorder, error) {
+	r := &Recorder{
+		format:      format,
+		sample:      1,
+		maxBodySize: 64 << 10,
+		redact:      map[string]bool{},
+		roll:        rand.Float64,
+	}
+	for _, name := range DefaultRedactHeaders {
+		r.redact[name] = true
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch format {
+	case "jsonl":
+		flags |= os.O_APPEND
+	case "har":
+		flags |= os.O_TRUNC
+	default:
+		return nil, fmt.Errorf("unknown recording format %q", format)
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r.f, r.w = f, bufio.NewWriter(f)
+	if format == "har" {
+		r.w.WriteString(`{"log":{"version":"1.2","creator":{"name":"revproxy","version":"1"},"entries":[` + "\n")
+	}
+	return r, nil
+}
+
+// Record writes entry.
+func (r *Recorder) Record(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return os.ErrClosed
+	}
+	if r.format == "har" && r.entries > 0 {
+		r.w.WriteString(",\n")
+	}
+	r.entries++
+	r.w.Write(data)
+	if r.format == "jsonl" {
+		r.w.WriteString("\n")
+	}
+	return r.w.Flush()
+}
+
+// Close completes and closes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return nil
+	}
+	if r.format == "har" {
+		r.w.WriteString("\n]}}\n")
+	}
+	err := r.w.Flush()
+	if closeErr := r.f.Close(); err == nil {
+		err = closeErr
+	}
+	r.w = nil
+	return err
+}
+
+// Middleware records the sampled requests passing through it.
+func (r *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.roll() >= r.sample {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		start := time.Now()
+		var reqBody *capture
+		if req.Body != nil && req.Body != http.NoBody {
+			reqBody = &capture{limit: r.maxBodySize}
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(req.Body, reqBody), req.Body}
+		}
+		rw := &responseRecorder{ResponseWriter: w, body: capture{limit: r.maxBodySize}}
+		defer func() {
+			// recorded even when the handler aborts, e.g. for a dropped upstream connection
+			if err := r.Record(r.entry(req, reqBody, rw, start)); err != nil {
+				log.Printf("recording %s %s: %v", req.Method, req.URL.Path, err)
+			}
+		}()
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (r *Recorder) entry(req *http.Request, reqBody *capture, rw *responseRecorder, start time.Time) *Entry {
+	end := time.Now()
+	if rw.status == 0 {
+		rw.status, rw.header, rw.wroteAt = http.StatusOK, rw.Header().Clone(), end
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	entry := &Entry{
+		StartedDateTime: start,
+		Time:            ms(end.Sub(start)),
+		Request: Request{
+			Method:      req.Method,
+			URL:         scheme + "://" + req.Host + req.URL.RequestURI(),
+			HTTPVersion: req.Proto,
+			Cookies:     []NameValue{},
+			Headers:     nameValues(req.Header, r.redact),
+			QueryString: queryString(req.URL),
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: Response{
+			Status:      rw.status,
+			StatusText:  http.StatusText(rw.status),
+			HTTPVersion: req.Proto,
+			Cookies:     []NameValue{},
+			Headers:     nameValues(rw.header, r.redact),
+			RedirectURL: rw.header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    rw.body.size,
+		},
+		Timings: Timings{
+			Wait:    ms(rw.wroteAt.Sub(start)),
+			Receive: ms(end.Sub(rw.wroteAt)),
+		},
+	}
+	if reqBody != nil {
+		contentType := req.Header.Get("Content-Type")
+		text, encoding := bodyText(reqBody.buf.Bytes(), contentType)
+		entry.Request.BodySize = reqBody.size
+		entry.Request.PostData = &PostData{MimeType: contentType, Text: text, Encoding: encoding}
+	}
+	contentType := rw.header.Get("Content-Type")
+	text, encoding := bodyText(rw.body.buf.Bytes(), contentType)
+	entry.Response.Content = Content{
+		Size:      rw.body.size,
+		MimeType:  contentType,
+		Text:      text,
+		Encoding:  encoding,
+		Truncated: rw.body.size > int64(rw.body.buf.Len()),
+	}
+	return entry
+}
+
+// bodyText returns body as text, base64 encoded when it isn't textual.
+func bodyText(body []byte, contentType string) (text, encoding string) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	textual := strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") || mediaType == "application/x-www-form-urlencoded"
+	if textual && utf8.Valid(body) {
+		return string(body), ""
+	}
+	if len(body) == 0 {
+		return "", ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// capture keeps the first limit bytes written and counts them all.
+type capture struct {
+	buf   bytes.Buffer
+	limit int64
+	size  int64
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	if room := c.limit - int64(c.buf.Len()); room > 0 {
+		c.buf.Write(p[:min(int64(len(p)), room)])
+	}
+	c.size += int64(len(p))
+	return len(p), nil
+}
+
+// responseRecorder captures the status, headers and body written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	header  http.Header
+	wroteAt time.Time
+	body    capture
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status, w.header, w.wroteAt = status, w.Header().Clone(), time.Now()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streams.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,146 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readLines(t *testing.T, path string) []Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Test the recorded entry redacts credentials and cuts bodies at the size limit
+func TestRecorder_JSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	r, err := Open(path, "jsonl", WithMaxBodySize(4), WithRedactHeaders("x-api-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("got " + string(body)))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://shop.example.com/orders?id=1&q=a%20b", strings.NewReader("order"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Team", "a")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, "got order", rec.Body.String())
+	assert.NoError(t, r.Close())
+
+	entries := readLines(t, path)
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	entry := entries[0]
+	assert.Equal(t, "POST", entry.Request.Method)
+	assert.Equal(t, "http://shop.example.com/orders?id=1&q=a%20b", entry.Request.URL)
+	assert.Equal(t, []NameValue{{"id", "1"}, {"q", "a b"}}, entry.Request.QueryString)
+	header := Header(entry.Request.Headers)
+	assert.Equal(t, "REDACTED", header.Get("Authorization"))
+	assert.Equal(t, "REDACTED", header.Get("X-Api-Key"))
+	assert.Equal(t, "a", header.Get("X-Team"))
+	assert.Equal(t, "orde", entry.Request.PostData.Text)
+	assert.Equal(t, int64(5), entry.Request.BodySize)
+
+	assert.Equal(t, http.StatusCreated, entry.Response.Status)
+	assert.Equal(t, "REDACTED", Header(entry.Response.Headers).Get("Set-Cookie"))
+	assert.Equal(t, "got ", entry.Response.Content.Text)
+	assert.Equal(t, int64(9), entry.Response.Content.Size)
+	assert.True(t, entry.Response.Content.Truncated)
+}
+
+// Test only the sampled fraction of requests is recorded
+func TestRecorder_Sample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	rolls := []float64{0.05, 0.5, 0.09, 0.99}
+	r, err := Open(path, "jsonl", WithSample(0.1), WithRoll(func() float64 {
+		roll := rolls[0]
+		rolls = rolls[1:]
+		return roll
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := 0
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { served++ }))
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	assert.NoError(t, r.Close())
+
+	assert.Equal(t, 4, served)
+	entries := readLines(t, path)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "http://example.com/a", entries[0].Request.URL)
+		assert.Equal(t, "http://example.com/c", entries[1].Request.URL)
+	}
+}
+
+// Test the HAR file is a complete document once closed, with binary bodies base64 encoded
+func TestRecorder_HAR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	r, err := Open(path, "har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/logo.png", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodHead, "/logo.png", nil))
+	assert.NoError(t, r.Close())
+	assert.ErrorIs(t, r.Record(&Entry{}), os.ErrClosed)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har struct {
+		Log struct {
+			Version string  `json:"version"`
+			Entries []Entry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("invalid HAR: %v", err)
+	}
+	assert.Equal(t, "1.2", har.Log.Version)
+	if assert.Len(t, har.Log.Entries, 2) {
+		content := har.Log.Entries[0].Response.Content
+		assert.Equal(t, "base64", content.Encoding)
+		assert.Equal(t, "iVBORw==", content.Text)
+		assert.Equal(t, "HEAD", har.Log.Entries[1].Request.Method)
+	}
+}
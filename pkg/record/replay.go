@@ -0,0 +1,249 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Read parses a recording, either a HAR document or JSON lines of entries, and returns its entries
+// in the order they started.
+func Read(r io.Reader) ([]Entry, error) {
+	br := bufio.NewReader(r)
+	var entries []Entry
+	if first, err := peekNonSpace(br); err != nil {
+		return nil, err
+	} else if first == '{' && isHAR(br) {
+		var har struct {
+			Log struct {
+				Entries []Entry `json:"entries"`
+			} `json:"log"`
+		}
+		if err := json.NewDecoder(br).Decode(&har); err != nil {
+			return nil, fmt.Errorf("har: %w", err)
+		}
+		entries = har.Log.Entries
+	} else {
+		dec := json.NewDecoder(br)
+		for line := 1; ; line++ {
+			var entry Entry
+			if err := dec.Decode(&entry); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", line, err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return a.StartedDateTime.Compare(b.StartedDateTime)
+	})
+	return entries, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0], nil
+		}
+		br.ReadByte()
+	}
+}
+
+// isHAR tells a HAR document, whose top-level key is "log", from a line of entries.
+func isHAR(br *bufio.Reader) bool {
+	head, _ := br.Peek(64)
+	key, _, _ := strings.Cut(strings.TrimLeft(string(head[1:]), " \t\r\n"), ":")
+	return strings.TrimSpace(key) == `"log"`
+}
+
+// ReplayResult summarizes a replay run. A request matches when it is answered with the recorded
+// status.
+type ReplayResult struct {
+	Total      int           `json:"total"`
+	Matched    int           `json:"matched"`
+	Mismatched []string      `json:"mismatched"`
+	Elapsed    time.Duration `json:"elapsed"`
+}
+
+type replayer struct {
+	speed       float64
+	concurrency int
+}
+
+type ReplayOption func(*replayer)
+
+// WithSpeed replays at speed times the recorded pace, 2 halves the gaps between requests. 0, the
+// default, sends requests as fast as the concurrency allows.
+func WithSpeed(speed float64) ReplayOption {
+	return func(r *replayer) {
+		r.speed = speed
+	}
+}
+
+// WithConcurrency bounds the requests in flight, 10 by default.
+func WithConcurrency(concurrency int) ReplayOption {
+	return func(r *replayer) {
+		r.concurrency = concurrency
+	}
+}
+
+// Replay re-issues entries through handler, normally the full proxy handler so routing,
+// middleware and caching apply as for client traffic. Redacted headers are left out and truncated
+// bodies are sent as recorded.
+func Replay(ctx context.Context, handler http.Handler, entries []Entry, opts ...ReplayOption) ReplayResult {
+	r := &replayer{concurrency: 10}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	result := ReplayResult{Total: len(entries), Mismatched: []string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(r.concurrency, 1))
+	start := time.Now()
+
+	for i := range entries {
+		entry := &entries[i]
+		if err := r.wait(ctx, start, entry.StartedDateTime.Sub(entries[0].StartedDateTime)); err != nil {
+			mu.Lock()
+			result.Mismatched = append(result.Mismatched, describe(entry)+": "+err.Error())
+			mu.Unlock()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			result.Mismatched = append(result.Mismatched, describe(entry)+": "+ctx.Err().Error())
+			mu.Unlock()
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			err := replayOne(ctx, handler, entry)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Mismatched = append(result.Mismatched, describe(entry)+": "+err.Error())
+			} else {
+				result.Matched++
+			}
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+	return result
+}
+
+// wait sleeps until offset, scaled by the speed, has passed since start.
+func (r *replayer) wait(ctx context.Context, start time.Time, offset time.Duration) error {
+	if r.speed <= 0 {
+		return nil
+	}
+	delay := time.Until(start.Add(time.Duration(float64(offset) / r.speed)))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func describe(entry *Entry) string {
+	return entry.Request.Method + " " + entry.Request.URL
+}
+
+func replayOne(ctx context.Context, handler http.Handler, entry *Entry) error {
+	req, err := NewRequest(ctx, entry)
+	if err != nil {
+		return err
+	}
+	w := &statusResponseWriter{header: http.Header{}}
+	handler.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status != entry.Response.Status {
+		return fmt.Errorf("status %d, recorded %d", w.status, entry.Response.Status)
+	}
+	return nil
+}
+
+// NewRequest rebuilds the recorded request, shaped like a server-side request so it can be served
+// by a handler directly.
+func NewRequest(ctx context.Context, entry *Entry) (*http.Request, error) {
+	u, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if postData := entry.Request.PostData; postData != nil {
+		if postData.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(postData.Text); err != nil {
+				return nil, fmt.Errorf("request body: %w", err)
+			}
+		} else {
+			body = []byte(postData.Text)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, entry.Request.Method, u.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Host = u.Host
+	req.RequestURI = u.RequestURI()
+	if u.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{}
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	for _, nv := range entry.Request.Headers {
+		if nv.Value != redacted {
+			req.Header.Add(nv.Name, nv.Value)
+		}
+	}
+	req.Header.Del("Content-Length")
+	return req, nil
+}
+
+// statusResponseWriter records the status and drops the body.
+type statusResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *statusResponseWriter) Header() http.Header { return w.header }
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *statusResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
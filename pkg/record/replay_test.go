@@ -0,0 +1,103 @@
+package record
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test recorded traffic reads back from both formats and replays with bodies and headers
+func TestReplay(t *testing.T) {
+	for _, format := range []string{"jsonl", "har"} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "traffic."+format)
+			r, err := Open(path, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				io.Copy(io.Discard, req.Body)
+				if req.URL.Path == "/missing" {
+					http.NotFound(w, req)
+				}
+			}))
+			post := httptest.NewRequest(http.MethodPost, "https://api.example.com/orders?id=1", strings.NewReader(`{"sku":"a"}`))
+			post.Header.Set("Content-Type", "application/json")
+			post.Header.Set("Authorization", "Bearer secret")
+			for _, req := range []*http.Request{post, httptest.NewRequest(http.MethodGet, "/missing", nil), httptest.NewRequest(http.MethodGet, "/gone", nil)} {
+				recorded.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			assert.NoError(t, r.Close())
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			entries, err := Read(f)
+			if err != nil || !assert.Len(t, entries, 3) {
+				t.Fatalf("read: %v", err)
+			}
+
+			var mu sync.Mutex
+			replayed := map[string]string{}
+			var seen *http.Request
+			result := Replay(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				mu.Lock()
+				defer mu.Unlock()
+				replayed[req.Method+" "+req.Host+req.URL.RequestURI()] = string(body)
+				if req.Method == http.MethodPost {
+					seen = req
+				}
+				if req.URL.Path == "/gone" {
+					w.WriteHeader(http.StatusGone)
+				}
+			}), entries, WithConcurrency(2))
+
+			assert.Equal(t, map[string]string{
+				"POST api.example.com/orders?id=1": `{"sku":"a"}`,
+				"GET example.com/missing":          "",
+				"GET example.com/gone":             "",
+			}, replayed)
+			assert.NotNil(t, seen.TLS)
+			assert.Equal(t, "application/json", seen.Header.Get("Content-Type"))
+			assert.Empty(t, seen.Header.Get("Authorization"))
+			assert.Equal(t, 3, result.Total)
+			assert.Equal(t, 1, result.Matched)
+			slices.Sort(result.Mismatched)
+			assert.Equal(t, []string{"GET http://example.com/gone: status 410, recorded 200", "GET http://example.com/missing: status 200, recorded 404"}, result.Mismatched)
+		})
+	}
+}
+
+// Test replays keep the recorded pace scaled by the speed
+func TestReplay_Speed(t *testing.T) {
+	start := time.Now()
+	entries := []Entry{
+		{StartedDateTime: start, Request: Request{Method: "GET", URL: "http://example.com/a"}, Response: Response{Status: 200}},
+		{StartedDateTime: start.Add(200 * time.Millisecond), Request: Request{Method: "GET", URL: "http://example.com/b"}, Response: Response{Status: 200}},
+	}
+	var mu sync.Mutex
+	var paths []string
+	result := Replay(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		paths = append(paths, req.URL.Path)
+		mu.Unlock()
+	}), entries, WithSpeed(2))
+
+	assert.Equal(t, []string{"/a", "/b"}, paths)
+	assert.Equal(t, 2, result.Matched)
+	assert.GreaterOrEqual(t, result.Elapsed, 100*time.Millisecond)
+	assert.Less(t, result.Elapsed, 200*time.Millisecond)
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ashpect/revproxy/pkg/experiment"
+	"github.com/ashpect/revproxy/pkg/geoip"
+)
+
+// Route dispatches requests matching its conditions to Handler. Empty conditions match anything.
+type Route struct {
+	Name       string
+	Host       string
+	PathPrefix string
+	// Countries matches clients resolved to any of these ISO codes by the GeoIP middleware
+	Countries []string
+	// Experiments matches visitors assigned to the given bucket of each experiment
+	Experiments map[string]string
+	// Query matches requests carrying each parameter, with the given value unless it is empty
+	Query   map[string]string
+	Handler http.Handler
+}
+
+// Matches reports whether r satisfies every condition of the route.
+func (rt Route) Matches(r *http.Request) bool {
+	if rt.Host != "" && !strings.EqualFold(hostname(r.Host), rt.Host) {
+		return false
+	}
+	if rt.PathPrefix != "" && !hasPathPrefix(r.URL.Path, rt.PathPrefix) {
+		return false
+	}
+	if len(rt.Countries) > 0 && !slices.ContainsFunc(rt.Countries, func(c string) bool {
+		return strings.EqualFold(c, geoip.FromContext(r.Context()))
+	}) {
+		return false
+	}
+	if len(rt.Query) > 0 {
+		query := r.URL.Query()
+		for name, value := range rt.Query {
+			values, ok := query[name]
+			if !ok || (value != "" && !slices.Contains(values, value)) {
+				return false
+			}
+		}
+	}
+	for name, bucket := range rt.Experiments {
+		if experiment.FromContext(r.Context(), name) != bucket {
+			return false
+		}
+	}
+	return true
+}
+
+type router struct {
+	routes   []Route
+	fallback http.Handler
+}
+
+type RouterOption func(*router)
+
+// WithRoute appends a route. Routes are tried in the order they were added, first match wins.
+func WithRoute(route Route) RouterOption {
+	return func(rt *router) {
+		rt.routes = append(rt.routes, route)
+	}
+}
+
+// WithFallback serves requests no route matched. Without it they get a 404.
+func WithFallback(handler http.Handler) RouterOption {
+	return func(rt *router) {
+		rt.fallback = handler
+	}
+}
+
+func NewRouter(opts ...RouterOption) *router {
+	rt := &router{}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	return rt
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.routes {
+		if route.Matches(r) {
+			route.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if rt.fallback != nil {
+		rt.fallback.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// hostname strips the port from a Host header value.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// hasPathPrefix matches prefix on path segment boundaries, so /api matches /api and /api/x but not /apix.
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ashpect/revproxy/pkg/experiment"
+	"github.com/ashpect/revproxy/pkg/geoip"
+	"github.com/stretchr/testify/assert"
+)
+
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+// Test routes are matched in order on host and path segment prefix
+func TestRouter_match(t *testing.T) {
+	rt := NewRouter(
+		WithRoute(Route{Name: "admin-api", Host: "admin.example.com", PathPrefix: "/api", Handler: named("admin-api")}),
+		WithRoute(Route{Name: "api", PathPrefix: "/api/", Handler: named("api")}),
+		WithFallback(named("fallback")),
+	)
+
+	cases := map[string]string{
+		"http://admin.example.com:8000/api/users": "admin-api",
+		"http://www.example.com/api/users":        "api",
+		"http://www.example.com/api":              "api",
+		"http://www.example.com/apix":             "fallback",
+		"http://admin.example.com/":               "fallback",
+	}
+	for target, want := range cases {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, want, rec.Body.String(), target)
+	}
+}
+
+// Test country conditions match the country stored by the GeoIP middleware
+func TestRouter_countries(t *testing.T) {
+	rt := NewRouter(
+		WithRoute(Route{Name: "eu", Countries: []string{"DE", "fr"}, Handler: named("eu")}),
+		WithFallback(named("fallback")),
+	)
+
+	cases := map[string]string{"DE": "eu", "FR": "eu", "US": "fallback", "": "fallback"}
+	for country, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(geoip.NewContext(req.Context(), country))
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		assert.Equal(t, want, rec.Body.String(), country)
+	}
+}
+
+// Test experiment conditions match the bucket assigned by the Experiment middleware
+func TestRouter_experiments(t *testing.T) {
+	rt := NewRouter(
+		WithRoute(Route{Name: "new-checkout", Experiments: map[string]string{"checkout": "new"}, Handler: named("new")}),
+		WithFallback(named("fallback")),
+	)
+
+	cases := map[string]string{"new": "new", "control": "fallback", "": "fallback"}
+	for bucket, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if bucket != "" {
+			req = req.WithContext(experiment.NewContext(req.Context(), "checkout", bucket))
+		}
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		assert.Equal(t, want, rec.Body.String(), bucket)
+	}
+}
+
+// Test query conditions match on presence or on one of the parameter's values
+func TestRouter_query(t *testing.T) {
+	rt := NewRouter(
+		WithRoute(Route{Name: "preview", Query: map[string]string{"preview": "true"}, Handler: named("preview")}),
+		WithRoute(Route{Name: "debug", Query: map[string]string{"debug": ""}, Handler: named("debug")}),
+		WithFallback(named("fallback")),
+	)
+
+	cases := map[string]string{
+		"http://example.com/?preview=true":               "preview",
+		"http://example.com/?preview=false&preview=true": "preview",
+		"http://example.com/?preview=false":              "fallback",
+		"http://example.com/?debug":                      "debug",
+		"http://example.com/?debug=1":                    "debug",
+		"http://example.com/":                            "fallback",
+	}
+	for target, want := range cases {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, want, rec.Body.String(), target)
+	}
+}
+
+// Test unmatched requests 404 without a fallback
+func TestRouter_notFound(t *testing.T) {
+	rt := NewRouter(WithRoute(Route{PathPrefix: "/api", Handler: named("api")}))
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
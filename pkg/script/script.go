@@ -0,0 +1,269 @@
+// Package script runs Lua hooks on proxied traffic, so custom logic can be deployed without
+// recompiling revproxy. A script defines any of these global functions:
+//
+//	function on_route(req)             -- before routing, may answer: return status, body[, headers]
+//	function on_upstream_request(req)  -- on the request about to be sent upstream
+//	function on_upstream_response(resp) -- on the upstream response, before caching
+//
+// req has method, host, path, query, remote_addr and headers, resp has status and headers. Header
+// values are strings, or lists of strings for repeated headers. Changes to the tables are applied
+// back, a header set to nil is removed.
+package script
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	HookRoute            = "on_route"
+	HookUpstreamRequest  = "on_upstream_request"
+	HookUpstreamResponse = "on_upstream_response"
+)
+
+// Script is a compiled Lua script. Hooks run on a pool of interpreters, one per concurrent call.
+type Script struct {
+	name    string
+	proto   *lua.FunctionProto
+	timeout time.Duration
+	hooks   map[string]bool
+	states  sync.Pool
+}
+
+type Option func(*Script)
+
+// WithTimeout bounds each hook call, 0 leaves only the request's own deadline.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Script) {
+		s.timeout = timeout
+	}
+}
+
+// Load compiles the script at path and runs it once to find the hooks it defines.
+func Load(path string, opts ...Option) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunk, err := parse.Parse(f, path)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Script{name: path, proto: proto, timeout: 100 * time.Millisecond, hooks: map[string]bool{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	L, err := s.newState()
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range []string{HookRoute, HookUpstreamRequest, HookUpstreamResponse} {
+		s.hooks[hook] = L.GetGlobal(hook).Type() == lua.LTFunction
+	}
+	s.states.Put(L)
+	return s, nil
+}
+
+// Has reports whether the script defines hook.
+func (s *Script) Has(hook string) bool {
+	return s.hooks[hook]
+}
+
+// newState starts an interpreter with the script's globals defined.
+func (s *Script) newState() (*lua.LState, error) {
+	L := lua.NewState()
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	L.SetTop(0)
+	return L, nil
+}
+
+// call runs hook with the table arg builds and hands the table and nret return values to result.
+func (s *Script) call(ctx context.Context, hook string, nret int, arg func(L *lua.LState) *lua.LTable, result func(t *lua.LTable, ret []lua.LValue)) error {
+	L, _ := s.states.Get().(*lua.LState)
+	if L == nil {
+		var err error
+		if L, err = s.newState(); err != nil {
+			return err
+		}
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	L.SetContext(ctx)
+
+	t := arg(L)
+	if err := L.CallByParam(lua.P{Fn: L.GetGlobal(hook), NRet: nret, Protect: true}, t); err != nil {
+		// an interrupted interpreter may be left mid-call, don't reuse it
+		L.Close()
+		return fmt.Errorf("%s %s: %w", s.name, hook, err)
+	}
+	ret := make([]lua.LValue, nret)
+	for i := range ret {
+		ret[i] = L.Get(i - nret)
+	}
+	L.Pop(nret)
+	L.RemoveContext()
+	s.states.Put(L)
+
+	result(t, ret)
+	return nil
+}
+
+// Middleware runs on_route before next, answering the request itself when the hook returns a
+// status. A failing hook answers 500.
+func (s *Script) Middleware(next http.Handler) http.Handler {
+	if !s.Has(HookRoute) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status int
+		var body string
+		err := s.call(r.Context(), HookRoute, 3, func(L *lua.LState) *lua.LTable {
+			return requestTable(L, r)
+		}, func(t *lua.LTable, ret []lua.LValue) {
+			applyRequest(t, r)
+			if n, ok := ret[0].(lua.LNumber); ok {
+				status, body = int(n), lua.LVAsString(ret[1])
+				if headers, ok := ret[2].(*lua.LTable); ok {
+					applyHeader(headers, w.Header())
+				}
+			}
+		})
+		if err != nil {
+			log.Printf("script: %v", err)
+			http.Error(w, "script error", http.StatusInternalServerError)
+			return
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RewriteRequest runs on_upstream_request on an outgoing request, for proxy.WithRewriteRequest.
+// Failures are logged and leave the request as it was.
+func (s *Script) RewriteRequest(r *http.Request) {
+	if !s.Has(HookUpstreamRequest) {
+		return
+	}
+	err := s.call(r.Context(), HookUpstreamRequest, 0, func(L *lua.LState) *lua.LTable {
+		return requestTable(L, r)
+	}, func(t *lua.LTable, _ []lua.LValue) {
+		applyRequest(t, r)
+	})
+	if err != nil {
+		log.Printf("script: %v", err)
+	}
+}
+
+// ModifyResponse runs on_upstream_response, for proxy.WithModifyResponse.
+func (s *Script) ModifyResponse(resp *http.Response) error {
+	if !s.Has(HookUpstreamResponse) {
+		return nil
+	}
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	return s.call(ctx, HookUpstreamResponse, 0, func(L *lua.LState) *lua.LTable {
+		t := L.NewTable()
+		t.RawSetString("status", lua.LNumber(resp.StatusCode))
+		t.RawSetString("headers", headerTable(L, resp.Header))
+		return t
+	}, func(t *lua.LTable, _ []lua.LValue) {
+		if n, ok := t.RawGetString("status").(lua.LNumber); ok && int(n) != resp.StatusCode {
+			resp.StatusCode = int(n)
+			resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+		if headers, ok := t.RawGetString("headers").(*lua.LTable); ok {
+			resp.Header = http.Header{}
+			applyHeader(headers, resp.Header)
+		}
+	})
+}
+
+func requestTable(L *lua.LState, r *http.Request) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("method", lua.LString(r.Method))
+	t.RawSetString("host", lua.LString(r.Host))
+	t.RawSetString("path", lua.LString(r.URL.Path))
+	t.RawSetString("query", lua.LString(r.URL.RawQuery))
+	t.RawSetString("remote_addr", lua.LString(r.RemoteAddr))
+	t.RawSetString("headers", headerTable(L, r.Header))
+	return t
+}
+
+// applyRequest copies the editable fields of t back to r.
+func applyRequest(t *lua.LTable, r *http.Request) {
+	if method := lua.LVAsString(t.RawGetString("method")); method != "" {
+		r.Method = strings.ToUpper(method)
+	}
+	if host := lua.LVAsString(t.RawGetString("host")); host != "" {
+		r.Host = host
+	}
+	if path := lua.LVAsString(t.RawGetString("path")); path != r.URL.Path {
+		r.URL.Path, r.URL.RawPath = path, ""
+	}
+	r.URL.RawQuery = lua.LVAsString(t.RawGetString("query"))
+	if headers, ok := t.RawGetString("headers").(*lua.LTable); ok {
+		for key := range r.Header {
+			delete(r.Header, key)
+		}
+		applyHeader(headers, r.Header)
+	}
+}
+
+func headerTable(L *lua.LState, header http.Header) *lua.LTable {
+	t := L.NewTable()
+	for key, values := range header {
+		if len(values) == 1 {
+			t.RawSetString(key, lua.LString(values[0]))
+			continue
+		}
+		list := L.NewTable()
+		for _, value := range values {
+			list.Append(lua.LString(value))
+		}
+		t.RawSetString(key, list)
+	}
+	return t
+}
+
+// applyHeader adds the headers of t to header, names are canonicalized.
+func applyHeader(t *lua.LTable, header http.Header) {
+	t.ForEach(func(key, value lua.LValue) {
+		name := lua.LVAsString(key)
+		switch value := value.(type) {
+		case lua.LString, lua.LNumber:
+			header.Add(name, lua.LVAsString(value))
+		case *lua.LTable:
+			value.ForEach(func(_, v lua.LValue) {
+				header.Add(name, lua.LVAsString(v))
+			})
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package script
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func load(t *testing.T, source string, opts ...Option) *Script {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hooks.lua")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path, opts...)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return s
+}
+
+// Test on_route can rewrite the request or answer it
+func TestScript_Middleware(t *testing.T) {
+	s := load(t, `
+function on_route(req)
+  if req.path == "/blocked" then
+    return 403, "blocked", {["X-Reason"] = "script"}
+  end
+  req.path = "/v2" .. req.path
+  req.headers["X-Team"] = nil
+  req.headers["X-Route"] = "script"
+end`)
+	assert.True(t, s.Has(HookRoute))
+	assert.False(t, s.Has(HookUpstreamRequest))
+
+	var seen *http.Request
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r }))
+
+	req := httptest.NewRequest(http.MethodGet, "/users?id=1", nil)
+	req.Header.Set("X-Team", "a")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "/v2/users", seen.URL.Path)
+	assert.Equal(t, "id=1", seen.URL.RawQuery)
+	assert.Empty(t, seen.Header.Get("X-Team"))
+	assert.Equal(t, "script", seen.Header.Get("X-Route"))
+
+	seen = nil
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blocked", nil))
+	assert.Nil(t, seen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "blocked", rec.Body.String())
+	assert.Equal(t, "script", rec.Header().Get("X-Reason"))
+}
+
+// Test upstream hooks edit the outgoing request and the response
+func TestScript_UpstreamHooks(t *testing.T) {
+	s := load(t, `
+function on_upstream_request(req)
+  req.headers["Authorization"] = "Bearer token"
+end
+function on_upstream_response(resp)
+  if resp.status == 500 then resp.status = 503 end
+  resp.headers["Set-Cookie"] = {"a=1", "b=2"}
+end`)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	s.RewriteRequest(req)
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	resp := &http.Response{StatusCode: 500, Header: http.Header{}, Request: req}
+	assert.NoError(t, s.ModifyResponse(resp))
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+}
+
+// Test a runaway hook is stopped by the timeout and answered with a 500
+func TestScript_Timeout(t *testing.T) {
+	s := load(t, `function on_route(req) while true do end end`, WithTimeout(20*time.Millisecond))
+	rec := httptest.NewRecorder()
+	s.Middleware(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
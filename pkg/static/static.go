@@ -0,0 +1,121 @@
+package static
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const defaultIndex = "index.html"
+
+// handler serves files below root. Directories serve their index file, and with spa set,
+// paths that match no file serve the root index so client-side routers can handle them.
+type handler struct {
+	root     http.FileSystem
+	index    string
+	spa      bool
+	dotfiles bool
+}
+
+type Option func(*handler)
+
+// WithIndex sets the file served for directory requests, index.html by default.
+func WithIndex(index string) Option {
+	return func(h *handler) {
+		h.index = index
+	}
+}
+
+// WithSPAFallback serves the root index for GET/HEAD requests matching no file, except for paths
+// with an extension, which most likely are missing assets and keep their 404.
+func WithSPAFallback(spa bool) Option {
+	return func(h *handler) {
+		h.spa = spa
+	}
+}
+
+// WithDotfiles serves paths with a segment starting with ".", such as .git/ or .env, which are
+// refused with a 404 by default.
+func WithDotfiles(dotfiles bool) Option {
+	return func(h *handler) {
+		h.dotfiles = dotfiles
+	}
+}
+
+func NewHandler(root string, opts ...Option) http.Handler {
+	h := &handler{root: http.Dir(root), index: defaultIndex}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if !h.dotfiles && strings.Contains(name, "/.") {
+		http.NotFound(w, r)
+		return
+	}
+	err := h.serve(w, r, name)
+	if errors.Is(err, fs.ErrNotExist) && h.spa && path.Ext(name) == "" {
+		err = h.serve(w, r, "/"+h.index)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// serve writes the file at name, or the index of the directory at name. Nothing is written when
+// an error is returned. Content types come from the extension (sniffed when unknown), and
+// ranges and conditional requests are handled by http.ServeContent.
+func (h *handler) serve(w http.ResponseWriter, r *http.Request, name string) error {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		// relative links in the index resolve against the directory, so it needs the slash
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return nil
+		}
+		index, err := h.root.Open(path.Join(name, h.index))
+		if err != nil {
+			return err
+		}
+		defer index.Close()
+		if info, err = index.Stat(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fs.ErrNotExist
+		}
+		f = index
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return nil
+}
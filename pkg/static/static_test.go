@@ -0,0 +1,80 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRoot(t *testing.T) string {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "index.html"), []byte("<html>app</html>"), 0o644))
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "docs", "index.html"), []byte("<html>docs</html>"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(1)"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, ".env"), []byte("SECRET=1"), 0o644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, ".git", "config"), []byte("[core]"), 0o644))
+	return root
+}
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// Test files, index files and content types are served, and traversal stays in the root
+func TestHandler_serve(t *testing.T) {
+	h := NewHandler(testRoot(t))
+
+	rec := get(h, "/app.js")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/javascript; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = get(h, "/docs/")
+	assert.Equal(t, "<html>docs</html>", rec.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = get(h, "/docs?v=1")
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "/docs/?v=1", rec.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusNotFound, get(h, "/users/42").Code)
+	assert.Equal(t, http.StatusNotFound, get(h, "/../../etc/passwd").Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/app.js", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// Test the SPA fallback serves the root index for client-side routes but not missing assets
+func TestHandler_spaFallback(t *testing.T) {
+	h := NewHandler(testRoot(t), WithSPAFallback(true))
+
+	rec := get(h, "/users/42")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<html>app</html>", rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get(h, "/missing.css").Code)
+	assert.Equal(t, "<html>docs</html>", get(h, "/docs/").Body.String())
+}
+
+// Test dotfiles and dot directories are refused unless enabled, the SPA fallback included
+func TestHandler_dotfiles(t *testing.T) {
+	root := testRoot(t)
+	h := NewHandler(root, WithSPAFallback(true))
+	for _, target := range []string{"/.env", "/.git/config", "/.git/", "/docs/../.env", "/.git/missing"} {
+		assert.Equal(t, http.StatusNotFound, get(h, target).Code, target)
+	}
+	assert.Equal(t, http.StatusOK, get(h, "/app.js").Code)
+
+	h = NewHandler(root, WithDotfiles(true))
+	rec := get(h, "/.git/config")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[core]", rec.Body.String())
+}
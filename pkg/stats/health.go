@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// downAfter is the number of consecutive failed exchanges taking a backend down, a successful
+// one brings it back up.
+const downAfter = 3
+
+// health is a backend's state as seen from the traffic to it.
+type health struct {
+	mu       sync.Mutex
+	down     bool
+	failures int
+	since    time.Time
+}
+
+func (h *health) exchange(failed bool, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.since.IsZero() {
+		h.since = now
+	}
+	if !failed {
+		h.failures = 0
+		if h.down {
+			h.down, h.since = false, now
+		}
+		return
+	}
+	h.failures++
+	if !h.down && h.failures >= downAfter {
+		h.down, h.since = true, now
+	}
+}
+
+// UpstreamHealth is a backend's health. State is "up" or "down", down after 3 consecutive failed
+// exchanges (no response or a 5xx) until one succeeds.
+type UpstreamHealth struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	// Since is when the backend entered State, or was first used
+	Since time.Time `json:"since"`
+	// InState is the time since Since, in seconds
+	InState float64 `json:"inStateSeconds"`
+}
+
+// Health returns the health of the backends by address.
+func (c *Counters) Health() map[string]UpstreamHealth {
+	now := time.Now()
+	backends := map[string]UpstreamHealth{}
+	c.upstreams.Range(func(key, value any) bool {
+		h := &value.(*upstreamCounters).health
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		state := "up"
+		if h.down {
+			state = "down"
+		}
+		backends[key.(string)] = UpstreamHealth{
+			State:               state,
+			ConsecutiveFailures: h.failures,
+			Since:               h.since,
+			InState:             now.Sub(h.since).Seconds(),
+		}
+		return true
+	})
+	return backends
+}
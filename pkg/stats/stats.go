@@ -0,0 +1,168 @@
+// Package stats keeps live traffic counters, cheap enough to update on every request, for the
+// admin stats stream. Rates are computed from the difference of two snapshots.
+package stats
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/proxy"
+)
+
+// Counters counts requests, connections, cache lookups and upstream exchanges. The zero value is
+// ready to use and its methods are safe for concurrent use.
+type Counters struct {
+	requests    atomic.Uint64
+	active      atomic.Int64
+	connections atomic.Int64
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	// upstreams are *upstreamCounters by backend address
+	upstreams sync.Map
+}
+
+type upstreamCounters struct {
+	requests atomic.Uint64
+	errors   atomic.Uint64
+	health   health
+}
+
+// Middleware counts the requests passing through it.
+func (c *Counters) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.requests.Add(1)
+		c.active.Add(1)
+		defer c.active.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConnState counts the open client connections, set it as the http.Server's ConnState.
+func (c *Counters) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		c.connections.Add(-1)
+	}
+}
+
+// CacheLookup implements proxy.Observer, stale responses served count as hits.
+func (c *Counters) CacheLookup(_ *http.Request, result proxy.CacheResult) {
+	if result == proxy.CacheMiss {
+		c.cacheMisses.Add(1)
+	} else {
+		c.cacheHits.Add(1)
+	}
+}
+
+// UpstreamExchange implements proxy.Observer, counting the exchanges and failures by backend.
+func (c *Counters) UpstreamExchange(_ *http.Request, upstream *url.URL, status int, err error, _ time.Duration) {
+	counters, ok := c.upstreams.Load(upstream.Host)
+	if !ok {
+		counters, _ = c.upstreams.LoadOrStore(upstream.Host, &upstreamCounters{})
+	}
+	u := counters.(*upstreamCounters)
+	u.requests.Add(1)
+	failed := err != nil || status >= http.StatusInternalServerError
+	if failed {
+		u.errors.Add(1)
+	}
+	u.health.exchange(failed, time.Now())
+}
+
+// Snapshot is the counters at one point in time.
+type Snapshot struct {
+	Time              time.Time `json:"time"`
+	Requests          uint64    `json:"requests"`
+	ActiveRequests    int64     `json:"activeRequests"`
+	ActiveConnections int64     `json:"activeConnections"`
+	CacheHits         uint64    `json:"cacheHits"`
+	CacheMisses       uint64    `json:"cacheMisses"`
+	// Upstreams are the exchanges by backend address
+	Upstreams map[string]UpstreamSnapshot `json:"upstreams"`
+}
+
+type UpstreamSnapshot struct {
+	Requests uint64 `json:"requests"`
+	// Errors are the exchanges failing without a response or answered with a 5xx
+	Errors uint64 `json:"errors"`
+}
+
+// Snapshot reads the counters.
+func (c *Counters) Snapshot() Snapshot {
+	s := Snapshot{
+		Time:              time.Now(),
+		Requests:          c.requests.Load(),
+		ActiveRequests:    c.active.Load(),
+		ActiveConnections: c.connections.Load(),
+		CacheHits:         c.cacheHits.Load(),
+		CacheMisses:       c.cacheMisses.Load(),
+		Upstreams:         map[string]UpstreamSnapshot{},
+	}
+	c.upstreams.Range(func(key, value any) bool {
+		u := value.(*upstreamCounters)
+		s.Upstreams[key.(string)] = UpstreamSnapshot{Requests: u.requests.Load(), Errors: u.errors.Load()}
+		return true
+	})
+	return s
+}
+
+// Rates is the traffic between two snapshots.
+type Rates struct {
+	Time              time.Time `json:"time"`
+	RPS               float64   `json:"rps"`
+	ActiveRequests    int64     `json:"activeRequests"`
+	ActiveConnections int64     `json:"activeConnections"`
+	// CacheHitRatio is the share of cache lookups answered from the cache, 0 without lookups
+	CacheHitRatio float64                  `json:"cacheHitRatio"`
+	Upstreams     map[string]UpstreamRates `json:"upstreams"`
+}
+
+// UpstreamRates is a backend's traffic between two snapshots. Health is "up" when some exchanges
+// succeeded, "down" when all failed and "idle" without exchanges.
+type UpstreamRates struct {
+	RPS        float64 `json:"rps"`
+	ErrorRatio float64 `json:"errorRatio"`
+	Health     string  `json:"health"`
+}
+
+// Since returns the rates from prev to s.
+func (s Snapshot) Since(prev Snapshot) Rates {
+	seconds := s.Time.Sub(prev.Time).Seconds()
+	perSecond := func(n uint64) float64 {
+		if seconds <= 0 {
+			return 0
+		}
+		return float64(n) / seconds
+	}
+	rates := Rates{
+		Time:              s.Time,
+		RPS:               perSecond(s.Requests - prev.Requests),
+		ActiveRequests:    s.ActiveRequests,
+		ActiveConnections: s.ActiveConnections,
+		Upstreams:         map[string]UpstreamRates{},
+	}
+	hits, misses := s.CacheHits-prev.CacheHits, s.CacheMisses-prev.CacheMisses
+	if hits+misses > 0 {
+		rates.CacheHitRatio = float64(hits) / float64(hits+misses)
+	}
+	for host, cur := range s.Upstreams {
+		old := prev.Upstreams[host]
+		requests, errors := cur.Requests-old.Requests, cur.Errors-old.Errors
+		u := UpstreamRates{RPS: perSecond(requests), Health: "idle"}
+		if requests > 0 {
+			u.ErrorRatio = float64(errors) / float64(requests)
+			u.Health = "up"
+			if errors == requests {
+				u.Health = "down"
+			}
+		}
+		rates.Upstreams[host] = u
+	}
+	return rates
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test rates are computed from the counters' difference between two snapshots
+func TestSnapshot_Since(t *testing.T) {
+	var c Counters
+	var _ proxy.Observer = &c
+	up, _ := url.Parse("http://10.0.0.1:8080")
+	down, _ := url.Parse("http://10.0.0.2:8080")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	c.UpstreamExchange(r, up, http.StatusOK, nil, time.Millisecond)
+	prev := c.Snapshot()
+	prev.Time = time.Now().Add(-2 * time.Second)
+
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, int64(1), c.Snapshot().ActiveRequests)
+	}))
+	for range 4 {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	c.CacheLookup(r, proxy.CacheHit)
+	c.CacheLookup(r, proxy.CacheStale)
+	c.CacheLookup(r, proxy.CacheHit)
+	c.CacheLookup(r, proxy.CacheMiss)
+	c.UpstreamExchange(r, up, http.StatusOK, nil, time.Millisecond)
+	c.UpstreamExchange(r, up, http.StatusBadGateway, nil, time.Millisecond)
+	c.UpstreamExchange(r, down, 0, errors.New("connection refused"), time.Millisecond)
+
+	rates := c.Snapshot().Since(prev)
+	assert.InDelta(t, 2, rates.RPS, 0.1)
+	assert.Zero(t, rates.ActiveRequests)
+	assert.Equal(t, 0.75, rates.CacheHitRatio)
+	assert.Equal(t, "up", rates.Upstreams["10.0.0.1:8080"].Health)
+	assert.Equal(t, 0.5, rates.Upstreams["10.0.0.1:8080"].ErrorRatio)
+	assert.Equal(t, "down", rates.Upstreams["10.0.0.2:8080"].Health)
+
+	assert.Equal(t, "idle", c.Snapshot().Since(c.Snapshot()).Upstreams["10.0.0.1:8080"].Health)
+}
+
+// Test a backend goes down after consecutive failures and back up on a success
+func TestCounters_Health(t *testing.T) {
+	var c Counters
+	backend, _ := url.Parse("http://10.0.0.1:8080")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	exchange := func(status int) {
+		c.UpstreamExchange(r, backend, status, nil, time.Millisecond)
+	}
+
+	exchange(http.StatusOK)
+	exchange(http.StatusBadGateway)
+	exchange(http.StatusServiceUnavailable)
+	h := c.Health()["10.0.0.1:8080"]
+	assert.Equal(t, "up", h.State)
+	assert.Equal(t, 2, h.ConsecutiveFailures)
+	upSince := h.Since
+
+	c.UpstreamExchange(r, backend, 0, errors.New("connection refused"), time.Millisecond)
+	h = c.Health()["10.0.0.1:8080"]
+	assert.Equal(t, "down", h.State)
+	assert.Equal(t, 3, h.ConsecutiveFailures)
+	assert.True(t, h.Since.After(upSince))
+	assert.GreaterOrEqual(t, h.InState, 0.0)
+
+	exchange(http.StatusNotFound)
+	h = c.Health()["10.0.0.1:8080"]
+	assert.Equal(t, "up", h.State)
+	assert.Zero(t, h.ConsecutiveFailures)
+}
@@ -5,10 +5,16 @@ package utils
 
 import "log"
 
+const defaultLevel = LevelDebug
+
 func Debug(fmt string, args ...interface{}) {
-	log.Printf("[DEBUG] "+fmt, args...)
+	if enabled(LevelDebug) {
+		log.Printf("[DEBUG] "+fmt, args...)
+	}
 }
 
 func Log(fmt string, args ...interface{}) {
-	log.Printf(fmt, args...)
+	if enabled(LevelInfo) {
+		log.Printf(fmt, args...)
+	}
 }
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	LevelDebug int32 = iota
+	LevelInfo
+	LevelError
+)
+
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(defaultLevel)
+}
+
+// SetLogLevel sets the runtime log level: debug, info or error.
+func SetLogLevel(level string) error {
+	switch strings.ToLower(level) {
+	case "debug":
+		logLevel.Store(LevelDebug)
+	case "info":
+		logLevel.Store(LevelInfo)
+	case "error":
+		logLevel.Store(LevelError)
+	default:
+		return fmt.Errorf("unknown log level %q (want debug, info or error)", level)
+	}
+	return nil
+}
+
+func enabled(level int32) bool {
+	return logLevel.Load() <= level
+}
@@ -5,7 +5,15 @@ package utils
 
 import "log"
 
-func Debug(_ string, _ ...interface{}) {}
+const defaultLevel = LevelInfo
+
+func Debug(fmt string, args ...interface{}) {
+	if enabled(LevelDebug) {
+		log.Printf("[DEBUG] "+fmt, args...)
+	}
+}
 func Log(fmt string, args ...interface{}) {
-	log.Printf(fmt, args...)
+	if enabled(LevelInfo) {
+		log.Printf(fmt, args...)
+	}
 }
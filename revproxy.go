@@ -0,0 +1,161 @@
+// Package revproxy is the reverse proxy as a library, for mounting it inside an application's own
+// mux or server instead of running cmd/proxy:
+//
+//	p, err := revproxy.New(nil,
+//		revproxy.WithUpstream("app", "http://127.0.0.1:3000"),
+//		revproxy.WithUpstream("api", "http://127.0.0.1:4000"),
+//		revproxy.WithRoute("api", "api", "", "/api"),
+//		revproxy.WithCache(1000, 60),
+//	)
+//	if err != nil { ... }
+//	defer p.Close()
+//	mux.Handle("/", p)
+//
+// Options mirror the sections of the config file, WithConfig reaches every other setting.
+package revproxy
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/ashpect/revproxy/pkg/config"
+)
+
+// Config is the proxy configuration, the schema of the config file.
+type Config = config.SystemCfg
+
+// DefaultConfig returns the defaults the config file is applied on top of.
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// Option adjusts the config New builds the proxy from.
+type Option func(*Config) error
+
+// WithConfigFile decodes a TOML, YAML or JSON config file (and its includes), like -config.
+func WithConfigFile(path string) Option {
+	return func(c *Config) error {
+		return c.DecodeFile(path)
+	}
+}
+
+// WithUpstream adds an upstream ([[upstreams]]), the first one serves unrouted traffic.
+func WithUpstream(name, rawURL string) Option {
+	return func(c *Config) error {
+		c.AddUpstream(name, rawURL)
+		return nil
+	}
+}
+
+// WithRoute adds a route ([[routes]]) sending requests matching host and pathPrefix, either may
+// be empty, to upstream.
+func WithRoute(name, upstream, host, pathPrefix string) Option {
+	return func(c *Config) error {
+		c.AddRouteTo(name, upstream, host, pathPrefix)
+		return nil
+	}
+}
+
+// WithCache enables the response cache ([cache]) with capacity entries kept defaultTTL seconds
+// unless upstream says otherwise.
+func WithCache(capacity, defaultTTL int) Option {
+	return func(c *Config) error {
+		c.CacheCfg.Enabled = true
+		c.CacheCfg.CacheCapacity = capacity
+		c.CacheCfg.DefaultTTL = defaultTTL
+		return nil
+	}
+}
+
+// WithoutCache disables the response cache.
+func WithoutCache() Option {
+	return func(c *Config) error {
+		c.CacheCfg.Enabled = false
+		return nil
+	}
+}
+
+// WithRateLimit ([ratelimit]) limits each client IP to rate requests per second with burst.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *Config) error {
+		c.RateLimitCfg.Enabled = true
+		c.RateLimitCfg.PerIPRate = rate
+		c.RateLimitCfg.PerIPBurst = burst
+		return nil
+	}
+}
+
+// WithConfig edits any other setting of the config.
+func WithConfig(edit func(*Config)) Option {
+	return func(c *Config) error {
+		edit(c)
+		return nil
+	}
+}
+
+// New builds the proxy from cfg, DefaultConfig when nil, with opts applied to a copy of it. The
+// listener, server and admin sections are the caller's to act on, see Proxy.Admin.
+func New(cfg *Config, opts ...Option) (*Proxy, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	c := *cfg
+	c.Upstreams = slices.Clone(cfg.Upstreams)
+	c.Routes = slices.Clone(cfg.Routes)
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Complete(); err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{}
+	if err := p.apply(&c); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ReopenLogs reopens the access log, after logrotate moved it away.
+func (p *Proxy) ReopenLogs() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.accessLog == nil {
+		return nil
+	}
+	return p.accessLog.Reopen()
+}
+
+// Close stops discovery, flushes the metrics and releases the cache and idle upstream connections.
+// The proxy must not serve requests afterwards.
+func (p *Proxy) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopDiscovery != nil {
+		p.stopDiscovery()
+	}
+	if p.cache != nil {
+		p.cache.Close()
+	}
+	if p.geo != nil {
+		p.geo.Close()
+	}
+	if p.recorder != nil {
+		p.recorder.Close()
+	}
+	if p.accessLog != nil {
+		p.accessLog.Close()
+	}
+	if p.metrics != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.metrics.Shutdown(ctx)
+	}
+	if p.clients != nil {
+		p.clients.CloseIdleConnections()
+	}
+}